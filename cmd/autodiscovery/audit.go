@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/sberz/ephemeral-envs/internal/audit"
+	"k8s.io/client-go/tools/record"
+)
+
+// auditOutputStdout writes the audit log to stdout, next to the request logs.
+const auditOutputStdout = "stdout"
+
+// auditor records mutating API operations in the audit log.
+type auditor struct {
+	log *audit.Log
+	// subjectHeader is the request header holding the authenticated subject.
+	subjectHeader string
+}
+
+func newAuditor(log *audit.Log, subjectHeader string) *auditor {
+	return &auditor{log: log, subjectHeader: subjectHeader}
+}
+
+// record adds the event to the audit log, with the subject and address of the request.
+func (a *auditor) record(r *http.Request, e audit.Event) {
+	if a.subjectHeader != "" {
+		e.Subject = r.Header.Get(a.subjectHeader)
+	}
+	e.RemoteAddr = r.RemoteAddr
+	e.ForwardedFor = r.Header.Get("X-Forwarded-For")
+
+	a.log.Record(r.Context(), e)
+}
+
+// setupAudit creates the audit log. Events of a previous run are loaded from the output
+// file. Events are mirrored to the Event recorders of the clusters if audit Events are enabled. The returned
+// function closes the output file.
+func setupAudit(ctx context.Context, cfg *serviceConfig, recorders map[string]record.EventRecorder) (*auditor, func() error, error) {
+	var mirrors []audit.Mirror
+	if cfg.Audit.KubernetesEvents {
+		mirrors = append(mirrors, audit.NewKubernetesMirror(recorders))
+	}
+
+	switch cfg.Audit.Output {
+	case "":
+		// Events are only kept in memory
+		return newAuditor(audit.NewLog(nil, cfg.Audit.Retain, mirrors...), cfg.Audit.SubjectHeader), func() error { return nil }, nil
+	case auditOutputStdout:
+		return newAuditor(audit.NewLog(os.Stdout, cfg.Audit.Retain, mirrors...), cfg.Audit.SubjectHeader), func() error { return nil }, nil
+	}
+
+	f, err := os.OpenFile(cfg.Audit.Output, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	log := audit.NewLog(f, cfg.Audit.Retain, mirrors...)
+	// Writes are appended, so the events of a previous run can be read from the start
+	if err := log.Load(ctx, f); err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to load audit log: %w", err)
+	}
+
+	return newAuditor(log, cfg.Audit.SubjectHeader), f.Close, nil
+}
+
+// handleListAuditEvents returns the retained audit events, newest first. They can be
+// filtered by environment and action, and limited with `limit`. The API is not
+// authenticated, so the subjects and client addresses are redacted; they are only
+// written to the audit log output.
+func handleListAuditEvents(a *auditor) http.Handler {
+	type response struct {
+		Events []audit.Event `json:"events"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := audit.Filter{
+			Environment: r.URL.Query().Get("environment"),
+			Action:      audit.Action(r.URL.Query().Get("action")),
+		}
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, "Bad Request: invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = n
+		}
+
+		events := a.log.Events(filter)
+		for i := range events {
+			events[i] = events[i].Redacted()
+		}
+		mustEncodeResponse(w, r, http.StatusOK, response{Events: events})
+	})
+}
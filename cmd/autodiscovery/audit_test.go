@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/sberz/ephemeral-envs/internal/audit"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestAuditor() *auditor {
+	return newAuditor(audit.NewLog(nil, 100), "X-Forwarded-User")
+}
+
+func TestHandleIgnitionEnvironmentAudit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		provider   *testIgnitionProvider
+		name       string
+		env        string
+		wantResult audit.Result
+	}{
+		{name: "accepted", provider: &testIgnitionProvider{}, env: "test", wantResult: audit.ResultAccepted},
+		{name: "failed", provider: &testIgnitionProvider{err: errTestProbeFailed}, env: "test", wantResult: audit.ResultFailed},
+		{name: "not found", provider: &testIgnitionProvider{}, env: "missing", wantResult: audit.ResultNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestStoreWithEnvironments(t, newTestEnvironment("test", "env-test", true, false))
+			a := newTestAuditor()
+			mux := http.NewServeMux()
+			mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(s, tt.provider, a))
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/environment/"+tt.env+"/ignition", nil)
+			req.Header.Set("X-Forwarded-User", "jane@example.com")
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+
+			events := a.log.Events(audit.Filter{})
+			if len(events) != 1 {
+				t.Fatalf("events = %v, want 1 event", events)
+			}
+			got := events[0]
+			if got.Result != tt.wantResult || got.Action != audit.ActionIgnition || got.Environment != tt.env {
+				t.Fatalf("event = %+v, want %s ignition of %q", got, tt.wantResult, tt.env)
+			}
+			if got.Subject != "jane@example.com" || got.RemoteAddr == "" {
+				t.Fatalf("event subject = %q, remote address = %q, want subject and address", got.Subject, got.RemoteAddr)
+			}
+		})
+	}
+}
+
+func TestSetupAuditKubernetesEvents(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(strconv.FormatBool(enabled), func(t *testing.T) {
+			t.Parallel()
+
+			// Discovery Events alone create the recorders, they must not mirror audit events
+			cfg := &serviceConfig{Discovery: DiscoveryConfig{KubernetesEvents: true}}
+			cfg.Audit.KubernetesEvents = enabled
+			cfg.Audit.ApplyDefaults()
+			recorder := record.NewFakeRecorder(1)
+
+			a, closeAudit, err := setupAudit(t.Context(), cfg, map[string]record.EventRecorder{"": recorder})
+			if err != nil {
+				t.Fatalf("setupAudit() error = %v", err)
+			}
+			t.Cleanup(func() { _ = closeAudit() })
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/environment/test/ignition", nil)
+			a.record(req, audit.Event{Action: audit.ActionIgnition, Environment: "test", Namespace: "env-test", Result: audit.ResultAccepted})
+
+			if got := len(recorder.Events); (got > 0) != enabled {
+				t.Fatalf("Events = %d, want mirrored %t", got, enabled)
+			}
+		})
+	}
+}
+
+func TestHandleListAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	a := newTestAuditor()
+	for _, env := range []string{"a", "b", "a"} {
+		a.log.Record(t.Context(), audit.Event{
+			Action: audit.ActionIgnition, Environment: env, Result: audit.ResultAccepted,
+			Subject: "jane@example.com", RemoteAddr: "192.0.2.1:1234", ForwardedFor: "198.51.100.7",
+		})
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantEvents int
+	}{
+		{name: "all", query: "", wantStatus: http.StatusOK, wantEvents: 3},
+		{name: "environment", query: "?environment=a", wantStatus: http.StatusOK, wantEvents: 2},
+		{name: "limit", query: "?limit=1", wantStatus: http.StatusOK, wantEvents: 1},
+		{name: "unknown environment", query: "?environment=c", wantStatus: http.StatusOK, wantEvents: 0},
+		{name: "invalid limit", query: "?limit=x", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/audit"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			handleListAuditEvents(a).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Events []audit.Event `json:"events"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if len(got.Events) != tt.wantEvents {
+				t.Fatalf("events = %d, want %d", len(got.Events), tt.wantEvents)
+			}
+			for _, e := range got.Events {
+				if e.Subject != "" || e.RemoteAddr != "" || e.ForwardedFor != "" {
+					t.Fatalf("event = %+v, want subject and addresses redacted", e)
+				}
+			}
+		})
+	}
+}
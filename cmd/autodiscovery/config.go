@@ -1,54 +1,492 @@
 package main
 
 import (
+	"cmp"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
+	"github.com/sberz/ephemeral-envs/internal/audit"
 	"github.com/sberz/ephemeral-envs/internal/ignition"
+	"github.com/sberz/ephemeral-envs/internal/kube"
 	"github.com/sberz/ephemeral-envs/internal/probe"
 	"github.com/sberz/ephemeral-envs/internal/prometheus"
+	"github.com/sberz/ephemeral-envs/internal/state"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type serviceConfig struct {
-	Prometheus   prometheus.Config
-	StatusChecks map[string]*prometheus.QueryConfig
+	StatusChecks map[string]*ProbeConfig
 	Metadata     map[string]*MetadataConfig
+	Datasources  map[string]prometheus.Config
+	URLs         map[string]string
 	Ignition     *ignition.ProviderConfig
 	configFile   string
-	LogLevel     slog.Level
-	MetricsPort  int
-	Port         int
+	Discovery    DiscoveryConfig
+	Clusters     []kube.ClusterConfig
+	// Audit configures the audit log of mutating API operations.
+	Audit      audit.Config
+	Prometheus prometheus.Config
+	// State configures the backend sharing state between replicas.
+	State state.Config
+	// LeaderElection configures the Lease based leader election between replicas.
+	LeaderElection kube.LeaderElectionConfig
+	// WriteBack configures writing resolved values back to namespace annotations.
+	WriteBack WriteBackConfig
+	// Snapshot configures the snapshot of the last known query results.
+	Snapshot prometheus.SnapshotConfig
+	// HTTPClient configures the client of HTTP checks.
+	HTTPClient  probe.HTTPClientConfig
+	LogLevel    slog.Level
+	MetricsPort int
+	Port        int
 }
 
 type configFile struct {
-	Ignition     *ignition.ProviderConfig           `yaml:"ignition"`
-	StatusChecks map[string]*prometheus.QueryConfig `yaml:"statusChecks"`
-	Metadata     map[string]*MetadataConfig         `yaml:"metadata"`
-	Prometheus   prometheus.Config                  `yaml:"prometheus"`
+	Ignition       *ignition.ProviderConfig     `yaml:"ignition"`
+	StatusChecks   map[string]*ProbeConfig      `yaml:"statusChecks"`
+	Metadata       map[string]*MetadataConfig   `yaml:"metadata"`
+	Datasources    map[string]prometheus.Config `yaml:"datasources"`
+	URLs           map[string]string            `yaml:"urls"`
+	Discovery      DiscoveryConfig              `yaml:"discovery"`
+	Clusters       []kube.ClusterConfig         `yaml:"clusters"`
+	Audit          audit.Config                 `yaml:"audit"`
+	Prometheus     prometheus.Config            `yaml:"prometheus"`
+	State          state.Config                 `yaml:"state"`
+	LeaderElection kube.LeaderElectionConfig    `yaml:"leaderElection"`
+	WriteBack      WriteBackConfig              `yaml:"writeBack"`
+	Snapshot       prometheus.SnapshotConfig    `yaml:"snapshot"`
+	HTTPClient     probe.HTTPClientConfig       `yaml:"httpClient"`
 }
 
 var (
 	nameRegex     = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
 	errInvalidKey = fmt.Errorf("key must match regex %s", nameRegex.String())
+
+	errInvalidLabel            = errors.New("invalid label key")
+	errInvalidCluster          = errors.New("invalid cluster")
+	errInvalidAnnotationPrefix = errors.New("invalid annotation prefix")
+	errInvalidScheme           = errors.New("invalid URL scheme")
+	errInvalidProbe            = errors.New("invalid probe")
+	errInvalidDatasource       = errors.New("invalid datasource")
+	errInvalidWriteBack        = errors.New("invalid write-back config")
 )
 
-type MetadataConfig struct {
-	Type                   probe.MetadataType `yaml:"type"`
+// KeyConfig defines the label and annotation keys used to describe an environment.
+// Empty fields fall back to the default envs.sberz.de keys.
+type KeyConfig struct {
+	// NameLabel is the label holding the environment name.
+	NameLabel string `yaml:"nameLabel"`
+	// URLAnnotationPrefix is the prefix of annotations defining environment URLs.
+	URLAnnotationPrefix string `yaml:"urlAnnotationPrefix"`
+	// StatusAnnotationPrefix is the prefix of annotations defining static status checks.
+	StatusAnnotationPrefix string `yaml:"statusAnnotationPrefix"`
+	// MetadataAnnotationPrefix is the prefix of annotations defining static metadata.
+	MetadataAnnotationPrefix string `yaml:"metadataAnnotationPrefix"`
+	// AliasesAnnotation is the annotation holding comma-separated aliases of the environment.
+	AliasesAnnotation string `yaml:"aliasesAnnotation"`
+}
+
+// DefaultKeyConfig returns the default envs.sberz.de label and annotation keys.
+func DefaultKeyConfig() KeyConfig {
+	return KeyConfig{
+		NameLabel:                LabelEnvName,
+		URLAnnotationPrefix:      AnnotationEnvURLPrefix,
+		StatusAnnotationPrefix:   AnnotationEnvStatusCheckPrefix,
+		MetadataAnnotationPrefix: AnnotationEnvMetadataPrefix,
+		AliasesAnnotation:        AnnotationEnvAliases,
+	}
+}
+
+// applyDefaults sets all empty keys to the values of defaults.
+func (c *KeyConfig) applyDefaults(defaults KeyConfig) {
+	c.NameLabel = cmp.Or(c.NameLabel, defaults.NameLabel)
+	c.URLAnnotationPrefix = cmp.Or(c.URLAnnotationPrefix, defaults.URLAnnotationPrefix)
+	c.StatusAnnotationPrefix = cmp.Or(c.StatusAnnotationPrefix, defaults.StatusAnnotationPrefix)
+	c.MetadataAnnotationPrefix = cmp.Or(c.MetadataAnnotationPrefix, defaults.MetadataAnnotationPrefix)
+	c.AliasesAnnotation = cmp.Or(c.AliasesAnnotation, defaults.AliasesAnnotation)
+}
+
+func (c KeyConfig) Validate() error {
+	if errs := validation.IsQualifiedName(c.NameLabel); len(errs) > 0 {
+		return fmt.Errorf("nameLabel: %w: %s", errInvalidLabel, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsQualifiedName(c.AliasesAnnotation); len(errs) > 0 {
+		return fmt.Errorf("aliasesAnnotation: %w: %s", errInvalidLabel, strings.Join(errs, ", "))
+	}
+
+	prefixes := map[string]string{
+		"urlAnnotationPrefix":      c.URLAnnotationPrefix,
+		"statusAnnotationPrefix":   c.StatusAnnotationPrefix,
+		"metadataAnnotationPrefix": c.MetadataAnnotationPrefix,
+	}
+	for field, prefix := range prefixes {
+		if err := validateAnnotationPrefix(prefix); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	return nil
+}
+
+// validateAnnotationPrefix checks that the prefix is a DNS subdomain followed by a slash,
+// so that appending a name results in a valid annotation key.
+func validateAnnotationPrefix(prefix string) error {
+	domain, ok := strings.CutSuffix(prefix, "/")
+	if !ok {
+		return fmt.Errorf("%w: %q must end with a slash", errInvalidAnnotationPrefix, prefix)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(domain); len(errs) > 0 {
+		return fmt.Errorf("%w: %q: %s", errInvalidAnnotationPrefix, prefix, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// DiscoveryConfig configures how environments are discovered in the cluster.
+// The label selector and keys are inherited by all sources that do not override them.
+type DiscoveryConfig struct {
+	KeyConfig `yaml:",inline"`
+	// LabelSelector is an additional label selector that discovered objects must match.
+	// It allows multiple instances to partition a cluster (e.g. by team).
+	LabelSelector string `yaml:"labelSelector"`
+	// ConflictPolicy decides which namespace keeps an environment name claimed by multiple
+	// namespaces: oldest, newest or reject. Defaults to oldest.
+	ConflictPolicy store.ConflictPolicy `yaml:"conflictPolicy"`
+	// Routes configures deriving environment URLs from routing resources.
+	Routes RoutesConfig `yaml:"routes"`
+	// Sources lists the resources environments are discovered from.
+	// Defaults to a single namespace source.
+	Sources []*SourceConfig `yaml:"sources"`
+	// KubernetesEvents emits Kubernetes Events on the environment namespaces about
+	// discovered environments, name conflicts, probes that failed to set up and ignition triggers.
+	KubernetesEvents bool `yaml:"kubernetesEvents"`
+}
+
+func (c *DiscoveryConfig) applyDefaults() {
+	c.KeyConfig.applyDefaults(DefaultKeyConfig())
+	c.ConflictPolicy = cmp.Or(c.ConflictPolicy, store.ConflictPolicyOldest)
+
+	if len(c.Sources) == 0 {
+		c.Sources = []*SourceConfig{{Type: SourceTypeNamespace}}
+	}
+
+	for _, src := range c.Sources {
+		src.KeyConfig.applyDefaults(c.KeyConfig)
+		src.LabelSelector = cmp.Or(src.LabelSelector, c.LabelSelector)
+	}
+
+	c.Routes.applyDefaults()
+}
+
+func (c *DiscoveryConfig) Validate() error {
+	if err := c.KeyConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.ConflictPolicy.Validate(); err != nil {
+		return fmt.Errorf("conflictPolicy: %w", err)
+	}
+
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		return fmt.Errorf("invalid labelSelector: %w", err)
+	}
+
+	for i, src := range c.Sources {
+		if err := src.Validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
+		}
+	}
+
+	if err := c.Routes.Validate(); err != nil {
+		return fmt.Errorf("routes: %w", err)
+	}
+
+	return nil
+}
+
+// RoutesConfig configures URL discovery from Ingresses and Gateway API HTTPRoutes
+// in environment namespaces. URLs defined via annotations take precedence.
+type RoutesConfig struct {
+	// NameLabel is the label on a routing resource naming its URL. Defaults to the resource name.
+	NameLabel string `yaml:"nameLabel"`
+	// HTTPRouteScheme is the URL scheme used for HTTPRoutes, as TLS is configured on the Gateway.
+	HTTPRouteScheme string `yaml:"httpRouteScheme"`
+	// Ingress enables URL discovery from Ingresses.
+	Ingress bool `yaml:"ingress"`
+	// HTTPRoute enables URL discovery from HTTPRoutes.
+	HTTPRoute bool `yaml:"httpRoute"`
+}
+
+// Enabled reports whether any routing resource is watched.
+func (c *RoutesConfig) Enabled() bool {
+	return c.Ingress || c.HTTPRoute
+}
+
+func (c *RoutesConfig) applyDefaults() {
+	c.NameLabel = cmp.Or(c.NameLabel, LabelURLName)
+	c.HTTPRouteScheme = cmp.Or(c.HTTPRouteScheme, "https")
+}
+
+func (c *RoutesConfig) Validate() error {
+	if errs := validation.IsQualifiedName(c.NameLabel); len(errs) > 0 {
+		return fmt.Errorf("nameLabel: %w: %s", errInvalidLabel, strings.Join(errs, ", "))
+	}
+
+	if c.HTTPRouteScheme != "http" && c.HTTPRouteScheme != "https" {
+		return fmt.Errorf("httpRouteScheme: %w: must be http or https, got %q", errInvalidScheme, c.HTTPRouteScheme)
+	}
+
+	return nil
+}
+
+// WriteBackConfig configures writing the resolved status checks and metadata of environments
+// back to annotations on their namespace.
+type WriteBackConfig struct {
+	// AnnotationPrefix is the prefix of the written annotations. Defaults to observed.envs.sberz.de/.
+	AnnotationPrefix string `yaml:"annotationPrefix"`
+	// StatusChecks lists the status and enum checks to write. Defaults to all checks of an environment.
+	StatusChecks []string `yaml:"statusChecks"`
+	// Metadata lists the metadata to write. Defaults to none.
+	Metadata []string `yaml:"metadata"`
+	// Interval is the time between checks for changed values. Defaults to 1m.
+	Interval time.Duration `yaml:"interval"`
+	// Burst and QPS limit the rate of writes to the Kubernetes API. Default to 10 and 5.
+	Burst int     `yaml:"burst"`
+	QPS   float32 `yaml:"qps"`
+	// Enabled enables the write-back.
+	Enabled bool `yaml:"enabled"`
+}
+
+func (c *WriteBackConfig) applyDefaults() {
+	c.AnnotationPrefix = cmp.Or(c.AnnotationPrefix, AnnotationObservedPrefix)
+	c.Interval = cmp.Or(c.Interval, time.Minute)
+	c.QPS = cmp.Or(c.QPS, 5)
+	c.Burst = cmp.Or(c.Burst, 10)
+}
+
+func (c *WriteBackConfig) Validate() error {
+	if err := validateAnnotationPrefix(c.AnnotationPrefix); err != nil {
+		return fmt.Errorf("annotationPrefix: %w", err)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval: %w: must be greater than 0", errInvalidWriteBack)
+	}
+	if c.QPS <= 0 || c.Burst <= 0 {
+		return fmt.Errorf("%w: qps and burst must be greater than 0", errInvalidWriteBack)
+	}
+
+	for _, check := range c.StatusChecks {
+		if check == observedAtKey {
+			return fmt.Errorf("statusChecks: %w: %q is reserved for the timestamp", errInvalidWriteBack, check)
+		}
+		if errs := validation.IsQualifiedName(c.AnnotationPrefix + check); len(errs) > 0 {
+			return fmt.Errorf("statusChecks.%s: %w: %s", check, errInvalidWriteBack, strings.Join(errs, ", "))
+		}
+	}
+	for _, meta := range c.Metadata {
+		if errs := validation.IsQualifiedName(c.AnnotationPrefix + observedMetadataKeyPrefix + meta); len(errs) > 0 {
+			return fmt.Errorf("metadata.%s: %w: %s", meta, errInvalidWriteBack, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// validateKeys checks that the written annotations are not read back by discovery, which
+// would turn observed values into static checks, metadata or URLs.
+func (c *WriteBackConfig) validateKeys(keys KeyConfig) error {
+	prefixes := map[string]string{
+		"urlAnnotationPrefix":      keys.URLAnnotationPrefix,
+		"statusAnnotationPrefix":   keys.StatusAnnotationPrefix,
+		"metadataAnnotationPrefix": keys.MetadataAnnotationPrefix,
+	}
+	for field, prefix := range prefixes {
+		if strings.HasPrefix(c.AnnotationPrefix, prefix) || strings.HasPrefix(prefix, c.AnnotationPrefix) {
+			return fmt.Errorf("annotationPrefix: %w: %q overlaps %s %q", errInvalidWriteBack, c.AnnotationPrefix, field, prefix)
+		}
+	}
+	return nil
+}
+
+type SourceType string
+
+const (
+	// SourceTypeNamespace discovers environments from namespaces.
+	SourceTypeNamespace SourceType = "namespace"
+	// SourceTypeResource discovers environments from arbitrary namespaced resources.
+	SourceTypeResource SourceType = "resource"
+)
+
+var errInvalidSource = errors.New("invalid source")
+
+// SourceConfig defines a single discovery source with its own label and annotation mapping.
+type SourceConfig struct {
+	// Type is the type of the source (`namespace` or `resource`).
+	Type SourceType `yaml:"type"`
+	// Group, Version and Resource identify the watched resource (resource only).
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+	// LabelSelector is an additional label selector that discovered objects must match.
+	LabelSelector string `yaml:"labelSelector"`
+	KeyConfig     `yaml:",inline"`
+}
+
+// namespaceResource is the resource of namespace sources.
+const namespaceResource = "namespaces"
+
+// resource returns the watched resource, e.g. `deployments.apps`.
+func (c *SourceConfig) resource() string {
+	if c.Type == SourceTypeNamespace {
+		return namespaceResource
+	}
+	return schema.GroupResource{Group: c.Group, Resource: c.Resource}.String()
+}
+
+func (c *SourceConfig) Validate() error {
+	switch c.Type {
+	case SourceTypeNamespace:
+		if c.Group != "" || c.Version != "" || c.Resource != "" {
+			return fmt.Errorf("%w: group, version and resource must be empty for namespace sources", errInvalidSource)
+		}
+	case SourceTypeResource:
+		if c.Version == "" || c.Resource == "" {
+			return fmt.Errorf("%w: version and resource must be set for resource sources", errInvalidSource)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %q", errInvalidSource, c.Type)
+	}
+
+	if err := c.KeyConfig.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		return fmt.Errorf("invalid labelSelector: %w", err)
+	}
+
+	return nil
+}
+
+// GroupVersionResource returns the watched resource of a resource source.
+func (c *SourceConfig) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource}
+}
+
+// Selector returns the label selector used to watch for environments of this source.
+// It always requires the name label to be present.
+func (c *SourceConfig) Selector() string {
+	return selector(c.NameLabel, c.LabelSelector)
+}
+
+// selector combines the required name label with an optional additional label selector.
+func selector(nameLabel string, labelSelector string) string {
+	if labelSelector == "" {
+		return nameLabel
+	}
+
+	return nameLabel + "," + labelSelector
+}
+
+// ProbeConfig configures a dynamic status check or metadata probe. The kind selects
+// between Prometheus queries (`single`, `bulk`), Kubernetes checks (`kubernetes`),
+// HTTP checks (`http`) and checks derived from other checks (`derived`).
+type ProbeConfig struct {
+	// Convert configures how the raw value is converted, e.g. with a threshold.
+	Convert probe.ConvertConfig `yaml:"convert"`
+	// Values turns a status check into an enum check with the given allowed values.
+	Values                 []string `yaml:"values"`
+	probe.HTTPConfig       `yaml:",inline"`
+	probe.KubernetesConfig `yaml:",inline"`
+	probe.DerivedConfig    `yaml:",inline"`
 	prometheus.QueryConfig `yaml:",inline"`
 }
 
+func (c *ProbeConfig) Validate() error {
+	isHTTP := c.URLName != "" || c.URLTemplate != "" || c.Path != "" || c.JSONPath != "" ||
+		len(c.ExpectedStatus) > 0 || c.MaxLatency != 0
+	if isHTTP && c.Kind != probe.KindHTTP {
+		return fmt.Errorf("%w: url, path, status and latency settings are only valid for http checks", errInvalidProbe)
+	}
+	if c.Expr != "" && c.Kind != probe.KindDerived {
+		return fmt.Errorf("%w: expr is only valid for derived checks", errInvalidProbe)
+	}
+	if c.Datasource != "" && (c.Kind == probe.KindKubernetes || c.Kind == probe.KindHTTP || c.Kind == probe.KindDerived) {
+		return fmt.Errorf("%w: datasource is only valid for Prometheus queries", errInvalidProbe)
+	}
+	if err := c.Convert.Validate(); err != nil {
+		return fmt.Errorf("convert: %w", err)
+	}
+	if err := probe.ValidateEnumValues(c.Values); err != nil {
+		return fmt.Errorf("values: %w", err)
+	}
+
+	switch c.Kind {
+	case probe.KindDerived:
+		if c.Query != "" || c.Check != "" || c.Selector != "" || !c.Convert.IsZero() || len(c.Values) > 0 {
+			return fmt.Errorf("%w: query, check, selector, convert and values must be empty for derived checks", errInvalidProbe)
+		}
+		return c.DerivedConfig.Validate()
+	case probe.KindKubernetes:
+		if c.Query != "" {
+			return fmt.Errorf("%w: query must be empty for kubernetes checks", errInvalidProbe)
+		}
+		return c.KubernetesConfig.Validate()
+	case probe.KindHTTP:
+		if c.Query != "" || c.Check != "" || c.Selector != "" {
+			return fmt.Errorf("%w: query, check and selector must be empty for http checks", errInvalidProbe)
+		}
+		if c.Interval <= 0 || c.Timeout <= 0 || c.Timeout >= c.Interval {
+			return fmt.Errorf("%w: interval and timeout must be greater than 0 and timeout less than interval", errInvalidProbe)
+		}
+		return c.HTTPConfig.Validate()
+	default:
+		if c.Check != "" || c.Selector != "" {
+			return fmt.Errorf("%w: check and selector are only valid for kubernetes checks", errInvalidProbe)
+		}
+		return c.QueryConfig.Validate()
+	}
+}
+
+type MetadataConfig struct {
+	Type        probe.MetadataType `yaml:"type"`
+	ProbeConfig `yaml:",inline"`
+}
+
 func (c *MetadataConfig) Validate() error {
 	err := c.Type.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid metadata type: %w", err)
 	}
 
-	err = c.QueryConfig.Validate()
+	if c.Kind == probe.KindDerived {
+		return fmt.Errorf("%w: derived probes are only supported for status checks", errInvalidProbe)
+	}
+	if len(c.Values) > 0 {
+		return fmt.Errorf("%w: values are only supported for status checks", errInvalidProbe)
+	}
+	if (c.Type == probe.MetadataTypeSeries) != (c.Kind == prometheus.QueryKindRange) {
+		return fmt.Errorf("%w: range queries are required for series metadata", errInvalidProbe)
+	}
+	if c.Kind == prometheus.QueryKindRange && !c.Convert.IsZero() {
+		return fmt.Errorf("%w: convert is not supported for range queries", errInvalidProbe)
+	}
+
+	err = c.ProbeConfig.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid query config: %w", err)
 	}
@@ -57,6 +495,79 @@ func (c *MetadataConfig) Validate() error {
 }
 
 func (c *configFile) validate() error {
+	c.Discovery.applyDefaults()
+	if err := c.Discovery.Validate(); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+
+	if err := validateClusters(c.Clusters); err != nil {
+		return fmt.Errorf("clusters: %w", err)
+	}
+
+	c.LeaderElection.ApplyDefaults()
+	if c.LeaderElection.Enabled {
+		if err := c.LeaderElection.Validate(); err != nil {
+			return fmt.Errorf("leaderElection: %w", err)
+		}
+		// The Lease must be held in one of the configured clusters
+		cluster := c.LeaderElection.Cluster
+		if cluster != "" && !slices.ContainsFunc(c.Clusters, func(cc kube.ClusterConfig) bool { return cc.Name == cluster }) {
+			return fmt.Errorf("leaderElection: %w: unknown cluster %q", errInvalidCluster, cluster)
+		}
+	}
+
+	c.State.ApplyDefaults()
+	if err := c.State.Validate(); err != nil {
+		return fmt.Errorf("state: %w", err)
+	}
+	if cluster := c.State.ConfigMap.Cluster; cluster != "" && !slices.ContainsFunc(c.Clusters, func(cc kube.ClusterConfig) bool { return cc.Name == cluster }) {
+		return fmt.Errorf("state: %w: unknown cluster %q", errInvalidCluster, cluster)
+	}
+
+	c.Snapshot.ApplyDefaults()
+	if c.Snapshot.Enabled {
+		if err := c.Snapshot.Validate(); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+		// A snapshot in memory is lost on restart
+		if c.State.Backend == state.BackendMemory {
+			return fmt.Errorf("snapshot: %w: requires the configmap or file backend", state.ErrInvalidBackend)
+		}
+	}
+
+	c.Audit.ApplyDefaults()
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	c.WriteBack.applyDefaults()
+	if c.WriteBack.Enabled {
+		if err := c.WriteBack.Validate(); err != nil {
+			return fmt.Errorf("writeBack: %w", err)
+		}
+		// Environments discovered from other resources may share a namespace
+		if slices.ContainsFunc(c.Discovery.Sources, func(src *SourceConfig) bool { return src.Type != SourceTypeNamespace }) {
+			return fmt.Errorf("writeBack: %w: requires namespace discovery sources only", errInvalidWriteBack)
+		}
+		for i, src := range c.Discovery.Sources {
+			if err := c.WriteBack.validateKeys(src.KeyConfig); err != nil {
+				return fmt.Errorf("writeBack: discovery.sources[%d]: %w", i, err)
+			}
+		}
+	}
+
+	c.HTTPClient.ApplyDefaults()
+	if err := c.HTTPClient.Validate(); err != nil {
+		return fmt.Errorf("httpClient: %w", err)
+	}
+
+	if _, exists := c.Datasources[prometheus.DefaultDatasource]; exists && c.Prometheus.Address != "" {
+		return fmt.Errorf("datasources.%s: %w: already defined by prometheus", prometheus.DefaultDatasource, errInvalidDatasource)
+	}
+	datasources := c.datasources()
+	if err := validateDatasources(datasources); err != nil {
+		return fmt.Errorf("datasources: %w", err)
+	}
 
 	for name, check := range c.StatusChecks {
 		// Name must be a valid label value
@@ -64,11 +575,22 @@ func (c *configFile) validate() error {
 			return fmt.Errorf("statusChecks.%s: %w", name, errInvalidKey)
 		}
 
+		if check.Kind == prometheus.QueryKindRange {
+			return fmt.Errorf("statusChecks.%s: %w: range queries are only supported for series metadata", name, errInvalidProbe)
+		}
+
 		check.Name = name
 		checkErr := check.Validate()
 		if checkErr != nil {
 			return fmt.Errorf("statusChecks.%s: %w", name, checkErr)
 		}
+		if err := validateDatasourceRef(datasources, check.Datasource); err != nil {
+			return fmt.Errorf("statusChecks.%s: %w", name, err)
+		}
+	}
+
+	if err := validateDerivedChecks(c.StatusChecks); err != nil {
+		return fmt.Errorf("statusChecks: %w", err)
 	}
 
 	for name, metadata := range c.Metadata {
@@ -80,6 +602,23 @@ func (c *configFile) validate() error {
 		if err := metadata.Validate(); err != nil {
 			return fmt.Errorf("metadata.%s: %w", name, err)
 		}
+		if err := validateDatasourceRef(datasources, metadata.Datasource); err != nil {
+			return fmt.Errorf("metadata.%s: %w", name, err)
+		}
+	}
+
+	if len(c.HTTPClient.AllowedHosts) == 0 && c.hasHTTPChecks() {
+		return fmt.Errorf("httpClient: %w: allowedHosts must be set for http checks", errInvalidProbe)
+	}
+
+	for name, tpl := range c.URLs {
+		if !nameRegex.MatchString(name) {
+			return fmt.Errorf("urls.%s: %w", name, errInvalidKey)
+		}
+
+		if _, err := parseURLTemplate(name, tpl); err != nil {
+			return fmt.Errorf("urls.%s: %w", name, err)
+		}
 	}
 
 	if err := c.Ignition.Validate(); err != nil {
@@ -88,6 +627,116 @@ func (c *configFile) validate() error {
 	return nil
 }
 
+// hasHTTPChecks reports whether a status check or metadata calls an HTTP endpoint.
+func (c *configFile) hasHTTPChecks() bool {
+	for _, check := range c.StatusChecks {
+		if check.Kind == probe.KindHTTP {
+			return true
+		}
+	}
+	for _, metadata := range c.Metadata {
+		if metadata.Kind == probe.KindHTTP {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDerivedChecks checks that derived checks do not depend on themselves. References
+// to unknown checks are allowed, as checks can also be defined via annotations.
+func validateDerivedChecks(checks map[string]*ProbeConfig) error {
+	exprs := make(map[string]*probe.Expression)
+	for name, check := range checks {
+		if check.Kind != probe.KindDerived {
+			continue
+		}
+
+		expr, err := probe.ParseExpression(check.Expr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, ref := range expr.Checks() {
+			if dep, ok := checks[ref]; ok && len(dep.Values) > 0 {
+				return fmt.Errorf("%s: %w: enum check %q cannot be used in expressions", name, probe.ErrInvalidExpression, ref)
+			}
+		}
+		exprs[name] = expr
+	}
+
+	return probe.CheckDependencyCycles(exprs)
+}
+
+// datasources returns all configured datasources. The `prometheus` section is the default datasource.
+func (c *configFile) datasources() map[string]prometheus.Config {
+	return mergeDatasources(c.Prometheus, c.Datasources)
+}
+
+func mergeDatasources(defaultCfg prometheus.Config, datasources map[string]prometheus.Config) map[string]prometheus.Config {
+	merged := make(map[string]prometheus.Config, len(datasources)+1)
+	maps.Copy(merged, datasources)
+	if defaultCfg.Address != "" {
+		merged[prometheus.DefaultDatasource] = defaultCfg
+	}
+	return merged
+}
+
+// validateDatasources checks the names, addresses and failover references of the datasources.
+func validateDatasources(datasources map[string]prometheus.Config) error {
+	for name, ds := range datasources {
+		if !nameRegex.MatchString(name) {
+			return fmt.Errorf("%s: %w", name, errInvalidKey)
+		}
+		if ds.Address == "" {
+			return fmt.Errorf("%s: %w: address must be set", name, errInvalidDatasource)
+		}
+		if err := ds.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		for _, failover := range ds.Failover {
+			if failover == name {
+				return fmt.Errorf("%s: %w: datasource cannot fail over to itself", name, errInvalidDatasource)
+			}
+			if _, exists := datasources[failover]; !exists {
+				return fmt.Errorf("%s: %w: unknown failover datasource %q", name, errInvalidDatasource, failover)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDatasourceRef checks that a query references a configured datasource. Queries without
+// a datasource use the default datasource, they are skipped if it is not configured.
+func validateDatasourceRef(datasources map[string]prometheus.Config, name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, exists := datasources[name]; !exists {
+		return fmt.Errorf("%w: unknown datasource %q", errInvalidDatasource, name)
+	}
+	return nil
+}
+
+// validateClusters checks that all clusters can be told apart. A single cluster may be unnamed.
+func validateClusters(clusters []kube.ClusterConfig) error {
+	seen := make(map[string]bool, len(clusters))
+
+	for i, cluster := range clusters {
+		if cluster.Name == "" && len(clusters) > 1 {
+			return fmt.Errorf("%w: [%d] name must be set when multiple clusters are configured", errInvalidCluster, i)
+		}
+		if cluster.Name != "" && !nameRegex.MatchString(cluster.Name) {
+			return fmt.Errorf("%w: [%d] name: %w", errInvalidCluster, i, errInvalidKey)
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("%w: [%d] duplicate name %q", errInvalidCluster, i, cluster.Name)
+		}
+		seen[cluster.Name] = true
+	}
+
+	return nil
+}
+
 func parseConfigFile(path string) (*configFile, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -128,10 +777,33 @@ func parseConfig(args []string, stderr io.Writer) (*serviceConfig, error) {
 			return nil, fmt.Errorf("failed to parse config file: %w", err)
 		}
 
+		cfg.Clusters = cfgFile.Clusters
+		cfg.Discovery = cfgFile.Discovery
 		cfg.Prometheus = cfgFile.Prometheus
+		cfg.Datasources = cfgFile.Datasources
 		cfg.StatusChecks = cfgFile.StatusChecks
 		cfg.Metadata = cfgFile.Metadata
+		cfg.URLs = cfgFile.URLs
 		cfg.Ignition = cfgFile.Ignition
+		cfg.LeaderElection = cfgFile.LeaderElection
+		cfg.State = cfgFile.State
+		cfg.Snapshot = cfgFile.Snapshot
+		cfg.Audit = cfgFile.Audit
+		cfg.WriteBack = cfgFile.WriteBack
+		cfg.HTTPClient = cfgFile.HTTPClient
+	}
+
+	cfg.Discovery.applyDefaults()
+	cfg.LeaderElection.ApplyDefaults()
+	cfg.State.ApplyDefaults()
+	cfg.Snapshot.ApplyDefaults()
+	cfg.Audit.ApplyDefaults()
+	cfg.WriteBack.applyDefaults()
+	cfg.HTTPClient.ApplyDefaults()
+
+	if len(cfg.Clusters) == 0 {
+		// Use the KUBECONFIG environment variable or the in-cluster configuration
+		cfg.Clusters = []kube.ClusterConfig{{}}
 	}
 
 	return cfg, nil
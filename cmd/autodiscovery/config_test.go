@@ -1,10 +1,18 @@
 package main
 
 import (
+	"cmp"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/probe"
+	"github.com/sberz/ephemeral-envs/internal/state"
+	"github.com/sberz/ephemeral-envs/internal/store"
 )
 
 func TestParseConfigDefaults(t *testing.T) {
@@ -27,6 +35,9 @@ func TestParseConfigDefaults(t *testing.T) {
 	if cfg.LogLevel != slog.LevelInfo {
 		t.Fatalf("LogLevel = %v, want %v", cfg.LogLevel, slog.LevelInfo)
 	}
+	if cfg.Discovery.KeyConfig != DefaultKeyConfig() {
+		t.Fatalf("Discovery keys = %#v, want defaults", cfg.Discovery.KeyConfig)
+	}
 }
 
 func TestParseConfigFileLoadsChecksAndMetadata(t *testing.T) {
@@ -227,6 +238,271 @@ func TestParseConfigFileIgnition(t *testing.T) {
 	}
 }
 
+func TestParseConfigFileDiscovery(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		want    DiscoveryConfig
+		wantErr bool
+	}{
+		"defaults when discovery is omitted": {
+			content: `statusChecks: {}
+`,
+			want: DiscoveryConfig{KeyConfig: DefaultKeyConfig()},
+		},
+		"custom keys and label selector": {
+			content: `discovery:
+  labelSelector: team=mobile
+  nameLabel: envs.example.com/name
+  urlAnnotationPrefix: url.envs.example.com/
+`,
+			want: DiscoveryConfig{
+				LabelSelector: "team=mobile",
+				KeyConfig: KeyConfig{
+					NameLabel:                "envs.example.com/name",
+					URLAnnotationPrefix:      "url.envs.example.com/",
+					StatusAnnotationPrefix:   AnnotationEnvStatusCheckPrefix,
+					MetadataAnnotationPrefix: AnnotationEnvMetadataPrefix,
+					AliasesAnnotation:        AnnotationEnvAliases,
+				},
+			},
+		},
+		"conflict policy": {
+			content: `discovery:
+  conflictPolicy: reject
+`,
+			want: DiscoveryConfig{KeyConfig: DefaultKeyConfig(), ConflictPolicy: store.ConflictPolicyReject},
+		},
+		"rejects unknown conflict policy": {
+			content: `discovery:
+  conflictPolicy: random
+`,
+			wantErr: true,
+		},
+		"rejects invalid name label": {
+			content: `discovery:
+  nameLabel: "not a label"
+`,
+			wantErr: true,
+		},
+		"rejects invalid aliases annotation": {
+			content: `discovery:
+  aliasesAnnotation: "not an annotation"
+`,
+			wantErr: true,
+		},
+		"rejects annotation prefix without slash": {
+			content: `discovery:
+  statusAnnotationPrefix: status.envs.example.com
+`,
+			wantErr: true,
+		},
+		"rejects invalid label selector": {
+			content: `discovery:
+  labelSelector: "team in (mobile"
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseConfigFile() error = nil, want non-nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseConfigFile() error = %v", err)
+			}
+
+			if cfg.Discovery.LabelSelector != tt.want.LabelSelector {
+				t.Fatalf("discovery.labelSelector = %q, want %q", cfg.Discovery.LabelSelector, tt.want.LabelSelector)
+			}
+			if cfg.Discovery.KeyConfig != tt.want.KeyConfig {
+				t.Fatalf("discovery keys = %#v, want %#v", cfg.Discovery.KeyConfig, tt.want.KeyConfig)
+			}
+			if wantPolicy := cmp.Or(tt.want.ConflictPolicy, store.ConflictPolicyOldest); cfg.Discovery.ConflictPolicy != wantPolicy {
+				t.Fatalf("discovery.conflictPolicy = %q, want %q", cfg.Discovery.ConflictPolicy, wantPolicy)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileDiscoverySources(t *testing.T) {
+	t.Parallel()
+
+	content := `discovery:
+  labelSelector: team=mobile
+  nameLabel: envs.example.com/name
+  sources:
+    - type: namespace
+    - type: resource
+      group: apps
+      version: v1
+      resource: deployments
+      nameLabel: preview.example.com/env
+      urlAnnotationPrefix: url.preview.example.com/
+`
+	path := writeTempConfig(t, content)
+
+	cfg, err := parseConfigFile(path)
+	if err != nil {
+		t.Fatalf("parseConfigFile() error = %v", err)
+	}
+
+	if len(cfg.Discovery.Sources) != 2 {
+		t.Fatalf("len(sources) = %d, want 2", len(cfg.Discovery.Sources))
+	}
+
+	ns := cfg.Discovery.Sources[0]
+	if ns.NameLabel != "envs.example.com/name" || ns.LabelSelector != "team=mobile" {
+		t.Fatalf("namespace source = %#v, want inherited keys and selector", ns)
+	}
+	if got, want := ns.Selector(), "envs.example.com/name,team=mobile"; got != want {
+		t.Fatalf("namespace source Selector() = %q, want %q", got, want)
+	}
+
+	res := cfg.Discovery.Sources[1]
+	if res.NameLabel != "preview.example.com/env" || res.URLAnnotationPrefix != "url.preview.example.com/" {
+		t.Fatalf("resource source keys = %#v, want overridden keys", res.KeyConfig)
+	}
+	if res.StatusAnnotationPrefix != AnnotationEnvStatusCheckPrefix {
+		t.Fatalf("resource source statusAnnotationPrefix = %q, want default %q", res.StatusAnnotationPrefix, AnnotationEnvStatusCheckPrefix)
+	}
+	if got := res.GroupVersionResource().String(); got != "apps/v1, Resource=deployments" {
+		t.Fatalf("resource source GroupVersionResource() = %q", got)
+	}
+}
+
+func TestParseConfigFileDiscoverySourcesInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"unknown source type": `discovery:
+  sources:
+    - type: pods
+`,
+		"resource source without resource": `discovery:
+  sources:
+    - type: resource
+      version: v1
+`,
+		"namespace source with resource": `discovery:
+  sources:
+    - type: namespace
+      version: v1
+      resource: services
+`,
+		"invalid http route scheme": `discovery:
+  routes:
+    httpRoute: true
+    httpRouteScheme: ftp
+`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, content)
+			if _, err := parseConfigFile(path); err == nil {
+				t.Fatal("parseConfigFile() error = nil, want non-nil")
+			}
+		})
+	}
+}
+
+func TestParseConfigDefaultSource(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig(nil, t.Output())
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	if len(cfg.Discovery.Sources) != 1 || cfg.Discovery.Sources[0].Type != SourceTypeNamespace {
+		t.Fatalf("sources = %#v, want single namespace source", cfg.Discovery.Sources)
+	}
+	if got := cfg.Discovery.Sources[0].Selector(); got != LabelEnvName {
+		t.Fatalf("Selector() = %q, want %q", got, LabelEnvName)
+	}
+
+	routes := cfg.Discovery.Routes
+	if routes.Enabled() || routes.NameLabel != LabelURLName || routes.HTTPRouteScheme != "https" {
+		t.Fatalf("routes = %#v, want disabled with default keys", routes)
+	}
+}
+
+func TestParseConfigFileClusters(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		want    int
+		wantErr bool
+	}{
+		"multiple named clusters": {
+			content: `clusters:
+  - name: local
+  - name: east
+    kubeconfig: /etc/clusters/east/kubeconfig
+    context: east
+`,
+			want: 2,
+		},
+		"single unnamed cluster": {
+			content: `clusters:
+  - kubeconfig: /etc/clusters/east/kubeconfig
+`,
+			want: 1,
+		},
+		"rejects unnamed cluster with multiple clusters": {
+			content: `clusters:
+  - name: local
+  - kubeconfig: /etc/clusters/east/kubeconfig
+`,
+			wantErr: true,
+		},
+		"rejects duplicate cluster names": {
+			content: `clusters:
+  - name: local
+  - name: local
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseConfigFile() error = nil, want non-nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseConfigFile() error = %v", err)
+			}
+			if len(cfg.Clusters) != tt.want {
+				t.Fatalf("len(clusters) = %d, want %d", len(cfg.Clusters), tt.want)
+			}
+		})
+	}
+}
+
 func writeTempConfig(t *testing.T, content string) string {
 	t.Helper()
 
@@ -237,3 +513,943 @@ func writeTempConfig(t *testing.T, content string) string {
 
 	return path
 }
+
+func TestParseConfigFileURLs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"valid templates": {
+			content: `urls:
+  api: https://api-{{.name}}.preview.example.com
+  team: https://{{index .labels "team"}}.example.com
+`,
+		},
+		"invalid template": {
+			content: `urls:
+  api: https://api-{{.name.preview.example.com
+`,
+			wantErr: true,
+		},
+		"invalid name": {
+			content: `urls:
+  bad name: https://{{.name}}.example.com
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(cfg.URLs) != 2 {
+				t.Fatalf("URLs = %#v, want 2 templates", cfg.URLs)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileKubernetesChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"status check and metadata": {
+			content: `statusChecks:
+  deployed:
+    kind: kubernetes
+    check: deploymentsAvailable
+    selector: app=web
+metadata:
+  readyPods:
+    type: number
+    kind: kubernetes
+    check: podsReady
+`,
+		},
+		"unknown check": {
+			content: `statusChecks:
+  deployed:
+    kind: kubernetes
+    check: nodesReady
+`,
+			wantErr: true,
+		},
+		"query for kubernetes check": {
+			content: `statusChecks:
+  deployed:
+    kind: kubernetes
+    check: podsReady
+    query: vector(1)
+`,
+			wantErr: true,
+		},
+		"check for prometheus query": {
+			content: `statusChecks:
+  deployed:
+    kind: single
+    check: podsReady
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := cfg.StatusChecks["deployed"]; got.Check != probe.KubernetesCheckDeploymentsAvailable || got.Selector != "app=web" {
+				t.Fatalf("statusChecks.deployed = %#v", got)
+			}
+			if got := cfg.Metadata["readyPods"]; got.Check != probe.KubernetesCheckPodsReady || got.Type != probe.MetadataTypeNumber {
+				t.Fatalf("metadata.readyPods = %#v", got)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileHTTPChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"status check and metadata": {
+			content: `httpClient:
+  allowedHosts: ["*.example.com"]
+statusChecks:
+  apiUp:
+    kind: http
+    urlName: api
+    path: /healthz
+    expectedStatus: [200, 204]
+    maxLatency: 500ms
+    interval: 30s
+    timeout: 2s
+metadata:
+  version:
+    type: string
+    kind: http
+    urlTemplate: https://api-{{.name}}.example.com
+    path: /info
+    jsonPath: version
+    interval: 5m
+    timeout: 5s
+`,
+		},
+		"missing interval": {
+			content: `statusChecks:
+  apiUp:
+    kind: http
+    urlName: api
+`,
+			wantErr: true,
+		},
+		"missing allowed hosts": {
+			content: `statusChecks:
+  apiUp:
+    kind: http
+    urlName: api
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+		"invalid allowed host": {
+			content: `httpClient:
+  allowedHosts: ["https://api.example.com"]
+statusChecks:
+  apiUp:
+    kind: http
+    urlName: api
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+		"invalid allowed scheme": {
+			content: `httpClient:
+  allowedHosts: ["api.example.com"]
+  allowedSchemes: [file]
+`,
+			wantErr: true,
+		},
+		"missing url": {
+			content: `statusChecks:
+  apiUp:
+    kind: http
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+		"http settings for prometheus query": {
+			content: `statusChecks:
+  apiUp:
+    kind: single
+    query: vector(1)
+    urlName: api
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := cfg.StatusChecks["apiUp"]; got.URLName != "api" || got.MaxLatency != 500*time.Millisecond || len(got.ExpectedStatus) != 2 {
+				t.Fatalf("statusChecks.apiUp = %#v", got)
+			}
+			if got := cfg.Metadata["version"]; got.JSONPath != "version" || got.Type != probe.MetadataTypeString {
+				t.Fatalf("metadata.version = %#v", got)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileDerivedChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"derived check": {
+			content: `statusChecks:
+  healthy:
+    kind: kubernetes
+    check: deploymentsAvailable
+  ready:
+    kind: derived
+    expr: active && healthy && meta.pods > 0
+`,
+		},
+		"derived check referencing derived check": {
+			content: `statusChecks:
+  healthy:
+    kind: derived
+    expr: active
+  ready:
+    kind: derived
+    expr: healthy && !degraded
+`,
+		},
+		"missing expr": {
+			content: `statusChecks:
+  ready:
+    kind: derived
+`,
+			wantErr: true,
+		},
+		"invalid expr": {
+			content: `statusChecks:
+  ready:
+    kind: derived
+    expr: active &&
+`,
+			wantErr: true,
+		},
+		"self reference": {
+			content: `statusChecks:
+  ready:
+    kind: derived
+    expr: ready || active
+`,
+			wantErr: true,
+		},
+		"cycle": {
+			content: `statusChecks:
+  a:
+    kind: derived
+    expr: b
+  b:
+    kind: derived
+    expr: c && active
+  c:
+    kind: derived
+    expr: "!a"
+`,
+			wantErr: true,
+		},
+		"expr for prometheus query": {
+			content: `statusChecks:
+  ready:
+    kind: single
+    query: vector(1)
+    expr: active
+    interval: 30s
+    timeout: 2s
+`,
+			wantErr: true,
+		},
+		"derived metadata": {
+			content: `metadata:
+  ready:
+    type: bool
+    kind: derived
+    expr: active
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			_, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileConvert(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"threshold and mapping": {
+			content: `statusChecks:
+  available:
+    kind: single
+    query: avg_over_time(up{namespace="{{.namespace}}"}[1h])
+    interval: 30s
+    timeout: 2s
+    convert:
+      threshold: "> 0.95"
+metadata:
+  phase:
+    type: string
+    kind: single
+    query: max(env_phase{namespace="{{.namespace}}"})
+    interval: 30s
+    timeout: 2s
+    convert:
+      map:
+        "0": stopped
+        "1": starting
+        "2": running
+  memory:
+    type: number
+    kind: kubernetes
+    check: podsReady
+    convert:
+      scale: 9.5367431640625e-07
+`,
+		},
+		"invalid threshold": {
+			content: `statusChecks:
+  available:
+    kind: single
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+    convert:
+      threshold: "0.95"
+`,
+			wantErr: true,
+		},
+		"convert for derived check": {
+			content: `statusChecks:
+  ready:
+    kind: derived
+    expr: active
+    convert:
+      threshold: "> 0"
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := cfg.StatusChecks["available"].Convert.Threshold; got != "> 0.95" {
+				t.Fatalf("statusChecks.available.convert.threshold = %q", got)
+			}
+			if got := cfg.Metadata["phase"].Convert.Map; got["2"] != "running" {
+				t.Fatalf("metadata.phase.convert.map = %#v", got)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileEnumChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"enum check": {
+			content: `statusChecks:
+  phase:
+    kind: single
+    query: max(env_phase{namespace="{{.namespace}}"})
+    interval: 30s
+    timeout: 2s
+    values: [stopped, starting, running]
+    convert:
+      map:
+        "0": stopped
+        "1": starting
+        "2": running
+`,
+		},
+		"duplicate values": {
+			content: `statusChecks:
+  phase:
+    kind: single
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+    values: [running, running]
+`,
+			wantErr: true,
+		},
+		"boolean values": {
+			content: `statusChecks:
+  phase:
+    kind: single
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+    values: ["true", running]
+`,
+			wantErr: true,
+		},
+		"values for derived check": {
+			content: `statusChecks:
+  ready:
+    kind: derived
+    expr: active
+    values: [running]
+`,
+			wantErr: true,
+		},
+		"enum check in expression": {
+			content: `statusChecks:
+  phase:
+    kind: single
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+    values: [running]
+  ready:
+    kind: derived
+    expr: phase && active
+`,
+			wantErr: true,
+		},
+		"values for metadata": {
+			content: `metadata:
+  phase:
+    type: string
+    kind: single
+    query: vector(1)
+    interval: 30s
+    timeout: 2s
+    values: [running]
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := cfg.StatusChecks["phase"].Values; !slices.Equal(got, []string{"stopped", "starting", "running"}) {
+				t.Fatalf("statusChecks.phase.values = %#v", got)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileSeriesMetadata(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"series metadata": {
+			content: `metadata:
+  activity:
+    type: series
+    kind: range
+    query: sum(rate(http_requests_total{namespace="{{.namespace}}"}[1m]))
+    interval: 1m
+    timeout: 5s
+    range: 1h
+    step: 1m
+    maxPoints: 61
+`,
+		},
+		"series without range query": {
+			content: `metadata:
+  activity:
+    type: series
+    kind: single
+    query: vector(1)
+    interval: 1m
+    timeout: 5s
+`,
+			wantErr: true,
+		},
+		"range query for number metadata": {
+			content: `metadata:
+  activity:
+    type: number
+    kind: range
+    query: vector(1)
+    interval: 1m
+    timeout: 5s
+    range: 1h
+    step: 1m
+`,
+			wantErr: true,
+		},
+		"range query for status check": {
+			content: `statusChecks:
+  active:
+    kind: range
+    query: vector(1)
+    interval: 1m
+    timeout: 5s
+    range: 1h
+    step: 1m
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := cfg.Metadata["activity"]; got.Range != time.Hour || got.Step != time.Minute || got.MaxPoints != 61 {
+				t.Fatalf("metadata.activity = %#v", got.QueryConfig)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileDatasources(t *testing.T) {
+	t.Parallel()
+
+	check := `statusChecks:
+  healthy:
+    kind: single
+    query: vector(1)
+    interval: 1m
+    timeout: 5s
+    datasource: %s
+`
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"named datasource with failover": {
+			content: `prometheus:
+  address: http://prometheus:9090
+datasources:
+  thanos:
+    address: http://thanos:9090
+    failover: [default]
+` + fmt.Sprintf(check, "thanos"),
+		},
+		"default datasource without prometheus": {
+			content: `datasources:
+  default:
+    address: http://thanos:9090
+` + fmt.Sprintf(check, "default"),
+		},
+		"default datasource defined twice": {
+			content: `prometheus:
+  address: http://prometheus:9090
+datasources:
+  default:
+    address: http://thanos:9090
+`,
+			wantErr: true,
+		},
+		"unknown datasource": {
+			content: `prometheus:
+  address: http://prometheus:9090
+` + fmt.Sprintf(check, "thanos"),
+			wantErr: true,
+		},
+		"unknown failover": {
+			content: `datasources:
+  thanos:
+    address: http://thanos:9090
+    failover: [mimir]
+`,
+			wantErr: true,
+		},
+		"self failover": {
+			content: `datasources:
+  thanos:
+    address: http://thanos:9090
+    failover: [thanos]
+`,
+			wantErr: true,
+		},
+		"mutual failover": {
+			content: `datasources:
+  thanos:
+    address: http://thanos:9090
+    failover: [mimir]
+  mimir:
+    address: http://mimir:9090
+    failover: [thanos]
+`,
+		},
+		"missing address": {
+			content: `datasources:
+  thanos:
+    failover: []
+`,
+			wantErr: true,
+		},
+		"invalid name": {
+			content: `datasources:
+  "Thanos!":
+    address: http://thanos:9090
+`,
+			wantErr: true,
+		},
+		"datasource for http check": {
+			content: `datasources:
+  thanos:
+    address: http://thanos:9090
+statusChecks:
+  healthy:
+    kind: http
+    urlTemplate: https://api-{{.name}}.example.com
+    path: /healthz
+    interval: 1m
+    timeout: 5s
+    datasource: thanos
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			_, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileLeaderElection(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"disabled": {
+			content: `leaderElection:
+  enabled: false
+  leaseDuration: 1s
+`,
+		},
+		"defaults": {
+			content: `leaderElection:
+  enabled: true
+`,
+		},
+		"lease in named cluster": {
+			content: `clusters:
+  - name: east
+  - name: west
+leaderElection:
+  enabled: true
+  cluster: west
+  leaseNamespace: ephemeral-envs
+`,
+		},
+		"unknown cluster": {
+			content: `leaderElection:
+  enabled: true
+  cluster: west
+`,
+			wantErr: true,
+		},
+		"invalid durations": {
+			content: `leaderElection:
+  enabled: true
+  leaseDuration: 5s
+  renewDeadline: 10s
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.LeaderElection.LeaseName == "" {
+				t.Fatal("leaderElection.leaseName is empty, want default")
+			}
+		})
+	}
+}
+
+func TestParseConfigFileState(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content     string
+		wantBackend state.BackendType
+		wantErr     bool
+	}{
+		"defaults to memory": {
+			content:     "{}\n",
+			wantBackend: state.BackendMemory,
+		},
+		"configmap in named cluster": {
+			content: `clusters:
+  - name: east
+  - name: west
+state:
+  backend: configmap
+  configMap:
+    name: shared-state
+    cluster: west
+  syncInterval: 30s
+`,
+			wantBackend: state.BackendConfigMap,
+		},
+		"unknown cluster": {
+			content: `state:
+  backend: configmap
+  configMap:
+    cluster: west
+`,
+			wantErr: true,
+		},
+		"unsupported backend": {
+			content: `state:
+  backend: redis
+`,
+			wantErr: true,
+		},
+		"snapshot in file": {
+			content: `state:
+  backend: file
+  file:
+    path: /var/lib/ephemeral-envs/state.json
+snapshot:
+  enabled: true
+  interval: 30s
+`,
+			wantBackend: state.BackendFile,
+		},
+		"snapshot in memory": {
+			content: `snapshot:
+  enabled: true
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.State.Backend != tt.wantBackend {
+				t.Fatalf("state.backend = %q, want %q", cfg.State.Backend, tt.wantBackend)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileWriteBack(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		wantErr bool
+	}{
+		"defaults": {
+			content: `writeBack:
+  enabled: true
+`,
+		},
+		"checks and metadata": {
+			content: `writeBack:
+  enabled: true
+  annotationPrefix: observed.envs.example.com/
+  statusChecks: [healthy, phase]
+  metadata: [owner]
+  interval: 30s
+  qps: 1
+  burst: 2
+`,
+		},
+		"reserved check name": {
+			content: `writeBack:
+  enabled: true
+  statusChecks: [observed-at]
+`,
+			wantErr: true,
+		},
+		"invalid annotation name": {
+			content: `writeBack:
+  enabled: true
+  metadata: [owner_]
+`,
+			wantErr: true,
+		},
+		"invalid prefix": {
+			content: `writeBack:
+  enabled: true
+  annotationPrefix: observed
+`,
+			wantErr: true,
+		},
+		"status prefix": {
+			content: `writeBack:
+  enabled: true
+  annotationPrefix: status.envs.sberz.de/
+`,
+			wantErr: true,
+		},
+		"custom metadata prefix": {
+			content: `discovery:
+  metadataAnnotationPrefix: observed.envs.example.com/
+writeBack:
+  enabled: true
+  annotationPrefix: observed.envs.example.com/
+`,
+			wantErr: true,
+		},
+		"source status prefix": {
+			content: `discovery:
+  sources:
+    - type: namespace
+      statusAnnotationPrefix: observed.envs.sberz.de/
+writeBack:
+  enabled: true
+`,
+			wantErr: true,
+		},
+		"resource source": {
+			content: `discovery:
+  sources:
+    - type: resource
+      group: apps
+      version: v1
+      resource: deployments
+writeBack:
+  enabled: true
+`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeTempConfig(t, tt.content)
+			cfg, err := parseConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && (cfg.WriteBack.Interval <= 0 || cfg.WriteBack.AnnotationPrefix == "") {
+				t.Fatalf("writeBack = %+v, want defaults applied", cfg.WriteBack)
+			}
+		})
+	}
+}
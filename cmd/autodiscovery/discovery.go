@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+)
+
+// watchCluster starts URL discovery and watching all discovery sources in a cluster.
+// It returns after the initial sync is complete.
+func watchCluster(ctx context.Context, clients *kube.Clients, cfg *DiscoveryConfig, controller *EventHandler, ready *readiness) error {
+	var routes *routeURLs
+	if cfg.Routes.Enabled() {
+		routes = newRouteURLs(cfg.Routes)
+	}
+
+	handler := controller.ForCluster(clients.Cluster, routes)
+
+	if routes != nil {
+		routes.onChange = handler.RefreshURLs
+		if err := watchRoutes(ctx, clients, routes, ready); err != nil {
+			return err
+		}
+	}
+
+	return watchSources(ctx, clients, cfg.Sources, handler, ready)
+}
+
+// watchRoutes starts watching the routing resources enabled in the routes config.
+func watchRoutes(ctx context.Context, clients *kube.Clients, routes *routeURLs, ready *readiness) error {
+	if routes.cfg.Ingress {
+		slog.DebugContext(ctx, "watching ingress events", "cluster", clients.Cluster)
+		err := kube.WatchIngressEvents(ctx, clients.Kubernetes, ready.watch(clients.Cluster, "ingresses.networking.k8s.io"), routes.HandleIngress, routes.HandleIngressDelete)
+		if err != nil {
+			return fmt.Errorf("failed to watch ingress events: %w", err)
+		}
+	}
+
+	if routes.cfg.HTTPRoute {
+		served, err := kube.HasResource(clients.Kubernetes.Discovery(), httpRouteGVR)
+		if err != nil {
+			return err
+		}
+		if !served {
+			slog.WarnContext(ctx, "HTTPRoutes are not served by the cluster, skipping URL discovery from HTTPRoutes", "cluster", clients.Cluster)
+			return nil
+		}
+
+		slog.DebugContext(ctx, "watching HTTPRoute events", "cluster", clients.Cluster)
+		err = kube.WatchResourceEvents(
+			ctx,
+			clients.Dynamic,
+			httpRouteGVR,
+			"",
+			ready.watch(clients.Cluster, httpRouteGVR.GroupResource().String()),
+			routes.HandleHTTPRoute,
+			routes.HandleHTTPRouteUpdate,
+			routes.HandleHTTPRouteDelete,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to watch HTTPRoute events: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// watchSources starts watching all configured discovery sources in a cluster. It returns after
+// the initial sync of all sources is complete.
+func watchSources(ctx context.Context, clients *kube.Clients, sources []*SourceConfig, controller *EventHandler, ready *readiness) error {
+	for _, src := range sources {
+		handler := controller.ForSource(src)
+
+		switch src.Type {
+		case SourceTypeNamespace:
+			slog.DebugContext(ctx, "watching namespace events", "cluster", clients.Cluster, "selector", src.Selector())
+			err := kube.WatchNamespaceEvents(
+				ctx,
+				clients.Kubernetes,
+				src.Selector(),
+				ready.watch(clients.Cluster, "namespaces"),
+				handler.HandleNamespaceAdd,
+				handler.HandleNamespaceUpdate,
+				handler.HandleNamespaceDelete,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to watch namespace events: %w", err)
+			}
+		case SourceTypeResource:
+			gvr := src.GroupVersionResource()
+			slog.DebugContext(ctx, "watching resource events", "cluster", clients.Cluster, "resource", gvr.String(), "selector", src.Selector())
+			err := kube.WatchResourceEvents(
+				ctx,
+				clients.Dynamic,
+				gvr,
+				src.Selector(),
+				ready.watch(clients.Cluster, gvr.GroupResource().String()),
+				handler.HandleResourceAdd,
+				handler.HandleResourceUpdate,
+				handler.HandleResourceDelete,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to watch %s events: %w", gvr.String(), err)
+			}
+		default:
+			return fmt.Errorf("%w: unsupported type %q", errInvalidSource, src.Type)
+		}
+	}
+
+	return nil
+}
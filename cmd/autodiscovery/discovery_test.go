@@ -0,0 +1,173 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/probe"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatchSourcesMultipleClusters(t *testing.T) {
+	t.Parallel()
+
+	created := time.Unix(1_700_000_000, 0).UTC()
+	newNamespace := func(name string, envName string, createdAt time.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(createdAt),
+			Labels:            map[string]string{LabelEnvName: envName},
+		}}
+	}
+
+	clusters := []*kube.Clients{
+		{
+			Cluster: "east",
+			Kubernetes: fake.NewClientset(
+				newNamespace("env-a", "a", created),
+				newNamespace("env-shared", "shared", created.Add(time.Hour)),
+			),
+		},
+		{
+			Cluster: "west",
+			Kubernetes: fake.NewClientset(
+				newNamespace("env-b", "b", created),
+				newNamespace("env-shared", "shared", created),
+			),
+		},
+	}
+
+	s := store.NewStore()
+	controller := NewEventHandler(t.Context(), s, DefaultKeyConfig(), nil, nil, nil, nil)
+	cfg := &DiscoveryConfig{Sources: []*SourceConfig{{Type: SourceTypeNamespace, KeyConfig: DefaultKeyConfig()}}}
+
+	for _, clients := range clusters {
+		if err := watchCluster(t.Context(), clients, cfg, controller, newReadiness(nil, nil, nil)); err != nil {
+			t.Fatalf("watchCluster(%s) error = %v", clients.Cluster, err)
+		}
+	}
+
+	// Event handlers are called asynchronously after the initial sync
+	waitFor(t, t.Context(), e2eWaitTimeout, 10*time.Millisecond, func() bool {
+		env, err := s.GetEnvironment(t.Context(), "shared")
+		return s.GetEnvironmentCount(t.Context()) == 3 && err == nil && env.Cluster == "west"
+	})
+
+	names := s.ListEnvironmentNames(t.Context())
+	if !slices.Equal(names, []string{"a", "b", "shared"}) {
+		t.Fatalf("ListEnvironmentNames() = %#v, want [a b shared]", names)
+	}
+
+	want := map[string]string{"a": "east", "b": "west", "shared": "west"}
+	for name, cluster := range want {
+		env, err := s.GetEnvironment(t.Context(), name)
+		if err != nil {
+			t.Fatalf("GetEnvironment(%s) error = %v", name, err)
+		}
+		if env.Cluster != cluster {
+			t.Fatalf("GetEnvironment(%s).Cluster = %q, want %q", name, env.Cluster, cluster)
+		}
+	}
+}
+
+func TestWatchClusterRouteURLs(t *testing.T) {
+	t.Parallel()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "env-a",
+		CreationTimestamp: metav1.NewTime(time.Unix(1_700_000_000, 0).UTC()),
+		Labels:            map[string]string{LabelEnvName: "a"},
+		Annotations:       map[string]string{AnnotationEnvURLPrefix + "docs": "https://docs.example.com"},
+	}}
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "env-a"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "a.example.com"}},
+			TLS:   []networkingv1.IngressTLS{{Hosts: []string{"a.example.com"}}},
+		},
+	}
+	docs := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "docs-ingress", Namespace: "env-a", Labels: map[string]string{LabelURLName: "docs"}},
+		Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "docs.a.example.com"}}},
+	}
+	client := fake.NewClientset(ns, ing, docs)
+	clients := &kube.Clients{Kubernetes: client}
+
+	s := store.NewStore()
+	web := &urlRecordingProber{urlName: "web"}
+	controller := NewEventHandler(t.Context(), s, DefaultKeyConfig(), map[string]probe.Prober[bool]{"web": web}, nil, nil, nil)
+	cfg := &DiscoveryConfig{
+		Sources: []*SourceConfig{{Type: SourceTypeNamespace, KeyConfig: DefaultKeyConfig()}},
+		Routes:  RoutesConfig{Ingress: true, NameLabel: LabelURLName},
+	}
+
+	if err := watchCluster(t.Context(), clients, cfg, controller, newReadiness(nil, nil, nil)); err != nil {
+		t.Fatalf("watchCluster() error = %v", err)
+	}
+
+	urlsEqual := func(want map[string]string) func() bool {
+		return func() bool {
+			env, err := s.GetEnvironment(t.Context(), "a")
+			return err == nil && maps.Equal(env.URL, want)
+		}
+	}
+
+	// The annotation takes precedence over the labelled docs Ingress
+	waitFor(t, t.Context(), e2eWaitTimeout, 10*time.Millisecond, urlsEqual(map[string]string{
+		"web":  "https://a.example.com",
+		"docs": "https://docs.example.com",
+	}))
+
+	ing = ing.DeepCopy()
+	ing.Spec.Rules[0].Host = "b.example.com"
+	if _, err := client.NetworkingV1().Ingresses("env-a").Update(t.Context(), ing, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update ingress: %v", err)
+	}
+
+	waitFor(t, t.Context(), e2eWaitTimeout, 10*time.Millisecond, urlsEqual(map[string]string{
+		"web":  "http://b.example.com",
+		"docs": "https://docs.example.com",
+	}))
+	// Probes resolving a URL are rebuilt with the changed URL
+	if got := web.lastURL(); got != "http://b.example.com" {
+		t.Fatalf("probed URL = %q, want %q", got, "http://b.example.com")
+	}
+
+	if err := client.NetworkingV1().Ingresses("env-a").Delete(t.Context(), "web", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete ingress: %v", err)
+	}
+
+	waitFor(t, t.Context(), e2eWaitTimeout, 10*time.Millisecond, urlsEqual(map[string]string{
+		"docs": "https://docs.example.com",
+	}))
+}
+
+// urlRecordingProber records the URL of the last added environment.
+type urlRecordingProber struct {
+	urlName string
+	url     string
+	mu      sync.Mutex
+}
+
+func (r *urlRecordingProber) AddEnvironment(env probe.Target) (probe.Probe[bool], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.url = env.URL[r.urlName]
+	return probe.NewStaticProbe(true), nil
+}
+
+func (r *urlRecordingProber) RemoveEnvironment(_ probe.Target) {}
+
+func (r *urlRecordingProber) lastURL() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.url
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Kubernetes Events emitted on environment namespaces.
+const (
+	reasonDiscovered  = "EnvironmentDiscovered"
+	reasonConflict    = "EnvironmentConflict"
+	reasonProbeFailed = "ProbeFailed"
+)
+
+// envEvents emits Kubernetes Events on the namespaces of environments, so developers can see
+// what the service thinks about their environment with `kubectl describe ns`. A nil envEvents
+// emits nothing. Only the leader emits Events, so replicas do not report the same environment
+// multiple times.
+type envEvents struct {
+	// recorders holds the Event recorder of each cluster.
+	recorders map[string]record.EventRecorder
+	// leader reports whether this replica is the leader.
+	leader func() bool
+}
+
+func newEnvEvents(recorders map[string]record.EventRecorder, leader func() bool) *envEvents {
+	if len(recorders) == 0 {
+		return nil
+	}
+	return &envEvents{recorders: recorders, leader: leader}
+}
+
+// setupEventRecorders creates an Event recorder for each cluster. It returns nil if no
+// Kubernetes Events are enabled.
+func setupEventRecorders(ctx context.Context, cfg *serviceConfig, clusters []*kube.Clients) map[string]record.EventRecorder {
+	if !cfg.Discovery.KubernetesEvents && !cfg.Audit.KubernetesEvents {
+		return nil
+	}
+
+	recorders := make(map[string]record.EventRecorder, len(clusters))
+	for _, c := range clusters {
+		recorders[c.Cluster] = kube.NewEventRecorder(ctx, c.Kubernetes)
+	}
+	return recorders
+}
+
+// discovered reports a newly discovered environment. Environments of the initial list of
+// the informers were discovered before, e.g. before a restart, and are not reported again.
+func (e *envEvents) discovered(ctx context.Context, env store.Environment) {
+	if kube.InInitialList(ctx) {
+		return
+	}
+	e.emit(ctx, env.Cluster, env.Namespace, corev1.EventTypeNormal, reasonDiscovered,
+		fmt.Sprintf("Discovered environment %q", env.Name))
+}
+
+// conflict reports a rejected claim of an environment name on the rejected namespace.
+// It is passed to store.Store.OnConflict.
+func (e *envEvents) conflict(ctx context.Context, rejected store.Environment, winner *store.Environment) {
+	msg := fmt.Sprintf("Environment name %q is claimed by multiple namespaces, all claims are rejected", rejected.Name)
+	if winner != nil {
+		msg = fmt.Sprintf("Environment name %q is already claimed by namespace %s in cluster %q, which takes precedence", rejected.Name, winner.Namespace, winner.Cluster)
+	}
+	e.emit(ctx, rejected.Cluster, rejected.Namespace, corev1.EventTypeWarning, reasonConflict, msg)
+}
+
+// probeFailed reports a probe that could not be set up for an environment.
+func (e *envEvents) probeFailed(ctx context.Context, cluster, namespace, kind, check string, err error) {
+	e.emit(ctx, cluster, namespace, corev1.EventTypeWarning, reasonProbeFailed,
+		fmt.Sprintf("Failed to set up %s %q: %v", kind, check, err))
+}
+
+func (e *envEvents) emit(ctx context.Context, cluster, namespace, eventType, reason, message string) {
+	if e == nil || !e.leader() {
+		return
+	}
+
+	recorder, ok := e.recorders[cluster]
+	if !ok {
+		slog.WarnContext(ctx, "no Event recorder for cluster", "cluster", cluster, "namespace", namespace)
+		return
+	}
+	recorder.Event(kube.NamespaceReference(namespace), eventType, reason, message)
+}
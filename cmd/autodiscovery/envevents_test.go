@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/probe"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventHandlerKubernetesEvents(t *testing.T) {
+	t.Parallel()
+
+	created := time.Unix(1_700_000_000, 0).UTC()
+	newNamespace := func(name string, envName string, createdAt time.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(createdAt),
+			Labels:            map[string]string{LabelEnvName: envName},
+		}}
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	events := newEnvEvents(map[string]record.EventRecorder{"": recorder}, func() bool { return true })
+
+	s := store.NewStore()
+	s.OnConflict(events.conflict)
+
+	h := NewEventHandler(t.Context(), s, DefaultKeyConfig(), map[string]probe.Prober[bool]{
+		"broken": &recordingBoolProber{err: errors.New("no query")},
+	}, nil, nil, nil)
+	h.UseEvents(events)
+	h = h.ForCluster("", nil)
+
+	h.HandleNamespaceAdd(t.Context(), newNamespace("env-new", "shared", created.Add(time.Hour)))
+	h.HandleNamespaceAdd(t.Context(), newNamespace("env-old", "shared", created))
+
+	got := make([]string, 0, len(recorder.Events))
+	for len(recorder.Events) > 0 {
+		got = append(got, <-recorder.Events)
+	}
+
+	want := []string{
+		"Warning ProbeFailed Failed to set up status check \"broken\": no query",
+		"Normal EnvironmentDiscovered Discovered environment \"shared\"",
+		"Warning ProbeFailed Failed to set up status check \"broken\": no query",
+		"Warning EnvironmentConflict Environment name \"shared\" is already claimed by namespace env-old in cluster \"\", which takes precedence",
+		"Normal EnvironmentDiscovered Discovered environment \"shared\"",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("events =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestEnvEventsDisabled(t *testing.T) {
+	t.Parallel()
+
+	events := newEnvEvents(nil, func() bool { return true })
+	if events != nil {
+		t.Fatalf("newEnvEvents(nil) = %#v, want nil", events)
+	}
+
+	// A nil envEvents must not emit or panic
+	events.discovered(t.Context(), store.Environment{Name: "a", Namespace: "env-a"})
+}
+
+func TestEnvEventsOnlyNewEnvironmentsOnLeader(t *testing.T) {
+	t.Parallel()
+
+	newNamespace := func(name string, envName string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(time.Unix(1_700_000_000, 0).UTC()),
+			Labels:            map[string]string{LabelEnvName: envName},
+		}}
+	}
+
+	for _, leader := range []bool{true, false} {
+		t.Run(fmt.Sprintf("leader %t", leader), func(t *testing.T) {
+			t.Parallel()
+
+			// env-a existed before the start, e.g. before a restart
+			client := fake.NewClientset(newNamespace("env-a", "a"))
+			recorder := record.NewFakeRecorder(10)
+			events := newEnvEvents(map[string]record.EventRecorder{"": recorder}, func() bool { return leader })
+
+			s := store.NewStore()
+			controller := NewEventHandler(t.Context(), s, DefaultKeyConfig(), nil, nil, nil, nil)
+			controller.UseEvents(events)
+			cfg := &DiscoveryConfig{Sources: []*SourceConfig{{Type: SourceTypeNamespace, KeyConfig: DefaultKeyConfig()}}}
+			if err := watchCluster(t.Context(), &kube.Clients{Kubernetes: client}, cfg, controller, newReadiness(nil, nil, nil)); err != nil {
+				t.Fatalf("watchCluster() error = %v", err)
+			}
+
+			if _, err := client.CoreV1().Namespaces().Create(t.Context(), newNamespace("env-b", "b"), metav1.CreateOptions{}); err != nil {
+				t.Fatalf("create namespace: %v", err)
+			}
+			waitFor(t, t.Context(), e2eWaitTimeout, 10*time.Millisecond, func() bool {
+				_, errA := s.GetEnvironment(t.Context(), "a")
+				_, errB := s.GetEnvironment(t.Context(), "b")
+				return errA == nil && errB == nil
+			})
+
+			var want []string
+			if leader {
+				want = []string{"Normal EnvironmentDiscovered Discovered environment \"b\""}
+			}
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("events = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base32"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sberz/ephemeral-envs/internal/probe"
 	"github.com/sberz/ephemeral-envs/internal/store"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// ownerMetadata is the name of the metadata holding the owner of an environment.
+const ownerMetadata = "owner"
+
 var (
 	eventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "ephemeralenv_events_processed_total",
@@ -24,105 +38,463 @@ var (
 type EventHandler struct {
 	s        *store.Store
 	checks   map[string]probe.Prober[bool]
+	enums    map[string]*probe.EnumProber
 	metadata map[string]probe.MetadataProber
+	urls     map[string]*template.Template
+	keys     KeyConfig
+	// source is the discovery source type, used to label metrics.
+	source SourceType
+	// resource is the resource of the handled objects, e.g. `deployments.apps`.
+	resource string
+	// routes provides URLs derived from routing resources. It is nil if URL discovery is disabled.
+	routes *routeURLs
+	// tracked holds the handled objects of a cluster to refresh their URLs. It is nil if URL discovery is disabled.
+	tracked *trackedObjects
+	// events emits Kubernetes Events on the environment namespaces. It is nil if Events are disabled.
+	events *envEvents
+	// ignoredAnnotationPrefix is the prefix of annotations written by this service. Updates
+	// that only change them are ignored.
+	ignoredAnnotationPrefix string
+	// cluster is the name of the cluster the handled objects belong to.
+	cluster string
+}
+
+// trackedObjects holds the last seen object of each environment, so derived URLs
+// can be rebuilt when routing resources change.
+type trackedObjects struct {
+	objects map[trackedKey]metav1.Object
+	mu      sync.Mutex
+}
+
+type trackedKey struct {
+	handler   *EventHandler
+	namespace string
+	name      string
+}
+
+func (t *trackedObjects) set(c *EventHandler, obj metav1.Object) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.objects[trackedKey{handler: c, namespace: obj.GetNamespace(), name: obj.GetName()}] = obj
 }
 
-func NewEventHandler(_ context.Context, store *store.Store, checks map[string]probe.Prober[bool], metadata map[string]probe.MetadataProber) *EventHandler {
+func (t *trackedObjects) delete(c *EventHandler, obj metav1.Object) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.objects, trackedKey{handler: c, namespace: obj.GetNamespace(), name: obj.GetName()})
+}
+
+func NewEventHandler(_ context.Context, store *store.Store, keys KeyConfig, checks map[string]probe.Prober[bool], enums map[string]*probe.EnumProber, metadata map[string]probe.MetadataProber, urls map[string]*template.Template) *EventHandler {
 	return &EventHandler{
 		s:        store,
 		checks:   checks,
+		enums:    enums,
 		metadata: metadata,
+		urls:     urls,
+		keys:     keys,
+		source:   SourceTypeNamespace,
+		resource: namespaceResource,
 	}
 }
 
-func (c *EventHandler) HandleNamespaceAdd(ctx context.Context, ns *corev1.Namespace) {
-	name := ns.Labels[LabelEnvName]
+// UseEvents emits Kubernetes Events about discovered environments with events. It must be
+// called before the cluster handlers are created.
+func (c *EventHandler) UseEvents(events *envEvents) {
+	c.events = events
+}
 
-	urls := c.buildURLMap(ctx, ns)
-	checks := c.buildStatusChecks(ctx, name, ns)
-	metadata := c.buildMetadataProbes(ctx, name, ns)
+// IgnoreAnnotations ignores updates that only change annotations with the given prefix, so
+// annotations written back by this service do not rebuild the environment. It must be called
+// before the cluster handlers are created.
+func (c *EventHandler) IgnoreAnnotations(prefix string) {
+	c.ignoredAnnotationPrefix = prefix
+}
 
-	err := c.s.AddEnvironment(ctx, store.Environment{
+// ForCluster returns a handler for objects of the given cluster. If routes is not nil,
+// URLs derived from routing resources are added to the environments of the cluster.
+func (c *EventHandler) ForCluster(cluster string, routes *routeURLs) *EventHandler {
+	h := &EventHandler{
+		s:        c.s,
+		checks:   c.checks,
+		enums:    c.enums,
+		metadata: c.metadata,
+		urls:     c.urls,
+		keys:     c.keys,
+		source:   c.source,
+		resource: c.resource,
+		cluster:  cluster,
+		routes:   routes,
+		events:   c.events,
+
+		ignoredAnnotationPrefix: c.ignoredAnnotationPrefix,
+	}
+
+	if routes != nil {
+		h.tracked = &trackedObjects{objects: make(map[trackedKey]metav1.Object)}
+	}
+
+	return h
+}
+
+// ForSource returns a handler for the given discovery source. The returned handler
+// shares the store, probers and cluster with c but uses the label and annotation keys of the source.
+func (c *EventHandler) ForSource(src *SourceConfig) *EventHandler {
+	return &EventHandler{
+		s:        c.s,
+		checks:   c.checks,
+		enums:    c.enums,
+		metadata: c.metadata,
+		urls:     c.urls,
+		keys:     src.KeyConfig,
+		source:   src.Type,
+		resource: src.resource(),
+		cluster:  c.cluster,
+		routes:   c.routes,
+		tracked:  c.tracked,
+		events:   c.events,
+
+		ignoredAnnotationPrefix: c.ignoredAnnotationPrefix,
+	}
+}
+
+// RefreshURLs rebuilds the URLs of all environments in the namespace. It is called
+// when routing resources in the namespace change.
+func (c *EventHandler) RefreshURLs(ctx context.Context, namespace string) {
+	if c.tracked == nil {
+		return
+	}
+
+	c.tracked.mu.Lock()
+	objects := make(map[trackedKey]metav1.Object)
+	for key, obj := range c.tracked.objects {
+		if envNamespace(obj) == namespace {
+			objects[key] = obj
+		}
+	}
+	c.tracked.mu.Unlock()
+
+	for key, obj := range objects {
+		key.handler.refreshURLs(ctx, obj)
+	}
+}
+
+func (c *EventHandler) refreshURLs(ctx context.Context, obj metav1.Object) {
+	name := obj.GetLabels()[c.keys.NameLabel]
+	eventType := "urls_refresh"
+
+	env, err := c.s.GetEnvironment(ctx, name)
+	if err != nil || env.Cluster != c.cluster || env.Namespace != envNamespace(obj) || env.Source != c.envSource(obj) {
+		// The environment is not (or no longer) backed by this object
+		return
+	}
+
+	urls := c.buildURLMap(ctx, obj)
+	if maps.Equal(urls, env.URL) {
+		return
+	}
+
+	// Probes resolve their URL when they are created, e.g. HTTP checks, so they are rebuilt
+	target := c.probeTarget(name, obj, urls)
+	metadata := c.buildMetadataProbes(ctx, target, obj)
+	target.Metadata = metadata
+
+	err = c.s.UpdateEnvironment(ctx, name, store.Environment{
 		Name:         name,
-		CreatedAt:    ns.GetCreationTimestamp().Time,
-		Namespace:    ns.Name,
+		Namespace:    env.Namespace,
+		Cluster:      c.cluster,
+		Source:       env.Source,
 		URL:          urls,
-		StatusChecks: checks,
+		StatusChecks: c.buildStatusChecks(ctx, target, obj),
+		EnumChecks:   c.buildEnumChecks(ctx, target, obj),
 		MetaProbes:   metadata,
+		Owner:        c.envOwner(ctx, obj),
 	})
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to add environment", "name", name, "error", err)
-		eventsProcessed.WithLabelValues("namespace_add", "error").Inc()
-	} else {
-		eventsProcessed.WithLabelValues("namespace_add", "success").Inc()
+		slog.ErrorContext(ctx, "failed to refresh environment URLs", "name", name, "error", err)
+		eventsProcessed.WithLabelValues(eventType, "error").Inc()
+		return
 	}
+	eventsProcessed.WithLabelValues(eventType, "success").Inc()
+}
+
+func (c *EventHandler) HandleNamespaceAdd(ctx context.Context, ns *corev1.Namespace) {
+	c.handleAdd(ctx, ns)
 }
 
 func (c *EventHandler) HandleNamespaceUpdate(ctx context.Context, oldNs, newNs *corev1.Namespace) {
+	c.handleUpdate(ctx, oldNs, newNs)
+}
+
+func (c *EventHandler) HandleNamespaceDelete(ctx context.Context, ns *corev1.Namespace) {
+	c.handleDelete(ctx, ns)
+}
 
-	oldName := oldNs.Labels[LabelEnvName]
-	newName := newNs.Labels[LabelEnvName]
+func (c *EventHandler) HandleResourceAdd(ctx context.Context, obj *unstructured.Unstructured) {
+	c.handleAdd(ctx, obj)
+}
+
+func (c *EventHandler) HandleResourceUpdate(ctx context.Context, oldObj, newObj *unstructured.Unstructured) {
+	c.handleUpdate(ctx, oldObj, newObj)
+}
+
+func (c *EventHandler) HandleResourceDelete(ctx context.Context, obj *unstructured.Unstructured) {
+	c.handleDelete(ctx, obj)
+}
+
+func (c *EventHandler) handleAdd(ctx context.Context, obj metav1.Object) {
+	name := obj.GetLabels()[c.keys.NameLabel]
+	eventType := string(c.source) + "_add"
+	c.tracked.set(c, obj)
 
-	urls := c.buildURLMap(ctx, newNs)
-	checks := c.buildStatusChecks(ctx, newName, newNs)
-	metadata := c.buildMetadataProbes(ctx, newName, newNs)
+	urls := c.buildURLMap(ctx, obj)
+	target := c.probeTarget(name, obj, urls)
+	metadata := c.buildMetadataProbes(ctx, target, obj)
+	target.Metadata = metadata
+	checks := c.buildStatusChecks(ctx, target, obj)
+	enums := c.buildEnumChecks(ctx, target, obj)
+
+	env := store.Environment{
+		Name:         name,
+		CreatedAt:    obj.GetCreationTimestamp().Time,
+		Namespace:    envNamespace(obj),
+		Cluster:      c.cluster,
+		Source:       c.envSource(obj),
+		URL:          urls,
+		StatusChecks: checks,
+		EnumChecks:   enums,
+		MetaProbes:   metadata,
+		Aliases:      c.parseAliases(ctx, name, obj),
+		ID:           envID(obj.GetUID()),
+		Labels:       maps.Clone(obj.GetLabels()),
+		Owner:        c.envOwner(ctx, obj),
+	}
+	err := c.s.AddEnvironment(ctx, env)
+	switch {
+	case errors.Is(err, store.ErrEnvironmentConflict):
+		// The store already logged the conflict
+		eventsProcessed.WithLabelValues(eventType, "conflict").Inc()
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to add environment", "name", name, "error", err)
+		eventsProcessed.WithLabelValues(eventType, "error").Inc()
+	default:
+		c.events.discovered(ctx, env)
+		eventsProcessed.WithLabelValues(eventType, "success").Inc()
+	}
+}
 
-	err := c.s.UpdateEnvironment(ctx, oldName, store.Environment{
+func (c *EventHandler) handleUpdate(ctx context.Context, oldObj, newObj metav1.Object) {
+	oldName := oldObj.GetLabels()[c.keys.NameLabel]
+	newName := newObj.GetLabels()[c.keys.NameLabel]
+	eventType := string(c.source) + "_update"
+	c.tracked.set(c, newObj)
+
+	if c.onlyIgnoredAnnotationsChanged(oldObj, newObj) {
+		eventsProcessed.WithLabelValues(eventType, "ignored").Inc()
+		return
+	}
+
+	urls := c.buildURLMap(ctx, newObj)
+	target := c.probeTarget(newName, newObj, urls)
+	metadata := c.buildMetadataProbes(ctx, target, newObj)
+	target.Metadata = metadata
+	checks := c.buildStatusChecks(ctx, target, newObj)
+	enums := c.buildEnumChecks(ctx, target, newObj)
+
+	env := store.Environment{
 		Name:         newName,
-		CreatedAt:    newNs.GetCreationTimestamp().Time,
-		Namespace:    newNs.Name,
+		CreatedAt:    newObj.GetCreationTimestamp().Time,
+		Namespace:    envNamespace(newObj),
+		Cluster:      c.cluster,
+		Source:       c.envSource(newObj),
 		URL:          urls,
 		StatusChecks: checks,
+		EnumChecks:   enums,
 		MetaProbes:   metadata,
-	})
-	if err != nil {
+		Aliases:      c.parseAliases(ctx, newName, newObj),
+		ID:           envID(newObj.GetUID()),
+		Labels:       maps.Clone(newObj.GetLabels()),
+		Owner:        c.envOwner(ctx, newObj),
+	}
+	if oldName != newName {
+		// Probes of the new name were created above
+		c.removeFromProbers(c.probeTarget(oldName, oldObj, nil))
+	}
+
+	err := c.s.UpdateEnvironment(ctx, oldName, env)
+	switch {
+	case errors.Is(err, store.ErrEnvironmentConflict):
+		// The store already logged the conflict
+		eventsProcessed.WithLabelValues(eventType, "conflict").Inc()
+	case err != nil:
 		slog.ErrorContext(ctx, "failed to update environment", "old_name", oldName, "new_name", newName, "error", err)
-		eventsProcessed.WithLabelValues("namespace_update", "error").Inc()
-	} else {
-		eventsProcessed.WithLabelValues("namespace_update", "success").Inc()
+		eventsProcessed.WithLabelValues(eventType, "error").Inc()
+	default:
+		if oldName != newName {
+			c.events.discovered(ctx, env)
+		}
+		eventsProcessed.WithLabelValues(eventType, "success").Inc()
 	}
 }
 
-func (c *EventHandler) HandleNamespaceDelete(ctx context.Context, ns *corev1.Namespace) {
-	name := ns.Labels[LabelEnvName]
+func (c *EventHandler) handleDelete(ctx context.Context, obj metav1.Object) {
+	name := obj.GetLabels()[c.keys.NameLabel]
+	eventType := string(c.source) + "_delete"
+	c.tracked.delete(c, obj)
 
-	err := c.s.DeleteEnvironment(ctx, name)
+	err := c.s.DeleteEnvironmentFrom(ctx, name, c.cluster, envNamespace(obj), c.envSource(obj))
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to delete environment", "name", name, "error", err)
-		eventsProcessed.WithLabelValues("namespace_delete", "error").Inc()
-	} else {
-		eventsProcessed.WithLabelValues("namespace_delete", "success").Inc()
+		eventsProcessed.WithLabelValues(eventType, "error").Inc()
+		return
+	}
+	eventsProcessed.WithLabelValues(eventType, "success").Inc()
+
+	// Only the claim of this object is released, probers keep the state shared with other
+	// claims of the name
+	c.removeFromProbers(c.probeTarget(name, obj, nil))
+}
+
+// removeFromProbers releases the resources the probers hold for the environment.
+func (c *EventHandler) removeFromProbers(target probe.Target) {
+	for _, prober := range c.checks {
+		prober.RemoveEnvironment(target)
+	}
+	for _, prober := range c.enums {
+		prober.RemoveEnvironment(target)
+	}
+	for _, prober := range c.metadata {
+		prober.RemoveEnvironment(target)
+	}
+}
+
+// onlyIgnoredAnnotationsChanged reports whether the labels and annotations of the objects only
+// differ in ignored annotations. Other fields do not affect the environment.
+func (c *EventHandler) onlyIgnoredAnnotationsChanged(oldObj, newObj metav1.Object) bool {
+	if c.ignoredAnnotationPrefix == "" || !maps.Equal(oldObj.GetLabels(), newObj.GetLabels()) {
+		return false
+	}
+	if maps.Equal(oldObj.GetAnnotations(), newObj.GetAnnotations()) {
+		// Resyncs rebuild the environment as before
+		return false
+	}
+
+	isIgnored := func(k string, _ string) bool { return strings.HasPrefix(k, c.ignoredAnnotationPrefix) }
+	oldAnnotations := maps.Clone(oldObj.GetAnnotations())
+	newAnnotations := maps.Clone(newObj.GetAnnotations())
+	maps.DeleteFunc(oldAnnotations, isIgnored)
+	maps.DeleteFunc(newAnnotations, isIgnored)
+	return maps.Equal(oldAnnotations, newAnnotations)
+}
+
+// envOwner returns the owner metadata of the environment if it is a string defined via
+// annotation. The store indexes environments by owner.
+func (c *EventHandler) envOwner(ctx context.Context, obj metav1.Object) string {
+	value, exists := obj.GetAnnotations()[c.keys.MetadataAnnotationPrefix+ownerMetadata]
+	if !exists {
+		return ""
+	}
+
+	owner, err := parseMetadataAnnotation(ctx, value).Value(ctx)
+	if s, ok := owner.(string); err == nil && ok {
+		return s
+	}
+	return ""
+}
+
+// parseAliases returns the valid aliases of the aliases annotation, a comma-separated list.
+// Aliases must be valid label values, so they are URL-safe.
+func (c *EventHandler) parseAliases(ctx context.Context, envName string, obj metav1.Object) []string {
+	aliases := []string{}
+	value, exists := obj.GetAnnotations()[c.keys.AliasesAnnotation]
+	if !exists {
+		return aliases
+	}
+
+	for alias := range strings.SplitSeq(value, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || alias == envName || slices.Contains(aliases, alias) {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(alias); len(errs) > 0 {
+			slog.WarnContext(ctx, "ignoring invalid environment alias", "name", envName, "alias", alias, "error", strings.Join(errs, ", "))
+			continue
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// envID returns the short ID of an environment, derived from the UID of its object (the
+// namespace for namespace sources). It is stable for the lifetime of the object and URL-safe.
+func envID(uid types.UID) string {
+	if uid == "" {
+		return ""
 	}
+
+	sum := sha256.Sum256([]byte(uid))
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:5]))
+}
+
+// envSource identifies the object of an environment within its namespace, so objects of
+// different resources with the same name are told apart.
+func (c *EventHandler) envSource(obj metav1.Object) string {
+	return c.resource + "/" + obj.GetName()
+}
+
+// envNamespace returns the namespace of an environment object. Namespaces are
+// cluster scoped, so their own name is used.
+func envNamespace(obj metav1.Object) string {
+	if ns := obj.GetNamespace(); ns != "" {
+		return ns
+	}
+
+	return obj.GetName()
 }
 
-func (c *EventHandler) buildURLMap(ctx context.Context, ns *corev1.Namespace) map[string]string {
-	urls := map[string]string{}
+// buildURLMap returns the URLs of an environment. URLs defined via annotations take
+// precedence over URL templates, which take precedence over URLs derived from routing resources.
+func (c *EventHandler) buildURLMap(ctx context.Context, obj metav1.Object) map[string]string {
+	urls := c.routes.URLs(envNamespace(obj))
+	maps.Copy(urls, c.templateURLs(ctx, obj.GetLabels()[c.keys.NameLabel], obj))
 
-	for k, v := range ns.Annotations {
-		if !strings.HasPrefix(k, AnnotationEnvURLPrefix) {
+	for k, v := range obj.GetAnnotations() {
+		if !strings.HasPrefix(k, c.keys.URLAnnotationPrefix) {
 			continue
 		}
 
 		slog.DebugContext(ctx, "found environment URL annotation", "key", k, "value", v)
 
-		urlName := strings.TrimPrefix(k, AnnotationEnvURLPrefix)
+		urlName := strings.TrimPrefix(k, c.keys.URLAnnotationPrefix)
 		urls[urlName] = v
 	}
 
 	return urls
 }
 
-func (c *EventHandler) buildStatusChecks(ctx context.Context, envName string, ns *corev1.Namespace) map[string]probe.Probe[bool] {
+func (c *EventHandler) buildStatusChecks(ctx context.Context, target probe.Target, obj metav1.Object) map[string]probe.Probe[bool] {
 	checks := make(map[string]probe.Probe[bool])
+	// Derived checks resolve their inputs from the complete map on evaluation
+	target.Checks = checks
 
-	for k, v := range ns.Annotations {
-		if !strings.HasPrefix(k, AnnotationEnvStatusCheckPrefix) {
+	for k, v := range obj.GetAnnotations() {
+		if !strings.HasPrefix(k, c.keys.StatusAnnotationPrefix) {
 			continue
 		}
 
 		slog.DebugContext(ctx, "found environment status check annotation", "key", k, "value", v)
 
-		checkName := strings.TrimPrefix(k, AnnotationEnvStatusCheckPrefix)
+		checkName := strings.TrimPrefix(k, c.keys.StatusAnnotationPrefix)
+		if _, isEnum := c.enums[checkName]; isEnum {
+			continue
+		}
 		checks[checkName] = probe.NewStaticProbe(v == "true" || v == "1")
 	}
 
@@ -132,9 +504,33 @@ func (c *EventHandler) buildStatusChecks(ctx context.Context, envName string, ns
 			continue
 		}
 
-		probe, err := prober.AddEnvironment(envName, ns.Name)
+		probe, err := prober.AddEnvironment(target)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to add environment to prober", "check", check, "env_name", target.Name, "error", err)
+			c.events.probeFailed(ctx, target.Cluster, target.Namespace, "status check", check, err)
+			continue
+		}
+		checks[check] = probe
+	}
+	return checks
+}
+
+// buildEnumChecks returns the probes of the configured enum checks. Status annotations
+// of enum checks set the value directly, it must be one of the allowed values.
+func (c *EventHandler) buildEnumChecks(ctx context.Context, target probe.Target, obj metav1.Object) map[string]probe.Probe[string] {
+	checks := make(map[string]probe.Probe[string], len(c.enums))
+
+	for check, prober := range c.enums {
+		if v, exists := obj.GetAnnotations()[c.keys.StatusAnnotationPrefix+check]; exists {
+			slog.DebugContext(ctx, "found environment enum check annotation", "check", check, "value", v)
+			checks[check] = probe.NewEnumProbe(probe.NewStaticProbe(v), prober.Values())
+			continue
+		}
+
+		probe, err := prober.AddEnvironment(target)
 		if err != nil {
-			slog.ErrorContext(ctx, "failed to add environment to prober", "check", check, "env_name", envName, "error", err)
+			slog.ErrorContext(ctx, "failed to add environment to prober", "check", check, "env_name", target.Name, "error", err)
+			c.events.probeFailed(ctx, target.Cluster, target.Namespace, "status check", check, err)
 			continue
 		}
 		checks[check] = probe
@@ -142,16 +538,16 @@ func (c *EventHandler) buildStatusChecks(ctx context.Context, envName string, ns
 	return checks
 }
 
-func (c *EventHandler) buildMetadataProbes(ctx context.Context, envName string, ns *corev1.Namespace) map[string]probe.MetadataProbe {
+func (c *EventHandler) buildMetadataProbes(ctx context.Context, target probe.Target, obj metav1.Object) map[string]probe.MetadataProbe {
 	probes := make(map[string]probe.MetadataProbe)
 
-	for k, v := range ns.Annotations {
-		if !strings.HasPrefix(k, AnnotationEnvMetadataPrefix) {
+	for k, v := range obj.GetAnnotations() {
+		if !strings.HasPrefix(k, c.keys.MetadataAnnotationPrefix) {
 			continue
 		}
 
 		slog.DebugContext(ctx, "found environment metadata annotation", "key", k, "value", v)
-		metaName := strings.TrimPrefix(k, AnnotationEnvMetadataPrefix)
+		metaName := strings.TrimPrefix(k, c.keys.MetadataAnnotationPrefix)
 		probes[metaName] = parseMetadataAnnotation(ctx, v)
 	}
 
@@ -161,9 +557,10 @@ func (c *EventHandler) buildMetadataProbes(ctx context.Context, envName string,
 			continue
 		}
 
-		probe, err := prober.AddEnvironment(envName, ns.Name)
+		probe, err := prober.AddEnvironment(target)
 		if err != nil {
-			slog.ErrorContext(ctx, "failed to add environment to metadata prober", "metadata", meta, "env_name", envName, "error", err)
+			slog.ErrorContext(ctx, "failed to add environment to metadata prober", "metadata", meta, "env_name", target.Name, "error", err)
+			c.events.probeFailed(ctx, target.Cluster, target.Namespace, "metadata", meta, err)
 			continue
 		}
 		probes[meta] = probe
@@ -171,6 +568,17 @@ func (c *EventHandler) buildMetadataProbes(ctx context.Context, envName string,
 	return probes
 }
 
+// probeTarget returns the target passed to probers for an environment object.
+func (c *EventHandler) probeTarget(envName string, obj metav1.Object, urls map[string]string) probe.Target {
+	return probe.Target{
+		URL:       urls,
+		Name:      envName,
+		Namespace: envNamespace(obj),
+		Cluster:   c.cluster,
+		Source:    c.envSource(obj),
+	}
+}
+
 // parseMetadataAnnotation tries to parse a metadata annotation as json. If it fails, it falls back to a static string probe.
 func parseMetadataAnnotation(ctx context.Context, value string) probe.MetadataProbe {
 	// Try to parse as JSON
@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"maps"
+	"slices"
 	"testing"
 	"time"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/sberz/ephemeral-envs/internal/store"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func TestEventHandlerBuildStatusChecksAnnotationOverridesProber(t *testing.T) {
@@ -17,10 +20,10 @@ func TestEventHandlerBuildStatusChecksAnnotationOverridesProber(t *testing.T) {
 	promOKProber := &recordingBoolProber{probe: probe.NewStaticProbe(true)}
 	extraProber := &recordingBoolProber{probe: probe.NewStaticProbe(true)}
 
-	h := NewEventHandler(t.Context(), store.NewStore(), map[string]probe.Prober[bool]{
+	h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), map[string]probe.Prober[bool]{
 		"prom_ok":     promOKProber,
 		"from_prober": extraProber,
-	}, nil)
+	}, nil, nil, nil)
 
 	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
 		Name: "env-a",
@@ -30,7 +33,7 @@ func TestEventHandlerBuildStatusChecksAnnotationOverridesProber(t *testing.T) {
 		},
 	}}
 
-	checks := h.buildStatusChecks(t.Context(), "a", ns)
+	checks := h.buildStatusChecks(t.Context(), probe.Target{Name: "a", Namespace: "env-a"}, ns)
 
 	promOKVal, err := checks["prom_ok"].Value(t.Context())
 	if err != nil {
@@ -63,10 +66,10 @@ func TestEventHandlerBuildMetadataProbesAnnotationOverridesProber(t *testing.T)
 	ownerProber := &recordingMetadataProber{probe: probe.WrapProbe(probe.NewStaticProbe("team-prober"))}
 	extraProber := &recordingMetadataProber{probe: probe.WrapProbe(probe.NewStaticProbe("extra"))}
 
-	h := NewEventHandler(t.Context(), store.NewStore(), nil, map[string]probe.MetadataProber{
+	h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), nil, nil, map[string]probe.MetadataProber{
 		"owner":       ownerProber,
 		"from_prober": extraProber,
-	})
+	}, nil)
 
 	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
 		Name: "env-a",
@@ -75,7 +78,7 @@ func TestEventHandlerBuildMetadataProbesAnnotationOverridesProber(t *testing.T)
 		},
 	}}
 
-	meta := h.buildMetadataProbes(t.Context(), "a", ns)
+	meta := h.buildMetadataProbes(t.Context(), probe.Target{Name: "a", Namespace: "env-a"}, ns)
 
 	ownerVal, err := meta["owner"].Value(t.Context())
 	if err != nil {
@@ -98,7 +101,8 @@ func TestEventHandlerHandleNamespaceUpdateRenameAndDelete(t *testing.T) {
 	t.Parallel()
 
 	s := store.NewStore()
-	h := NewEventHandler(t.Context(), s, nil, nil)
+	prober := &recordingBoolProber{probe: probe.NewStaticProbe(true)}
+	h := NewEventHandler(t.Context(), s, DefaultKeyConfig(), map[string]probe.Prober[bool]{"healthy": prober}, nil, nil, nil)
 
 	created := time.Unix(1_700_000_000, 0).UTC()
 	oldNS := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
@@ -135,15 +139,160 @@ func TestEventHandlerHandleNamespaceUpdateRenameAndDelete(t *testing.T) {
 	if _, err := s.GetEnvironment(t.Context(), "new"); !errors.Is(err, store.ErrEnvironmentNotFound) {
 		t.Fatalf("GetEnvironment(new) after delete error = %v, want ErrEnvironmentNotFound", err)
 	}
+
+	// The probes of the old name are released on rename, the probes of the new name on delete
+	var removed []string
+	for _, target := range prober.removed {
+		removed = append(removed, target.Name)
+	}
+	if !slices.Equal(removed, []string{"old", "new"}) {
+		t.Fatalf("removed environments = %v, want [old new]", removed)
+	}
+
+	// Deleting an object that backs no environment in the store releases nothing
+	h.HandleNamespaceDelete(t.Context(), newNS)
+	if len(prober.removed) != 2 {
+		t.Fatalf("removed environments = %d after deleting an unknown object, want 2", len(prober.removed))
+	}
+}
+
+func TestEventHandlerCustomKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := KeyConfig{
+		NameLabel:                "envs.example.com/name",
+		URLAnnotationPrefix:      "url.envs.example.com/",
+		StatusAnnotationPrefix:   "status.envs.example.com/",
+		MetadataAnnotationPrefix: "metadata.envs.example.com/",
+	}
+
+	s := store.NewStore()
+	h := NewEventHandler(t.Context(), s, keys, nil, nil, nil, nil)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "env-a",
+		CreationTimestamp: metav1.NewTime(time.Unix(1_700_000_000, 0).UTC()),
+		Labels: map[string]string{
+			"envs.example.com/name": "a",
+			LabelEnvName:            "ignored",
+		},
+		Annotations: map[string]string{
+			"url.envs.example.com/app":        "https://a.example.test",
+			AnnotationEnvURLPrefix + "legacy": "https://legacy.example.test",
+			"status.envs.example.com/active":  "true",
+			"metadata.envs.example.com/owner": `"team-a"`,
+		},
+	}}
+
+	h.HandleNamespaceAdd(t.Context(), ns)
+
+	env, err := s.GetEnvironment(t.Context(), "a")
+	if err != nil {
+		t.Fatalf("GetEnvironment(a) error = %v", err)
+	}
+
+	if len(env.URL) != 1 || env.URL["app"] != "https://a.example.test" {
+		t.Fatalf("env.URL = %#v, want only app url", env.URL)
+	}
+	if _, ok := env.StatusChecks["active"]; !ok {
+		t.Fatalf("env.StatusChecks = %#v, want key active", env.StatusChecks)
+	}
+	if _, ok := env.MetaProbes["owner"]; !ok {
+		t.Fatalf("env.MetaProbes = %#v, want key owner", env.MetaProbes)
+	}
+	if env.Owner != "team-a" {
+		t.Fatalf("env.Owner = %q, want %q", env.Owner, "team-a")
+	}
+}
+
+func TestEventHandlerAliases(t *testing.T) {
+	t.Parallel()
+
+	s := store.NewStore()
+	h := NewEventHandler(t.Context(), s, DefaultKeyConfig(), nil, nil, nil, nil)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "env-a",
+		UID:               "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+		CreationTimestamp: metav1.NewTime(time.Unix(1_700_000_000, 0).UTC()),
+		Labels:            map[string]string{LabelEnvName: "a"},
+		Annotations:       map[string]string{AnnotationEnvAliases: " pr-1, a,,Invalid Alias,pr-1 ,feature_x"},
+	}}
+	h.HandleNamespaceAdd(t.Context(), ns)
+
+	env, err := s.GetEnvironment(t.Context(), "a")
+	if err != nil {
+		t.Fatalf("GetEnvironment(a) error = %v", err)
+	}
+	if want := []string{"pr-1", "feature_x"}; !slices.Equal(env.Aliases, want) {
+		t.Fatalf("env.Aliases = %v, want %v", env.Aliases, want)
+	}
+	if len(env.ID) != 8 || env.ID != envID(ns.UID) {
+		t.Fatalf("env.ID = %q, want 8 character ID", env.ID)
+	}
+	if env.Labels[LabelEnvName] != "a" {
+		t.Fatalf("env.Labels = %v, want name label", env.Labels)
+	}
+	if got, err := s.ResolveEnvironment(t.Context(), env.ID); err != nil || got.Name != "a" {
+		t.Fatalf("ResolveEnvironment(%s) = %s, %v, want a", env.ID, got.Name, err)
+	}
+
+	// Removing the annotation removes the aliases
+	updated := ns.DeepCopy()
+	delete(updated.Annotations, AnnotationEnvAliases)
+	h.HandleNamespaceUpdate(t.Context(), ns, updated)
+
+	if _, err := s.ResolveEnvironment(t.Context(), "pr-1"); !errors.Is(err, store.ErrEnvironmentNotFound) {
+		t.Fatalf("ResolveEnvironment(pr-1) error = %v, want ErrEnvironmentNotFound", err)
+	}
+}
+
+func TestEventHandlerResourceSource(t *testing.T) {
+	t.Parallel()
+
+	s := store.NewStore()
+	base := NewEventHandler(t.Context(), s, DefaultKeyConfig(), nil, nil, nil, nil)
+	h := base.ForSource(&SourceConfig{
+		Type:      SourceTypeResource,
+		Version:   "v1",
+		Resource:  "services",
+		KeyConfig: KeyConfig{NameLabel: "preview.example.com/env", URLAnnotationPrefix: AnnotationEnvURLPrefix},
+	})
+
+	obj := &unstructured.Unstructured{}
+	obj.SetName("api")
+	obj.SetNamespace("team-a")
+	obj.SetCreationTimestamp(metav1.NewTime(time.Unix(1_700_000_000, 0).UTC()))
+	obj.SetLabels(map[string]string{"preview.example.com/env": "pr-1"})
+	obj.SetAnnotations(map[string]string{AnnotationEnvURLPrefix + "api": "https://pr-1.example.test"})
+
+	h.HandleResourceAdd(t.Context(), obj)
+
+	env, err := s.GetEnvironment(t.Context(), "pr-1")
+	if err != nil {
+		t.Fatalf("GetEnvironment(pr-1) error = %v", err)
+	}
+	if env.Namespace != "team-a" {
+		t.Fatalf("env.Namespace = %q, want namespace of the object %q", env.Namespace, "team-a")
+	}
+	if env.URL["api"] != "https://pr-1.example.test" {
+		t.Fatalf("env.URL[api] = %q, want %q", env.URL["api"], "https://pr-1.example.test")
+	}
+
+	h.HandleResourceDelete(t.Context(), obj)
+	if _, err := s.GetEnvironment(t.Context(), "pr-1"); !errors.Is(err, store.ErrEnvironmentNotFound) {
+		t.Fatalf("GetEnvironment(pr-1) after delete error = %v, want ErrEnvironmentNotFound", err)
+	}
 }
 
 type recordingBoolProber struct {
-	probe probe.Probe[bool]
-	err   error
-	calls int
+	probe   probe.Probe[bool]
+	err     error
+	removed []probe.Target
+	calls   int
 }
 
-func (r *recordingBoolProber) AddEnvironment(_, _ string) (probe.Probe[bool], error) {
+func (r *recordingBoolProber) AddEnvironment(_ probe.Target) (probe.Probe[bool], error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -151,16 +300,179 @@ func (r *recordingBoolProber) AddEnvironment(_, _ string) (probe.Probe[bool], er
 	return r.probe, nil
 }
 
+func (r *recordingBoolProber) RemoveEnvironment(env probe.Target) {
+	r.removed = append(r.removed, env)
+}
+
 type recordingMetadataProber struct {
 	probe probe.MetadataProbe
 	err   error
 	calls int
 }
 
-func (r *recordingMetadataProber) AddEnvironment(_, _ string) (probe.MetadataProbe, error) {
+func (r *recordingMetadataProber) AddEnvironment(_ probe.Target) (probe.MetadataProbe, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
 	r.calls++
 	return r.probe, nil
 }
+
+func (r *recordingMetadataProber) RemoveEnvironment(_ probe.Target) {}
+
+func TestEventHandlerBuildURLMapTemplates(t *testing.T) {
+	t.Parallel()
+
+	templates, err := setupURLTemplates(&serviceConfig{URLs: map[string]string{
+		"api":  "https://api-{{.name}}.preview.example.com",
+		"docs": "https://docs-{{.name}}.preview.example.com",
+		"team": "https://{{.labels.team}}.example.com/{{.namespace}}",
+	}})
+	if err != nil {
+		t.Fatalf("setupURLTemplates() error = %v", err)
+	}
+
+	h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), nil, nil, nil, templates)
+
+	tests := []struct {
+		labels      map[string]string
+		annotations map[string]string
+		want        map[string]string
+		name        string
+	}{
+		{
+			name:   "templates rendered",
+			labels: map[string]string{LabelEnvName: "a", "team": "mobile"},
+			want: map[string]string{
+				"api":  "https://api-a.preview.example.com",
+				"docs": "https://docs-a.preview.example.com",
+				"team": "https://mobile.example.com/env-a",
+			},
+		},
+		{
+			name:        "annotation overrides template and missing label skips template",
+			labels:      map[string]string{LabelEnvName: "a"},
+			annotations: map[string]string{AnnotationEnvURLPrefix + "docs": "https://docs.example.com"},
+			want: map[string]string{
+				"api":  "https://api-a.preview.example.com",
+				"docs": "https://docs.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "env-a", Labels: tt.labels, Annotations: tt.annotations}}
+			got := h.buildURLMap(t.Context(), ns)
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("buildURLMap() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventHandlerDerivedCheckInputs(t *testing.T) {
+	t.Parallel()
+
+	derived, err := probe.NewDerivedProber(probe.DerivedConfig{Expr: "active && healthy && meta.pods > 0"})
+	if err != nil {
+		t.Fatalf("NewDerivedProber() error = %v", err)
+	}
+
+	h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), map[string]probe.Prober[bool]{
+		"ready":   derived,
+		"healthy": &recordingBoolProber{probe: probe.NewStaticProbe(true)},
+	}, nil, nil, nil)
+
+	tests := []struct {
+		annotations map[string]string
+		name        string
+		want        bool
+	}{
+		{
+			name:        "all inputs true",
+			annotations: map[string]string{AnnotationEnvStatusCheckPrefix + "active": "true", AnnotationEnvMetadataPrefix + "pods": "2"},
+			want:        true,
+		},
+		{
+			name:        "metadata input false",
+			annotations: map[string]string{AnnotationEnvStatusCheckPrefix + "active": "true", AnnotationEnvMetadataPrefix + "pods": "0"},
+			want:        false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "env-a", Annotations: tt.annotations}}
+			target := probe.Target{Name: "a", Namespace: "env-a"}
+			target.Metadata = h.buildMetadataProbes(t.Context(), target, ns)
+			checks := h.buildStatusChecks(t.Context(), target, ns)
+
+			got, err := checks["ready"].Value(t.Context())
+			if err != nil {
+				t.Fatalf("ready Value() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("ready = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+type staticStringProber struct {
+	value string
+}
+
+func (p staticStringProber) AddEnvironment(_ probe.Target) (probe.Probe[string], error) {
+	return probe.NewStaticProbe(p.value), nil
+}
+
+func (p staticStringProber) RemoveEnvironment(_ probe.Target) {}
+
+func TestEventHandlerBuildEnumChecks(t *testing.T) {
+	t.Parallel()
+
+	values := []string{"pending", "running"}
+	phase, err := probe.NewEnumProber(staticStringProber{value: "running"}, values)
+	if err != nil {
+		t.Fatalf("NewEnumProber() error = %v", err)
+	}
+	stage, err := probe.NewEnumProber(staticStringProber{value: "running"}, values)
+	if err != nil {
+		t.Fatalf("NewEnumProber() error = %v", err)
+	}
+
+	h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), nil, map[string]*probe.EnumProber{
+		"phase": phase,
+		"stage": stage,
+	}, nil, nil)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "env-a",
+		Annotations: map[string]string{
+			AnnotationEnvStatusCheckPrefix + "stage": "pending",
+		},
+	}}
+	target := probe.Target{Name: "a", Namespace: "env-a"}
+
+	enums := h.buildEnumChecks(t.Context(), target, ns)
+	want := map[string]string{"phase": "running", "stage": "pending"}
+	for name, wantValue := range want {
+		got, err := enums[name].Value(t.Context())
+		if err != nil {
+			t.Fatalf("%s Value() error = %v", name, err)
+		}
+		if got != wantValue {
+			t.Fatalf("%s = %q, want %q", name, got, wantValue)
+		}
+	}
+
+	// Annotations of enum checks must not create boolean checks
+	if _, exists := h.buildStatusChecks(t.Context(), target, ns)["stage"]; exists {
+		t.Fatal("buildStatusChecks() contains enum check stage")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/ignition"
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	promAPI "github.com/sberz/ephemeral-envs/internal/prometheus"
+)
+
+// Status of the service and its components in the readiness response.
+const (
+	componentOK       = "ok"
+	componentFailed   = "failed"
+	componentDegraded = "degraded"
+	serviceNotReady   = "not ready"
+)
+
+// healthReporter reports whether each datasource is connected.
+type healthReporter interface {
+	Health() map[string]bool
+}
+
+// readiness collects the health of the components the service depends on. The service is
+// ready once the initial sync of all watches is complete and no watch is failing.
+// Disconnected datasources and an unhealthy ignition provider are reported as degraded,
+// as the service still serves environments without them.
+type readiness struct {
+	datasources healthReporter
+	ignition    ignition.Provider
+	leadership  *kube.Leadership
+	watches     map[string]*kube.WatchHealth
+	mu          sync.RWMutex
+	synced      atomic.Bool
+}
+
+func newReadiness(datasources healthReporter, ignitionProvider ignition.Provider, leadership *kube.Leadership) *readiness {
+	return &readiness{
+		datasources: datasources,
+		ignition:    ignitionProvider,
+		leadership:  leadership,
+		watches:     make(map[string]*kube.WatchHealth),
+	}
+}
+
+// watch registers a watch of the given cluster and resource and returns its health.
+func (r *readiness) watch(cluster string, resource string) *kube.WatchHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := "watch:" + resource
+	if cluster != "" {
+		name = "watch:" + cluster + "/" + resource
+	}
+	// The same resource can be watched with different selectors
+	unique := name
+	for i := 2; r.watches[unique] != nil; i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+
+	h := kube.NewWatchHealth(unique)
+	r.watches[unique] = h
+	return h
+}
+
+// markSynced marks the initial sync of all clusters as complete.
+func (r *readiness) markSynced() {
+	r.synced.Store(true)
+}
+
+type componentStatus struct {
+	// LastEventAge is the time since the last event of a watch.
+	LastEventAge string `json:"lastEventAge,omitempty"`
+	// Role is the role of this replica in the leader election (`leader` or `follower`).
+	Role   string `json:"role,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Status string `json:"status"`
+}
+
+type readyzResponse struct {
+	Components map[string]componentStatus `json:"components,omitempty"`
+	Status     string                     `json:"status"`
+}
+
+// check evaluates the health of all components.
+func (r *readiness) check(ctx context.Context) readyzResponse {
+	res := readyzResponse{
+		Status:     componentOK,
+		Components: make(map[string]componentStatus),
+	}
+	if !r.synced.Load() {
+		res.Status = serviceNotReady
+		res.Components["sync"] = componentStatus{Status: componentFailed, Error: kube.ErrNotSynced.Error()}
+	}
+
+	r.mu.RLock()
+	for name, h := range r.watches {
+		status := componentStatus{Status: componentOK}
+		if last := h.LastEvent(); !last.IsZero() {
+			status.LastEventAge = time.Since(last).Round(time.Second).String()
+		}
+		if err := h.Err(); err != nil {
+			status.Status = componentFailed
+			status.Error = err.Error()
+			res.Status = serviceNotReady
+		}
+		res.Components[name] = status
+	}
+	r.mu.RUnlock()
+
+	degrade := func(name string, err error) {
+		if err == nil {
+			res.Components[name] = componentStatus{Status: componentOK}
+			return
+		}
+		res.Components[name] = componentStatus{Status: componentDegraded, Error: err.Error()}
+		if res.Status == componentOK {
+			res.Status = componentDegraded
+		}
+	}
+
+	if r.datasources != nil {
+		for name, connected := range r.datasources.Health() {
+			var err error
+			if !connected {
+				err = promAPI.ErrUnavailable
+			}
+			degrade("datasource:"+name, err)
+		}
+	}
+	if r.ignition != nil {
+		degrade("ignition", ignition.CheckHealth(ctx, r.ignition))
+	}
+	if r.leadership != nil && r.leadership.Enabled() {
+		role := "follower"
+		if r.leadership.IsLeader() {
+			role = "leader"
+		}
+		res.Components["leaderElection"] = componentStatus{Status: componentOK, Role: role}
+	}
+
+	return res
+}
+
+// handleLivez reports that the service is running. It does not check any dependencies, so
+// a failing dependency does not cause restarts.
+func handleLivez() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mustEncodeResponse(w, r, http.StatusOK, map[string]string{
+			"status": componentOK,
+		})
+	})
+}
+
+// handleReadyz reports whether the service is ready to serve environments. With the
+// `verbose` query parameter, the status of each component is included.
+func handleReadyz(ready *readiness) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := ready.check(r.Context())
+
+		code := http.StatusOK
+		if res.Status == serviceNotReady {
+			code = http.StatusServiceUnavailable
+		}
+		if !r.URL.Query().Has("verbose") {
+			res.Components = nil
+		}
+
+		mustEncodeResponse(w, r, code, res)
+	})
+}
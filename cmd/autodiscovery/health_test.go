@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+)
+
+var errIgnitionUnavailable = errors.New("ignition backend unavailable")
+
+type unhealthyIgnitionProvider struct {
+	testIgnitionProvider
+}
+
+func (*unhealthyIgnitionProvider) Healthy(context.Context) error {
+	return errIgnitionUnavailable
+}
+
+func TestHandleReadyz(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		setup          func(r *readiness)
+		datasources    healthReporter
+		wantComponents map[string]string
+		url            string
+		wantStatus     string
+		wantCode       int
+	}{
+		"not synced": {
+			url:        "/readyz",
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: serviceNotReady,
+		},
+		"synced": {
+			url:        "/readyz",
+			setup:      func(r *readiness) { r.markSynced() },
+			wantCode:   http.StatusOK,
+			wantStatus: componentOK,
+		},
+		"watch not synced": {
+			url: "/readyz?verbose",
+			setup: func(r *readiness) {
+				r.watch("", "namespaces")
+				r.markSynced()
+			},
+			wantCode:       http.StatusServiceUnavailable,
+			wantStatus:     serviceNotReady,
+			wantComponents: map[string]string{"watch:namespaces": componentFailed},
+		},
+		"disconnected datasource": {
+			url:            "/readyz?verbose",
+			datasources:    testHealthReporter{"default": true, "thanos": false},
+			setup:          func(r *readiness) { r.markSynced() },
+			wantCode:       http.StatusOK,
+			wantStatus:     componentDegraded,
+			wantComponents: map[string]string{"datasource:default": componentOK, "datasource:thanos": componentDegraded},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ready := newReadiness(tt.datasources, nil, nil)
+			if tt.setup != nil {
+				tt.setup(ready)
+			}
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+
+			handleReadyz(ready).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var got readyzResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status field = %q, want %q", got.Status, tt.wantStatus)
+			}
+			for component, want := range tt.wantComponents {
+				if got.Components[component].Status != want {
+					t.Errorf("components[%s] = %#v, want status %q", component, got.Components[component], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessIgnition(t *testing.T) {
+	t.Parallel()
+
+	ready := newReadiness(nil, &unhealthyIgnitionProvider{}, nil)
+	ready.markSynced()
+
+	res := ready.check(t.Context())
+	if res.Status != componentDegraded {
+		t.Fatalf("status = %q, want %q", res.Status, componentDegraded)
+	}
+	if got := res.Components["ignition"]; got.Error != errIgnitionUnavailable.Error() {
+		t.Fatalf("components[ignition] = %#v, want error %q", got, errIgnitionUnavailable)
+	}
+}
+
+func TestReadinessLeaderElection(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		leadership *kube.Leadership
+		wantRole   string
+	}{
+		"disabled": {leadership: kube.NewLeadership(false)},
+		"follower": {leadership: kube.NewLeadership(true), wantRole: "follower"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ready := newReadiness(nil, nil, tt.leadership)
+			ready.markSynced()
+
+			res := ready.check(t.Context())
+			if got := res.Components["leaderElection"].Role; got != tt.wantRole {
+				t.Fatalf("leaderElection role = %q, want %q", got, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestReadinessWatchNames(t *testing.T) {
+	t.Parallel()
+
+	ready := newReadiness(nil, nil, nil)
+	names := []string{
+		ready.watch("", "namespaces").Name,
+		ready.watch("", "namespaces").Name,
+		ready.watch("prod", "deployments.apps").Name,
+	}
+
+	want := []string{"watch:namespaces", "watch:namespaces#2", "watch:prod/deployments.apps"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("watch name %d = %q, want %q", i, names[i], want[i])
+		}
+	}
+}
+
+func TestHandleLivez(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/livez", nil)
+	rec := httptest.NewRecorder()
+
+	handleLivez().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -5,15 +5,16 @@ import (
 	"fmt"
 
 	"github.com/sberz/ephemeral-envs/internal/ignition"
+	"github.com/sberz/ephemeral-envs/internal/state"
 )
 
-func setupIgnitionProvider(_ context.Context, cfg *serviceConfig) (ignition.Provider, error) {
+func setupIgnitionProvider(ctx context.Context, cfg *serviceConfig, backend state.Backend) (ignition.Provider, error) {
 	providerCfg := &ignition.ProviderConfig{Type: ignition.ProviderTypePrometheus}
 	if cfg.Ignition != nil && !cfg.Ignition.IsZero() {
 		providerCfg = cfg.Ignition
 	}
 
-	provider, err := ignition.NewProvider(providerCfg)
+	provider, err := ignition.NewProvider(ctx, providerCfg, backend)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize ignition provider: %w", err)
 	}
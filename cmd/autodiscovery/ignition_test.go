@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/sberz/ephemeral-envs/internal/ignition"
+	"github.com/sberz/ephemeral-envs/internal/state"
 )
 
 func TestSetupIgnitionProvider(t *testing.T) {
@@ -32,7 +33,7 @@ func TestSetupIgnitionProvider(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			provider, err := setupIgnitionProvider(t.Context(), tt.cfg)
+			provider, err := setupIgnitionProvider(t.Context(), tt.cfg, state.NewMemory())
 			if tt.wantErr {
 				if err == nil {
 					t.Fatal("setupIgnitionProvider() error = nil, want non-nil")
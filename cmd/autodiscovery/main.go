@@ -17,14 +17,24 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sberz/ephemeral-envs/internal/kube"
 	"github.com/sberz/ephemeral-envs/internal/store"
+	"k8s.io/client-go/kubernetes"
 )
 
+// Default label and annotation keys. They can be overridden with the discovery
+// section of the config file.
 const (
 	LabelEnvName = "envs.sberz.de/name"
 
 	AnnotationEnvURLPrefix         = "url.envs.sberz.de/"
 	AnnotationEnvStatusCheckPrefix = "status.envs.sberz.de/"
 	AnnotationEnvMetadataPrefix    = "metadata.envs.sberz.de/"
+	AnnotationEnvAliases           = "aliases.envs.sberz.de"
+
+	// LabelURLName names the URL derived from an Ingress or HTTPRoute.
+	LabelURLName = "envs.sberz.de/url-name"
+
+	// AnnotationObservedPrefix is the prefix of the annotations written by the write-back.
+	AnnotationObservedPrefix = "observed.envs.sberz.de/"
 )
 
 var logLevel = &slog.LevelVar{}
@@ -34,6 +44,10 @@ var (
 		Name: "ephemeralenv_environments",
 		Help: "Total number of discovered environments",
 	}
+	leaderOpt = prometheus.GaugeOpts{
+		Name: "ephemeralenv_leader",
+		Help: "Whether this replica is the leader (1) or not (0)",
+	}
 )
 
 func main() {
@@ -45,6 +59,16 @@ func main() {
 	}
 }
 
+// clusterClient returns the client of the named cluster, defaulting to the first cluster.
+func clusterClient(clusters []*kube.Clients, cluster string) kubernetes.Interface {
+	for _, c := range clusters {
+		if c.Cluster == cluster {
+			return c.Kubernetes
+		}
+	}
+	return clusters[0].Kubernetes
+}
+
 func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 	logger := slog.New(slog.NewJSONHandler(stdout, &slog.HandlerOptions{
 		AddSource: false,
@@ -64,51 +88,92 @@ func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 
 	slog.DebugContext(ctx, "starting autodiscovery service", "args", args)
 
-	slog.DebugContext(ctx, "setting up Kubernetes client")
-	clientset, err := kube.GetClient()
-	if err != nil {
-		return fmt.Errorf("failed to get Kubernetes client: %w", err)
+	clusters := make([]*kube.Clients, 0, len(cfg.Clusters))
+	for _, clusterCfg := range cfg.Clusters {
+		slog.DebugContext(ctx, "setting up Kubernetes client", "cluster", clusterCfg.Name)
+		clients, err := kube.NewClients(clusterCfg)
+		if err != nil {
+			return fmt.Errorf("failed to get Kubernetes client for cluster %q: %w", clusterCfg.Name, err)
+		}
+		clusters = append(clusters, clients)
 	}
 
 	envStore := store.NewStore()
+	envStore.SetConflictPolicy(cfg.Discovery.ConflictPolicy)
 
 	promauto.NewGaugeFunc(envTotalOpt, func() float64 {
 		return float64(envStore.GetEnvironmentCount(ctx))
 	})
 
-	statusChecks, metadataProbers, err := setupProbers(ctx, cfg)
+	backend, err := setupState(ctx, cfg, clusters)
+	if err != nil {
+		return fmt.Errorf("failed to set up state backend: %w", err)
+	}
+
+	datasources, snapshot, err := setupDatasources(ctx, cfg, backend)
+	if err != nil {
+		return fmt.Errorf("failed to set up datasources: %w", err)
+	}
+
+	statusChecks, enumChecks, metadataProbers, err := setupProbers(ctx, cfg, clusters, datasources, backend)
 	if err != nil {
 		return fmt.Errorf("failed to set up probers: %w", err)
 	}
 
-	ignitionProvider, err := setupIgnitionProvider(ctx, cfg)
+	ignitionProvider, err := setupIgnitionProvider(ctx, cfg, backend)
 	if err != nil {
 		return fmt.Errorf("failed to set up ignition provider: %w", err)
 	}
 
-	slog.DebugContext(ctx, "watching namespace events")
-	controller := NewEventHandler(ctx, envStore, statusChecks, metadataProbers)
-	err = kube.WatchNamespaceEvents(
-		ctx,
-		clientset,
-		LabelEnvName,
-		controller.HandleNamespaceAdd,
-		controller.HandleNamespaceUpdate,
-		controller.HandleNamespaceDelete,
-	)
+	recorders := setupEventRecorders(ctx, cfg, clusters)
+
+	auditor, closeAudit, err := setupAudit(ctx, cfg, recorders)
+	if err != nil {
+		return fmt.Errorf("failed to set up audit log: %w", err)
+	}
+	defer func() {
+		if err := closeAudit(); err != nil {
+			slog.ErrorContext(ctx, "failed to close audit log", "error", err)
+		}
+	}()
+
+	urlTemplates, err := setupURLTemplates(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to watch namespace events: %w", err)
+		return fmt.Errorf("failed to set up URL templates: %w", err)
 	}
 
-	slog.InfoContext(ctx, "initial sync complete, waiting for events", "env_count", envStore.GetEnvironmentCount(ctx))
+	leadership := kube.NewLeadership(cfg.LeaderElection.Enabled)
+	controller := NewEventHandler(ctx, envStore, cfg.Discovery.KeyConfig, statusChecks, enumChecks, metadataProbers, urlTemplates)
+	if cfg.Discovery.KubernetesEvents {
+		events := newEnvEvents(recorders, leadership.IsLeader)
+		controller.UseEvents(events)
+		envStore.OnConflict(events.conflict)
+	}
+	promauto.NewGaugeFunc(leaderOpt, func() float64 {
+		if leadership.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+
+	if snapshot != nil {
+		leadership.OnLeading(func(ctx context.Context) { snapshot.Run(ctx, cfg.Snapshot.Interval) })
+	}
 
-	// Start the HTTP server
+	if cfg.WriteBack.Enabled {
+		controller.IgnoreAnnotations(cfg.WriteBack.AnnotationPrefix)
+		leadership.OnLeading(newWriteBack(envStore, clusters, cfg.WriteBack).Run)
+	}
+
+	ready := newReadiness(datasources, ignitionProvider, leadership)
+
+	// Start the HTTP server before the initial sync, so the readiness is reported while syncing
 	slog.DebugContext(ctx, "starting HTTP server", "port", cfg.Port)
 	errLogger := slog.NewLogLogger(logger.Handler(), slog.LevelError)
 
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      NewServerHandler(envStore, ignitionProvider),
+		Handler:      NewServerHandler(envStore, ignitionProvider, newAPISchema(cfg), ready, auditor),
 		ErrorLog:     errLogger,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -143,6 +208,19 @@ func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		}()
 	}
 
+	for _, clients := range clusters {
+		if err := watchCluster(ctx, clients, &cfg.Discovery, controller, ready); err != nil {
+			return fmt.Errorf("cluster %q: %w", clients.Cluster, err)
+		}
+	}
+	ready.markSynced()
+
+	// Singleton work depends on the synced store, so the election starts after the initial sync
+	if err := leadership.Run(ctx, clusterClient(clusters, cfg.LeaderElection.Cluster), cfg.LeaderElection); err != nil {
+		return fmt.Errorf("failed to start leader election: %w", err)
+	}
+
+	slog.InfoContext(ctx, "initial sync complete, waiting for events", "env_count", envStore.GetEnvironmentCount(ctx))
 	slog.InfoContext(ctx, "autodiscovery service started", "address", server.Addr)
 
 	select {
@@ -5,40 +5,147 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/sberz/ephemeral-envs/internal/kube"
 	"github.com/sberz/ephemeral-envs/internal/probe"
 	promAPI "github.com/sberz/ephemeral-envs/internal/prometheus"
+	"github.com/sberz/ephemeral-envs/internal/state"
+	"k8s.io/client-go/kubernetes"
 )
 
-// setupProbers initializes status check and metadata probers from configuration.
-func setupProbers(ctx context.Context, cfg *serviceConfig) (map[string]probe.Prober[bool], map[string]probe.MetadataProber, error) {
-	statusChecks := make(map[string]probe.Prober[bool])
-	metadata := make(map[string]probe.MetadataProber)
+// setupDatasources creates the clients of all configured Prometheus datasources. Unreachable
+// datasources are reconnected in the background, until then their checks are unavailable.
+// If the snapshot is enabled, queries are seeded with the last known results from backend. The
+// returned snapshot is nil if it is disabled; it must be run to store the results.
+func setupDatasources(ctx context.Context, cfg *serviceConfig, backend state.Backend) (*promAPI.Registry, *promAPI.Snapshot, error) {
+	datasources := mergeDatasources(cfg.Prometheus, cfg.Datasources)
+	for name, ds := range datasources {
+		slog.DebugContext(ctx, "setting up Prometheus client", "datasource", name, "url", ds.Address)
+	}
 
-	if len(cfg.Prometheus.Address) == 0 {
-		return statusChecks, metadata, nil
+	registry, err := promAPI.NewRegistry(ctx, datasources)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Prometheus clients: %w", err)
 	}
 
-	slog.DebugContext(ctx, "setting up Prometheus client", "url", cfg.Prometheus.Address)
-	prometheus, err := promAPI.NewPrometheus(ctx, cfg.Prometheus)
+	if !cfg.Snapshot.Enabled {
+		return registry, nil, nil
+	}
+
+	snapshot, err := promAPI.NewSnapshot(ctx, backend)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create Prometheus client: %w", err)
+		return nil, nil, fmt.Errorf("failed to set up snapshot: %w", err)
+	}
+	registry.UseSnapshot(snapshot)
+
+	return registry, snapshot, nil
+}
+
+// setupProbers initializes status check, enum check and metadata probers from configuration.
+// Prometheus probers are skipped if their datasource is not configured. The values of status
+// and enum checks are shared with other replicas through backend.
+func setupProbers(ctx context.Context, cfg *serviceConfig, clusters []*kube.Clients, registry *promAPI.Registry, backend state.Backend) (map[string]probe.Prober[bool], map[string]*probe.EnumProber, map[string]probe.MetadataProber, error) {
+	statusChecks := make(map[string]probe.Prober[bool])
+	enumChecks := make(map[string]*probe.EnumProber)
+	metadata := make(map[string]probe.MetadataProber)
+
+	clients := make(map[string]kubernetes.Interface, len(clusters))
+	for _, c := range clusters {
+		clients[c.Cluster] = c.Kubernetes
 	}
+	watcher := probe.NewKubernetesWatcher(ctx, clients)
+	httpClient := probe.NewHTTPClient(cfg.HTTPClient)
+	maxAge := cfg.State.MaxAge
 
 	for name, cfg := range cfg.StatusChecks {
-		prober, err := probe.NewPrometheusProber(ctx, prometheus, *cfg, probe.PromValToBool)
+		transforms, err := cfg.Convert.Transforms()
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create Prometheus prober for check %q: %w", name, err)
+			return nil, nil, nil, fmt.Errorf("invalid convert config for check %q: %w", name, err)
+		}
+
+		prometheus := registry.Get(cfg.Datasource)
+
+		if len(cfg.Values) > 0 {
+			var prober probe.Prober[string]
+			converter := probe.Chain(probe.PromValToString, transforms...)
+			switch {
+			case cfg.Kind == probe.KindKubernetes:
+				prober, err = probe.NewKubernetesProber(watcher, cfg.KubernetesConfig, converter)
+			case cfg.Kind == probe.KindHTTP:
+				prober, err = probe.NewHTTPProber(httpClient, cfg.QueryConfig, cfg.HTTPConfig, converter)
+			case prometheus != nil:
+				prober, err = probe.NewPrometheusProber(ctx, prometheus, cfg.QueryConfig, converter)
+			default:
+				slog.WarnContext(ctx, "no Prometheus address configured, skipping status check", "check", name)
+				continue
+			}
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to create prober for check %q: %w", name, err)
+			}
+			prober, err = probe.NewSharedProber(prober, backend, name, maxAge)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to create prober for check %q: %w", name, err)
+			}
+			enumChecks[name], err = probe.NewEnumProber(prober, cfg.Values)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to create prober for check %q: %w", name, err)
+			}
+			continue
+		}
+
+		converter := probe.Chain(probe.PromValToBool, transforms...)
+
+		var prober probe.Prober[bool]
+		switch {
+		case cfg.Kind == probe.KindKubernetes:
+			prober, err = probe.NewKubernetesProber(watcher, cfg.KubernetesConfig, converter)
+		case cfg.Kind == probe.KindHTTP:
+			prober, err = probe.NewHTTPProber(httpClient, cfg.QueryConfig, cfg.HTTPConfig, converter)
+		case cfg.Kind == probe.KindDerived:
+			prober, err = probe.NewDerivedProber(cfg.DerivedConfig)
+		case prometheus != nil:
+			prober, err = probe.NewPrometheusProber(ctx, prometheus, cfg.QueryConfig, converter)
+		default:
+			slog.WarnContext(ctx, "no Prometheus address configured, skipping status check", "check", name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create prober for check %q: %w", name, err)
+		}
+		if cfg.Kind != probe.KindDerived {
+			// Derived checks are computed from the shared values of their inputs
+			prober, err = probe.NewSharedProber(prober, backend, name, maxAge)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to create prober for check %q: %w", name, err)
+			}
 		}
 		statusChecks[name] = prober
 	}
 
 	for name, metaCfg := range cfg.Metadata {
-		prober, err := probe.NewPrometheusMetadataProber(ctx, prometheus, metaCfg.Type, metaCfg.QueryConfig)
+		transforms, err := metaCfg.Convert.Transforms()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid convert config for metadata %q: %w", name, err)
+		}
+
+		prometheus := registry.Get(metaCfg.Datasource)
+
+		var prober probe.MetadataProber
+		switch {
+		case metaCfg.Kind == probe.KindKubernetes:
+			prober, err = probe.NewKubernetesMetadataProber(watcher, metaCfg.Type, metaCfg.KubernetesConfig, transforms...)
+		case metaCfg.Kind == probe.KindHTTP:
+			prober, err = probe.NewHTTPMetadataProber(httpClient, metaCfg.Type, metaCfg.QueryConfig, metaCfg.HTTPConfig, transforms...)
+		case prometheus != nil:
+			prober, err = probe.NewPrometheusMetadataProber(ctx, prometheus, metaCfg.Type, metaCfg.QueryConfig, transforms...)
+		default:
+			slog.WarnContext(ctx, "no Prometheus address configured, skipping metadata", "metadata", name)
+			continue
+		}
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create metadata prober for %q: %w", name, err)
+			return nil, nil, nil, fmt.Errorf("failed to create metadata prober for %q: %w", name, err)
 		}
 		metadata[name] = prober
 	}
 
-	return statusChecks, metadata, nil
+	return statusChecks, enumChecks, metadata, nil
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var httpRouteGVR = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// routeURLs keeps the URLs derived from the routing resources of a cluster.
+type routeURLs struct {
+	// onChange is called after the URLs of a namespace changed.
+	onChange func(ctx context.Context, namespace string)
+	// urls maps namespace -> routing resource -> URL name -> URL.
+	urls map[string]map[string]map[string]string
+	cfg  RoutesConfig
+	mu   sync.RWMutex
+}
+
+func newRouteURLs(cfg RoutesConfig) *routeURLs {
+	return &routeURLs{
+		cfg:  cfg,
+		urls: make(map[string]map[string]map[string]string),
+	}
+}
+
+// URLs returns the URLs derived for a namespace. If multiple routing resources
+// define the same URL name, the resource sorted first by kind and name wins.
+func (r *routeURLs) URLs(namespace string) map[string]string {
+	urls := map[string]string{}
+	if r == nil {
+		return urls
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byResource := r.urls[namespace]
+	for _, key := range slices.Backward(slices.Sorted(maps.Keys(byResource))) {
+		maps.Copy(urls, byResource[key])
+	}
+
+	return urls
+}
+
+// set stores the URLs of a routing resource. Empty urls remove the resource.
+func (r *routeURLs) set(ctx context.Context, namespace string, key string, urls map[string]string) {
+	r.mu.Lock()
+	byResource := r.urls[namespace]
+	if maps.Equal(byResource[key], urls) {
+		r.mu.Unlock()
+		return
+	}
+
+	if len(urls) == 0 {
+		delete(byResource, key)
+		if len(byResource) == 0 {
+			delete(r.urls, namespace)
+		}
+	} else {
+		if byResource == nil {
+			byResource = make(map[string]map[string]string)
+			r.urls[namespace] = byResource
+		}
+		byResource[key] = urls
+	}
+	r.mu.Unlock()
+
+	slog.DebugContext(ctx, "routing URLs changed", "namespace", namespace, "resource", key, "urls", urls)
+
+	if r.onChange != nil {
+		r.onChange(ctx, namespace)
+	}
+}
+
+func (r *routeURLs) HandleIngress(ctx context.Context, ing *networkingv1.Ingress) {
+	r.set(ctx, ing.Namespace, "ingress/"+ing.Name, ingressURLs(ing, r.cfg.NameLabel))
+}
+
+func (r *routeURLs) HandleIngressDelete(ctx context.Context, ing *networkingv1.Ingress) {
+	r.set(ctx, ing.Namespace, "ingress/"+ing.Name, nil)
+}
+
+func (r *routeURLs) HandleHTTPRoute(ctx context.Context, obj *unstructured.Unstructured) {
+	r.set(ctx, obj.GetNamespace(), "httproute/"+obj.GetName(), httpRouteURLs(ctx, obj, r.cfg.NameLabel, r.cfg.HTTPRouteScheme))
+}
+
+func (r *routeURLs) HandleHTTPRouteUpdate(ctx context.Context, _, newObj *unstructured.Unstructured) {
+	r.HandleHTTPRoute(ctx, newObj)
+}
+
+func (r *routeURLs) HandleHTTPRouteDelete(ctx context.Context, obj *unstructured.Unstructured) {
+	r.set(ctx, obj.GetNamespace(), "httproute/"+obj.GetName(), nil)
+}
+
+// ingressURLs derives one URL per host of an Ingress. The scheme is https if the
+// host is listed in the TLS section, the path is the first path of the rule.
+func ingressURLs(ing *networkingv1.Ingress, nameLabel string) map[string]string {
+	tlsHosts := map[string]bool{}
+	for _, tls := range ing.Spec.TLS {
+		for _, host := range tls.Hosts {
+			tlsHosts[host] = true
+		}
+	}
+
+	var hosts []string
+	paths := map[string]string{}
+	for _, rule := range ing.Spec.Rules {
+		if !usableHost(rule.Host) || slices.Contains(hosts, rule.Host) {
+			continue
+		}
+
+		hosts = append(hosts, rule.Host)
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			paths[rule.Host] = rule.HTTP.Paths[0].Path
+		}
+	}
+
+	urls := map[string]string{}
+	base := routeName(ing, nameLabel)
+	for i, host := range hosts {
+		scheme := "http"
+		if tlsHosts[host] {
+			scheme = "https"
+		}
+		urls[indexedName(base, i)] = routeURL(scheme, host, paths[host])
+	}
+
+	return urls
+}
+
+// httpRoute contains the fields of a Gateway API HTTPRoute used for URL discovery.
+type httpRoute struct {
+	Spec struct {
+		Hostnames []string `json:"hostnames"`
+		Rules     []struct {
+			Matches []struct {
+				Path *struct {
+					Type  string `json:"type"`
+					Value string `json:"value"`
+				} `json:"path"`
+			} `json:"matches"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// httpRouteURLs derives one URL per hostname of an HTTPRoute. The path is the first
+// exact or prefix path match of the route.
+func httpRouteURLs(ctx context.Context, obj *unstructured.Unstructured, nameLabel string, scheme string) map[string]string {
+	var route httpRoute
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &route); err != nil {
+		slog.WarnContext(ctx, "failed to parse HTTPRoute", "namespace", obj.GetNamespace(), "name", obj.GetName(), "error", err)
+		return nil
+	}
+
+	path := ""
+	for _, rule := range route.Spec.Rules {
+		for _, match := range rule.Matches {
+			if match.Path != nil && match.Path.Type != "RegularExpression" {
+				path = match.Path.Value
+				break
+			}
+		}
+		if path != "" {
+			break
+		}
+	}
+
+	urls := map[string]string{}
+	base := routeName(obj, nameLabel)
+	i := 0
+	for _, host := range route.Spec.Hostnames {
+		if !usableHost(host) {
+			continue
+		}
+		urls[indexedName(base, i)] = routeURL(scheme, host, path)
+		i++
+	}
+
+	return urls
+}
+
+// usableHost reports whether a URL can be built for the host. Wildcard hosts are skipped.
+func usableHost(host string) bool {
+	return host != "" && !strings.HasPrefix(host, "*")
+}
+
+// routeName returns the URL name of a routing resource.
+func routeName(obj metav1.Object, nameLabel string) string {
+	return cmp.Or(obj.GetLabels()[nameLabel], obj.GetName())
+}
+
+// indexedName returns base for the first URL of a resource and base-<i> for further ones.
+func indexedName(base string, i int) string {
+	if i == 0 {
+		return base
+	}
+
+	return base + "-" + strconv.Itoa(i)
+}
+
+func routeURL(scheme string, host string, path string) string {
+	if path == "/" {
+		path = ""
+	}
+
+	u := url.URL{Scheme: scheme, Host: host, Path: path}
+	return u.String()
+}
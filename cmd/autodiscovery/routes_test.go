@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIngressURLs(t *testing.T) {
+	t.Parallel()
+
+	paths := func(path string) *networkingv1.HTTPIngressRuleValue {
+		return &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{Path: path}}}
+	}
+
+	tests := []struct {
+		want   map[string]string
+		labels map[string]string
+		name   string
+		spec   networkingv1.IngressSpec
+	}{
+		{
+			name: "tls host with path",
+			spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: "a.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: paths("/app")}}},
+				TLS:   []networkingv1.IngressTLS{{Hosts: []string{"a.example.com"}}},
+			},
+			want: map[string]string{"web": "https://a.example.com/app"},
+		},
+		{
+			name: "root path and plain http",
+			spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: "a.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: paths("/")}}},
+			},
+			want: map[string]string{"web": "http://a.example.com"},
+		},
+		{
+			name:   "multiple hosts with name label",
+			labels: map[string]string{LabelURLName: "app"},
+			spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: "a.example.com"}, {Host: "a.example.com"}, {Host: "b.example.com"}},
+			},
+			want: map[string]string{"app": "http://a.example.com", "app-1": "http://b.example.com"},
+		},
+		{
+			name: "wildcard and empty hosts are skipped",
+			spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: "*.example.com"}, {}},
+			},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: tt.labels}, Spec: tt.spec}
+			got := ingressURLs(ing, LabelURLName)
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("ingressURLs() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPRouteURLs(t *testing.T) {
+	t.Parallel()
+
+	route := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{"name": "web", "namespace": "env-a"},
+		"spec": map[string]any{
+			"hostnames": []any{"a.example.com", "*.example.com", "b.example.com"},
+			"rules": []any{
+				map[string]any{"matches": []any{map[string]any{"path": map[string]any{"type": "RegularExpression", "value": "/r.*"}}}},
+				map[string]any{"matches": []any{map[string]any{"path": map[string]any{"type": "PathPrefix", "value": "/app"}}}},
+			},
+		},
+	}}
+
+	got := httpRouteURLs(t.Context(), route, LabelURLName, "https")
+	want := map[string]string{"web": "https://a.example.com/app", "web-1": "https://b.example.com/app"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("httpRouteURLs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestRouteURLsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	var changed []string
+	r := newRouteURLs(RoutesConfig{NameLabel: LabelURLName})
+	r.onChange = func(_ context.Context, namespace string) {
+		changed = append(changed, namespace)
+	}
+
+	r.set(t.Context(), "env-a", "ingress/web", map[string]string{"web": "http://ingress.example.com"})
+	r.set(t.Context(), "env-a", "httproute/web", map[string]string{"web": "https://route.example.com"})
+	// Unchanged URLs do not trigger a refresh
+	r.set(t.Context(), "env-a", "httproute/web", map[string]string{"web": "https://route.example.com"})
+
+	got := r.URLs("env-a")
+	if want := map[string]string{"web": "https://route.example.com"}; !maps.Equal(got, want) {
+		t.Fatalf("URLs() = %#v, want %#v", got, want)
+	}
+
+	r.set(t.Context(), "env-a", "httproute/web", nil)
+	got = r.URLs("env-a")
+	if want := map[string]string{"web": "http://ingress.example.com"}; !maps.Equal(got, want) {
+		t.Fatalf("URLs() after delete = %#v, want %#v", got, want)
+	}
+
+	if len(changed) != 3 {
+		t.Fatalf("onChange called %d times, want 3", len(changed))
+	}
+
+	if got := (*routeURLs)(nil).URLs("env-a"); got == nil || len(got) != 0 {
+		t.Fatalf("nil URLs() = %#v, want empty map", got)
+	}
+}
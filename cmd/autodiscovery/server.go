@@ -11,7 +11,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sberz/ephemeral-envs/internal/audit"
 	"github.com/sberz/ephemeral-envs/internal/ignition"
+	"github.com/sberz/ephemeral-envs/internal/probe"
 	"github.com/sberz/ephemeral-envs/internal/store"
 )
 
@@ -28,14 +30,19 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
-func NewServerHandler(store *store.Store, ignitionProvider ignition.Provider) http.Handler {
+func NewServerHandler(store *store.Store, ignitionProvider ignition.Provider, schema apiSchema, ready *readiness, auditor *auditor) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.Handle("GET /health", handleHealthCheck())
+	mux.Handle("GET /health", handleHealthCheck(ready.datasources))
+	mux.Handle("GET /livez", handleLivez())
+	mux.Handle("GET /readyz", handleReadyz(ready))
+	mux.Handle("GET /v1/schema", handleGetSchema(schema))
 	mux.Handle("GET /v1/environment", handleListEnvironmentNames(store))
 	mux.Handle("GET /v1/environment/all", handleGetAllEnvironments(store))
 	mux.Handle("GET /v1/environment/{name}", handleGetEnvironment(store))
-	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(store, ignitionProvider))
+	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(store, ignitionProvider, auditor))
+	mux.Handle("GET /v1/conflicts", handleListConflicts(store))
+	mux.Handle("GET /v1/audit", handleListAuditEvents(auditor))
 
 	// Register Middleware for logging
 	var handler http.Handler = mux
@@ -94,11 +101,80 @@ func middlewareCORS(next http.Handler) http.Handler {
 	})
 }
 
-func handleHealthCheck() http.Handler {
+type healthResponse struct {
+	Datasources map[string]string `json:"datasources,omitempty"`
+	Status      string            `json:"status"`
+}
+
+// handleHealthCheck reports the service as `degraded` if a datasource is disconnected. The
+// service keeps serving environments without the dynamic checks, so the status code is not
+// affected.
+func handleHealthCheck(datasources healthReporter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		mustEncodeResponse(w, r, http.StatusOK, map[string]string{
-			"status": "ok",
-		})
+		res := healthResponse{Status: "ok"}
+		if datasources != nil {
+			health := datasources.Health()
+			res.Datasources = make(map[string]string, len(health))
+			for name, connected := range health {
+				res.Datasources[name] = "connected"
+				if !connected {
+					res.Datasources[name] = "disconnected"
+					res.Status = "degraded"
+				}
+			}
+		}
+
+		mustEncodeResponse(w, r, http.StatusOK, res)
+	})
+}
+
+// Types of status checks in the API schema.
+const (
+	checkTypeBool = "bool"
+	checkTypeEnum = "enum"
+)
+
+// apiSchema describes the configured status checks and metadata. Checks and metadata
+// that are only set via annotations are not part of the schema.
+type apiSchema struct {
+	StatusChecks map[string]checkSchema    `json:"statusChecks"`
+	Metadata     map[string]metadataSchema `json:"metadata"`
+}
+
+type checkSchema struct {
+	Type   string   `json:"type"`
+	Values []string `json:"values,omitempty"`
+}
+
+type metadataSchema struct {
+	Type probe.MetadataType `json:"type"`
+}
+
+// newAPISchema builds the API schema from the configuration.
+func newAPISchema(cfg *serviceConfig) apiSchema {
+	schema := apiSchema{
+		StatusChecks: make(map[string]checkSchema, len(cfg.StatusChecks)),
+		Metadata:     make(map[string]metadataSchema, len(cfg.Metadata)),
+	}
+
+	for name, check := range cfg.StatusChecks {
+		if len(check.Values) > 0 {
+			schema.StatusChecks[name] = checkSchema{Type: checkTypeEnum, Values: check.Values}
+			continue
+		}
+		schema.StatusChecks[name] = checkSchema{Type: checkTypeBool}
+	}
+
+	for name, meta := range cfg.Metadata {
+		schema.Metadata[name] = metadataSchema{Type: meta.Type}
+	}
+
+	return schema
+}
+
+func handleGetSchema(schema apiSchema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mustEncodeResponse(w, r, http.StatusOK, schema)
 	})
 }
 
@@ -108,35 +184,26 @@ func handleListEnvironmentNames(s *store.Store) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		filterNamespace := r.URL.Query().Get("namespace")
-		filterStatus := parseStatusFilter(r, "status")
+		labels, err := parseLabelFilter(r, "label")
+		if err != nil {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		slog.InfoContext(r.Context(), "listing environments", "namespace", filterNamespace, "status", filterStatus)
+		// Namespaces are only unique within a cluster, so all filters are combined
+		query := store.Query{
+			Labels:    labels,
+			Status:    parseStatusFilter(r, "status"),
+			Namespace: r.URL.Query().Get("namespace"),
+			Cluster:   r.URL.Query().Get("cluster"),
+			Owner:     r.URL.Query().Get("owner"),
+		}
 
-		envs := []string{}
+		slog.InfoContext(r.Context(), "listing environments", "namespace", query.Namespace, "cluster", query.Cluster, "status", query.Status, "labels", query.Labels, "owner", query.Owner)
 
-		switch {
-		case filterNamespace != "":
-			env, err := s.GetEnvironmentByNamespace(r.Context(), filterNamespace)
-			switch {
-			case err == nil:
-				// Environment found
-				if len(filterStatus) == 0 || env.MatchesStatus(r.Context(), filterStatus) {
-					envs = []string{env.Name}
-				}
-			case errors.Is(err, store.ErrEnvironmentNotFound):
-				// No environments found for the namespace
-				envs = []string{}
-			default:
-				slog.ErrorContext(r.Context(), "failed to get environment by namespace", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-
-		case len(filterStatus) > 0:
-			envs = s.GetEnvironmentNamesWithState(r.Context(), filterStatus)
-		default:
-			envs = s.ListEnvironmentNames(r.Context())
+		envs := []string{}
+		for _, env := range s.FindEnvironments(r.Context(), query) {
+			envs = append(envs, env.Name)
 		}
 
 		mustEncodeResponse(w, r, http.StatusOK, response{Environments: envs})
@@ -147,7 +214,7 @@ func handleGetEnvironment(s *store.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name := r.PathValue("name")
 
-		env, err := s.GetEnvironment(r.Context(), name)
+		env, err := s.ResolveEnvironment(r.Context(), name)
 		if err != nil {
 			if errors.Is(err, store.ErrEnvironmentNotFound) {
 				http.Error(w, "Environment Not Found", http.StatusNotFound)
@@ -158,13 +225,7 @@ func handleGetEnvironment(s *store.Store) http.Handler {
 			return
 		}
 
-		es, err := env.ResolveProbes(r.Context(), true, nil)
-		if err != nil {
-			slog.ErrorContext(r.Context(), "failed to resolve probes for environment", "error", err, "name", name)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		mustEncodeResponse(w, r, http.StatusOK, es)
+		mustEncodeResponse(w, r, http.StatusOK, env.ResolveProbes(r.Context(), true, nil))
 	})
 }
 
@@ -175,37 +236,51 @@ func handleGetAllEnvironments(s *store.Store) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		includeStatus := parseStatusFilter(r, "withStatus")
-		envs := s.GetAllEnvironments(r.Context())
+		filterCluster := r.URL.Query().Get("cluster")
+		envs := s.FindEnvironments(r.Context(), store.Query{Cluster: filterCluster})
 		res := make([]store.EnvironmentResponse, 0, len(envs))
 
 		for _, env := range envs {
-			es, err := env.ResolveProbes(r.Context(), false, includeStatus)
-			if err != nil {
-				slog.ErrorContext(r.Context(), "failed to resolve probes for environment", "error", err, "name", env.Name)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-			res = append(res, es)
+			res = append(res, env.ResolveProbes(r.Context(), false, includeStatus))
 		}
 
 		mustEncodeResponse(w, r, http.StatusOK, response{Environments: res})
 	})
 }
 
-func handleIgnitionEnvironment(s *store.Store, ignitionProvider ignition.Provider) http.Handler {
+// handleListConflicts returns the environment names that are claimed by multiple namespaces.
+func handleListConflicts(s *store.Store) http.Handler {
+	type response struct {
+		Conflicts []store.Conflict `json:"conflicts"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mustEncodeResponse(w, r, http.StatusOK, response{Conflicts: s.GetConflicts(r.Context())})
+	})
+}
+
+func handleIgnitionEnvironment(s *store.Store, ignitionProvider ignition.Provider, auditor *auditor) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name := r.PathValue("name")
+		// Every outcome is recorded, the result defaults to failed for unexpected errors
+		event := audit.Event{Action: audit.ActionIgnition, Environment: name, Result: audit.ResultFailed}
+		defer func() { auditor.record(r, event) }()
 
-		env, err := s.GetEnvironment(r.Context(), name)
+		env, err := s.ResolveEnvironment(r.Context(), name)
 		if err != nil {
 			if errors.Is(err, store.ErrEnvironmentNotFound) {
+				event.Result = audit.ResultNotFound
 				http.Error(w, "Environment Not Found", http.StatusNotFound)
 			} else {
 				slog.ErrorContext(r.Context(), "failed to get environment", "error", err, "name", name)
+				event.Error = err.Error()
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 			return
 		}
+		// The environment may be referenced by an alias or its ID
+		name = env.Name
+		event.Environment, event.Namespace, event.Cluster = env.Name, env.Namespace, env.Cluster
 
 		slog.InfoContext(r.Context(), "triggering ignition for environment", "name", name, "namespace", env.Namespace)
 		err = ignitionProvider.Trigger(r.Context(), ignition.TriggerRequest{
@@ -214,10 +289,12 @@ func handleIgnitionEnvironment(s *store.Store, ignitionProvider ignition.Provide
 		})
 		if err != nil {
 			slog.ErrorContext(r.Context(), "failed to trigger ignition", "error", err, "name", name, "namespace", env.Namespace)
+			event.Error = err.Error()
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		event.Result = audit.ResultAccepted
 		w.WriteHeader(http.StatusAccepted)
 	})
 }
@@ -242,9 +319,33 @@ func mustEncodeResponse[T any](w http.ResponseWriter, r *http.Request, status in
 	}
 }
 
-func parseStatusFilter(r *http.Request, param string) map[string]bool {
+var errInvalidLabelFilter = errors.New("invalid label filter")
+
+// parseLabelFilter parses a comma separated list of `key=value` label filters.
+func parseLabelFilter(r *http.Request, param string) (map[string]string, error) {
 	query := strings.Join(r.URL.Query()[param], ",")
-	filter := make(map[string]bool)
+	filter := make(map[string]string)
+
+	for f := range strings.SplitSeq(query, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%w: %q, must be key=value", errInvalidLabelFilter, f)
+		}
+		filter[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return filter, nil
+}
+
+// parseStatusFilter parses a comma separated list of status checks. A check name
+// requires the check to be true, `!name` requires it to be false and `?name` unknown.
+func parseStatusFilter(r *http.Request, param string) map[string]store.CheckStatus {
+	query := strings.Join(r.URL.Query()[param], ",")
+	filter := make(map[string]store.CheckStatus)
 
 	if query == "" {
 		return filter
@@ -256,16 +357,21 @@ func parseStatusFilter(r *http.Request, param string) map[string]bool {
 			continue
 		}
 
-		if after, ok := strings.CutPrefix(f, "!"); ok {
-			f = after
-			f = strings.TrimSpace(f)
-			if f == "" {
-				continue
-			}
-			filter[f] = false
-		} else {
-			filter[f] = true
+		status := store.StatusTrue
+		if name, value, ok := strings.Cut(f, ":"); ok {
+			// Enum checks are filtered by value, e.g. `phase:running`
+			f, status = name, store.CheckStatus(strings.TrimSpace(value))
+		} else if after, ok := strings.CutPrefix(f, "!"); ok {
+			f, status = after, store.StatusFalse
+		} else if after, ok := strings.CutPrefix(f, "?"); ok {
+			f, status = after, store.StatusUnknown
+		}
+
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
 		}
+		filter[f] = status
 	}
 	return filter
 }
@@ -7,6 +7,9 @@ import (
 	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +22,7 @@ func TestParseStatusFilter(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		want  map[string]bool
+		want  map[string]store.CheckStatus
 		name  string
 		url   string
 		param string
@@ -28,41 +31,60 @@ func TestParseStatusFilter(t *testing.T) {
 			name:  "empty query",
 			url:   "/v1/environment",
 			param: "status",
-			want:  map[string]bool{},
+			want:  map[string]store.CheckStatus{},
 		},
 		{
 			name:  "single positive filter",
 			url:   "/v1/environment?status=healthy",
 			param: "status",
-			want: map[string]bool{
-				"healthy": true,
+			want: map[string]store.CheckStatus{
+				"healthy": store.StatusTrue,
 			},
 		},
 		{
 			name:  "mixed filters with spaces and empty values",
 			url:   "/v1/environment?status=healthy,!active&status=%20ready%20,%20!%20,",
 			param: "status",
-			want: map[string]bool{
-				"healthy": true,
-				"active":  false,
-				"ready":   true,
+			want: map[string]store.CheckStatus{
+				"healthy": store.StatusTrue,
+				"active":  store.StatusFalse,
+				"ready":   store.StatusTrue,
 			},
 		},
 		{
 			name:  "negative filter with inner spaces",
 			url:   "/v1/environment?status=!%20ready%20",
 			param: "status",
-			want: map[string]bool{
-				"ready": false,
+			want: map[string]store.CheckStatus{
+				"ready": store.StatusFalse,
+			},
+		},
+		{
+			name:  "unknown filter",
+			url:   "/v1/environment?status=?healthy,!ready",
+			param: "status",
+			want: map[string]store.CheckStatus{
+				"healthy": store.StatusUnknown,
+				"ready":   store.StatusFalse,
+			},
+		},
+		{
+			name:  "enum filter",
+			url:   "/v1/environment?status=phase:running,healthy,phase-b:%20failed",
+			param: "status",
+			want: map[string]store.CheckStatus{
+				"phase":   "running",
+				"phase-b": "failed",
+				"healthy": store.StatusTrue,
 			},
 		},
 		{
 			name:  "different query key",
 			url:   "/v1/environment/all?withStatus=deployed,!smoke",
 			param: "withStatus",
-			want: map[string]bool{
-				"deployed": true,
-				"smoke":    false,
+			want: map[string]store.CheckStatus{
+				"deployed": store.StatusTrue,
+				"smoke":    store.StatusFalse,
 			},
 		},
 	}
@@ -127,13 +149,43 @@ func TestHandleGetEnvironmentOK(t *testing.T) {
 	}
 }
 
+func TestHandleGetEnvironmentByAlias(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnvironment("test", "env-test", true, false)
+	env.Aliases = []string{"pr-42"}
+	env.ID = "abcdefgh"
+	s := newTestStoreWithEnvironments(t, env)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/environment/{name}", handleGetEnvironment(s))
+
+	for _, ref := range []string{"pr-42", "abcdefgh"} {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/environment/"+ref, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", ref, rec.Code, http.StatusOK)
+		}
+
+		var got store.EnvironmentResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unmarshal response: %v", err)
+		}
+		if got.Name != "test" || got.ID != "abcdefgh" || !slices.Equal(got.Aliases, []string{"pr-42"}) {
+			t.Fatalf("GET %s = %+v, want environment test with alias and ID", ref, got.Environment)
+		}
+	}
+}
+
 func TestHandleIgnitionEnvironmentAccepted(t *testing.T) {
 	t.Parallel()
 
 	s := newTestStoreWithEnvironments(t, newTestEnvironment("test", "env-test", true, false))
 	provider := &testIgnitionProvider{}
 	mux := http.NewServeMux()
-	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(s, provider))
+	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(s, provider, newTestAuditor()))
 
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/environment/test/ignition", nil)
 	rec := httptest.NewRecorder()
@@ -156,7 +208,7 @@ func TestHandleIgnitionEnvironmentNotFound(t *testing.T) {
 	t.Parallel()
 
 	s := store.NewStore()
-	h := handleIgnitionEnvironment(s, &testIgnitionProvider{})
+	h := handleIgnitionEnvironment(s, &testIgnitionProvider{}, newTestAuditor())
 
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/environment/missing/ignition", nil)
 	rec := httptest.NewRecorder()
@@ -172,7 +224,7 @@ func TestHandleIgnitionEnvironmentProviderError(t *testing.T) {
 
 	s := newTestStoreWithEnvironments(t, newTestEnvironment("test", "env-test", true, false))
 	mux := http.NewServeMux()
-	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(s, &testIgnitionProvider{err: errTestProbeFailed}))
+	mux.Handle("POST /v1/environment/{name}/ignition", handleIgnitionEnvironment(s, &testIgnitionProvider{err: errTestProbeFailed}, newTestAuditor()))
 
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/v1/environment/test/ignition", nil)
 	rec := httptest.NewRecorder()
@@ -260,6 +312,146 @@ func TestHandleListEnvironmentNamesByNamespaceAndStatus(t *testing.T) {
 	}
 }
 
+func TestHandleListEnvironmentNamesByLabelAndOwner(t *testing.T) {
+	t.Parallel()
+
+	a := newTestEnvironment("a", "env-a", true, false)
+	a.Labels = map[string]string{"team": "mobile", "tier": "dev"}
+	a.Owner = "team-mobile"
+	b := newTestEnvironment("b", "env-b", true, false)
+	b.Labels = map[string]string{"team": "mobile"}
+	s := newTestStoreWithEnvironments(t, a, b, newTestEnvironment("c", "env-c", true, false))
+
+	h := handleListEnvironmentNames(s)
+
+	tests := map[string]struct {
+		query      string
+		want       []string
+		wantStatus int
+	}{
+		"label":         {query: "label=team%3Dmobile", want: []string{"a", "b"}, wantStatus: http.StatusOK},
+		"labels":        {query: "label=team%3Dmobile,tier%3Ddev", want: []string{"a"}, wantStatus: http.StatusOK},
+		"owner":         {query: "owner=team-mobile", want: []string{"a"}, wantStatus: http.StatusOK},
+		"no match":      {query: "label=team%3Dweb", want: []string{}, wantStatus: http.StatusOK},
+		"invalid label": {query: "label=team", wantStatus: http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/environment?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Environments []string `json:"environments"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if !slices.Equal(got.Environments, tt.want) {
+				t.Fatalf("environments = %#v, want %#v", got.Environments, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleListEnvironmentNamesByCluster(t *testing.T) {
+	t.Parallel()
+
+	east := newTestEnvironment("a", "env-a", true, false)
+	east.Cluster = "east"
+	west := newTestEnvironment("b", "env-a", true, false)
+	west.Cluster = "west"
+	westUnhealthy := newTestEnvironment("c", "env-c", false, false)
+	westUnhealthy.Cluster = "west"
+
+	s := newTestStoreWithEnvironments(t, east, west, westUnhealthy)
+	h := handleListEnvironmentNames(s)
+
+	tests := map[string]struct {
+		url  string
+		want []string
+	}{
+		"cluster only":             {url: "/v1/environment?cluster=west", want: []string{"b", "c"}},
+		"cluster and namespace":    {url: "/v1/environment?cluster=west&namespace=env-a", want: []string{"b"}},
+		"cluster and status":       {url: "/v1/environment?cluster=west&status=healthy", want: []string{"b"}},
+		"unknown cluster is empty": {url: "/v1/environment?cluster=north", want: []string{}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			var got struct {
+				Environments []string `json:"environments"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+
+			if !slices.Equal(got.Environments, tt.want) {
+				t.Fatalf("environments = %#v, want %#v", got.Environments, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleListConflicts(t *testing.T) {
+	t.Parallel()
+
+	older := newTestEnvironment("shared", "env-a", true, false)
+	newer := newTestEnvironment("shared", "env-b", true, false)
+	newer.CreatedAt = older.CreatedAt.Add(time.Hour)
+
+	s := store.NewStore()
+	if err := s.AddEnvironment(t.Context(), older); err != nil {
+		t.Fatalf("AddEnvironment(older) error = %v", err)
+	}
+	if err := s.AddEnvironment(t.Context(), newer); !errors.Is(err, store.ErrEnvironmentConflict) {
+		t.Fatalf("AddEnvironment(newer) error = %v, want ErrEnvironmentConflict", err)
+	}
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/conflicts", nil)
+	rec := httptest.NewRecorder()
+	handleListConflicts(s).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got struct {
+		Conflicts []store.Conflict `json:"conflicts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+
+	if len(got.Conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want 1", got.Conflicts)
+	}
+	c := got.Conflicts[0]
+	if c.Name != "shared" || c.Winner == nil || c.Winner.Namespace != "env-a" || len(c.Rejected) != 1 || c.Rejected[0].Namespace != "env-b" {
+		t.Fatalf("conflict = %+v, want env-a serving shared and env-b rejected", c)
+	}
+}
+
 func TestHandleListEnvironmentNamesByNamespaceNotFound(t *testing.T) {
 	t.Parallel()
 
@@ -310,8 +502,21 @@ func TestHandleGetEnvironmentStatusProbeError(t *testing.T) {
 	rec := httptest.NewRecorder()
 	mux.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got store.EnvironmentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+
+	status, ok := got.Status["healthy"]
+	if !ok || status != store.StatusUnknown {
+		t.Fatalf("status.healthy = %v (present %t), want unknown", status, ok)
+	}
+	if got.StatusReason["healthy"] == "" {
+		t.Fatalf("statusReason = %#v, want reason for healthy", got.StatusReason)
 	}
 }
 
@@ -340,8 +545,20 @@ func TestHandleGetEnvironmentMetadataProbeError(t *testing.T) {
 	rec := httptest.NewRecorder()
 	mux.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got store.EnvironmentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+
+	if _, ok := got.Meta["owner"]; ok {
+		t.Fatalf("meta = %#v, want failing owner omitted", got.Meta)
+	}
+	if got.Status["healthy"] != store.StatusTrue {
+		t.Fatalf("status.healthy = %v, want true", got.Status["healthy"])
 	}
 }
 
@@ -368,8 +585,60 @@ func TestHandleGetAllEnvironmentsStatusProbeError(t *testing.T) {
 	rec := httptest.NewRecorder()
 	mux.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusInternalServerError {
-		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got map[string][]map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+
+	envs := got["environments"]
+	if len(envs) != 1 || string(envs[0]["status"]) != `{"healthy":null}` {
+		t.Fatalf("environments = %s, want healthy status null", rec.Body.String())
+	}
+}
+
+func TestHandleListEnvironmentsUnknownStatusFilter(t *testing.T) {
+	t.Parallel()
+
+	broken := newTestEnvironment("broken", "env-broken", true, false)
+	broken.StatusChecks["healthy"] = failingBoolProbe{}
+	s := newTestStoreWithEnvironments(t,
+		newTestEnvironment("a", "env-a", true, false),
+		broken,
+		newTestEnvironment("b", "env-b", false, false),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/environment", handleListEnvironmentNames(s))
+
+	tests := map[string][]string{
+		"?healthy": {"broken"},
+		"!healthy": {"b"},
+		"healthy":  {"a"},
+		"?missing": {"a", "b", "broken"},
+	}
+
+	for filter, want := range tests {
+		t.Run(filter, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/environment?status="+url.QueryEscape(filter), nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			var got struct {
+				Environments []string `json:"environments"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if !slices.Equal(got.Environments, want) {
+				t.Fatalf("environments = %#v, want %#v", got.Environments, want)
+			}
+		})
 	}
 }
 
@@ -420,7 +689,7 @@ func TestHandleHealthCheck(t *testing.T) {
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/health", nil)
 	rec := httptest.NewRecorder()
 
-	handleHealthCheck().ServeHTTP(rec, req)
+	handleHealthCheck(nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
@@ -440,10 +709,80 @@ func TestHandleHealthCheck(t *testing.T) {
 	}
 }
 
+type testHealthReporter map[string]bool
+
+func (r testHealthReporter) Health() map[string]bool {
+	return r
+}
+
+func TestHandleHealthCheckDatasources(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		health testHealthReporter
+		want   string
+	}{
+		"connected": {
+			health: testHealthReporter{"default": true},
+			want:   `{"datasources":{"default":"connected"},"status":"ok"}`,
+		},
+		"disconnected": {
+			health: testHealthReporter{"default": true, "thanos": false},
+			want:   `{"datasources":{"default":"connected","thanos":"disconnected"},"status":"degraded"}`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/health", nil)
+			rec := httptest.NewRecorder()
+
+			handleHealthCheck(tt.health).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Fatalf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleGetSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := newAPISchema(&serviceConfig{
+		StatusChecks: map[string]*ProbeConfig{
+			"healthy": {},
+			"phase":   {Values: []string{"pending", "running"}},
+		},
+		Metadata: map[string]*MetadataConfig{
+			"owner": {Type: probe.MetadataTypeString},
+		},
+	})
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/v1/schema", nil)
+	rec := httptest.NewRecorder()
+
+	handleGetSchema(schema).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	want := `{"statusChecks":{"healthy":{"type":"bool"},"phase":{"type":"enum","values":["pending","running"]}},"metadata":{"owner":{"type":"string"}}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("body = %s, want %s", got, want)
+	}
+}
+
 func TestNewServerHandlerRoutingAndMiddleware(t *testing.T) {
 	t.Parallel()
 
-	h := NewServerHandler(newTestStoreWithEnvironments(t, newTestEnvironment("a", "env-a", true, false)), &testIgnitionProvider{})
+	h := NewServerHandler(newTestStoreWithEnvironments(t, newTestEnvironment("a", "env-a", true, false)), &testIgnitionProvider{}, apiSchema{}, newReadiness(nil, nil, nil), newTestAuditor())
 
 	preflight := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "/v1/environment", nil)
 	preflightRec := httptest.NewRecorder()
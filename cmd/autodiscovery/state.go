@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/state"
+)
+
+// setupState creates the configured state backend. The ConfigMap and file backends load the
+// state before they are returned and are synchronized in the background until ctx is done.
+func setupState(ctx context.Context, cfg *serviceConfig, clusters []*kube.Clients) (state.Backend, error) {
+	switch cfg.State.Backend {
+	case state.BackendMemory:
+		return state.NewMemory(), nil
+	case state.BackendConfigMap:
+		namespace, err := kube.PodNamespace(cfg.State.ConfigMap.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("state ConfigMap: %w", err)
+		}
+
+		client := clusterClient(clusters, cfg.State.ConfigMap.Cluster)
+		backend := state.NewConfigMap(client, namespace, cfg.State.ConfigMap.Name, cfg.State.Retention)
+		if err := backend.Sync(ctx); err != nil {
+			// Start with an empty state, the ConfigMap is retried on the next synchronization
+			slog.WarnContext(ctx, "failed to load state", "configmap", cfg.State.ConfigMap.Name, "namespace", namespace, "error", err)
+		}
+
+		go backend.Run(ctx, cfg.State.SyncInterval)
+		return backend, nil
+	case state.BackendFile:
+		backend, err := state.NewFile(cfg.State.File.Path, cfg.State.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("state file: %w", err)
+		}
+
+		go backend.Run(ctx, cfg.State.SyncInterval)
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported backend %q", state.ErrInvalidBackend, cfg.State.Backend)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// parseURLTemplate parses a URL template from the config. Templates can use the fields
+// name, namespace, cluster, labels and annotations of an environment.
+func parseURLTemplate(name string, tpl string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("url must be a valid template: %w", err)
+	}
+
+	return t, nil
+}
+
+// setupURLTemplates parses all URL templates from the config.
+func setupURLTemplates(cfg *serviceConfig) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(cfg.URLs))
+
+	for name, tpl := range cfg.URLs {
+		t, err := parseURLTemplate(name, tpl)
+		if err != nil {
+			return nil, fmt.Errorf("url %q: %w", name, err)
+		}
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+// templateURLs renders the configured URL templates for an environment object.
+// Templates that fail to render, e.g. because of a missing label, are skipped.
+func (c *EventHandler) templateURLs(ctx context.Context, envName string, obj metav1.Object) map[string]string {
+	urls := map[string]string{}
+	if len(c.urls) == 0 {
+		return urls
+	}
+
+	data := map[string]any{
+		"name":        envName,
+		"namespace":   envNamespace(obj),
+		"cluster":     c.cluster,
+		"labels":      obj.GetLabels(),
+		"annotations": obj.GetAnnotations(),
+	}
+
+	for name, tpl := range c.urls {
+		var sb strings.Builder
+		if err := tpl.Execute(&sb, data); err != nil {
+			slog.WarnContext(ctx, "failed to render URL template", "url", name, "env_name", envName, "error", err)
+			continue
+		}
+
+		if sb.Len() == 0 {
+			continue
+		}
+		urls[name] = sb.String()
+	}
+
+	return urls
+}
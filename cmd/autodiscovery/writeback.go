@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// writeBackFieldManager owns the written annotations. Annotations of checks that no
+	// longer exist are removed by the server-side apply.
+	writeBackFieldManager = "ephemeral-envs-writeback"
+	// observedAtKey is the annotation name of the time the values were written.
+	observedAtKey = "observed-at"
+	// observedMetadataKeyPrefix prefixes the annotation names of metadata. Check names cannot
+	// contain dots, so they do not collide.
+	observedMetadataKeyPrefix = "metadata."
+)
+
+var writeBackWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ephemeralenv_writeback_writes_total",
+	Help: "Total number of namespace annotation writes",
+}, []string{"cluster", "status"})
+
+// writeBack writes the resolved status checks and metadata of environments back to
+// annotations on their namespace, so tools that can only read Kubernetes objects can use them.
+type writeBack struct {
+	store   *store.Store
+	clients map[string]kubernetes.Interface
+	limiter flowcontrol.RateLimiter
+	// now returns the time written to the timestamp annotation.
+	now func() time.Time
+	cfg WriteBackConfig
+}
+
+// writeBackKey identifies the namespace of an environment.
+type writeBackKey struct {
+	cluster   string
+	namespace string
+}
+
+func newWriteBack(s *store.Store, clusters []*kube.Clients, cfg WriteBackConfig) *writeBack {
+	clients := make(map[string]kubernetes.Interface, len(clusters))
+	for _, c := range clusters {
+		clients[c.Cluster] = c.Kubernetes
+	}
+
+	return &writeBack{
+		store:   s,
+		clients: clients,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(cfg.QPS, cfg.Burst),
+		now:     time.Now,
+		cfg:     cfg,
+	}
+}
+
+// Run writes the changed values every interval until ctx is done. It is singleton work
+// started on the leader, so replicas do not write the same namespaces.
+func (w *writeBack) Run(ctx context.Context) {
+	// written holds the last written annotations of each namespace, without the timestamp
+	written := make(map[writeBackKey]map[string]string)
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.sync(ctx, written)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync writes the annotations of all environments whose values changed since the last write.
+func (w *writeBack) sync(ctx context.Context, written map[writeBackKey]map[string]string) {
+	seen := make(map[writeBackKey]bool)
+	for _, env := range w.store.GetAllEnvironments(ctx) {
+		key := writeBackKey{cluster: env.Cluster, namespace: env.Namespace}
+		seen[key] = true
+
+		annotations := w.annotations(ctx, &env)
+		if last, ok := written[key]; ok && maps.Equal(last, annotations) {
+			continue
+		}
+
+		if err := w.write(ctx, key, annotations); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.WarnContext(ctx, "failed to write back environment status", "name", env.Name, "namespace", env.Namespace, "cluster", env.Cluster, "error", err)
+			writeBackWrites.WithLabelValues(env.Cluster, "error").Inc()
+			continue
+		}
+		written[key] = annotations
+	}
+
+	for key := range written {
+		if !seen[key] {
+			delete(written, key)
+		}
+	}
+}
+
+// annotations returns the resolved values of the environment as annotations, without the timestamp.
+func (w *writeBack) annotations(ctx context.Context, env *store.Environment) map[string]string {
+	var filter map[string]store.CheckStatus
+	if len(w.cfg.StatusChecks) > 0 {
+		filter = make(map[string]store.CheckStatus, len(w.cfg.StatusChecks))
+		for _, check := range w.cfg.StatusChecks {
+			filter[check] = store.StatusTrue
+		}
+	}
+
+	res := env.ResolveProbes(ctx, len(w.cfg.Metadata) > 0, filter)
+	annotations := make(map[string]string, len(res.Status)+len(w.cfg.Metadata))
+
+	for check, status := range res.Status {
+		key := w.cfg.AnnotationPrefix + check
+		if check == observedAtKey || len(validation.IsQualifiedName(key)) > 0 {
+			// Checks defined via annotations are not validated by the config
+			slog.DebugContext(ctx, "skipping status check without valid annotation name", "name", env.Name, "check", check)
+			continue
+		}
+		annotations[key] = status.String()
+	}
+
+	for _, meta := range w.cfg.Metadata {
+		if val, ok := res.Meta[meta]; ok {
+			annotations[w.cfg.AnnotationPrefix+observedMetadataKeyPrefix+meta] = formatObserved(val)
+		}
+	}
+
+	return annotations
+}
+
+// write applies the annotations and the timestamp to the namespace. Namespaces that are
+// deleted or already have the annotations, e.g. written by a previous leader, are skipped.
+func (w *writeBack) write(ctx context.Context, key writeBackKey, annotations map[string]string) error {
+	client, ok := w.clients[key.cluster]
+	if !ok {
+		return fmt.Errorf("%w: %q", errInvalidCluster, key.cluster)
+	}
+
+	if err := w.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("rate limiter: %w", err)
+	}
+
+	// An apply creates missing objects, so make sure the namespace still exists
+	ns, err := client.CoreV1().Namespaces().Get(ctx, key.namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+	if ns.DeletionTimestamp != nil {
+		return nil
+	}
+
+	current := make(map[string]string)
+	for k, v := range ns.GetAnnotations() {
+		if strings.HasPrefix(k, w.cfg.AnnotationPrefix) && k != w.cfg.AnnotationPrefix+observedAtKey {
+			current[k] = v
+		}
+	}
+	if maps.Equal(current, annotations) {
+		return nil
+	}
+
+	apply := make(map[string]string, len(annotations)+1)
+	maps.Copy(apply, annotations)
+	apply[w.cfg.AnnotationPrefix+observedAtKey] = w.now().UTC().Format(time.RFC3339)
+
+	_, err = client.CoreV1().Namespaces().Apply(ctx,
+		applycorev1.Namespace(key.namespace).WithAnnotations(apply),
+		metav1.ApplyOptions{FieldManager: writeBackFieldManager, Force: true},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to apply namespace annotations: %w", err)
+	}
+
+	slog.DebugContext(ctx, "wrote back environment status", "namespace", key.namespace, "cluster", key.cluster, "annotations", len(annotations))
+	writeBackWrites.WithLabelValues(key.cluster, "success").Inc()
+	return nil
+}
+
+// formatObserved formats a metadata value as annotation value.
+func formatObserved(val any) string {
+	if t, ok := val.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(val)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	"github.com/sberz/ephemeral-envs/internal/probe"
+	"github.com/sberz/ephemeral-envs/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteBackSync(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	created := time.Unix(1_700_000_000, 0).UTC()
+	observedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	client := fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "env-a",
+		Annotations: map[string]string{"team": "mobile"},
+	}})
+
+	healthy := &mutableBoolProbe{value: true}
+	s := store.NewStore()
+	envs := []store.Environment{
+		{
+			Name:         "a",
+			Namespace:    "env-a",
+			CreatedAt:    created,
+			URL:          map[string]string{},
+			StatusChecks: map[string]probe.Probe[bool]{"healthy": healthy},
+			EnumChecks:   map[string]probe.Probe[string]{"phase": probe.NewEnumProbe(probe.NewStaticProbe("running"), []string{"running"})},
+			MetaProbes: map[string]probe.MetadataProbe{
+				"owner":  probe.WrapProbe(probe.NewStaticProbe("team-mobile")),
+				"secret": probe.WrapProbe(probe.NewStaticProbe("hidden")),
+			},
+		},
+		{
+			// The namespace does not exist, it must not be created by the apply
+			Name:         "gone",
+			Namespace:    "env-gone",
+			CreatedAt:    created,
+			URL:          map[string]string{},
+			StatusChecks: map[string]probe.Probe[bool]{},
+			MetaProbes:   map[string]probe.MetadataProbe{},
+		},
+	}
+	for _, env := range envs {
+		if err := s.AddEnvironment(ctx, env); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", env.Name, err)
+		}
+	}
+
+	cfg := WriteBackConfig{Enabled: true, Metadata: []string{"owner"}}
+	cfg.applyDefaults()
+	w := newWriteBack(s, []*kube.Clients{{Kubernetes: client}}, cfg)
+	w.now = func() time.Time { return observedAt }
+
+	written := make(map[writeBackKey]map[string]string)
+	wantAnnotations := func(healthy string) map[string]string {
+		return map[string]string{
+			"team":                                  "mobile",
+			"observed.envs.sberz.de/healthy":        healthy,
+			"observed.envs.sberz.de/phase":          "running",
+			"observed.envs.sberz.de/metadata.owner": "team-mobile",
+			"observed.envs.sberz.de/observed-at":    "2026-01-02T03:04:05Z",
+		}
+	}
+	assertAnnotations := func(want map[string]string) {
+		t.Helper()
+
+		ns, err := client.CoreV1().Namespaces().Get(ctx, "env-a", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(env-a) error = %v", err)
+		}
+		if !maps.Equal(ns.Annotations, want) {
+			t.Fatalf("annotations = %v, want %v", ns.Annotations, want)
+		}
+	}
+	countApplies := func() int {
+		n := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "patch" {
+				n++
+			}
+		}
+		return n
+	}
+
+	w.sync(ctx, written)
+	assertAnnotations(wantAnnotations("true"))
+	if _, err := client.CoreV1().Namespaces().Get(ctx, "env-gone", metav1.GetOptions{}); err == nil {
+		t.Fatal("namespace env-gone was created by the write-back")
+	}
+	if got := countApplies(); got != 1 {
+		t.Fatalf("applies = %d, want 1", got)
+	}
+
+	// Unchanged values are not written again
+	w.sync(ctx, written)
+	if got := countApplies(); got != 1 {
+		t.Fatalf("applies after unchanged sync = %d, want 1", got)
+	}
+
+	healthy.value = false
+	w.sync(ctx, written)
+	assertAnnotations(wantAnnotations("false"))
+
+	// A new leader does not rewrite namespaces that already have the values
+	w.sync(ctx, make(map[writeBackKey]map[string]string))
+	if got := countApplies(); got != 2 {
+		t.Fatalf("applies after leader change = %d, want 2", got)
+	}
+}
+
+func TestEventHandlerIgnoresWrittenAnnotations(t *testing.T) {
+	t.Parallel()
+
+	newNamespace := func(labels, annotations map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "env-a", Labels: labels, Annotations: annotations}}
+	}
+	labels := map[string]string{LabelEnvName: "a"}
+
+	tests := map[string]struct {
+		oldNs *corev1.Namespace
+		newNs *corev1.Namespace
+		want  bool
+	}{
+		"written annotation": {
+			oldNs: newNamespace(labels, map[string]string{"url.envs.sberz.de/app": "https://a.example.com"}),
+			newNs: newNamespace(labels, map[string]string{"url.envs.sberz.de/app": "https://a.example.com", AnnotationObservedPrefix + "healthy": "true"}),
+			want:  true,
+		},
+		"other annotation": {
+			oldNs: newNamespace(labels, map[string]string{AnnotationObservedPrefix + "healthy": "true"}),
+			newNs: newNamespace(labels, map[string]string{AnnotationObservedPrefix + "healthy": "false", "url.envs.sberz.de/app": "https://a.example.com"}),
+		},
+		"label": {
+			oldNs: newNamespace(labels, nil),
+			newNs: newNamespace(map[string]string{LabelEnvName: "b"}, map[string]string{AnnotationObservedPrefix + "healthy": "true"}),
+		},
+		"resync": {
+			oldNs: newNamespace(labels, map[string]string{AnnotationObservedPrefix + "healthy": "true"}),
+			newNs: newNamespace(labels, map[string]string{AnnotationObservedPrefix + "healthy": "true"}),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewEventHandler(t.Context(), store.NewStore(), DefaultKeyConfig(), nil, nil, nil, nil)
+			h.IgnoreAnnotations(AnnotationObservedPrefix)
+			h = h.ForCluster("", nil)
+
+			if got := h.onlyIgnoredAnnotationsChanged(tt.oldNs, tt.newNs); got != tt.want {
+				t.Fatalf("onlyIgnoredAnnotationsChanged() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// mutableBoolProbe is a probe whose value can be changed by the test.
+type mutableBoolProbe struct {
+	value bool
+}
+
+func (p *mutableBoolProbe) Value(_ context.Context) (bool, error) {
+	return p.value, nil
+}
+
+func (p *mutableBoolProbe) LastUpdate() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,210 @@
+// Package audit records mutating API operations, separate from the request logs.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultRetain = 1000
+
+var ErrInvalidConfig = errors.New("invalid audit config")
+
+var auditEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ephemeralenv_audit_events_total",
+	Help: "Total number of audited API operations",
+}, []string{"action", "result"})
+
+// Action is the audited operation.
+type Action string
+
+const (
+	ActionIgnition Action = "ignition"
+)
+
+// Result is the outcome of an audited operation.
+type Result string
+
+const (
+	ResultAccepted Result = "accepted"
+	ResultFailed   Result = "failed"
+	ResultNotFound Result = "not_found"
+)
+
+// Event is a single audited operation.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	Result Result    `json:"result"`
+	// Environment is the name of the environment the operation was requested for.
+	Environment string `json:"environment"`
+	Namespace   string `json:"namespace,omitempty"`
+	Cluster     string `json:"cluster,omitempty"`
+	// Subject is the authenticated user or token subject, as reported by the auth proxy.
+	Subject string `json:"subject,omitempty"`
+	// RemoteAddr is the address of the client, ForwardedFor the X-Forwarded-For header.
+	RemoteAddr   string `json:"remoteAddr,omitempty"`
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Redacted returns the event without the subject and client addresses.
+func (e Event) Redacted() Event {
+	e.Subject, e.RemoteAddr, e.ForwardedFor = "", "", ""
+	return e
+}
+
+// Config configures the audit log.
+type Config struct {
+	// Output is `stdout` or the path of a file the events are appended to as JSON lines.
+	// If empty, events are only kept in memory.
+	Output string `yaml:"output"`
+	// SubjectHeader is the request header holding the authenticated subject, e.g.
+	// `X-Forwarded-User` set by an auth proxy.
+	SubjectHeader string `yaml:"subjectHeader"`
+	// Retain is the number of recent events kept for the API. Defaults to 1000.
+	Retain int `yaml:"retain"`
+	// KubernetesEvents mirrors the events as Kubernetes Events on the environment namespace.
+	KubernetesEvents bool `yaml:"kubernetesEvents"`
+}
+
+// ApplyDefaults sets the default values of unset fields.
+func (c *Config) ApplyDefaults() {
+	if c.Retain == 0 {
+		c.Retain = defaultRetain
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.Retain < 0 {
+		return fmt.Errorf("%w: retain must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Mirror receives a copy of every audit event, e.g. to publish it elsewhere.
+type Mirror interface {
+	Mirror(ctx context.Context, event Event)
+}
+
+// Filter selects audit events. Empty fields match all events.
+type Filter struct {
+	Environment string
+	Action      Action
+	// Limit is the maximum number of events returned. Zero returns all events.
+	Limit int
+}
+
+// Log records audit events. Events are written as JSON lines, kept in memory for queries
+// and passed to the mirrors.
+type Log struct {
+	w       io.Writer
+	mirrors []Mirror
+	// events holds the most recent events, oldest first.
+	events []Event
+	retain int
+	mu     sync.Mutex
+}
+
+// NewLog creates an audit log writing to w. The latest retain events are kept in memory.
+func NewLog(w io.Writer, retain int, mirrors ...Mirror) *Log {
+	if w == nil {
+		w = io.Discard
+	}
+	return &Log{w: w, mirrors: mirrors, retain: retain}
+}
+
+// Load reads previously written events from r, so they can be queried after a restart.
+// Invalid lines are skipped.
+func (l *Log) Load(ctx context.Context, r io.Reader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	skipped := 0
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			skipped++
+			continue
+		}
+		l.append(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if skipped > 0 {
+		slog.WarnContext(ctx, "skipped invalid audit log lines", "count", skipped)
+	}
+	return nil
+}
+
+// Record adds an event to the log. The time is set if it is zero.
+func (l *Log) Record(ctx context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	auditEvents.WithLabelValues(string(e.Action), string(e.Result)).Inc()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode audit event", "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.append(e)
+	_, err = l.w.Write(append(data, '\n'))
+	l.mu.Unlock()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to write audit event", "error", err, "action", e.Action, "environment", e.Environment)
+	}
+
+	for _, m := range l.mirrors {
+		m.Mirror(ctx, e)
+	}
+}
+
+// Events returns the retained events matching the filter, newest first.
+func (l *Log) Events(filter Filter) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make([]Event, 0)
+	for _, e := range slices.Backward(l.events) {
+		if filter.Limit > 0 && len(res) >= filter.Limit {
+			break
+		}
+		if filter.Environment != "" && e.Environment != filter.Environment {
+			continue
+		}
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// append retains the event. It must be called with the mutex held.
+func (l *Log) append(e Event) {
+	if l.retain <= 0 {
+		return
+	}
+	if len(l.events) >= l.retain {
+		l.events = slices.Delete(l.events, 0, len(l.events)-l.retain+1)
+	}
+	l.events = append(l.events, e)
+}
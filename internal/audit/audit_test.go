@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLogRecord(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	log := NewLog(&out, 2)
+	for _, env := range []string{"a", "b", "c"} {
+		log.Record(t.Context(), Event{Action: ActionIgnition, Environment: env, Result: ResultAccepted})
+	}
+
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Fatalf("written lines = %d, want 3", lines)
+	}
+
+	// Only the latest events are retained, newest first
+	events := log.Events(Filter{})
+	if len(events) != 2 || events[0].Environment != "c" || events[1].Environment != "b" {
+		t.Fatalf("Events() = %+v, want c and b", events)
+	}
+	if events[0].Time.IsZero() {
+		t.Fatal("event time is zero, want time of recording")
+	}
+
+	// A restarted log loads the written events
+	restarted := NewLog(nil, 10)
+	if err := restarted.Load(t.Context(), strings.NewReader(out.String()+"invalid\n")); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if events := restarted.Events(Filter{Environment: "a"}); len(events) != 1 {
+		t.Fatalf("Events(a) = %+v, want 1 event", events)
+	}
+}
+
+func TestKubernetesMirror(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			name:  "accepted",
+			event: Event{Action: ActionIgnition, Result: ResultAccepted, Environment: "test", Namespace: "env-test", Subject: "jane", RemoteAddr: "10.0.0.1:1234"},
+			want:  `Normal IgnitionAccepted ignition of environment "test" accepted`,
+		},
+		{
+			name:  "failed",
+			event: Event{Action: ActionIgnition, Result: ResultFailed, Environment: "test", Namespace: "env-test", RemoteAddr: "10.0.0.1:1234", Error: "boom"},
+			want:  `Warning IgnitionFailed ignition of environment "test" failed: boom`,
+		},
+		{
+			name:  "not found",
+			event: Event{Action: ActionIgnition, Result: ResultNotFound, Environment: "missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := record.NewFakeRecorder(1)
+			NewKubernetesMirror(map[string]record.EventRecorder{"": recorder}).Mirror(t.Context(), tt.event)
+
+			select {
+			case got := <-recorder.Events:
+				if got != tt.want {
+					t.Fatalf("Event = %q, want %q", got, tt.want)
+				}
+			default:
+				if tt.want != "" {
+					t.Fatalf("no Event, want %q", tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/sberz/ephemeral-envs/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// KubernetesMirror mirrors audit events as Kubernetes Events on the environment namespace.
+type KubernetesMirror struct {
+	// recorders holds the Event recorder of each cluster.
+	recorders map[string]record.EventRecorder
+}
+
+var _ Mirror = (*KubernetesMirror)(nil)
+
+// NewKubernetesMirror creates a mirror emitting Events with the recorder of the environment's cluster.
+func NewKubernetesMirror(recorders map[string]record.EventRecorder) *KubernetesMirror {
+	return &KubernetesMirror{recorders: recorders}
+}
+
+func (m *KubernetesMirror) Mirror(ctx context.Context, e Event) {
+	if e.Namespace == "" {
+		// The environment was not found, there is no namespace to attach the Event to
+		return
+	}
+
+	recorder, ok := m.recorders[e.Cluster]
+	if !ok {
+		slog.WarnContext(ctx, "no Event recorder for cluster", "cluster", e.Cluster, "environment", e.Environment)
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if e.Result != ResultAccepted {
+		eventType = corev1.EventTypeWarning
+	}
+
+	recorder.Event(kube.NamespaceReference(e.Namespace), eventType, eventReason(e), eventMessage(e))
+}
+
+// eventReason returns the UpperCamelCase reason of the Event, e.g. `IgnitionAccepted`.
+func eventReason(e Event) string {
+	var sb strings.Builder
+	for part := range strings.SplitSeq(string(e.Action)+"_"+string(e.Result), "_") {
+		if part != "" {
+			sb.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return sb.String()
+}
+
+// eventMessage describes the event. Events can be read by anyone with access to the
+// namespace, so the subject and client addresses are left out.
+func eventMessage(e Event) string {
+	msg := fmt.Sprintf("%s of environment %q %s", e.Action, e.Environment, e.Result)
+	if e.Error != "" {
+		msg += ": " + e.Error
+	}
+	return msg
+}
@@ -10,3 +10,18 @@ type TriggerRequest struct {
 type Provider interface {
 	Trigger(ctx context.Context, req TriggerRequest) error
 }
+
+// HealthChecker is implemented by providers that depend on external systems.
+type HealthChecker interface {
+	// Healthy returns an error if the provider can not accept triggers.
+	Healthy(ctx context.Context) error
+}
+
+// CheckHealth returns an error if the provider is unhealthy. Providers that do not
+// implement HealthChecker are always healthy.
+func CheckHealth(ctx context.Context, p Provider) error {
+	if hc, ok := p.(HealthChecker); ok {
+		return hc.Healthy(ctx) //nolint:wrapcheck // Returned as is to the health check
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package ignition
 
 import (
 	"context"
+	"errors"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sberz/ephemeral-envs/internal/state"
 )
 
 func TestProviderConfigValidate(t *testing.T) {
@@ -61,7 +65,7 @@ func TestNewProvider(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			provider, err := NewProvider(tt.cfg)
+			provider, err := NewProvider(t.Context(), tt.cfg, state.NewMemory())
 			if tt.wantErr {
 				if err == nil {
 					t.Fatal("NewProvider() error = nil, want non-nil")
@@ -82,7 +86,7 @@ func TestNewProvider(t *testing.T) {
 func TestPrometheusProviderTrigger(t *testing.T) {
 	t.Parallel()
 
-	provider := NewPrometheusProvider(&PrometheusProviderConfig{})
+	provider := NewPrometheusProvider(t.Context(), &PrometheusProviderConfig{}, state.NewMemory())
 	tests := map[string]struct {
 		req     TriggerRequest
 		wantErr bool
@@ -110,3 +114,69 @@ func TestPrometheusProviderTrigger(t *testing.T) {
 		})
 	}
 }
+
+var errUnhealthy = errors.New("unhealthy")
+
+type unhealthyProvider struct{}
+
+func (unhealthyProvider) Trigger(context.Context, TriggerRequest) error {
+	return nil
+}
+
+func (unhealthyProvider) Healthy(context.Context) error {
+	return errUnhealthy
+}
+
+func TestCheckHealth(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		provider Provider
+		wantErr  error
+	}{
+		"provider without health check": {
+			provider: NewPrometheusProvider(t.Context(), &PrometheusProviderConfig{}, state.NewMemory()),
+		},
+		"unhealthy provider": {
+			provider: unhealthyProvider{},
+			wantErr:  errUnhealthy,
+		},
+		"instrumented unhealthy provider": {
+			provider: &instrumentedProvider{next: unhealthyProvider{}, providerName: "test"},
+			wantErr:  errUnhealthy,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := CheckHealth(t.Context(), tt.provider); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckHealth() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrometheusProviderState(t *testing.T) {
+	t.Parallel()
+
+	backend := state.NewMemory()
+	provider := NewPrometheusProvider(t.Context(), &PrometheusProviderConfig{}, backend)
+	if err := provider.Trigger(t.Context(), TriggerRequest{Environment: "state-env", Namespace: "state-ns"}); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	rec, ok, err := backend.Get(t.Context(), state.Key(statePrefix, "state-ns", "state-env"))
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %t, %v, want record", rec, ok, err)
+	}
+
+	// A restarted provider restores the metric from the state
+	ignitionRequestedAt.DeleteLabelValues("state-env", "state-ns")
+	NewPrometheusProvider(t.Context(), &PrometheusProviderConfig{}, backend)
+
+	if got := testutil.ToFloat64(ignitionRequestedAt.WithLabelValues("state-env", "state-ns")); got != float64(rec.UpdatedAt.Unix()) {
+		t.Fatalf("restored metric = %v, want %d", got, rec.UpdatedAt.Unix())
+	}
+}
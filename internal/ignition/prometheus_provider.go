@@ -3,12 +3,18 @@ package ignition
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sberz/ephemeral-envs/internal/state"
 )
 
+// statePrefix is the prefix of the state keys of ignition records.
+const statePrefix = "ignition"
+
 var ignitionRequestedAt = promauto.NewGaugeVec(prometheus.GaugeOpts{
 	Name: "ephemeralenv_last_ignition_requested",
 	Help: "Unix timestamp of the latest ignition trigger request",
@@ -16,17 +22,49 @@ var ignitionRequestedAt = promauto.NewGaugeVec(prometheus.GaugeOpts{
 
 var ErrEnvironmentRequired = errors.New("environment is required")
 
-type PrometheusProvider struct{}
+// PrometheusProvider exposes ignition requests as metric. Requests are recorded in the state
+// backend, so the metric survives restarts.
+type PrometheusProvider struct {
+	backend state.Backend
+}
 
-func NewPrometheusProvider(_ *PrometheusProviderConfig) *PrometheusProvider {
-	return &PrometheusProvider{}
+// NewPrometheusProvider creates the provider and restores the metric from the ignition
+// records in backend.
+func NewPrometheusProvider(ctx context.Context, _ *PrometheusProviderConfig, backend state.Backend) *PrometheusProvider {
+	p := &PrometheusProvider{backend: backend}
+	p.restore(ctx)
+	return p
 }
 
-func (p *PrometheusProvider) Trigger(_ context.Context, req TriggerRequest) error {
+func (p *PrometheusProvider) Trigger(ctx context.Context, req TriggerRequest) error {
 	if req.Environment == "" {
 		return ErrEnvironmentRequired
 	}
 
-	ignitionRequestedAt.WithLabelValues(req.Environment, req.Namespace).Set(float64(time.Now().Unix()))
+	now := time.Now()
+	ignitionRequestedAt.WithLabelValues(req.Environment, req.Namespace).Set(float64(now.Unix()))
+
+	key := state.Key(statePrefix, req.Namespace, req.Environment)
+	if err := p.backend.Set(ctx, key, state.Record{UpdatedAt: now}); err != nil {
+		// The request was accepted, it is only not preserved
+		slog.WarnContext(ctx, "failed to record ignition request", "environment", req.Environment, "namespace", req.Namespace, "error", err)
+	}
 	return nil
 }
+
+func (p *PrometheusProvider) restore(ctx context.Context) {
+	records, err := p.backend.List(ctx, statePrefix+"/")
+	if err != nil {
+		slog.WarnContext(ctx, "failed to restore ignition requests", "error", err)
+		return
+	}
+
+	for key, rec := range records {
+		namespace, env, ok := strings.Cut(strings.TrimPrefix(key, statePrefix+"/"), "/")
+		if !ok || env == "" {
+			continue
+		}
+		ignitionRequestedAt.WithLabelValues(env, namespace).Set(float64(rec.UpdatedAt.Unix()))
+	}
+	slog.DebugContext(ctx, "restored ignition requests", "count", len(records))
+}
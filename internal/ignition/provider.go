@@ -6,6 +6,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sberz/ephemeral-envs/internal/state"
 )
 
 var ignitionTriggers = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -29,7 +30,12 @@ func (p *instrumentedProvider) Trigger(ctx context.Context, req TriggerRequest)
 	return nil
 }
 
-func NewProvider(cfg *ProviderConfig) (Provider, error) {
+func (p *instrumentedProvider) Healthy(ctx context.Context) error {
+	return CheckHealth(ctx, p.next)
+}
+
+// NewProvider creates the configured provider. Ignition requests are recorded in backend.
+func NewProvider(ctx context.Context, cfg *ProviderConfig, backend state.Backend) (Provider, error) {
 	if cfg == nil {
 		return nil, ErrProviderConfigRequired
 	}
@@ -41,7 +47,7 @@ func NewProvider(cfg *ProviderConfig) (Provider, error) {
 		}
 		return &instrumentedProvider{
 			providerName: string(cfg.Type),
-			next:         NewPrometheusProvider(cfg.Prometheus),
+			next:         NewPrometheusProvider(ctx, cfg.Prometheus, backend),
 		}, nil
 	default:
 		return nil, fmt.Errorf("%w: %q", ErrUnsupportedProviderType, cfg.Type)
@@ -0,0 +1,39 @@
+package kube
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// EventComponent is the source component of the Kubernetes Events emitted by the service.
+const EventComponent = "ephemeral-envs"
+
+// NewEventRecorder creates a recorder that emits Kubernetes Events in the cluster of client.
+// Events are sent in the background until ctx is done.
+func NewEventRecorder(ctx context.Context, client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	go func() {
+		<-ctx.Done()
+		broadcaster.Shutdown()
+	}()
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: EventComponent})
+}
+
+// NamespaceReference returns the reference to a namespace, used as the object of its Events.
+// The Events are created in the namespace itself, so they are listed by `kubectl describe ns`.
+func NamespaceReference(name string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       name,
+		Namespace:  name,
+	}
+}
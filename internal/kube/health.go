@@ -0,0 +1,99 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchErrorTTL is the time after which a watch error is considered resolved if it does not
+// reoccur. The reflector retries failed watches with a backoff of at most 30 seconds.
+const watchErrorTTL = time.Minute
+
+var ErrNotSynced = errors.New("initial sync is not complete")
+
+// WatchHealth tracks the health of an informer watch: whether the initial sync is complete,
+// the last watch error and the time of the last event.
+type WatchHealth struct {
+	lastErrorAt time.Time
+	lastError   error
+	// Name identifies the watch, e.g. the cluster and resource.
+	Name      string
+	lastEvent atomic.Int64
+	mu        sync.RWMutex
+	synced    atomic.Bool
+}
+
+// NewWatchHealth creates the health of a watch that has not been synced yet.
+func NewWatchHealth(name string) *WatchHealth {
+	return &WatchHealth{Name: name}
+}
+
+// Err returns why the watch is unhealthy, or nil if it is synced and has no recent watch errors.
+func (h *WatchHealth) Err() error {
+	if !h.synced.Load() {
+		return ErrNotSynced
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.lastError == nil || time.Since(h.lastErrorAt) > watchErrorTTL || h.LastEvent().After(h.lastErrorAt) {
+		return nil
+	}
+	return h.lastError
+}
+
+// LastEvent returns the time of the last event, or the zero time if there was no event.
+func (h *WatchHealth) LastEvent() time.Time {
+	nanos := h.lastEvent.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (h *WatchHealth) recordEvent() {
+	h.lastEvent.Store(time.Now().UnixNano())
+}
+
+func (h *WatchHealth) recordError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastError = err
+	h.lastErrorAt = time.Now()
+}
+
+// observe registers the handlers that track the health of the informer. It must be called
+// before the informer is started. A nil WatchHealth does not track anything.
+func (h *WatchHealth) observe(informer cache.SharedIndexInformer) error {
+	if h == nil {
+		return nil
+	}
+
+	if err := informer.SetWatchErrorHandlerWithContext(func(ctx context.Context, r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(ctx, r, err)
+		h.recordError(err)
+	}); err != nil {
+		return err //nolint:wrapcheck // Wrapped by the caller
+	}
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { h.recordEvent() },
+		UpdateFunc: func(any, any) { h.recordEvent() },
+		DeleteFunc: func(any) { h.recordEvent() },
+	})
+	return err //nolint:wrapcheck // Wrapped by the caller
+}
+
+// markSynced marks the initial sync of the watch as complete.
+func (h *WatchHealth) markSynced() {
+	if h != nil {
+		h.synced.Store(true)
+	}
+}
@@ -0,0 +1,75 @@
+package kube
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var errWatch = errors.New("watch failed")
+
+func TestWatchHealthErr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		lastErrorAt time.Time
+		lastEvent   time.Time
+		lastError   error
+		wantErr     error
+		name        string
+		synced      bool
+	}{
+		{name: "not synced", wantErr: ErrNotSynced},
+		{name: "synced", synced: true},
+		{name: "recent watch error", synced: true, lastError: errWatch, lastErrorAt: time.Now(), wantErr: errWatch},
+		{name: "expired watch error", synced: true, lastError: errWatch, lastErrorAt: time.Now().Add(-2 * watchErrorTTL)},
+		{name: "event after watch error", synced: true, lastError: errWatch, lastErrorAt: time.Now().Add(-time.Second), lastEvent: time.Now()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewWatchHealth("test")
+			h.synced.Store(tt.synced)
+			h.lastError = tt.lastError
+			h.lastErrorAt = tt.lastErrorAt
+			if !tt.lastEvent.IsZero() {
+				h.lastEvent.Store(tt.lastEvent.UnixNano())
+			}
+
+			if err := h.Err(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Err() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWatchNamespaceEventsTracksHealth(t *testing.T) {
+	t.Parallel()
+
+	ns := &corev1.Namespace{}
+	ns.Name = "env-test"
+	client := fake.NewClientset(ns)
+
+	h := NewWatchHealth("namespaces")
+	if err := WatchNamespaceEvents(t.Context(), client, "", h, nil, nil, nil); err != nil {
+		t.Fatalf("WatchNamespaceEvents() error = %v", err)
+	}
+
+	if err := h.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil after sync", err)
+	}
+
+	// Event handlers are called asynchronously after the sync
+	deadline := time.Now().Add(5 * time.Second)
+	for h.LastEvent().IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("LastEvent() is zero, want time of the initial add event")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
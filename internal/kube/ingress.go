@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchIngressEvents registers event handlers for Ingress events in all namespaces.
+// onChange is called with the current state of added and updated Ingresses, onDelete
+// with the last known state of deleted Ingresses.
+// The health of the watch is tracked in health if it is not nil.
+func WatchIngressEvents(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	health *WatchHealth,
+	onChange func(ctx context.Context, ing *networkingv1.Ingress),
+	onDelete func(ctx context.Context, ing *networkingv1.Ingress),
+) error {
+	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+	informer := factory.Networking().V1().Ingresses().Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			ing := toIngress(ctx, obj)
+			if ing == nil || onChange == nil {
+				return
+			}
+			slog.DebugContext(ctx, "ingress added", "namespace", ing.Namespace, "name", ing.Name)
+			onChange(ctx, ing)
+		},
+		UpdateFunc: func(_, newObj any) {
+			ing := toIngress(ctx, newObj)
+			if ing == nil || onChange == nil {
+				return
+			}
+			slog.DebugContext(ctx, "ingress updated", "namespace", ing.Namespace, "name", ing.Name)
+			onChange(ctx, ing)
+		},
+		DeleteFunc: func(obj any) {
+			ing := toIngress(ctx, obj)
+			if ing == nil || onDelete == nil {
+				return
+			}
+			slog.DebugContext(ctx, "ingress deleted", "namespace", ing.Namespace, "name", ing.Name)
+			onDelete(ctx, ing)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event handler to ingress informer: %w", err)
+	}
+
+	if err := health.observe(informer); err != nil {
+		return fmt.Errorf("failed to observe ingress informer: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("%w: %v", ErrInformerCacheSyncFailed, informerType)
+		}
+	}
+
+	health.markSynced()
+	return nil
+}
+
+// HasResource reports whether the API server serves the given resource. Informers for
+// resources that are not installed, such as optional CRDs, never sync.
+func HasResource(client discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover resources of %s: %w", gvr.GroupVersion().String(), err)
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// toIngress converts the object from the event handler to a *networkingv1.Ingress.
+func toIngress(ctx context.Context, obj any) *networkingv1.Ingress {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	if tombstone, ok := obj.(*cache.DeletedFinalStateUnknown); ok && tombstone != nil {
+		obj = tombstone.Obj
+	}
+
+	ing, ok := obj.(*networkingv1.Ingress)
+	if !ok || ing == nil {
+		slog.ErrorContext(ctx, "received object is not an Ingress", "objectType", fmt.Sprintf("%T", obj))
+		return nil
+	}
+	return ing
+}
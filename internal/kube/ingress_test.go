@@ -0,0 +1,74 @@
+package kube
+
+import (
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestToIngress(t *testing.T) {
+	t.Parallel()
+
+	ing := &networkingv1.Ingress{}
+	ing.Name = "web"
+
+	tests := []struct {
+		want *networkingv1.Ingress
+		obj  any
+		name string
+	}{
+		{name: "ingress object", obj: ing, want: ing},
+		{name: "deleted tombstone value", obj: cache.DeletedFinalStateUnknown{Obj: ing}, want: ing},
+		{name: "deleted tombstone pointer", obj: &cache.DeletedFinalStateUnknown{Obj: ing}, want: ing},
+		{name: "invalid object", obj: "nope", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := toIngress(t.Context(), tt.obj)
+			if got != tt.want {
+				t.Fatalf("toIngress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasResource(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "gateway.networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{{Name: "httproutes"}},
+	}}
+
+	tests := []struct {
+		gvr  schema.GroupVersionResource
+		name string
+		want bool
+	}{
+		{name: "served", gvr: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}, want: true},
+		{name: "unknown resource", gvr: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "grpcroutes"}, want: false},
+		{name: "unknown group", gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "routes"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := HasResource(client.Discovery(), tt.gvr)
+			if err != nil {
+				t.Fatalf("HasResource() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("HasResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -20,14 +21,40 @@ import (
 
 var ErrInformerCacheSyncFailed = errors.New("failed to sync informer cache")
 
-// GetClient return a configured Kubernetes client. It uses the kube config file set in the KUBECONFIG environment variable if it is set, otherwise it uses in-cluster configuration.
-func GetClient() (*kubernetes.Clientset, error) {
-	kubeconfig := os.Getenv("KUBECONFIG")
+// ClusterConfig defines how to connect to a Kubernetes cluster.
+type ClusterConfig struct {
+	// Name identifies the cluster. It is used to tag discovered environments.
+	Name string `yaml:"name"`
+	// Kubeconfig is the path to a kube config file (e.g. a mounted secret).
+	// If empty, the KUBECONFIG environment variable or the in-cluster configuration is used.
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context is the kube config context to use. Defaults to the current context.
+	Context string `yaml:"context"`
+}
+
+// Clients holds the Kubernetes clients of a single cluster.
+type Clients struct {
+	Kubernetes kubernetes.Interface
+	Dynamic    dynamic.Interface
+	// Cluster is the name of the cluster.
+	Cluster string
+}
+
+// getConfig returns the Kubernetes client config. It uses the kube config file of the cluster config or the KUBECONFIG environment variable if it is set, otherwise it uses in-cluster configuration.
+func getConfig(cfg ClusterConfig) (*rest.Config, error) {
+	kubeconfig := cfg.Kubeconfig
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+
 	var config *rest.Config
 	var err error
 
 	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+			&clientcmd.ConfigOverrides{CurrentContext: cfg.Context},
+		).ClientConfig()
 	} else {
 		config, err = rest.InClusterConfig()
 	}
@@ -35,6 +62,16 @@ func GetClient() (*kubernetes.Clientset, error) {
 		return nil, fmt.Errorf("failed to create Kubernetes client config: %w", err)
 	}
 
+	return config, nil
+}
+
+// GetClient return a configured Kubernetes client. It uses the kube config file set in the KUBECONFIG environment variable if it is set, otherwise it uses in-cluster configuration.
+func GetClient() (*kubernetes.Clientset, error) {
+	config, err := getConfig(ClusterConfig{})
+	if err != nil {
+		return nil, err
+	}
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
@@ -43,13 +80,40 @@ func GetClient() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
+// NewClients returns the typed and dynamic Kubernetes clients for the configured cluster.
+func NewClients(cfg ClusterConfig) (*Clients, error) {
+	config, err := getConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+
+	return &Clients{
+		Kubernetes: clientset,
+		Dynamic:    dynamicClient,
+		Cluster:    cfg.Name,
+	}, nil
+}
+
 // WatchNamespaceEvents registers event handlers for namespace events in the Kubernetes cluster.
 // Only namespaces matching the provided label selector will trigger the handlers.
-// onAdd, onUpdate, onDelete are called with *corev1.Namespace as argument.
+// The health of the watch is tracked in health if it is not nil.
+// onAdd, onUpdate, onDelete are called with *corev1.Namespace as argument. InInitialList
+// reports whether onAdd is called for a namespace of the initial list.
 func WatchNamespaceEvents(
 	ctx context.Context,
-	clientset *kubernetes.Clientset,
+	clientset kubernetes.Interface,
 	labelSelector string,
+	health *WatchHealth,
 	onAdd func(ctx context.Context, ns *corev1.Namespace),
 	onUpdate func(ctx context.Context, oldNs, newNs *corev1.Namespace),
 	onDelete func(ctx context.Context, ns *corev1.Namespace),
@@ -61,16 +125,16 @@ func WatchNamespaceEvents(
 	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute, opts)
 	nsInformer := factory.Core().V1().Namespaces().Informer()
 
-	_, err := nsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj any) {
+	_, err := nsInformer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj any, isInInitialList bool) {
 			ns := toNamespace(ctx, obj)
 			if ns == nil {
 				return
 			}
-			slog.DebugContext(ctx, "namespace added", "name", ns.Name, "labels", ns.Labels)
+			slog.DebugContext(ctx, "namespace added", "name", ns.Name, "labels", ns.Labels, "initial_list", isInInitialList)
 
 			if onAdd != nil {
-				onAdd(ctx, ns)
+				onAdd(addContext(ctx, isInInitialList), ns)
 			} else {
 				slog.WarnContext(ctx, "onAdd handler is nil, skipping add event", "name", ns.Name)
 			}
@@ -110,6 +174,10 @@ func WatchNamespaceEvents(
 		return fmt.Errorf("failed to add event handler to namespace informer: %w", err)
 	}
 
+	if err := health.observe(nsInformer); err != nil {
+		return fmt.Errorf("failed to observe namespace informer: %w", err)
+	}
+
 	factory.Start(ctx.Done())
 	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
 		if !synced {
@@ -117,9 +185,28 @@ func WatchNamespaceEvents(
 		}
 	}
 
+	health.markSynced()
 	return nil
 }
 
+// initialListKey is the context key marking add events of objects in the initial list.
+type initialListKey struct{}
+
+// InInitialList reports whether the context of an add event belongs to an object of the
+// initial list of the informer, e.g. an object that already existed before a restart.
+func InInitialList(ctx context.Context) bool {
+	initial, _ := ctx.Value(initialListKey{}).(bool)
+	return initial
+}
+
+// addContext returns the context of an add event.
+func addContext(ctx context.Context, isInInitialList bool) context.Context {
+	if !isInInitialList {
+		return ctx
+	}
+	return context.WithValue(ctx, initialListKey{}, true)
+}
+
 // toNamespace converts the object from the event handler to a *corev1.Namespace.
 func toNamespace(ctx context.Context, obj any) *corev1.Namespace {
 	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
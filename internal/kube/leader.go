@@ -0,0 +1,201 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseName     = "ephemeral-envs"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+
+	// serviceAccountNamespaceFile holds the namespace of the pod when running in a cluster.
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var (
+	ErrInvalidLeaderElection = errors.New("invalid leader election config")
+	ErrNoNamespace           = errors.New("namespace is not set and can not be detected")
+)
+
+// LeaderElectionConfig configures the Lease based leader election.
+type LeaderElectionConfig struct {
+	// LeaseName is the name of the Lease. Defaults to `ephemeral-envs`.
+	LeaseName string `yaml:"leaseName"`
+	// LeaseNamespace is the namespace of the Lease. Defaults to the namespace of the pod.
+	LeaseNamespace string `yaml:"leaseNamespace"`
+	// Cluster is the name of the cluster holding the Lease. Defaults to the first cluster.
+	Cluster string `yaml:"cluster"`
+	// LeaseDuration is the time non-leaders wait before they try to acquire the Lease.
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+	// RenewDeadline is the time the leader retries to renew the Lease before giving up.
+	RenewDeadline time.Duration `yaml:"renewDeadline"`
+	// RetryPeriod is the time between attempts to acquire or renew the Lease.
+	RetryPeriod time.Duration `yaml:"retryPeriod"`
+	// Enabled enables leader election. Without leader election, every replica is the leader.
+	Enabled bool `yaml:"enabled"`
+}
+
+// ApplyDefaults sets the default values of unset fields.
+func (c *LeaderElectionConfig) ApplyDefaults() {
+	if c.LeaseName == "" {
+		c.LeaseName = defaultLeaseName
+	}
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = defaultLeaseDuration
+	}
+	if c.RenewDeadline == 0 {
+		c.RenewDeadline = defaultRenewDeadline
+	}
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = defaultRetryPeriod
+	}
+}
+
+func (c *LeaderElectionConfig) Validate() error {
+	if c.LeaseDuration <= c.RenewDeadline {
+		return fmt.Errorf("%w: leaseDuration must be greater than renewDeadline", ErrInvalidLeaderElection)
+	}
+	if c.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(c.RetryPeriod)) {
+		return fmt.Errorf("%w: renewDeadline must be greater than %.1f times retryPeriod", ErrInvalidLeaderElection, leaderelection.JitterFactor)
+	}
+	if c.RetryPeriod <= 0 {
+		return fmt.Errorf("%w: retryPeriod must be positive", ErrInvalidLeaderElection)
+	}
+	return nil
+}
+
+// Leadership tracks whether this replica is the leader and runs singleton work while it is.
+// The API is served by all replicas, only work that must not run concurrently is gated on
+// the leadership.
+type Leadership struct {
+	// identity is the name of this replica in the Lease.
+	identity  string
+	onLeading []func(ctx context.Context)
+	mu        sync.Mutex
+	leader    atomic.Bool
+	enabled   bool
+}
+
+// NewLeadership creates the leadership of this replica. If leader election is disabled,
+// this replica is the leader once Run is called.
+func NewLeadership(enabled bool) *Leadership {
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &Leadership{identity: identity, enabled: enabled}
+}
+
+// Enabled reports whether leader election is enabled.
+func (l *Leadership) Enabled() bool {
+	return l.enabled
+}
+
+// IsLeader reports whether this replica is currently the leader.
+func (l *Leadership) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// OnLeading registers singleton work. fn is called each time this replica becomes the leader,
+// its context is canceled when the leadership is lost. It must be called before Run.
+func (l *Leadership) OnLeading(fn func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onLeading = append(l.onLeading, fn)
+}
+
+// Run takes part in the leader election until ctx is done. Without leader election, the
+// singleton work is started immediately.
+func (l *Leadership) Run(ctx context.Context, client kubernetes.Interface, cfg LeaderElectionConfig) error {
+	if !l.enabled {
+		l.startedLeading(ctx)
+		return nil
+	}
+
+	namespace, err := PodNamespace(cfg.LeaseNamespace)
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: cfg.LeaseName, Namespace: namespace},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: l.identity},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: l.startedLeading,
+			OnStoppedLeading: func() {
+				l.leader.Store(false)
+				slog.InfoContext(ctx, "stopped leading", "identity", l.identity)
+			},
+			OnNewLeader: func(identity string) {
+				slog.InfoContext(ctx, "observed new leader", "leader", identity, "identity", l.identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidLeaderElection, err)
+	}
+
+	slog.InfoContext(ctx, "starting leader election", "lease", cfg.LeaseName, "namespace", namespace, "identity", l.identity)
+	go func() {
+		// Run returns when the leadership is lost, so take part in the election again
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+
+	return nil
+}
+
+func (l *Leadership) startedLeading(ctx context.Context) {
+	l.leader.Store(true)
+	slog.InfoContext(ctx, "started leading", "identity", l.identity, "leader_election", l.enabled)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, fn := range l.onLeading {
+		go fn(ctx)
+	}
+}
+
+// PodNamespace returns the configured namespace, or the namespace of the pod if it is empty.
+func PodNamespace(namespace string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns, nil
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns, nil
+		}
+	}
+	return "", ErrNoNamespace
+}
@@ -0,0 +1,79 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElectionConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     LeaderElectionConfig
+		wantErr bool
+	}{
+		{name: "defaults", cfg: LeaderElectionConfig{}},
+		{name: "lease shorter than renew deadline", cfg: LeaderElectionConfig{LeaseDuration: 5 * time.Second}, wantErr: true},
+		{name: "renew deadline shorter than retry period", cfg: LeaderElectionConfig{RetryPeriod: 9 * time.Second}, wantErr: true},
+		{name: "negative retry period", cfg: LeaderElectionConfig{RetryPeriod: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.cfg.ApplyDefaults()
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidLeaderElection) {
+				t.Fatalf("Validate() error = %v, want %v", err, ErrInvalidLeaderElection)
+			}
+		})
+	}
+}
+
+func TestLeadershipRun(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "disabled", enabled: false},
+		{name: "enabled", enabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := NewLeadership(tt.enabled)
+			l.identity = "replica-a"
+
+			started := make(chan struct{})
+			l.OnLeading(func(context.Context) { close(started) })
+
+			cfg := LeaderElectionConfig{LeaseNamespace: "ephemeral-envs", Enabled: tt.enabled}
+			cfg.ApplyDefaults()
+			if err := l.Run(t.Context(), fake.NewClientset(), cfg); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("singleton work was not started")
+			}
+			if !l.IsLeader() {
+				t.Fatal("IsLeader() = false, want true")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchResourceEvents registers event handlers for events of an arbitrary resource in all namespaces.
+// Only objects matching the provided label selector will trigger the handlers.
+// The health of the watch is tracked in health if it is not nil.
+// onAdd, onUpdate, onDelete are called with *unstructured.Unstructured as argument.
+// InInitialList reports whether onAdd is called for an object of the initial list.
+func WatchResourceEvents(
+	ctx context.Context,
+	client dynamic.Interface,
+	gvr schema.GroupVersionResource,
+	labelSelector string,
+	health *WatchHealth,
+	onAdd func(ctx context.Context, obj *unstructured.Unstructured),
+	onUpdate func(ctx context.Context, oldObj, newObj *unstructured.Unstructured),
+	onDelete func(ctx context.Context, obj *unstructured.Unstructured),
+) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 10*time.Minute, metav1.NamespaceAll, func(lo *metav1.ListOptions) {
+		lo.LabelSelector = labelSelector
+	})
+	informer := factory.ForResource(gvr).Informer()
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj any, isInInitialList bool) {
+			u := toUnstructured(ctx, obj)
+			if u == nil {
+				return
+			}
+			slog.DebugContext(ctx, "resource added", "resource", gvr.String(), "namespace", u.GetNamespace(), "name", u.GetName(), "labels", u.GetLabels(), "initial_list", isInInitialList)
+
+			if onAdd != nil {
+				onAdd(addContext(ctx, isInInitialList), u)
+			} else {
+				slog.WarnContext(ctx, "onAdd handler is nil, skipping add event", "resource", gvr.String(), "name", u.GetName())
+			}
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			oldU := toUnstructured(ctx, oldObj)
+			if oldU == nil {
+				return
+			}
+			newU := toUnstructured(ctx, newObj)
+			if newU == nil {
+				return
+			}
+			slog.DebugContext(ctx, "resource updated", "resource", gvr.String(), "namespace", newU.GetNamespace(), "name", newU.GetName(), "oldLabels", oldU.GetLabels(), "newLabels", newU.GetLabels())
+
+			if onUpdate != nil {
+				onUpdate(ctx, oldU, newU)
+			} else {
+				slog.WarnContext(ctx, "onUpdate handler is nil, skipping update event", "resource", gvr.String(), "name", newU.GetName())
+			}
+		},
+		DeleteFunc: func(obj any) {
+			u := toUnstructured(ctx, obj)
+			if u == nil {
+				return
+			}
+			slog.DebugContext(ctx, "resource deleted", "resource", gvr.String(), "namespace", u.GetNamespace(), "name", u.GetName(), "labels", u.GetLabels())
+
+			if onDelete != nil {
+				onDelete(ctx, u)
+			} else {
+				slog.WarnContext(ctx, "onDelete handler is nil, skipping delete event", "resource", gvr.String(), "name", u.GetName())
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event handler to %s informer: %w", gvr.String(), err)
+	}
+
+	if err := health.observe(informer); err != nil {
+		return fmt.Errorf("failed to observe resource informer: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("%w: %v", ErrInformerCacheSyncFailed, informerType)
+		}
+	}
+
+	health.markSynced()
+	return nil
+}
+
+// toUnstructured converts the object from the event handler to a *unstructured.Unstructured.
+func toUnstructured(ctx context.Context, obj any) *unstructured.Unstructured {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	if tombstone, ok := obj.(*cache.DeletedFinalStateUnknown); ok && tombstone != nil {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u == nil {
+		slog.ErrorContext(ctx, "received object is not an Unstructured", "objectType", fmt.Sprintf("%T", obj))
+		return nil
+	}
+	return u
+}
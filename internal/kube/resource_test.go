@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestToUnstructured(t *testing.T) {
+	t.Parallel()
+
+	obj := &unstructured.Unstructured{}
+	obj.SetName("api")
+	obj.SetNamespace("env-test")
+
+	tests := []struct {
+		want *unstructured.Unstructured
+		obj  any
+		name string
+	}{
+		{name: "unstructured object", obj: obj, want: obj},
+		{name: "deleted tombstone value", obj: cache.DeletedFinalStateUnknown{Obj: obj}, want: obj},
+		{name: "deleted tombstone pointer", obj: &cache.DeletedFinalStateUnknown{Obj: obj}, want: obj},
+		{name: "deleted tombstone nil pointer", obj: (*cache.DeletedFinalStateUnknown)(nil), want: nil},
+		{name: "invalid object", obj: "nope", want: nil},
+		{name: "tombstone invalid inner", obj: cache.DeletedFinalStateUnknown{Obj: 42}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := toUnstructured(t.Context(), tt.obj)
+			if got != tt.want {
+				t.Fatalf("toUnstructured() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
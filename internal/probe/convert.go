@@ -0,0 +1,193 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnmappedValue    = errors.New("value has no mapping")
+	ErrRegexNoMatch     = errors.New("regex does not match")
+	errInvalidConverter = errors.New("invalid convert config")
+)
+
+// TransformFunc adjusts the raw value and text of a probe before it is converted to the probe type.
+type TransformFunc func(value float64, text string) (float64, string, error)
+
+// Chain returns a converter applying the transforms in order before converter.
+func Chain[V Type](converter ConverterFunc[V], transforms ...TransformFunc) ConverterFunc[V] {
+	if len(transforms) == 0 {
+		return converter
+	}
+
+	return func(value float64, text string) (V, error) {
+		var err error
+		for _, transform := range transforms {
+			value, text, err = transform(value, text)
+			if err != nil {
+				var zero V
+				return zero, err
+			}
+		}
+		return converter(value, text)
+	}
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// parseText returns the numeric value of a text, keeping fallback for non-numeric texts.
+func parseText(text string, fallback float64) float64 {
+	if b, err := strconv.ParseBool(text); err == nil {
+		return boolValue(b)
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
+// Scale multiplies the value by factor, e.g. 1/1048576 to convert bytes to MiB.
+func Scale(factor float64) TransformFunc {
+	return func(value float64, _ string) (float64, string, error) {
+		value *= factor
+		return value, formatValue(value), nil
+	}
+}
+
+// Threshold compares the value with threshold. The result is 1 (`true`) if the comparison holds, else 0 (`false`).
+// Supported operators are `>`, `>=`, `<`, `<=`, `==` and `!=`.
+func Threshold(op string, threshold float64) (TransformFunc, error) {
+	var cmp func(float64) bool
+	switch op {
+	case ">":
+		cmp = func(v float64) bool { return v > threshold }
+	case ">=":
+		cmp = func(v float64) bool { return v >= threshold }
+	case "<":
+		cmp = func(v float64) bool { return v < threshold }
+	case "<=":
+		cmp = func(v float64) bool { return v <= threshold }
+	case "==":
+		cmp = func(v float64) bool { return v == threshold }
+	case "!=":
+		cmp = func(v float64) bool { return v != threshold }
+	default:
+		return nil, fmt.Errorf("%w: unsupported threshold operator %q", errInvalidConverter, op)
+	}
+
+	return func(value float64, _ string) (float64, string, error) {
+		ok := cmp(value)
+		return boolValue(ok), strconv.FormatBool(ok), nil
+	}, nil
+}
+
+// MapValues replaces the text with its mapped label. Keys are matched against the text,
+// which is the label value for queries with an extract label and the formatted value otherwise.
+// Numeric and boolean labels also replace the value.
+func MapValues(mapping map[string]string) TransformFunc {
+	return func(value float64, text string) (float64, string, error) {
+		label, ok := mapping[text]
+		if !ok {
+			label, ok = mapping[formatValue(value)]
+		}
+		if !ok {
+			return 0, "", fmt.Errorf("%w: %q", ErrUnmappedValue, text)
+		}
+		return parseText(label, value), label, nil
+	}
+}
+
+// ExtractRegex replaces the text with the first capture group of re, or the whole match
+// if re has no groups. Numeric results also replace the value.
+func ExtractRegex(re *regexp.Regexp) TransformFunc {
+	return func(value float64, text string) (float64, string, error) {
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			return 0, "", fmt.Errorf("%w: %q", ErrRegexNoMatch, text)
+		}
+
+		extracted := match[0]
+		if len(match) > 1 {
+			extracted = match[1]
+		}
+		return parseText(extracted, value), extracted, nil
+	}
+}
+
+// ConvertConfig configures how the raw value of a probe is converted. The steps are
+// applied in the order regex, map, scale and threshold.
+type ConvertConfig struct {
+	// Map maps values (or extracted labels) to labels, e.g. `0: stopped`.
+	Map map[string]string `yaml:"map"`
+	// Regex extracts the first capture group from the text, e.g. the label selected by extractLabel.
+	Regex string `yaml:"regex"`
+	// Threshold compares the value, e.g. `> 0.95`.
+	Threshold string `yaml:"threshold"`
+	// Scale multiplies the value, e.g. `9.5367431640625e-07` (1/1048576) to convert bytes to MiB.
+	Scale float64 `yaml:"scale"`
+}
+
+// IsZero reports whether no conversion is configured.
+func (c ConvertConfig) IsZero() bool {
+	return len(c.Map) == 0 && c.Regex == "" && c.Threshold == "" && c.Scale == 0
+}
+
+func (c ConvertConfig) Validate() error {
+	_, err := c.Transforms()
+	return err
+}
+
+// Transforms returns the configured conversion steps.
+func (c ConvertConfig) Transforms() ([]TransformFunc, error) {
+	var transforms []TransformFunc
+
+	if c.Regex != "" {
+		re, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid regex: %w", errInvalidConverter, err)
+		}
+		transforms = append(transforms, ExtractRegex(re))
+	}
+
+	if len(c.Map) > 0 {
+		transforms = append(transforms, MapValues(c.Map))
+	}
+
+	if c.Scale != 0 {
+		transforms = append(transforms, Scale(c.Scale))
+	}
+
+	if c.Threshold != "" {
+		op, value, err := parseThreshold(c.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		t, err := Threshold(op, value)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+
+	return transforms, nil
+}
+
+// parseThreshold splits a threshold like `> 0.95` into operator and value.
+func parseThreshold(threshold string) (string, float64, error) {
+	threshold = strings.TrimSpace(threshold)
+	i := strings.IndexFunc(threshold, func(r rune) bool { return !strings.ContainsRune("<>=!", r) })
+	if i <= 0 {
+		return "", 0, fmt.Errorf("%w: threshold %q must start with an operator", errInvalidConverter, threshold)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(threshold[i:]), 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: invalid threshold value in %q", errInvalidConverter, threshold)
+	}
+	return threshold[:i], value, nil
+}
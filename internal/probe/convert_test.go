@@ -0,0 +1,100 @@
+package probe
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		wantErr  error
+		name     string
+		text     string
+		wantText string
+		cfg      ConvertConfig
+		value    float64
+		want     float64
+	}{
+		{name: "no conversion", value: 3, text: "3", want: 3, wantText: "3"},
+		{name: "threshold above", cfg: ConvertConfig{Threshold: "> 0.95"}, value: 0.99, text: "0.99", want: 1, wantText: "true"},
+		{name: "threshold below", cfg: ConvertConfig{Threshold: ">0.95"}, value: 0.5, text: "0.5", want: 0, wantText: "false"},
+		{name: "threshold less or equal", cfg: ConvertConfig{Threshold: "<= 2"}, value: 2, text: "2", want: 1, wantText: "true"},
+		{name: "map value", cfg: ConvertConfig{Map: map[string]string{"0": "stopped", "2": "running"}}, value: 2, text: "2", want: 2, wantText: "running"},
+		{name: "map to bool", cfg: ConvertConfig{Map: map[string]string{"running": "true"}}, value: 0, text: "running", want: 1, wantText: "true"},
+		{name: "map missing value", cfg: ConvertConfig{Map: map[string]string{"0": "stopped"}}, value: 5, text: "5", wantErr: ErrUnmappedValue},
+		{name: "scale bytes to MiB", cfg: ConvertConfig{Scale: 1.0 / 1048576}, value: 3145728, text: "3145728", want: 3, wantText: "3"},
+		{name: "regex capture group", cfg: ConvertConfig{Regex: `^v(\d+)\.`}, value: 1, text: "v12.3.0", want: 12, wantText: "12"},
+		{name: "regex whole match", cfg: ConvertConfig{Regex: `[a-z]+`}, value: 1, text: "team-core", want: 1, wantText: "team"},
+		{name: "regex no match", cfg: ConvertConfig{Regex: `^v\d+`}, value: 1, text: "main", wantErr: ErrRegexNoMatch},
+		{
+			name:     "steps are composed",
+			cfg:      ConvertConfig{Regex: `size=(\d+)`, Scale: 0.001, Threshold: ">= 2"},
+			value:    1,
+			text:     "size=2500",
+			want:     1,
+			wantText: "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transforms, err := tt.cfg.Transforms()
+			if err != nil {
+				t.Fatalf("Transforms() error = %v", err)
+			}
+
+			gotValue, err := Chain(PromValToFloat, transforms...)(tt.value, tt.text)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("converter error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("converter error = %v", err)
+			}
+			if gotValue != tt.want {
+				t.Fatalf("value = %v, want %v", gotValue, tt.want)
+			}
+
+			gotText, err := Chain(PromValToString, transforms...)(tt.value, tt.text)
+			if err != nil {
+				t.Fatalf("converter error = %v", err)
+			}
+			if gotText != tt.wantText {
+				t.Fatalf("text = %q, want %q", gotText, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestConvertConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     ConvertConfig
+		wantErr bool
+	}{
+		{name: "empty", cfg: ConvertConfig{}},
+		{name: "valid threshold", cfg: ConvertConfig{Threshold: "!= 0"}},
+		{name: "threshold without operator", cfg: ConvertConfig{Threshold: "0.95"}, wantErr: true},
+		{name: "threshold with unknown operator", cfg: ConvertConfig{Threshold: "=> 1"}, wantErr: true},
+		{name: "threshold without value", cfg: ConvertConfig{Threshold: ">"}, wantErr: true},
+		{name: "invalid regex", cfg: ConvertConfig{Regex: "("}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/prometheus"
+)
+
+// KindDerived is the probe kind for status checks computed from other checks and metadata.
+const KindDerived prometheus.QueryKind = "derived"
+
+var (
+	ErrDependencyCycle    = errors.New("dependency cycle")
+	errInvalidDerivedConf = errors.New("invalid derived check config")
+)
+
+// DerivedConfig configures a status check derived from other checks and metadata.
+type DerivedConfig struct {
+	// Expr is the boolean expression, e.g. `active && healthy && meta.pods > 0`.
+	Expr string `yaml:"expr"`
+}
+
+func (c DerivedConfig) Validate() error {
+	if c.Expr == "" {
+		return fmt.Errorf("%w: expr must be set", errInvalidDerivedConf)
+	}
+
+	if _, err := ParseExpression(c.Expr); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidDerivedConf, err)
+	}
+
+	return nil
+}
+
+// CheckDependencyCycles returns an error if the expressions of derived checks,
+// keyed by check name, reference each other in a cycle.
+func CheckDependencyCycles(exprs map[string]*Expression) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(exprs))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %v", ErrDependencyCycle, append(path, name))
+		case done:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range exprs[name].Checks() {
+			if _, ok := exprs[dep]; !ok {
+				continue
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+
+	// Sorted for deterministic error messages
+	names := make([]string, 0, len(exprs))
+	for name := range exprs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DerivedProber creates probes evaluating an expression over the other probes of an environment.
+type DerivedProber struct {
+	expr *Expression
+}
+
+var _ Prober[bool] = (*DerivedProber)(nil)
+
+// NewDerivedProber creates a prober for the configured expression.
+func NewDerivedProber(cfg DerivedConfig) (*DerivedProber, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	expr, err := ParseExpression(cfg.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidDerivedConf, err)
+	}
+
+	return &DerivedProber{expr: expr}, nil
+}
+
+// AddEnvironment creates a probe reading its inputs from the Checks and Metadata
+// of env. Inputs are resolved on evaluation, so the maps may still be filled afterwards.
+func (p *DerivedProber) AddEnvironment(env Target) (Probe[bool], error) {
+	return &DerivedProbe{
+		expr:   p.expr,
+		inputs: inputs{checks: env.Checks, metadata: env.Metadata},
+	}, nil
+}
+
+func (p *DerivedProber) RemoveEnvironment(_ Target) {}
+
+// DerivedProbe evaluates an expression over the probes of a single environment.
+type DerivedProbe struct {
+	expr   *Expression
+	inputs inputs
+}
+
+var _ Probe[bool] = (*DerivedProbe)(nil)
+
+func (p *DerivedProbe) Value(ctx context.Context) (bool, error) {
+	v, err := p.expr.evalBool(ctx, p.inputs)
+	if err != nil {
+		return false, fmt.Errorf("derived check %q failed: %w", p.expr, err)
+	}
+	return v, nil
+}
+
+// LastUpdate returns the oldest update time of the referenced inputs. Inputs
+// that never report an update time, like static probes, are ignored.
+func (p *DerivedProbe) LastUpdate() time.Time {
+	var oldest time.Time
+	consider := func(t time.Time) {
+		if !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+
+	for _, name := range p.expr.Checks() {
+		if c, ok := p.inputs.checks[name]; ok && c != nil {
+			consider(c.LastUpdate())
+		}
+	}
+	for _, name := range p.expr.Metadata() {
+		if m, ok := p.inputs.metadata[name]; ok && m != nil {
+			consider(m.LastUpdate())
+		}
+	}
+
+	return oldest
+}
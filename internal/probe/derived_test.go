@@ -0,0 +1,213 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type timedProbe[V Type] struct {
+	updated time.Time
+	err     error
+	value   V
+}
+
+func (p timedProbe[V]) Value(context.Context) (V, error) {
+	return p.value, p.err
+}
+
+func (p timedProbe[V]) LastUpdate() time.Time {
+	return p.updated
+}
+
+func TestParseExpression(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		expr         string
+		wantChecks   []string
+		wantMetadata []string
+		wantErr      bool
+	}{
+		{name: "single check", expr: "active", wantChecks: []string{"active"}},
+		{name: "combined", expr: "active && healthy && meta.pods > 0", wantChecks: []string{"active", "healthy"}, wantMetadata: []string{"pods"}},
+		{name: "duplicate references", expr: "(a || b) && !a", wantChecks: []string{"a", "b"}},
+		{name: "literals", expr: `meta.version == "v1" || meta.ratio >= -0.5 || true`, wantMetadata: []string{"version", "ratio"}},
+		{name: "names with dashes", expr: "api-up && meta.build-id != \"\"", wantChecks: []string{"api-up"}, wantMetadata: []string{"build-id"}},
+		{name: "empty", expr: "", wantErr: true},
+		{name: "dangling operator", expr: "active &&", wantErr: true},
+		{name: "missing parenthesis", expr: "(active", wantErr: true},
+		{name: "unterminated string", expr: `meta.a == "x`, wantErr: true},
+		{name: "unknown character", expr: "active & healthy", wantErr: true},
+		{name: "missing metadata name", expr: "meta.", wantErr: true},
+		{name: "trailing tokens", expr: "active healthy", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			e, err := ParseExpression(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpression() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidExpression) {
+					t.Fatalf("expected ErrInvalidExpression, got %v", err)
+				}
+				return
+			}
+			if !slices.Equal(e.Checks(), tt.wantChecks) {
+				t.Errorf("Checks() = %v, want %v", e.Checks(), tt.wantChecks)
+			}
+			if !slices.Equal(e.Metadata(), tt.wantMetadata) {
+				t.Errorf("Metadata() = %v, want %v", e.Metadata(), tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestDerivedProbeValue(t *testing.T) {
+	t.Parallel()
+
+	errFailing := errors.New("query failed")
+	checks := map[string]Probe[bool]{
+		"active":  NewStaticProbe(true),
+		"healthy": NewStaticProbe(true),
+		"broken":  NewStaticProbe(false),
+		"failing": timedProbe[bool]{err: errFailing},
+	}
+	metadata := map[string]MetadataProbe{
+		"pods":    WrapProbe(NewStaticProbe(3.0)),
+		"version": WrapProbe(NewStaticProbe("v1.2.0")),
+		"flag":    WrapProbe(NewStaticProbe(true)),
+		"started": WrapProbe(NewStaticProbe(time.Unix(1000, 0))),
+	}
+
+	tests := []struct {
+		wantErr error
+		name    string
+		expr    string
+		want    bool
+	}{
+		{name: "and", expr: "active && healthy && meta.pods > 0", want: true},
+		{name: "and false", expr: "active && broken", want: false},
+		{name: "or", expr: "broken || healthy", want: true},
+		{name: "not", expr: "!broken", want: true},
+		{name: "precedence", expr: "broken && healthy || active", want: true},
+		{name: "parentheses", expr: "broken && (healthy || active)", want: false},
+		{name: "string comparison", expr: `meta.version == "v1.2.0"`, want: true},
+		{name: "number comparison", expr: "meta.pods <= 2", want: false},
+		{name: "bool metadata", expr: "meta.flag && meta.flag == true", want: true},
+		{name: "timestamp comparison", expr: "meta.started > 999", want: true},
+		{name: "short circuit skips failing input", expr: "broken && failing", want: false},
+		{name: "failing input", expr: "active && failing", wantErr: errFailing},
+		{name: "unknown check", expr: "missing", wantErr: ErrUnknownInput},
+		{name: "unknown metadata", expr: "meta.missing > 1", wantErr: ErrUnknownInput},
+		{name: "non boolean result", expr: "meta.pods", wantErr: errTypeMismatch},
+		{name: "mismatched comparison", expr: `meta.pods == "3"`, wantErr: errTypeMismatch},
+		{name: "ordered boolean comparison", expr: "active > broken", wantErr: errTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prober, err := NewDerivedProber(DerivedConfig{Expr: tt.expr})
+			if err != nil {
+				t.Fatalf("NewDerivedProber() error = %v", err)
+			}
+			p, err := prober.AddEnvironment(Target{Name: "env-a", Checks: checks, Metadata: metadata})
+			if err != nil {
+				t.Fatalf("AddEnvironment() error = %v", err)
+			}
+
+			got, err := p.Value(t.Context())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Value() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Value() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Value() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivedProbeLastUpdate(t *testing.T) {
+	t.Parallel()
+
+	older := time.Unix(1000, 0)
+	newer := time.Unix(2000, 0)
+	checks := map[string]Probe[bool]{
+		"active":  NewStaticProbe(true),
+		"healthy": timedProbe[bool]{value: true, updated: newer},
+		"unused":  timedProbe[bool]{value: true, updated: time.Unix(1, 0)},
+	}
+	metadata := map[string]MetadataProbe{
+		"pods": WrapProbe[float64](timedProbe[float64]{value: 1, updated: older}),
+	}
+
+	prober, err := NewDerivedProber(DerivedConfig{Expr: "active && healthy && meta.pods > 0"})
+	if err != nil {
+		t.Fatalf("NewDerivedProber() error = %v", err)
+	}
+	p, err := prober.AddEnvironment(Target{Checks: checks, Metadata: metadata})
+	if err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	if got := p.LastUpdate(); !got.Equal(older) {
+		t.Fatalf("LastUpdate() = %v, want %v", got, older)
+	}
+}
+
+func TestCheckDependencyCycles(t *testing.T) {
+	t.Parallel()
+
+	parse := func(exprs map[string]string) map[string]*Expression {
+		parsed := make(map[string]*Expression, len(exprs))
+		for name, src := range exprs {
+			e, err := ParseExpression(src)
+			if err != nil {
+				t.Fatalf("ParseExpression(%q) error = %v", src, err)
+			}
+			parsed[name] = e
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		exprs   map[string]string
+		name    string
+		wantErr bool
+	}{
+		{name: "no derived checks", exprs: map[string]string{}},
+		{name: "chain", exprs: map[string]string{"a": "b && active", "b": "c", "c": "healthy"}},
+		{name: "diamond", exprs: map[string]string{"a": "b && c", "b": "d", "c": "d", "d": "active"}},
+		{name: "self reference", exprs: map[string]string{"a": "a"}, wantErr: true},
+		{name: "cycle", exprs: map[string]string{"a": "b", "b": "c", "c": "!a && active"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := CheckDependencyCycles(parse(tt.exprs))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckDependencyCycles() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrDependencyCycle) {
+				t.Fatalf("expected ErrDependencyCycle, got %v", err)
+			}
+		})
+	}
+}
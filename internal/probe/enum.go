@@ -0,0 +1,101 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUnexpectedValue = errors.New("value is not allowed")
+	errInvalidEnumConf = errors.New("invalid enum check config")
+)
+
+// ValidateEnumValues checks the allowed values of an enum check. Values must be unique and
+// must not be empty or boolean, so they can be told apart from boolean checks.
+func ValidateEnumValues(values []string) error {
+	for i, v := range values {
+		switch v {
+		case "":
+			return fmt.Errorf("%w: values must not be empty", errInvalidEnumConf)
+		case "true", "false":
+			return fmt.Errorf("%w: value %q is reserved for boolean checks", errInvalidEnumConf, v)
+		}
+		if slices.Contains(values[:i], v) {
+			return fmt.Errorf("%w: duplicate value %q", errInvalidEnumConf, v)
+		}
+	}
+	return nil
+}
+
+// EnumProber creates probes of status checks with a fixed set of allowed values.
+type EnumProber struct {
+	prober Prober[string]
+	values []string
+}
+
+var _ Prober[string] = (*EnumProber)(nil)
+
+// NewEnumProber wraps prober so its probes fail for values that are not allowed.
+func NewEnumProber(prober Prober[string], values []string) (*EnumProber, error) {
+	if prober == nil {
+		return nil, fmt.Errorf("prober must be provided: %w", ErrInvalidNil)
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: values must be set", errInvalidEnumConf)
+	}
+	if err := ValidateEnumValues(values); err != nil {
+		return nil, err
+	}
+
+	return &EnumProber{prober: prober, values: values}, nil
+}
+
+// Values returns the allowed values.
+func (p *EnumProber) Values() []string {
+	return p.values
+}
+
+func (p *EnumProber) AddEnvironment(env Target) (Probe[string], error) {
+	probe, err := p.prober.AddEnvironment(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add environment: %w", err)
+	}
+	return NewEnumProbe(probe, p.values), nil
+}
+
+func (p *EnumProber) RemoveEnvironment(env Target) {
+	p.prober.RemoveEnvironment(env)
+}
+
+// EnumProbe validates the values of the wrapped probe.
+type EnumProbe struct {
+	probe  Probe[string]
+	values []string
+}
+
+// NewEnumProbe wraps probe so it fails for values that are not allowed.
+func NewEnumProbe(probe Probe[string], values []string) *EnumProbe {
+	return &EnumProbe{probe: probe, values: values}
+}
+
+var _ Probe[string] = (*EnumProbe)(nil)
+
+func (p *EnumProbe) Value(ctx context.Context) (string, error) {
+	v, err := p.probe.Value(ctx)
+	if err != nil {
+		return "", fmt.Errorf("enum probe failed: %w", err)
+	}
+
+	if !slices.Contains(p.values, v) {
+		return "", fmt.Errorf("%w: %q", ErrUnexpectedValue, v)
+	}
+	return v, nil
+}
+
+func (p *EnumProbe) LastUpdate() time.Time {
+	return p.probe.LastUpdate()
+}
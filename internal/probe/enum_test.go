@@ -0,0 +1,73 @@
+package probe
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateEnumValues(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr bool
+	}{
+		{name: "valid", values: []string{"pending", "running", "failed"}},
+		{name: "none", values: nil},
+		{name: "empty value", values: []string{"running", ""}, wantErr: true},
+		{name: "boolean value", values: []string{"true", "running"}, wantErr: true},
+		{name: "duplicate", values: []string{"running", "failed", "running"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateEnumValues(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateEnumValues() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnumProbeValue(t *testing.T) {
+	t.Parallel()
+
+	errFailing := errors.New("query failed")
+	values := []string{"pending", "running"}
+
+	tests := []struct {
+		probe   Probe[string]
+		wantErr error
+		name    string
+		want    string
+	}{
+		{name: "allowed value", probe: NewStaticProbe("running"), want: "running"},
+		{name: "unexpected value", probe: NewStaticProbe("crashed"), wantErr: ErrUnexpectedValue},
+		{name: "probe error", probe: timedProbe[string]{err: errFailing}, wantErr: errFailing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewEnumProbe(tt.probe, values).Value(t.Context())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Value() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Value() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEnumProberValidation(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewEnumProber(nil, []string{"running"}); !errors.Is(err, ErrInvalidNil) {
+		t.Fatalf("NewEnumProber(nil) error = %v, want ErrInvalidNil", err)
+	}
+}
@@ -0,0 +1,480 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrInvalidExpression = errors.New("invalid expression")
+	ErrUnknownInput      = errors.New("unknown expression input")
+	errTypeMismatch      = errors.New("type mismatch")
+)
+
+// metaPrefix is the prefix referencing metadata in expressions.
+const metaPrefix = "meta."
+
+// Expression is a parsed boolean expression over status checks and metadata,
+// e.g. `active && healthy && meta.pods > 0`.
+//
+// Supported are the operators `!`, `&&`, `||`, `==`, `!=`, `<`, `<=`, `>`, `>=`,
+// parentheses, the literals `true`, `false`, numbers and double quoted strings.
+// Bare identifiers reference status checks, `meta.<name>` references metadata.
+type Expression struct {
+	root     node
+	source   string
+	checks   []string
+	metadata []string
+}
+
+// inputs provides the values referenced by an expression.
+type inputs struct {
+	checks   map[string]Probe[bool]
+	metadata map[string]MetadataProbe
+}
+
+type node interface {
+	eval(ctx context.Context, in inputs) (any, error)
+}
+
+// ParseExpression parses an expression.
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidExpression, p.tokens[p.pos].text)
+	}
+
+	e := &Expression{root: root, source: source}
+	for _, t := range tokens {
+		if t.kind != tokenIdent {
+			continue
+		}
+		if name, ok := strings.CutPrefix(t.text, metaPrefix); ok {
+			e.metadata = appendUnique(e.metadata, name)
+		} else {
+			e.checks = appendUnique(e.checks, t.text)
+		}
+	}
+
+	return e, nil
+}
+
+func appendUnique(s []string, v string) []string {
+	if slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
+
+// Checks returns the names of the status checks referenced by the expression.
+func (e *Expression) Checks() []string {
+	return e.checks
+}
+
+// Metadata returns the names of the metadata referenced by the expression.
+func (e *Expression) Metadata() []string {
+	return e.metadata
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// evalBool evaluates the expression, which must result in a boolean.
+func (e *Expression) evalBool(ctx context.Context, in inputs) (bool, error) {
+	v, err := e.root.eval(ctx, in)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expression %q does not result in a boolean", errTypeMismatch, e.source)
+	}
+	return b, nil
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	text string
+	kind tokenKind
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")"})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidExpression)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:end])})
+			i = end
+		case isIdentRune(r):
+			end := i
+			for end < len(runes) && isIdentRune(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[i:end])})
+			i = end
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(string(runes[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected character %q", ErrInvalidExpression, r)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op})
+			i += len(op)
+		}
+	}
+
+	// true and false are literals, not check references
+	for i, t := range tokens {
+		if t.kind == tokenIdent && (t.text == "true" || t.text == "false") {
+			tokens[i].kind = tokenOperator
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) acceptOperator(ops ...string) (string, bool) {
+	t, ok := p.peek()
+	if ok && t.kind == tokenOperator && slices.Contains(ops, t.text) {
+		p.pos++
+		return t.text, true
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOperator("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.acceptOperator("!"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidExpression)
+	}
+	p.pos++
+
+	switch t.kind {
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.kind != tokenRParen {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidExpression)
+		}
+		p.pos++
+		return inner, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %q", ErrInvalidExpression, t.text)
+		}
+		return literalNode{value: f}, nil
+	case tokenString:
+		return literalNode{value: t.text}, nil
+	case tokenIdent:
+		if name, ok := strings.CutPrefix(t.text, metaPrefix); ok {
+			if name == "" {
+				return nil, fmt.Errorf("%w: missing metadata name", ErrInvalidExpression)
+			}
+			return metaNode{name: name}, nil
+		}
+		return checkNode{name: t.text}, nil
+	case tokenOperator:
+		if t.text == "true" || t.text == "false" {
+			return literalNode{value: t.text == "true"}, nil
+		}
+	case tokenRParen:
+	}
+
+	return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidExpression, t.text)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n literalNode) eval(context.Context, inputs) (any, error) {
+	return n.value, nil
+}
+
+type checkNode struct {
+	name string
+}
+
+func (n checkNode) eval(ctx context.Context, in inputs) (any, error) {
+	p, ok := in.checks[n.name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("%w: status check %q", ErrUnknownInput, n.name)
+	}
+
+	v, err := p.Value(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("status check %q: %w", n.name, err)
+	}
+	return v, nil
+}
+
+type metaNode struct {
+	name string
+}
+
+func (n metaNode) eval(ctx context.Context, in inputs) (any, error) {
+	p, ok := in.metadata[n.name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("%w: metadata %q", ErrUnknownInput, n.name)
+	}
+
+	v, err := p.Value(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("metadata %q: %w", n.name, err)
+	}
+
+	if t, ok := v.(time.Time); ok {
+		// Timestamps are compared as seconds since epoch
+		return float64(t.Unix()), nil
+	}
+	return v, nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(ctx context.Context, in inputs) (any, error) {
+	v, err := evalBool(ctx, n.operand, in)
+	if err != nil {
+		return nil, err
+	}
+	return !v, nil
+}
+
+type logicalNode struct {
+	left  node
+	right node
+	op    string
+}
+
+func (n logicalNode) eval(ctx context.Context, in inputs) (any, error) {
+	left, err := evalBool(ctx, n.left, in)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit evaluation
+	if n.op == "&&" && !left {
+		return false, nil
+	}
+	if n.op == "||" && left {
+		return true, nil
+	}
+
+	return evalBool(ctx, n.right, in)
+}
+
+func evalBool(ctx context.Context, n node, in inputs) (bool, error) {
+	v, err := n.eval(ctx, in)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expected boolean, got %T", errTypeMismatch, v)
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	left  node
+	right node
+	op    string
+}
+
+func (n compareNode) eval(ctx context.Context, in inputs) (any, error) {
+	left, err := n.left.eval(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot compare number with %T", errTypeMismatch, right)
+		}
+		return compareOrdered(n.op, l, r), nil
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot compare string with %T", errTypeMismatch, right)
+		}
+		return compareOrdered(n.op, l, r), nil
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot compare boolean with %T", errTypeMismatch, right)
+		}
+		switch n.op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+		return nil, fmt.Errorf("%w: operator %s is not supported for booleans", errTypeMismatch, n.op)
+	default:
+		return nil, fmt.Errorf("%w: cannot compare %T", errTypeMismatch, left)
+	}
+}
+
+func compareOrdered[T float64 | string](op string, l, r T) bool {
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
@@ -0,0 +1,478 @@
+package probe
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promAPI "github.com/sberz/ephemeral-envs/internal/prometheus"
+)
+
+// KindHTTP is the probe kind for checks calling an HTTP endpoint of the environment.
+const KindHTTP promAPI.QueryKind = "http"
+
+const (
+	// maxHTTPBodySize limits the response body read for JSON path extraction.
+	maxHTTPBodySize = 1 << 20
+	// defaultHTTPClientTimeout is the default limit of a request, including redirects.
+	defaultHTTPClientTimeout = 30 * time.Second
+)
+
+var (
+	ErrURLNotFound      = errors.New("environment URL not found")
+	ErrJSONPathNotFound = errors.New("json path not found")
+	ErrURLNotAllowed    = errors.New("URL not allowed")
+	errInvalidHTTPConf  = errors.New("invalid http check config")
+)
+
+var (
+	httpProbeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ephemeralenv_http_probe_duration_seconds",
+		Help: "Duration of HTTP probe requests",
+
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"probe_name", "status"})
+
+	httpProbeCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ephemeralenv_http_probe_cache_hits_total",
+		Help: "Total number of HTTP probe cache hits",
+	}, []string{"probe_name", "cache"})
+)
+
+// HTTPConfig configures a check calling an HTTP endpoint of the environment.
+type HTTPConfig struct {
+	// URLName is the name of the environment URL to call.
+	URLName string `yaml:"urlName"`
+	// URLTemplate is a Go template of the URL to call. It can use the fields
+	// name, namespace, cluster and url (the URL map of the environment).
+	URLTemplate string `yaml:"urlTemplate"`
+	// Path is appended to the URL.
+	Path string `yaml:"path"`
+	// JSONPath extracts a value from the JSON response body, e.g. `build.version`.
+	JSONPath string `yaml:"jsonPath"`
+	// ExpectedStatus lists the accepted status codes. Defaults to any 2xx status.
+	ExpectedStatus []int `yaml:"expectedStatus"`
+	// MaxLatency is the maximum duration of a successful request. Zero disables the check.
+	MaxLatency time.Duration `yaml:"maxLatency"`
+}
+
+func (c HTTPConfig) Validate() error {
+	if (c.URLName == "") == (c.URLTemplate == "") {
+		return fmt.Errorf("%w: exactly one of urlName and urlTemplate must be set", errInvalidHTTPConf)
+	}
+
+	if c.URLTemplate != "" {
+		if _, err := template.New("url").Parse(c.URLTemplate); err != nil {
+			return fmt.Errorf("%w: urlTemplate must be a valid template: %w", errInvalidHTTPConf, err)
+		}
+	}
+
+	for _, code := range c.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("%w: invalid expected status %d", errInvalidHTTPConf, code)
+		}
+	}
+
+	if c.MaxLatency < 0 {
+		return fmt.Errorf("%w: maxLatency must not be negative", errInvalidHTTPConf)
+	}
+
+	if c.JSONPath != "" && slices.Contains(strings.Split(c.JSONPath, "."), "") {
+		return fmt.Errorf("%w: jsonPath %q contains an empty segment", errInvalidHTTPConf, c.JSONPath)
+	}
+
+	return nil
+}
+
+// HTTPClientConfig configures the client of HTTP checks. Environment URLs are defined by the
+// environments, so only allowed schemes and hosts are called.
+type HTTPClientConfig struct {
+	// AllowedHosts lists the hosts that may be called. A leading `*.` matches any subdomain,
+	// e.g. `*.preview.example.com`.
+	AllowedHosts []string `yaml:"allowedHosts"`
+	// AllowedSchemes lists the schemes that may be called. Defaults to http and https.
+	AllowedSchemes []string `yaml:"allowedSchemes"`
+	// Timeout limits a request, including redirects. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxRedirects is the number of redirects followed. Defaults to 0, the redirect response
+	// itself is the result.
+	MaxRedirects int `yaml:"maxRedirects"`
+}
+
+// ApplyDefaults sets the default values of unset fields.
+func (c *HTTPClientConfig) ApplyDefaults() {
+	if len(c.AllowedSchemes) == 0 {
+		c.AllowedSchemes = []string{"http", "https"}
+	}
+	c.Timeout = cmp.Or(c.Timeout, defaultHTTPClientTimeout)
+}
+
+func (c HTTPClientConfig) Validate() error {
+	for _, scheme := range c.AllowedSchemes {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("%w: allowedSchemes must be http or https, got %q", errInvalidHTTPConf, scheme)
+		}
+	}
+
+	for _, host := range c.AllowedHosts {
+		domain := strings.TrimPrefix(host, "*.")
+		if domain == "" || strings.ContainsAny(domain, "*/:@") || domain != strings.ToLower(domain) {
+			return fmt.Errorf("%w: allowedHosts entry %q must be a lower case host name, optionally prefixed with *.", errInvalidHTTPConf, host)
+		}
+	}
+
+	if c.Timeout <= 0 {
+		return fmt.Errorf("%w: timeout must be greater than 0", errInvalidHTTPConf)
+	}
+	if c.MaxRedirects < 0 {
+		return fmt.Errorf("%w: maxRedirects must not be negative", errInvalidHTTPConf)
+	}
+
+	return nil
+}
+
+// Allowed checks the scheme and host of u against the allowlists.
+func (c HTTPClientConfig) Allowed(u *url.URL) error {
+	if !slices.Contains(c.AllowedSchemes, u.Scheme) {
+		return fmt.Errorf("%w: scheme %q", ErrURLNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	allowed := slices.ContainsFunc(c.AllowedHosts, func(pattern string) bool {
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			return strings.HasSuffix(host, "."+domain)
+		}
+		return host == pattern
+	})
+	if !allowed {
+		return fmt.Errorf("%w: host %q", ErrURLNotAllowed, host)
+	}
+
+	return nil
+}
+
+// NewHTTPClient creates the client of HTTP checks. Every request, including redirects, is
+// checked against the allowlists of cfg.
+func NewHTTPClient(cfg HTTPClientConfig) *http.Client {
+	return &http.Client{
+		Transport: &allowlistTransport{next: http.DefaultTransport, cfg: cfg},
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// allowlistTransport rejects requests to URLs that are not allowed.
+type allowlistTransport struct {
+	next http.RoundTripper
+	cfg  HTTPClientConfig
+}
+
+func (t *allowlistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.cfg.Allowed(req.URL); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req) //nolint:wrapcheck // the client wraps transport errors in an url.Error
+}
+
+// HTTPProber creates probes calling an HTTP endpoint of each environment.
+type HTTPProber[V Type] struct {
+	client    *http.Client
+	urlTpl    *template.Template
+	converter ConverterFunc[V]
+	name      string
+	cfg       HTTPConfig
+	interval  time.Duration
+	timeout   time.Duration
+}
+
+var _ Prober[bool] = (*HTTPProber[bool])(nil)
+
+// NewHTTPProber creates a prober that calls the configured endpoint. The name, interval and
+// timeout are taken from query. Without a json path the value is 1 if the endpoint responded
+// with an expected status within the max latency. With a json path, the value is extracted
+// from the response body.
+func NewHTTPProber[V Type](client *http.Client, query promAPI.QueryConfig, cfg HTTPConfig, converter ConverterFunc[V]) (*HTTPProber[V], error) {
+	if client == nil || converter == nil {
+		return nil, fmt.Errorf("client and converter must be provided: %w", ErrInvalidNil)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if query.Interval <= 0 || query.Timeout <= 0 || query.Timeout >= query.Interval {
+		return nil, fmt.Errorf("%w: interval and timeout must be greater than 0 and timeout less than interval", errInvalidHTTPConf)
+	}
+
+	p := &HTTPProber[V]{
+		client:    client,
+		converter: converter,
+		name:      query.Name,
+		cfg:       cfg,
+		interval:  query.Interval,
+		timeout:   query.Timeout,
+	}
+
+	if cfg.URLTemplate != "" {
+		t, err := template.New("url").Option("missingkey=error").Parse(cfg.URLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse url template: %w", err)
+		}
+		p.urlTpl = t
+	}
+
+	return p, nil
+}
+
+func (p *HTTPProber[V]) AddEnvironment(env Target) (Probe[V], error) {
+	target, err := p.targetURL(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add environment: %w", err)
+	}
+
+	return &HTTPProbe[V]{
+		prober: p,
+		url:    target,
+	}, nil
+}
+
+func (p *HTTPProber[V]) RemoveEnvironment(_ Target) {}
+
+// targetURL builds the URL called for an environment.
+func (p *HTTPProber[V]) targetURL(env Target) (string, error) {
+	var base string
+	if p.urlTpl != nil {
+		var sb strings.Builder
+		err := p.urlTpl.Execute(&sb, map[string]any{
+			"name":      env.Name,
+			"namespace": env.Namespace,
+			"cluster":   env.Cluster,
+			"url":       env.URL,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to execute url template: %w", err)
+		}
+		base = sb.String()
+	} else {
+		var ok bool
+		base, ok = env.URL[p.cfg.URLName]
+		if !ok {
+			return "", fmt.Errorf("%w: %q", ErrURLNotFound, p.cfg.URLName)
+		}
+	}
+
+	if p.cfg.Path == "" {
+		return base, nil
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid environment URL %q: %w", base, err)
+	}
+	return u.JoinPath(p.cfg.Path).String(), nil
+}
+
+// HTTPProbe calls the endpoint of a single environment. Results, including
+// failures, are cached for the configured interval. Failures caused by the context of
+// the caller, e.g. a cancelled API request, are not cached.
+type HTTPProbe[V Type] struct {
+	checkedAt  time.Time
+	lastUpdate time.Time
+	lastErr    error
+	prober     *HTTPProber[V]
+	// running is closed when the running request completes. It is nil if no request is running.
+	running   chan struct{}
+	url       string
+	lastText  string
+	lastValue float64
+	mu        sync.RWMutex
+}
+
+var _ Probe[bool] = (*HTTPProbe[bool])(nil)
+
+func (p *HTTPProbe[V]) Value(ctx context.Context) (V, error) {
+	var zero V
+
+	val, text, err := p.result(ctx)
+	if err != nil {
+		return zero, fmt.Errorf("http probe failed: %w", err)
+	}
+
+	sample, err := p.prober.converter(val, text)
+	if err != nil {
+		return zero, fmt.Errorf("probe value conversion failed: %w", err)
+	}
+	return sample, nil
+}
+
+func (p *HTTPProbe[V]) LastUpdate() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.lastUpdate
+}
+
+// result returns the cached result or calls the endpoint once the interval elapsed. The
+// lock is not held during the request; concurrent callers wait for it to complete.
+func (p *HTTPProbe[V]) result(ctx context.Context) (float64, string, error) {
+	p.mu.Lock()
+	for p.running != nil || time.Since(p.checkedAt) < p.prober.interval {
+		if p.running == nil {
+			val, text, err := p.lastValue, p.lastText, p.lastErr
+			p.mu.Unlock()
+			httpProbeCache.WithLabelValues(p.prober.name, "hit").Inc()
+			return val, text, err
+		}
+
+		// The result of the running request is not cached if its caller gave up, so
+		// the cache is checked again
+		running := p.running
+		p.mu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return 0, "", fmt.Errorf("failed to wait for request: %w", ctx.Err())
+		}
+		p.mu.Lock()
+	}
+
+	running := make(chan struct{})
+	p.running = running
+	p.mu.Unlock()
+
+	httpProbeCache.WithLabelValues(p.prober.name, "miss").Inc()
+	val, text, err := p.call(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = nil
+	close(running)
+
+	if err != nil && ctx.Err() != nil {
+		return val, text, err
+	}
+
+	p.lastValue, p.lastText, p.lastErr = val, text, err
+	p.checkedAt = time.Now()
+	if err == nil {
+		p.lastUpdate = p.checkedAt
+	}
+
+	return val, text, err
+}
+
+// call performs the request and judges the response.
+func (p *HTTPProbe[V]) call(ctx context.Context) (float64, string, error) {
+	cfg := p.prober.cfg
+	status := "failed"
+	start := time.Now()
+	defer func() {
+		httpProbeDuration.WithLabelValues(p.prober.name, status).Observe(time.Since(start).Seconds())
+	}()
+
+	reqCtx, cancel := context.WithTimeout(ctx, p.prober.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	res, err := p.prober.client.Do(req)
+	if err != nil {
+		// Only the probe timeout is a result of the endpoint, not the caller giving up
+		if cfg.JSONPath != "" || errors.Is(err, ErrURLNotAllowed) || ctx.Err() != nil {
+			return 0, "", fmt.Errorf("request failed: %w", err)
+		}
+		// An unreachable endpoint is a valid (negative) result of a status check
+		return 0, err.Error(), nil
+	}
+	defer res.Body.Close()
+
+	latency := time.Since(start)
+	ok := expectedStatus(cfg.ExpectedStatus, res.StatusCode) && (cfg.MaxLatency == 0 || latency <= cfg.MaxLatency)
+	status = strconv.Itoa(res.StatusCode)
+
+	if cfg.JSONPath == "" {
+		return boolValue(ok), status, nil
+	}
+
+	if !expectedStatus(cfg.ExpectedStatus, res.StatusCode) {
+		return 0, "", fmt.Errorf("%w: unexpected status %d", promAPI.ErrResultNotFound, res.StatusCode)
+	}
+
+	var body any
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxHTTPBodySize)).Decode(&body); err != nil {
+		return 0, "", fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	return extractJSONPath(body, cfg.JSONPath)
+}
+
+func expectedStatus(expected []int, code int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(expected, code)
+}
+
+// extractJSONPath returns the numeric value and text of the value at the dot separated path.
+// Numeric segments index into arrays.
+func extractJSONPath(body any, path string) (float64, string, error) {
+	cur := body
+	for segment := range strings.SplitSeq(path, ".") {
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[segment]
+			if !ok {
+				return 0, "", fmt.Errorf("%w: %q", ErrJSONPathNotFound, path)
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return 0, "", fmt.Errorf("%w: %q", ErrJSONPathNotFound, path)
+			}
+			cur = v[i]
+		default:
+			return 0, "", fmt.Errorf("%w: %q", ErrJSONPathNotFound, path)
+		}
+	}
+
+	switch v := cur.(type) {
+	case bool:
+		return boolValue(v), strconv.FormatBool(v), nil
+	case float64:
+		return v, strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		// Numeric strings can be used as numbers
+		f, _ := strconv.ParseFloat(v, 64)
+		return f, v, nil
+	case nil:
+		return 0, "", fmt.Errorf("%w: %q is null", ErrJSONPathNotFound, path)
+	default:
+		text, err := json.Marshal(v)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to encode value at %q: %w", path, err)
+		}
+		return 0, string(text), nil
+	}
+}
@@ -0,0 +1,368 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	prom "github.com/sberz/ephemeral-envs/internal/prometheus"
+)
+
+func httpQueryConfig() prom.QueryConfig {
+	return prom.QueryConfig{Name: "api", Kind: KindHTTP, Interval: time.Minute, Timeout: time.Second}
+}
+
+func TestHTTPProbeStatus(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) })
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		cfg  HTTPConfig
+		want bool
+	}{
+		{name: "ok", cfg: HTTPConfig{URLName: "api", Path: "/ok"}, want: true},
+		{name: "unexpected status", cfg: HTTPConfig{URLName: "api", Path: "/fail"}, want: false},
+		{name: "expected status", cfg: HTTPConfig{URLName: "api", Path: "/fail", ExpectedStatus: []int{503}}, want: true},
+		{name: "too slow", cfg: HTTPConfig{URLName: "api", Path: "/slow", MaxLatency: 10 * time.Millisecond}, want: false},
+		{name: "url template", cfg: HTTPConfig{URLTemplate: `{{index .url "api"}}/{{.name}}`}, want: true},
+		{name: "unreachable", cfg: HTTPConfig{URLTemplate: "http://127.0.0.1:1/health"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prober, err := NewHTTPProber(srv.Client(), httpQueryConfig(), tt.cfg, PromValToBool)
+			if err != nil {
+				t.Fatalf("NewHTTPProber() error = %v", err)
+			}
+
+			p, err := prober.AddEnvironment(Target{Name: "ok", URL: map[string]string{"api": srv.URL}})
+			if err != nil {
+				t.Fatalf("AddEnvironment() error = %v", err)
+			}
+
+			got, err := p.Value(t.Context())
+			if err != nil {
+				t.Fatalf("Value() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Value() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProbeJSONPathAndCache(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"build": {"version": "1.2.3"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	prober, err := NewHTTPProber(srv.Client(), httpQueryConfig(), HTTPConfig{URLName: "api", Path: "/info", JSONPath: "build.version"}, PromValToString)
+	if err != nil {
+		t.Fatalf("NewHTTPProber() error = %v", err)
+	}
+
+	p, err := prober.AddEnvironment(Target{Name: "a", URL: map[string]string{"api": srv.URL}})
+	if err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	for range 3 {
+		got, err := p.Value(t.Context())
+		if err != nil {
+			t.Fatalf("Value() error = %v", err)
+		}
+		if got != "1.2.3" {
+			t.Fatalf("Value() = %q, want %q", got, "1.2.3")
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Fatalf("server calls = %d, want 1 (cached)", calls.Load())
+	}
+	if p.LastUpdate().IsZero() {
+		t.Fatal("LastUpdate() is zero, want time of the request")
+	}
+}
+
+func TestHTTPProbeCallerCancellation(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	prober, err := NewHTTPProber(srv.Client(), httpQueryConfig(), HTTPConfig{URLName: "api"}, PromValToBool)
+	if err != nil {
+		t.Fatalf("NewHTTPProber() error = %v", err)
+	}
+	p, err := prober.AddEnvironment(Target{Name: "a", URL: map[string]string{"api": srv.URL}})
+	if err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := p.Value(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Value() error = %v, want context.Canceled", err)
+	}
+
+	// The cancelled request is not cached
+	got, err := p.Value(t.Context())
+	if err != nil {
+		t.Fatalf("Value() error = %v", err)
+	}
+	if !got {
+		t.Fatal("Value() = false, want true")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("server calls = %d, want 2", calls.Load())
+	}
+}
+
+func TestHTTPProbeConcurrentCallers(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	prober, err := NewHTTPProber(srv.Client(), httpQueryConfig(), HTTPConfig{URLName: "api"}, PromValToBool)
+	if err != nil {
+		t.Fatalf("NewHTTPProber() error = %v", err)
+	}
+	p, err := prober.AddEnvironment(Target{Name: "a", URL: map[string]string{"api": srv.URL}})
+	if err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			if got, err := p.Value(t.Context()); err != nil || !got {
+				t.Errorf("Value() = %t, %v, want true", got, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("server calls = %d, want 1 (shared)", calls.Load())
+	}
+}
+
+func TestHTTPProberMissingURL(t *testing.T) {
+	t.Parallel()
+
+	prober, err := NewHTTPProber(http.DefaultClient, httpQueryConfig(), HTTPConfig{URLName: "api"}, PromValToBool)
+	if err != nil {
+		t.Fatalf("NewHTTPProber() error = %v", err)
+	}
+
+	if _, err := prober.AddEnvironment(Target{Name: "a", URL: map[string]string{}}); !errors.Is(err, ErrURLNotFound) {
+		t.Fatalf("AddEnvironment() error = %v, want ErrURLNotFound", err)
+	}
+}
+
+func TestHTTPConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     HTTPConfig
+		wantErr bool
+	}{
+		{name: "url name", cfg: HTTPConfig{URLName: "api"}},
+		{name: "url template", cfg: HTTPConfig{URLTemplate: "https://{{.name}}.example.com"}},
+		{name: "no url", cfg: HTTPConfig{}, wantErr: true},
+		{name: "both urls", cfg: HTTPConfig{URLName: "api", URLTemplate: "https://example.com"}, wantErr: true},
+		{name: "invalid template", cfg: HTTPConfig{URLTemplate: "https://{{.name"}, wantErr: true},
+		{name: "invalid status", cfg: HTTPConfig{URLName: "api", ExpectedStatus: []int{42}}, wantErr: true},
+		{name: "empty json path segment", cfg: HTTPConfig{URLName: "api", JSONPath: "build..version"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPClientAllowlist(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/ok", http.StatusFound) })
+	mux.HandleFunc("/external", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://metadata.internal/", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name       string
+		path       string
+		hosts      []string
+		redirects  int
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "allowed", path: "/ok", hosts: []string{"127.0.0.1"}, wantStatus: http.StatusOK},
+		{name: "host not allowed", path: "/ok", hosts: []string{"*.example.com"}, wantErr: true},
+		{name: "redirect not followed", path: "/redirect", hosts: []string{"127.0.0.1"}, wantStatus: http.StatusFound},
+		{name: "redirect followed", path: "/redirect", hosts: []string{"127.0.0.1"}, redirects: 1, wantStatus: http.StatusOK},
+		{name: "redirect to host not allowed", path: "/external", hosts: []string{"127.0.0.1"}, redirects: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := HTTPClientConfig{AllowedHosts: tt.hosts, MaxRedirects: tt.redirects}
+			cfg.ApplyDefaults()
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			res, err := NewHTTPClient(cfg).Do(req)
+			if tt.wantErr {
+				if !errors.Is(err, ErrURLNotAllowed) {
+					t.Fatalf("Do() error = %v, want ErrURLNotAllowed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			_ = res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHTTPClientConfigAllowed(t *testing.T) {
+	t.Parallel()
+
+	cfg := HTTPClientConfig{AllowedHosts: []string{"*.preview.example.com", "status.example.com"}}
+	cfg.ApplyDefaults()
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://api-test.preview.example.com/healthz"},
+		{url: "http://status.example.com:8080/"},
+		{url: "https://API.Preview.Example.com/"},
+		{url: "https://preview.example.com/", wantErr: true},
+		{url: "https://evil-preview.example.com/", wantErr: true},
+		{url: "https://status.example.com.evil.com/", wantErr: true},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{url: "ftp://status.example.com/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("url.Parse() error = %v", err)
+			}
+			if err := cfg.Allowed(u); (err != nil) != tt.wantErr {
+				t.Fatalf("Allowed() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	t.Parallel()
+
+	body := map[string]any{
+		"ok":      true,
+		"count":   float64(3),
+		"version": "1.2.3",
+		"items":   []any{map[string]any{"name": "first"}},
+		"nothing": nil,
+	}
+
+	tests := []struct {
+		path     string
+		wantText string
+		want     float64
+		wantErr  bool
+	}{
+		{path: "ok", want: 1, wantText: "true"},
+		{path: "count", want: 3, wantText: "3"},
+		{path: "version", want: 0, wantText: "1.2.3"},
+		{path: "items.0.name", want: 0, wantText: "first"},
+		{path: "items.1.name", wantErr: true},
+		{path: "missing", wantErr: true},
+		{path: "nothing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			got, text, err := extractJSONPath(body, tt.path)
+			if tt.wantErr {
+				if !errors.Is(err, ErrJSONPathNotFound) {
+					t.Fatalf("extractJSONPath() error = %v, want ErrJSONPathNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractJSONPath() error = %v", err)
+			}
+			if got != tt.want || text != tt.wantText {
+				t.Fatalf("extractJSONPath() = (%v, %q), want (%v, %q)", got, text, tt.want, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// KindKubernetes is the probe kind for checks evaluated against the Kubernetes API server.
+const KindKubernetes prometheus.QueryKind = "kubernetes"
+
+var (
+	ErrUnknownCluster  = errors.New("unknown cluster")
+	ErrCacheNotSynced  = errors.New("informer cache not synced")
+	errInvalidKubeConf = errors.New("invalid kubernetes check config")
+)
+
+type KubernetesCheck string
+
+const (
+	// KubernetesCheckDeploymentsAvailable is 1 if all Deployments have their desired replicas available.
+	KubernetesCheckDeploymentsAvailable KubernetesCheck = "deploymentsAvailable"
+	// KubernetesCheckPodsReady is the number of ready pods.
+	KubernetesCheckPodsReady KubernetesCheck = "podsReady"
+	// KubernetesCheckPodsCrashLooping is the number of pods with a container in CrashLoopBackOff.
+	KubernetesCheckPodsCrashLooping KubernetesCheck = "podsCrashLooping"
+	// KubernetesCheckNoPodsCrashLooping is 1 if no pod has a container in CrashLoopBackOff.
+	KubernetesCheckNoPodsCrashLooping KubernetesCheck = "noPodsCrashLooping"
+	// KubernetesCheckPVCsBound is 1 if all PersistentVolumeClaims are bound.
+	KubernetesCheckPVCsBound KubernetesCheck = "pvcsBound"
+)
+
+// KubernetesConfig configures a check evaluated against the objects in the environment namespace.
+type KubernetesConfig struct {
+	// Check is the check to evaluate.
+	Check KubernetesCheck `yaml:"check"`
+	// Selector optionally restricts the check to objects matching the label selector.
+	Selector string `yaml:"selector"`
+}
+
+func (c KubernetesConfig) Validate() error {
+	switch c.Check {
+	case KubernetesCheckDeploymentsAvailable, KubernetesCheckPodsReady, KubernetesCheckPodsCrashLooping,
+		KubernetesCheckNoPodsCrashLooping, KubernetesCheckPVCsBound:
+	default:
+		return fmt.Errorf("%w: unsupported check %q", errInvalidKubeConf, c.Check)
+	}
+
+	if _, err := labels.Parse(c.Selector); err != nil {
+		return fmt.Errorf("%w: invalid selector: %w", errInvalidKubeConf, err)
+	}
+
+	return nil
+}
+
+// KubernetesWatcher maintains informers for the namespaces of environments. Informers
+// are started when the first probe of a namespace is created and shared by all probes of
+// the namespace. They are stopped once all environments of the namespace are released.
+type KubernetesWatcher struct {
+	stop       <-chan struct{}
+	clients    map[string]kubernetes.Interface
+	namespaces map[namespaceKey]*namespaceCache
+	mu         sync.Mutex
+}
+
+type namespaceKey struct {
+	cluster   string
+	namespace string
+}
+
+// namespaceCache holds the informers of a single environment namespace.
+type namespaceCache struct {
+	deployments appslisters.DeploymentNamespaceLister
+	pods        corelisters.PodNamespaceLister
+	pvcs        corelisters.PersistentVolumeClaimNamespaceLister
+	// released is closed to stop the informers of the namespace.
+	released chan struct{}
+	synced   []cache.InformerSynced
+	// envs holds the environments of the namespace. It is guarded by the mutex of the watcher.
+	envs map[targetKey]struct{}
+	// lastEvent is the unix nano timestamp of the last observed change in the namespace.
+	lastEvent int64
+	mu        sync.RWMutex
+}
+
+// NewKubernetesWatcher creates a watcher for the given clients keyed by cluster name.
+// The informers are stopped when ctx is canceled.
+func NewKubernetesWatcher(ctx context.Context, clients map[string]kubernetes.Interface) *KubernetesWatcher {
+	return &KubernetesWatcher{
+		stop:       ctx.Done(),
+		clients:    clients,
+		namespaces: make(map[namespaceKey]*namespaceCache),
+	}
+}
+
+// namespace returns the cache of the namespace of env, starting its informers if needed,
+// and registers env with it.
+func (w *KubernetesWatcher) namespace(env Target) (*namespaceCache, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := namespaceKey{cluster: env.Cluster, namespace: env.Namespace}
+	nc, ok := w.namespaces[key]
+	if !ok {
+		client, ok := w.clients[env.Cluster]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCluster, env.Cluster)
+		}
+
+		var err error
+		if nc, err = w.startNamespace(client, env.Namespace); err != nil {
+			return nil, err
+		}
+		w.namespaces[key] = nc
+	}
+	nc.envs[env.key()] = struct{}{}
+
+	return nc, nil
+}
+
+// startNamespace starts the informers of a namespace. They run until the namespace is
+// released or the watcher is stopped.
+func (w *KubernetesWatcher) startNamespace(client kubernetes.Interface, namespace string) (*namespaceCache, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 10*time.Minute, informers.WithNamespace(namespace))
+	deployments := factory.Apps().V1().Deployments()
+	pods := factory.Core().V1().Pods()
+	pvcs := factory.Core().V1().PersistentVolumeClaims()
+
+	nc := &namespaceCache{
+		deployments: deployments.Lister().Deployments(namespace),
+		pods:        pods.Lister().Pods(namespace),
+		pvcs:        pvcs.Lister().PersistentVolumeClaims(namespace),
+		released:    make(chan struct{}),
+		envs:        make(map[targetKey]struct{}),
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { nc.touch() },
+		UpdateFunc: func(any, any) { nc.touch() },
+		DeleteFunc: func(any) { nc.touch() },
+	}
+	for _, informer := range []cache.SharedIndexInformer{deployments.Informer(), pods.Informer(), pvcs.Informer()} {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return nil, fmt.Errorf("failed to add event handler: %w", err)
+		}
+		nc.synced = append(nc.synced, informer.HasSynced)
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-w.stop:
+		case <-nc.released:
+		}
+	}()
+	factory.Start(stop)
+
+	return nc, nil
+}
+
+// release unregisters env from the cache of its namespace. The informers of
+// the namespace are stopped once it has no environments.
+func (w *KubernetesWatcher) release(env Target) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := namespaceKey{cluster: env.Cluster, namespace: env.Namespace}
+	nc, ok := w.namespaces[key]
+	if !ok {
+		return
+	}
+
+	delete(nc.envs, env.key())
+	if len(nc.envs) == 0 {
+		delete(w.namespaces, key)
+		close(nc.released)
+	}
+}
+
+func (nc *namespaceCache) touch() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.lastEvent = time.Now().UnixNano()
+}
+
+func (nc *namespaceCache) lastUpdate() time.Time {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+
+	if nc.lastEvent == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nc.lastEvent)
+}
+
+func (nc *namespaceCache) hasSynced() bool {
+	for _, synced := range nc.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate returns the numeric value and the text representation of a check.
+func (nc *namespaceCache) evaluate(check KubernetesCheck, selector labels.Selector) (float64, string, error) {
+	if !nc.hasSynced() {
+		return 0, "", ErrCacheNotSynced
+	}
+
+	switch check {
+	case KubernetesCheckDeploymentsAvailable:
+		deployments, err := nc.deployments.List(selector)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to list deployments: %w", err)
+		}
+		available := 0
+		for _, d := range deployments {
+			if deploymentAvailable(d) {
+				available++
+			}
+		}
+		return boolValue(available == len(deployments)), fmt.Sprintf("%d/%d", available, len(deployments)), nil
+	case KubernetesCheckPodsReady:
+		pods, err := nc.pods.List(selector)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to list pods: %w", err)
+		}
+		ready := 0
+		for _, p := range pods {
+			if podReady(p) {
+				ready++
+			}
+		}
+		return float64(ready), strconv.Itoa(ready), nil
+	case KubernetesCheckPodsCrashLooping, KubernetesCheckNoPodsCrashLooping:
+		pods, err := nc.pods.List(selector)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to list pods: %w", err)
+		}
+		crashing := 0
+		for _, p := range pods {
+			if podCrashLooping(p) {
+				crashing++
+			}
+		}
+		if check == KubernetesCheckNoPodsCrashLooping {
+			return boolValue(crashing == 0), strconv.Itoa(crashing), nil
+		}
+		return float64(crashing), strconv.Itoa(crashing), nil
+	case KubernetesCheckPVCsBound:
+		pvcs, err := nc.pvcs.List(selector)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to list persistent volume claims: %w", err)
+		}
+		bound := 0
+		for _, pvc := range pvcs {
+			if pvc.Status.Phase == corev1.ClaimBound {
+				bound++
+			}
+		}
+		return boolValue(bound == len(pvcs)), fmt.Sprintf("%d/%d", bound, len(pvcs)), nil
+	default:
+		return 0, "", fmt.Errorf("%w: unsupported check %q", errInvalidKubeConf, check)
+	}
+}
+
+func deploymentAvailable(d *appsv1.Deployment) bool {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	return d.Status.AvailableReplicas >= desired
+}
+
+func podReady(p *corev1.Pod) bool {
+	if p.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func podCrashLooping(p *corev1.Pod) bool {
+	for _, statuses := range [][]corev1.ContainerStatus{p.Status.InitContainerStatuses, p.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if s.State.Waiting != nil && s.State.Waiting.Reason == "CrashLoopBackOff" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// KubernetesProber creates probes evaluating a check against the environment namespace.
+type KubernetesProber[V Type] struct {
+	watcher   *KubernetesWatcher
+	selector  labels.Selector
+	converter ConverterFunc[V]
+	check     KubernetesCheck
+}
+
+var _ Prober[bool] = (*KubernetesProber[bool])(nil)
+
+// NewKubernetesProber creates a prober that evaluates the configured check using the informers of watcher.
+func NewKubernetesProber[V Type](watcher *KubernetesWatcher, cfg KubernetesConfig, converter ConverterFunc[V]) (*KubernetesProber[V], error) {
+	if watcher == nil || converter == nil {
+		return nil, fmt.Errorf("watcher and converter must be provided: %w", ErrInvalidNil)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	selector, err := labels.Parse(cfg.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid selector: %w", errInvalidKubeConf, err)
+	}
+
+	return &KubernetesProber[V]{
+		watcher:   watcher,
+		selector:  selector,
+		converter: converter,
+		check:     cfg.Check,
+	}, nil
+}
+
+func (p *KubernetesProber[V]) AddEnvironment(env Target) (Probe[V], error) {
+	nc, err := p.watcher.namespace(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add environment: %w", err)
+	}
+
+	return &KubernetesProbe[V]{
+		cache:     nc,
+		selector:  p.selector,
+		converter: p.converter,
+		check:     p.check,
+	}, nil
+}
+
+// RemoveEnvironment releases the namespace cache of env. The informers of the namespace are
+// stopped with its last environment.
+func (p *KubernetesProber[V]) RemoveEnvironment(env Target) {
+	p.watcher.release(env)
+}
+
+// KubernetesProbe evaluates a check against the informer cache of a namespace. Values
+// are computed from the cache on every call and never trigger API requests.
+type KubernetesProbe[V Type] struct {
+	cache     *namespaceCache
+	selector  labels.Selector
+	converter ConverterFunc[V]
+	check     KubernetesCheck
+}
+
+var _ Probe[bool] = (*KubernetesProbe[bool])(nil)
+
+func (p *KubernetesProbe[V]) Value(_ context.Context) (V, error) {
+	var zero V
+
+	val, text, err := p.cache.evaluate(p.check, p.selector)
+	if err != nil {
+		return zero, fmt.Errorf("kubernetes check %q failed: %w", p.check, err)
+	}
+
+	sample, err := p.converter(val, text)
+	if err != nil {
+		return zero, fmt.Errorf("probe value conversion failed: %w", err)
+	}
+	return sample, nil
+}
+
+// LastUpdate returns the time of the last observed change in the namespace.
+func (p *KubernetesProbe[V]) LastUpdate() time.Time {
+	return p.cache.lastUpdate()
+}
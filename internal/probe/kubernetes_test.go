@@ -0,0 +1,191 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     KubernetesConfig
+		wantErr bool
+	}{
+		{name: "valid check", cfg: KubernetesConfig{Check: KubernetesCheckPodsReady}},
+		{name: "valid check with selector", cfg: KubernetesConfig{Check: KubernetesCheckDeploymentsAvailable, Selector: "app=web"}},
+		{name: "unknown check", cfg: KubernetesConfig{Check: "nodesReady"}, wantErr: true},
+		{name: "invalid selector", cfg: KubernetesConfig{Check: KubernetesCheckPodsReady, Selector: "app in"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKubernetesProbeChecks(t *testing.T) {
+	t.Parallel()
+
+	replicas := int32(2)
+	pod := func(name string, ready bool, waitingReason string) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "env-a", Labels: map[string]string{"app": "web"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+		if waitingReason != "" {
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: waitingReason}}}}
+		}
+		return p
+	}
+
+	client := fake.NewClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "env-a", Labels: map[string]string{"app": "web"}},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{AvailableReplicas: 2},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "env-a"},
+			Status:     appsv1.DeploymentStatus{AvailableReplicas: 0},
+		},
+		pod("web-1", true, ""),
+		pod("web-2", true, ""),
+		pod("worker-1", false, "CrashLoopBackOff"),
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "env-a"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		},
+	)
+	// Objects in other namespaces are ignored
+	other := pod("other", true, "CrashLoopBackOff")
+	other.Namespace = "env-b"
+	if _, err := client.CoreV1().Pods("env-b").Create(t.Context(), other, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+
+	watcher := NewKubernetesWatcher(t.Context(), map[string]kubernetes.Interface{"": client})
+
+	// The converter returns the value and the text of the check
+	converter := func(v float64, text string) (string, error) {
+		return fmt.Sprintf("%v %s", v, text), nil
+	}
+
+	tests := []struct {
+		cfg  KubernetesConfig
+		name string
+		want string
+	}{
+		{name: "deployments available", cfg: KubernetesConfig{Check: KubernetesCheckDeploymentsAvailable}, want: "0 1/2"},
+		{name: "deployments available with selector", cfg: KubernetesConfig{Check: KubernetesCheckDeploymentsAvailable, Selector: "app=web"}, want: "1 1/1"},
+		{name: "pods ready", cfg: KubernetesConfig{Check: KubernetesCheckPodsReady}, want: "2 2"},
+		{name: "pods crash looping", cfg: KubernetesConfig{Check: KubernetesCheckPodsCrashLooping}, want: "1 1"},
+		{name: "no pods crash looping", cfg: KubernetesConfig{Check: KubernetesCheckNoPodsCrashLooping}, want: "0 1"},
+		{name: "pvcs bound", cfg: KubernetesConfig{Check: KubernetesCheckPVCsBound}, want: "1 1/1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prober, err := NewKubernetesProber(watcher, tt.cfg, converter)
+			if err != nil {
+				t.Fatalf("NewKubernetesProber() error = %v", err)
+			}
+
+			p, err := prober.AddEnvironment(Target{Name: "a", Namespace: "env-a"})
+			if err != nil {
+				t.Fatalf("AddEnvironment() error = %v", err)
+			}
+
+			var got string
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				got, err = p.Value(t.Context())
+				if !errors.Is(err, ErrCacheNotSynced) || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatalf("Value() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("Value() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesProberUnknownCluster(t *testing.T) {
+	t.Parallel()
+
+	watcher := NewKubernetesWatcher(t.Context(), map[string]kubernetes.Interface{"east": fake.NewClientset()})
+	prober, err := NewKubernetesProber(watcher, KubernetesConfig{Check: KubernetesCheckPodsReady}, PromValToBool)
+	if err != nil {
+		t.Fatalf("NewKubernetesProber() error = %v", err)
+	}
+
+	if _, err := prober.AddEnvironment(Target{Name: "a", Namespace: "env-a", Cluster: "west"}); !errors.Is(err, ErrUnknownCluster) {
+		t.Fatalf("AddEnvironment() error = %v, want ErrUnknownCluster", err)
+	}
+}
+
+func TestKubernetesWatcherReleasesNamespaces(t *testing.T) {
+	t.Parallel()
+
+	watcher := NewKubernetesWatcher(t.Context(), map[string]kubernetes.Interface{"": fake.NewClientset()})
+	a := Target{Name: "a", Namespace: "env-a", Source: "namespaces/env-a"}
+	b := Target{Name: "b", Namespace: "env-a", Source: "deployments.apps/b"}
+	// The same name claimed by another object of the namespace
+	a2 := Target{Name: "a", Namespace: "env-a", Source: "deployments.apps/a"}
+
+	var caches []*namespaceCache
+	for _, env := range []Target{a, b, a2} {
+		nc, err := watcher.namespace(env)
+		if err != nil {
+			t.Fatalf("namespace(%s) error = %v", env.Source, err)
+		}
+		caches = append(caches, nc)
+	}
+	if caches[0] != caches[1] || caches[0] != caches[2] {
+		t.Fatal("environments of the same namespace do not share the namespace cache")
+	}
+	nc := caches[0]
+
+	watcher.release(a)
+	watcher.release(b)
+	if _, ok := watcher.namespaces[namespaceKey{namespace: "env-a"}]; !ok {
+		t.Fatal("namespace cache released while the claim of deployments.apps/a still exists")
+	}
+
+	watcher.release(a2)
+	if _, ok := watcher.namespaces[namespaceKey{namespace: "env-a"}]; ok {
+		t.Fatal("namespace cache not released after all environments were removed")
+	}
+	select {
+	case <-nc.released:
+	default:
+		t.Fatal("informers of the namespace not stopped")
+	}
+}
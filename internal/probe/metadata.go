@@ -3,6 +3,7 @@ package probe
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/sberz/ephemeral-envs/internal/prometheus"
@@ -19,11 +20,13 @@ const (
 	MetadataTypeBool      MetadataType = "bool"
 	MetadataTypeNumber    MetadataType = "number"
 	MetadataTypeTimestamp MetadataType = "timestamp"
+	// MetadataTypeSeries is a series of points returned by range queries.
+	MetadataTypeSeries MetadataType = "series"
 )
 
 func (t MetadataType) Validate() error {
 	switch t {
-	case MetadataTypeString, MetadataTypeBool, MetadataTypeNumber, MetadataTypeTimestamp:
+	case MetadataTypeString, MetadataTypeBool, MetadataTypeNumber, MetadataTypeTimestamp, MetadataTypeSeries:
 		return nil
 	default:
 		return fmt.Errorf("%w: %s", ErrInvalidType, t)
@@ -61,7 +64,9 @@ func WrapProbe[T Type](probe Probe[T]) MetadataProbe {
 
 // MetadataProber is a factory for creating MetadataProbes. It allows adding environments to create probes that are specific to an environment.
 type MetadataProber interface {
-	AddEnvironment(name string, namespace string) (MetadataProbe, error)
+	AddEnvironment(env Target) (MetadataProbe, error)
+	// RemoveEnvironment releases the resources held for env.
+	RemoveEnvironment(env Target)
 }
 
 // metadataProber is a Prober adapter that creates MetadataProbes from typed Probers. It holds a reference to the underlying typed Prober and creates MetadataProbes on demand.
@@ -69,8 +74,8 @@ type metadataProber[V Type] struct {
 	Prober[V]
 }
 
-func (m *metadataProber[V]) AddEnvironment(name string, namespace string) (MetadataProbe, error) {
-	probe, err := m.Prober.AddEnvironment(name, namespace)
+func (m *metadataProber[V]) AddEnvironment(env Target) (MetadataProbe, error) {
+	probe, err := m.Prober.AddEnvironment(env)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add environment to prober: %w", err)
 	}
@@ -86,16 +91,61 @@ func WrapProber[V Type](prober Prober[V], err error) (MetadataProber, error) {
 	return &metadataProber[V]{Prober: prober}, nil
 }
 
-func NewPrometheusMetadataProber(ctx context.Context, prom *prometheus.Prometheus, t MetadataType, cfg prometheus.QueryConfig) (MetadataProber, error) {
+// NewPrometheusMetadataProber creates a metadata prober of type t. The transforms are applied
+// to the raw query result before it is converted.
+func NewPrometheusMetadataProber(ctx context.Context, prom *prometheus.Prometheus, t MetadataType, cfg prometheus.QueryConfig, transforms ...TransformFunc) (MetadataProber, error) {
 	switch t {
 	case MetadataTypeString:
-		return WrapProber(NewPrometheusProber(ctx, prom, cfg, PromValToString))
+		return WrapProber(NewPrometheusProber(ctx, prom, cfg, Chain(PromValToString, transforms...)))
 	case MetadataTypeBool:
-		return WrapProber(NewPrometheusProber(ctx, prom, cfg, PromValToBool))
+		return WrapProber(NewPrometheusProber(ctx, prom, cfg, Chain(PromValToBool, transforms...)))
 	case MetadataTypeNumber:
-		return WrapProber(NewPrometheusProber(ctx, prom, cfg, PromValToFloat))
+		return WrapProber(NewPrometheusProber(ctx, prom, cfg, Chain(PromValToFloat, transforms...)))
 	case MetadataTypeTimestamp:
-		return WrapProber(NewPrometheusProber(ctx, prom, cfg, PromValToDateTime))
+		return WrapProber(NewPrometheusProber(ctx, prom, cfg, Chain(PromValToDateTime, transforms...)))
+	case MetadataTypeSeries:
+		if len(transforms) > 0 {
+			return nil, fmt.Errorf("%w: %q does not support conversion", ErrInvalidType, t)
+		}
+		prober, err := NewSeriesProber(ctx, prom, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return prober, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidType, t)
+	}
+}
+
+func NewKubernetesMetadataProber(watcher *KubernetesWatcher, t MetadataType, cfg KubernetesConfig, transforms ...TransformFunc) (MetadataProber, error) {
+	switch t {
+	case MetadataTypeString:
+		return WrapProber(NewKubernetesProber(watcher, cfg, Chain(PromValToString, transforms...)))
+	case MetadataTypeBool:
+		return WrapProber(NewKubernetesProber(watcher, cfg, Chain(PromValToBool, transforms...)))
+	case MetadataTypeNumber:
+		return WrapProber(NewKubernetesProber(watcher, cfg, Chain(PromValToFloat, transforms...)))
+	case MetadataTypeTimestamp:
+		return WrapProber(NewKubernetesProber(watcher, cfg, Chain(PromValToDateTime, transforms...)))
+	case MetadataTypeSeries:
+		return nil, fmt.Errorf("%w: %q is only supported for range queries", ErrInvalidType, t)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidType, t)
+	}
+}
+
+func NewHTTPMetadataProber(client *http.Client, t MetadataType, query prometheus.QueryConfig, cfg HTTPConfig, transforms ...TransformFunc) (MetadataProber, error) {
+	switch t {
+	case MetadataTypeString:
+		return WrapProber(NewHTTPProber(client, query, cfg, Chain(PromValToString, transforms...)))
+	case MetadataTypeBool:
+		return WrapProber(NewHTTPProber(client, query, cfg, Chain(PromValToBool, transforms...)))
+	case MetadataTypeNumber:
+		return WrapProber(NewHTTPProber(client, query, cfg, Chain(PromValToFloat, transforms...)))
+	case MetadataTypeTimestamp:
+		return WrapProber(NewHTTPProber(client, query, cfg, Chain(PromValToDateTime, transforms...)))
+	case MetadataTypeSeries:
+		return nil, fmt.Errorf("%w: %q is only supported for range queries", ErrInvalidType, t)
 	default:
 		return nil, fmt.Errorf("%w: %q", ErrInvalidType, t)
 	}
@@ -25,6 +25,7 @@ func TestMetadataTypeValidate(t *testing.T) {
 		{name: "bool", metadataType: MetadataTypeBool},
 		{name: "number", metadataType: MetadataTypeNumber},
 		{name: "timestamp", metadataType: MetadataTypeTimestamp},
+		{name: "series", metadataType: MetadataTypeSeries},
 		{name: "invalid", metadataType: MetadataType("invalid"), wantErr: true},
 	}
 
@@ -76,7 +77,7 @@ func TestWrapProber(t *testing.T) {
 		t.Fatalf("WrapProber() error = %v", err)
 	}
 
-	metaProbe, err := metaProber.AddEnvironment("a", "env-a")
+	metaProbe, err := metaProber.AddEnvironment(Target{Name: "a", Namespace: "env-a"})
 	if err != nil {
 		t.Fatalf("MetadataProber.AddEnvironment() error = %v", err)
 	}
@@ -114,7 +115,7 @@ func TestWrapProberErrorPaths(t *testing.T) {
 			t.Fatalf("WrapProber() error = %v", err)
 		}
 
-		if _, err := metaProber.AddEnvironment("a", "env-a"); err == nil {
+		if _, err := metaProber.AddEnvironment(Target{Name: "a", Namespace: "env-a"}); err == nil {
 			t.Fatal("MetadataProber.AddEnvironment() error = nil, want non-nil")
 		}
 	})
@@ -135,10 +136,12 @@ type fakeTypedProber[V Type] struct {
 	calls int
 }
 
-func (f *fakeTypedProber[V]) AddEnvironment(_, _ string) (Probe[V], error) {
+func (f *fakeTypedProber[V]) AddEnvironment(_ Target) (Probe[V], error) {
 	if f.err != nil {
 		return nil, f.err
 	}
 	f.calls++
 	return f.probe, nil
 }
+
+func (f *fakeTypedProber[V]) RemoveEnvironment(_ Target) {}
@@ -2,6 +2,7 @@ package probe
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -9,11 +10,79 @@ type Type interface {
 	~bool | ~float64 | ~string | time.Time
 }
 
+// Target identifies the environment a probe is created for.
+type Target struct {
+	// URL is the URL map of the environment.
+	URL map[string]string
+	// Checks are the status check probes of the environment, used as inputs of derived checks.
+	Checks map[string]Probe[bool]
+	// Metadata are the metadata probes of the environment, used as inputs of derived checks.
+	Metadata map[string]MetadataProbe
+	// Name is the environment name.
+	Name string
+	// Namespace is the namespace of the environment.
+	Namespace string
+	// Cluster is the name of the cluster the environment was discovered in.
+	Cluster string
+	// Source identifies the object the environment was discovered from, e.g. `namespaces/env-a`.
+	Source string
+}
+
+// targetKey identifies an environment claim. Claims of the same name in a namespace can
+// come from different sources.
+type targetKey struct {
+	cluster   string
+	namespace string
+	source    string
+	name      string
+}
+
+func (t Target) key() targetKey {
+	return targetKey{cluster: t.Cluster, namespace: t.Namespace, source: t.Source, name: t.Name}
+}
+
+// claims tracks the claims of environments whose probes share state keyed by cluster,
+// namespace and name. The state must only be released with the last claim.
+type claims struct {
+	m  map[targetKey]map[string]struct{}
+	mu sync.Mutex
+}
+
+func (c *claims) add(env Target) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.m == nil {
+		c.m = make(map[targetKey]map[string]struct{})
+	}
+	key := env.key()
+	key.source = ""
+	if c.m[key] == nil {
+		c.m[key] = make(map[string]struct{})
+	}
+	c.m[key][env.Source] = struct{}{}
+}
+
+// remove drops the claim of env and reports whether it was the last claim of the name.
+func (c *claims) remove(env Target) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := env.key()
+	key.source = ""
+	delete(c.m[key], env.Source)
+	if len(c.m[key]) > 0 {
+		return false
+	}
+	delete(c.m, key)
+	return true
+}
+
 type Prober[V Type] interface {
-	AddEnvironment(name string, namespace string) (Probe[V], error)
-	// Keep it simple for now and run queries within the probe and cache results
-	// RemoveEnvironment(name string) error
-	// Shutdown(ctx context.Context) error
+	AddEnvironment(env Target) (Probe[V], error)
+	// RemoveEnvironment releases the resources held for env. It is called when the
+	// environment is deleted; probes created for env must no longer be used.
+	RemoveEnvironment(env Target)
 }
 
 type Probe[V Type] interface {
@@ -2,6 +2,7 @@ package probe
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -24,6 +25,8 @@ var _ Probe[bool] = (*PrometheusProbe[bool])(nil)
 type PrometheusProber[V Type] struct {
 	query     prometheus.EnvironmentQuerier
 	converter ConverterFunc[V]
+	// claims of the same name, namespace and cluster share the environment query.
+	claims claims
 }
 
 var _ Prober[bool] = (*PrometheusProber[bool])(nil)
@@ -39,9 +42,15 @@ func NewPrometheusProber[V Type](ctx context.Context, prom *prometheus.Prometheu
 
 	switch cfg.Kind {
 	case prometheus.QueryKindSingleValue:
-		query, err = prometheus.NewSingleValueQuery(ctx, *prom, cfg)
+		// Single value queries are combined into one bulk query where possible
+		query, err = prometheus.NewBatchQuery(ctx, *prom, cfg)
+		if errors.Is(err, prometheus.ErrQueryNotBatchable) {
+			query, err = prometheus.NewSingleValueQuery(ctx, *prom, cfg)
+		}
 	case prometheus.QueryKindBulk:
 		query, err = prometheus.NewBulkValueQuery(ctx, *prom, cfg)
+	case prometheus.QueryKindRange:
+		return nil, fmt.Errorf("%w: %s queries are only supported for series metadata", prometheus.ErrInvalidQueryKind, cfg.Kind)
 	default:
 		return nil, fmt.Errorf("%w: %s", prometheus.ErrInvalidQueryKind, cfg.Kind)
 	}
@@ -56,14 +65,22 @@ func NewPrometheusProber[V Type](ctx context.Context, prom *prometheus.Prometheu
 	return prober, nil
 }
 
-func (p *PrometheusProber[V]) AddEnvironment(name string, namespace string) (Probe[V], error) {
-	e, err := p.query.AddEnvironment(name, namespace)
+func (p *PrometheusProber[V]) AddEnvironment(env Target) (Probe[V], error) {
+	e, err := p.query.AddEnvironment(env.Name, env.Namespace, env.Cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add environment: %w", err)
 	}
+	p.claims.add(env)
 	return NewPrometheusProbe[V](e, p.converter)
 }
 
+// RemoveEnvironment releases the environment query once no other claim of the name uses it.
+func (p *PrometheusProber[V]) RemoveEnvironment(env Target) {
+	if p.claims.remove(env) {
+		p.query.RemoveEnvironment(env.Name, env.Namespace, env.Cluster)
+	}
+}
+
 var (
 	PromValToFloat = func(value float64, _ string) (float64, error) {
 		return value, nil
@@ -155,6 +155,45 @@ func TestNewPrometheusProberValidation(t *testing.T) {
 	}
 }
 
+func TestPrometheusProberRemoveEnvironmentKeepsSharedQuery(t *testing.T) {
+	t.Parallel()
+
+	query := &recordingQuerier{}
+	prober := &PrometheusProber[bool]{query: query, converter: PromValToBool}
+
+	winner := Target{Name: "a", Namespace: "env-a", Source: "namespaces/env-a"}
+	loser := Target{Name: "a", Namespace: "env-a", Source: "deployments.apps/a"}
+	for _, env := range []Target{winner, loser} {
+		if _, err := prober.AddEnvironment(env); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", env.Source, err)
+		}
+	}
+
+	prober.RemoveEnvironment(loser)
+	if query.removed != 0 {
+		t.Fatal("query removed while the claim of namespaces/env-a still uses it")
+	}
+	prober.RemoveEnvironment(winner)
+	if query.removed != 1 {
+		t.Fatalf("removed = %d, want the query removed with the last claim", query.removed)
+	}
+}
+
+// recordingQuerier counts removed environments. The embedded querier is nil, it only
+// provides the unexported methods.
+type recordingQuerier struct {
+	prom.EnvironmentQuerier
+	removed int
+}
+
+func (q *recordingQuerier) AddEnvironment(_ string, _ string, _ string) (prom.QueryExecutor, error) {
+	return &fakeQueryExecutor{value: 1, text: "1"}, nil
+}
+
+func (q *recordingQuerier) RemoveEnvironment(_ string, _ string, _ string) {
+	q.removed++
+}
+
 type intLikeFloat float64
 
 type fakeQueryExecutor struct {
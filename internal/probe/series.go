@@ -0,0 +1,55 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/prometheus"
+)
+
+// SeriesProber creates metadata probes returning the series of a Prometheus range query.
+type SeriesProber struct {
+	query *prometheus.RangeQuery
+}
+
+var _ MetadataProber = (*SeriesProber)(nil)
+
+// NewSeriesProber creates a prober for series metadata, e.g. the requests per minute over the last hour.
+func NewSeriesProber(ctx context.Context, prom *prometheus.Prometheus, cfg prometheus.QueryConfig) (*SeriesProber, error) {
+	if prom == nil {
+		return nil, fmt.Errorf("prom must be provided: %w", ErrInvalidNil)
+	}
+
+	query, err := prometheus.NewRangeQuery(ctx, *prom, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Prometheus query: %w", err)
+	}
+
+	return &SeriesProber{query: query}, nil
+}
+
+func (p *SeriesProber) AddEnvironment(env Target) (MetadataProbe, error) {
+	return &SeriesProbe{series: p.query.AddEnvironment(env.Name, env.Namespace)}, nil
+}
+
+func (p *SeriesProber) RemoveEnvironment(_ Target) {}
+
+// SeriesProbe returns the points of a series as metadata value.
+type SeriesProbe struct {
+	series prometheus.SeriesExecutor
+}
+
+var _ MetadataProbe = (*SeriesProbe)(nil)
+
+func (p *SeriesProbe) Value(ctx context.Context) (any, error) {
+	points, err := p.series.Series(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get series: %w", err)
+	}
+	return points, nil
+}
+
+func (p *SeriesProbe) LastUpdate() time.Time {
+	return p.series.LastUpdate()
+}
@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/state"
+)
+
+// statePrefix is the prefix of the state keys of probe values.
+const statePrefix = "probe"
+
+// SharedProber shares the values of its probes through a state backend. Each replica still
+// runs its own probes, but serves the value of another replica while its own probe has
+// none, e.g. because the datasource is not reachable from the replica.
+type SharedProber[V Type] struct {
+	prober  Prober[V]
+	backend state.Backend
+	check   string
+	// claims tracks the claims of the shared records, which are deleted with the last claim.
+	claims claims
+	// maxAge is the age after which shared values are no longer served.
+	maxAge time.Duration
+}
+
+var _ Prober[bool] = (*SharedProber[bool])(nil)
+
+// NewSharedProber wraps prober so the values of the check are shared through backend.
+func NewSharedProber[V Type](prober Prober[V], backend state.Backend, check string, maxAge time.Duration) (*SharedProber[V], error) {
+	if prober == nil || backend == nil {
+		return nil, fmt.Errorf("prober and backend must be provided: %w", ErrInvalidNil)
+	}
+
+	return &SharedProber[V]{prober: prober, backend: backend, check: check, maxAge: maxAge}, nil
+}
+
+func (p *SharedProber[V]) AddEnvironment(env Target) (Probe[V], error) {
+	probe, err := p.prober.AddEnvironment(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add environment: %w", err)
+	}
+
+	p.claims.add(env)
+	return &SharedProbe[V]{
+		probe:   probe,
+		backend: p.backend,
+		key:     p.key(env),
+		maxAge:  p.maxAge,
+	}, nil
+}
+
+func (p *SharedProber[V]) RemoveEnvironment(env Target) {
+	p.prober.RemoveEnvironment(env)
+
+	if !p.claims.remove(env) {
+		return
+	}
+	// Removing an environment is not bound to a request
+	ctx := context.Background()
+	if err := p.backend.Delete(ctx, p.key(env)); err != nil {
+		slog.WarnContext(ctx, "failed to delete shared probe value", "key", p.key(env), "error", err)
+	}
+}
+
+// key returns the state key of the value of env.
+func (p *SharedProber[V]) key(env Target) string {
+	return state.Key(statePrefix, p.check, env.Cluster, env.Namespace, env.Name)
+}
+
+// SharedProbe serves the value of the wrapped probe, or the value stored in the state
+// backend while the wrapped probe has none.
+type SharedProbe[V Type] struct {
+	probe   Probe[V]
+	backend state.Backend
+	key     string
+	maxAge  time.Duration
+	// shared is the update time of the last shared value served, in Unix nanoseconds. It
+	// is zero if the last value was served by the wrapped probe.
+	shared atomic.Int64
+	// stored is the update time of the last value stored, in Unix nanoseconds.
+	stored atomic.Int64
+}
+
+var _ Probe[bool] = (*SharedProbe[bool])(nil)
+
+func (p *SharedProbe[V]) Value(ctx context.Context) (V, error) {
+	val, err := p.probe.Value(ctx)
+	updated := p.probe.LastUpdate()
+
+	if err == nil && !updated.IsZero() {
+		p.shared.Store(0)
+		if updated.UnixNano() > p.stored.Load() {
+			p.store(ctx, val, updated)
+		}
+		return val, nil
+	}
+
+	// The state backend is only read while the probe has no value of its own
+	rec, ok, getErr := p.backend.Get(ctx, p.key)
+	if getErr != nil {
+		slog.WarnContext(ctx, "failed to get shared probe value", "key", p.key, "error", getErr)
+	}
+
+	if ok && rec.UpdatedAt.After(updated) && time.Since(rec.UpdatedAt) <= p.maxAge {
+		var shared V
+		if err := json.Unmarshal([]byte(rec.Value), &shared); err == nil {
+			p.shared.Store(rec.UpdatedAt.UnixNano())
+			return shared, nil
+		}
+		slog.WarnContext(ctx, "ignoring invalid shared probe value", "key", p.key, "error", err)
+	}
+
+	//nolint:wrapcheck // the error is returned unchanged so the status reason matches the unshared probe
+	return val, err
+}
+
+// LastUpdate returns the update time of the newest value served.
+func (p *SharedProbe[V]) LastUpdate() time.Time {
+	updated := p.probe.LastUpdate()
+	if shared := p.shared.Load(); shared != 0 && time.Unix(0, shared).After(updated) {
+		return time.Unix(0, shared)
+	}
+	return updated
+}
+
+func (p *SharedProbe[V]) store(ctx context.Context, val V, updated time.Time) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to encode shared probe value", "key", p.key, "error", err)
+		return
+	}
+
+	if err := p.backend.Set(ctx, p.key, state.Record{UpdatedAt: updated, Value: string(data)}); err != nil {
+		slog.WarnContext(ctx, "failed to store shared probe value", "key", p.key, "error", err)
+		return
+	}
+	p.stored.Store(updated.UnixNano())
+}
@@ -0,0 +1,155 @@
+package probe
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/state"
+)
+
+func TestSharedProbeValue(t *testing.T) {
+	t.Parallel()
+
+	errFailing := errors.New("query failed")
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		shared      *state.Record
+		wantErr     error
+		name        string
+		local       timedProbe[string]
+		want        string
+		wantUpdated time.Time
+		wantStored  string
+	}{
+		{
+			name:        "stores local value",
+			local:       timedProbe[string]{value: "running", updated: now},
+			want:        "running",
+			wantUpdated: now,
+			wantStored:  `"running"`,
+		},
+		{
+			name:        "prefers local value over newer shared value",
+			local:       timedProbe[string]{value: "pending", updated: now.Add(-time.Minute)},
+			shared:      &state.Record{UpdatedAt: now, Value: `"running"`},
+			want:        "pending",
+			wantUpdated: now.Add(-time.Minute),
+			wantStored:  `"running"`,
+		},
+		{
+			name:        "replaces older shared value",
+			local:       timedProbe[string]{value: "running", updated: now},
+			shared:      &state.Record{UpdatedAt: now.Add(-time.Minute), Value: `"pending"`},
+			want:        "running",
+			wantUpdated: now,
+			wantStored:  `"running"`,
+		},
+		{
+			name:        "ignores expired shared value",
+			local:       timedProbe[string]{value: "pending", updated: now.Add(-2 * time.Hour)},
+			shared:      &state.Record{UpdatedAt: now.Add(-time.Hour), Value: `"running"`},
+			want:        "pending",
+			wantUpdated: now.Add(-2 * time.Hour),
+			wantStored:  `"running"`,
+		},
+		{
+			name:        "serves shared value if probe fails",
+			local:       timedProbe[string]{err: errFailing},
+			shared:      &state.Record{UpdatedAt: now, Value: `"running"`},
+			want:        "running",
+			wantUpdated: now,
+			wantStored:  `"running"`,
+		},
+		{
+			name:       "ignores expired shared value if probe fails",
+			local:      timedProbe[string]{err: errFailing},
+			shared:     &state.Record{UpdatedAt: now.Add(-time.Hour), Value: `"running"`},
+			wantErr:    errFailing,
+			wantStored: `"running"`,
+		},
+		{
+			name:    "probe error without shared value",
+			local:   timedProbe[string]{err: errFailing},
+			wantErr: errFailing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			backend := state.NewMemory()
+			if tt.shared != nil {
+				if err := backend.Set(t.Context(), "check", *tt.shared); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+
+			probe := &SharedProbe[string]{probe: tt.local, backend: backend, key: "check", maxAge: 10 * time.Minute}
+			got, err := probe.Value(t.Context())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Value() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Value() = %q, want %q", got, tt.want)
+			}
+			if updated := probe.LastUpdate(); !updated.Equal(tt.wantUpdated) {
+				t.Fatalf("LastUpdate() = %v, want %v", updated, tt.wantUpdated)
+			}
+
+			rec, _, err := backend.Get(t.Context(), "check")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if rec.Value != tt.wantStored {
+				t.Fatalf("stored value = %q, want %q", rec.Value, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestSharedProberRemoveEnvironment(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	backend := state.NewMemory()
+	prober, err := NewSharedProber[string](&timedProber{probe: timedProbe[string]{value: "running", updated: now}}, backend, "phase", time.Minute)
+	if err != nil {
+		t.Fatalf("NewSharedProber() error = %v", err)
+	}
+
+	winner := Target{Name: "a", Namespace: "env-a", Source: "namespaces/env-a"}
+	loser := Target{Name: "a", Namespace: "env-a", Source: "deployments.apps/a"}
+	for _, env := range []Target{winner, loser} {
+		p, err := prober.AddEnvironment(env)
+		if err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", env.Source, err)
+		}
+		if _, err := p.Value(t.Context()); err != nil {
+			t.Fatalf("Value(%s) error = %v", env.Source, err)
+		}
+	}
+
+	key := prober.key(winner)
+	prober.RemoveEnvironment(loser)
+	if _, ok, _ := backend.Get(t.Context(), key); !ok {
+		t.Fatal("shared value deleted while the claim of namespaces/env-a still uses it")
+	}
+	prober.RemoveEnvironment(winner)
+	if _, ok, _ := backend.Get(t.Context(), key); ok {
+		t.Fatal("shared value kept after the last claim was removed")
+	}
+}
+
+// timedProber returns the same probe for every environment.
+type timedProber struct {
+	probe timedProbe[string]
+}
+
+func (p *timedProber) AddEnvironment(_ Target) (Probe[string], error) {
+	return p.probe, nil
+}
+
+func (p *timedProber) RemoveEnvironment(_ Target) {}
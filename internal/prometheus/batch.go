@@ -0,0 +1,309 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// maxBatchSize is the maximum number of namespaces combined into one request.
+	maxBatchSize = 50
+	// queryKindBatch labels the metrics of batched single value queries.
+	queryKindBatch = "batch"
+	// batchNamespace and batchName replace the template fields when the query is rewritten.
+	batchNamespace = "__ephemeralenv_namespace__"
+	batchName      = "__ephemeralenv_name__"
+	// batchMatchLabel is the label the results of a batch are matched on.
+	batchMatchLabel model.LabelName = "namespace"
+)
+
+var (
+	ErrQueryNotBatchable = errors.New("query cannot be batched")
+	errBatchUnmatched    = errors.New("batch result without namespace label")
+)
+
+var (
+	// namespaceMatcher matches the namespace label matcher of the rewritten query template.
+	namespaceMatcher = regexp.MustCompile(`\bnamespace\s*=\s*"` + batchNamespace + `"`)
+	// unbatchableFunc matches functions whose results change if the query selects the
+	// series of multiple namespaces, while still carrying the namespace label.
+	unbatchableFunc = regexp.MustCompile(`\b(topk|bottomk|limitk|limit_ratio|scalar|vector|absent|absent_over_time)\s*\(`)
+)
+
+// BatchQuery executes a single value query for all environments with one bulk query. The
+// namespace matcher of the query is rewritten to match the namespaces of all environments,
+// and the results are matched to the environments on their namespace label.
+// Environments without a result in the last batch, e.g. because they were created after it
+// or the batch failed, are queried on their own. If the results of a batch do not carry the
+// namespace label, e.g. because it is aggregated away, all environments are queried on their
+// own from then on.
+type BatchQuery struct {
+	lastQuery  time.Time
+	Prometheus *Prometheus
+	// single executes the query of a single environment.
+	single *SingleValueQuery
+	// results holds the results of the last batch by namespace.
+	results map[string]batchResult
+	// envs holds the registered environments by namespace.
+	envs map[string]map[batchEnv]struct{}
+	// running is closed when the running batch completes. It is nil if no batch is running.
+	running chan struct{}
+	// query is the rewritten query. batchNamespace is replaced by the namespace regex.
+	query string
+	cfg   QueryConfig
+	// unmatched is set once a batch returned results without namespace label.
+	unmatched atomic.Bool
+	mu        sync.Mutex
+}
+
+// batchEnv identifies an environment of a namespace. Namespaces of the same name in
+// different clusters share the result of the namespace.
+type batchEnv struct {
+	cluster string
+	name    string
+}
+
+type batchResult struct {
+	err    error
+	sample model.Sample
+}
+
+var _ EnvironmentQuerier = (*BatchQuery)(nil)
+
+// NewBatchQuery creates a single value query that is executed for all environments at once.
+// It returns ErrQueryNotBatchable if the query cannot be rewritten, e.g. because it depends
+// on the environment name; a SingleValueQuery must be used instead.
+func NewBatchQuery(ctx context.Context, prom Prometheus, cfg QueryConfig) (*BatchQuery, error) {
+	single, err := NewSingleValueQuery(ctx, prom, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := rewriteBatchQuery(single.QueryTpl)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "creating batched single value Prometheus query", "name", cfg.Name, "query_kind", cfg.Kind, "query", query)
+
+	return &BatchQuery{
+		Prometheus: single.Prometheus,
+		single:     single,
+		query:      query,
+		cfg:        cfg,
+		results:    make(map[string]batchResult),
+		envs:       make(map[string]map[batchEnv]struct{}),
+	}, nil
+}
+
+// rewriteBatchQuery renders tpl with a namespace regex matcher instead of the namespace of a
+// single environment. Every use of the namespace must be the value of a namespace matcher.
+func rewriteBatchQuery(tpl *template.Template) (string, error) {
+	var sb strings.Builder
+	err := tpl.Execute(&sb, map[string]string{
+		"name":      batchName,
+		"namespace": batchNamespace,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute query template: %w", err)
+	}
+	query := sb.String()
+
+	switch {
+	case strings.Contains(query, batchName):
+		return "", fmt.Errorf("%w: depends on the environment name", ErrQueryNotBatchable)
+	case unbatchableFunc.MatchString(query):
+		return "", fmt.Errorf("%w: uses a function that depends on all selected series", ErrQueryNotBatchable)
+	}
+
+	matchers := len(namespaceMatcher.FindAllStringIndex(query, -1))
+	if matchers == 0 || matchers != strings.Count(query, batchNamespace) {
+		return "", fmt.Errorf("%w: namespace is not only used in namespace=\"...\" matchers", ErrQueryNotBatchable)
+	}
+
+	return namespaceMatcher.ReplaceAllLiteralString(query, `namespace=~"`+batchNamespace+`"`), nil
+}
+
+func (q *BatchQuery) AddEnvironment(name string, namespace string, cluster string) (QueryExecutor, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.envs[namespace] == nil {
+		q.envs[namespace] = make(map[batchEnv]struct{})
+	}
+	q.envs[namespace][batchEnv{cluster: cluster, name: name}] = struct{}{}
+
+	return q.Prometheus.newEnvironmentQuery(q, name, namespace, cluster), nil
+}
+
+func (q *BatchQuery) RemoveEnvironment(name string, namespace string, cluster string) {
+	q.mu.Lock()
+	delete(q.envs[namespace], batchEnv{cluster: cluster, name: name})
+	if len(q.envs[namespace]) == 0 {
+		delete(q.envs, namespace)
+	}
+	q.mu.Unlock()
+
+	q.Prometheus.removeEnvironmentQuery(q, name, namespace, cluster)
+}
+
+func (q *BatchQuery) Config() QueryConfig {
+	return q.cfg
+}
+
+func (q *BatchQuery) queryForEnvironment(ctx context.Context, name string, namespace string) (model.Sample, error) {
+	if q.unmatched.Load() {
+		return q.single.queryForEnvironment(ctx, name, namespace)
+	}
+
+	res, ok, err := q.result(ctx, namespace)
+	if err != nil {
+		return model.ZeroSample, err
+	}
+	if !ok {
+		// The environment was not part of the last batch or its batch failed
+		return q.single.queryForEnvironment(ctx, name, namespace)
+	}
+	return res.sample, res.err
+}
+
+// result returns the result of namespace in the last batch. A new batch is run once the
+// interval elapsed. The lock is not held while the batch is running; all callers, including
+// the one that started it, wait for it to complete.
+func (q *BatchQuery) result(ctx context.Context, namespace string) (batchResult, bool, error) {
+	q.mu.Lock()
+	if q.running == nil && time.Since(q.lastQuery) >= q.cfg.Interval {
+		q.running = make(chan struct{})
+		// The batch serves all waiting callers, so it is not cancelled with the caller
+		// that started it. Each request of the batch is bounded by the query timeout.
+		go q.run(context.WithoutCancel(ctx), slices.Sorted(maps.Keys(q.envs)), q.running)
+	}
+
+	if running := q.running; running != nil {
+		q.mu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return batchResult{}, false, fmt.Errorf("failed to wait for batch: %w", ctx.Err())
+		}
+		q.mu.Lock()
+	}
+	defer q.mu.Unlock()
+
+	res, ok := q.results[namespace]
+	return res, ok, nil
+}
+
+// run runs the batches of the namespaces and hands their results to the waiting callers.
+func (q *BatchQuery) run(ctx context.Context, namespaces []string, running chan struct{}) {
+	results := q.runBatches(ctx, namespaces)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.results, q.lastQuery, q.running = results, time.Now(), nil
+	close(running)
+}
+
+// runBatches queries the namespaces in chunks of maxBatchSize. Namespaces of failed chunks
+// have no result.
+func (q *BatchQuery) runBatches(ctx context.Context, namespaces []string) map[string]batchResult {
+	results := make(map[string]batchResult, len(namespaces))
+	for chunk := range slices.Chunk(namespaces, maxBatchSize) {
+		chunkResults, err := q.runBatch(ctx, chunk)
+		if errors.Is(err, errBatchUnmatched) {
+			slog.WarnContext(ctx, "batched query results do not carry the namespace label, querying environments one by one", "name", q.cfg.Name, "query", q.query)
+			q.unmatched.Store(true)
+			return nil
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "batched query failed, querying environments one by one", "name", q.cfg.Name, "batch_size", len(chunk), "error", err)
+			continue
+		}
+		maps.Copy(results, chunkResults)
+	}
+	return results
+}
+
+// runBatch executes the query for the namespaces in a single request.
+func (q *BatchQuery) runBatch(ctx context.Context, namespaces []string) (map[string]batchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	queryStatus := "failed"
+	defer func() {
+		promQueryDuration.WithLabelValues(q.cfg.Name, queryKindBatch, queryStatus).Observe(time.Since(start).Seconds())
+	}()
+
+	patterns := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		patterns[i] = regexp.QuoteMeta(ns)
+	}
+	// The regex is embedded in a PromQL string, so backslashes must be escaped
+	regex := strings.ReplaceAll(strings.Join(patterns, "|"), `\`, `\\`)
+	query := strings.ReplaceAll(q.query, batchNamespace, regex)
+
+	log := slog.With("name", q.cfg.Name, "query_kind", queryKindBatch, "batch_size", len(namespaces))
+	log.DebugContext(ctx, "executing batched Prometheus query", "query", query)
+
+	release, err := q.Prometheus.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, warnings, err := q.Prometheus.query(ctx, query, time.Now(), v1.WithTimeout(q.cfg.Timeout))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if len(warnings) > 0 {
+		log.WarnContext(ctx, "prometheus query succeeded with warnings", "warnings", warnings)
+	}
+
+	samples, ok := res.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %T: %w", res, ErrResultNotParsable)
+	}
+
+	results := make(map[string]batchResult, len(namespaces))
+	for _, ns := range namespaces {
+		results[ns] = batchResult{err: ErrResultNotFound}
+	}
+
+	counts := make(map[string]int, len(namespaces))
+	for _, sample := range samples {
+		ns := string(sample.Metric[batchMatchLabel])
+		if ns == "" {
+			return nil, errBatchUnmatched
+		}
+		if _, requested := results[ns]; !requested {
+			continue
+		}
+
+		counts[ns]++
+		results[ns] = batchResult{sample: *sample}
+	}
+
+	for ns, count := range counts {
+		if count > 1 {
+			log.ErrorContext(ctx, "prometheus query returned too many results", "num_results", count, "env_namespace", ns)
+			results[ns] = batchResult{err: ErrTooManyResults}
+		}
+	}
+
+	queryStatus = "success"
+	return results, nil
+}
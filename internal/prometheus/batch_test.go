@@ -0,0 +1,322 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func newTestBatchQuery(t *testing.T, prom Prometheus, query string, namespaces ...string) *BatchQuery {
+	t.Helper()
+
+	q, err := NewBatchQuery(t.Context(), prom, QueryConfig{
+		Name:     "healthy",
+		Kind:     QueryKindSingleValue,
+		Query:    query,
+		Interval: time.Minute,
+		Timeout:  2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewBatchQuery() error = %v", err)
+	}
+
+	for _, ns := range namespaces {
+		if _, err := q.AddEnvironment(ns, ns, ""); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", ns, err)
+		}
+	}
+	return q
+}
+
+func TestBatchQueryRewritesNamespaceMatcher(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		want := `sum by (namespace) (up{namespace=~"env-a|env-b|env-c"})`
+		if q := requestQueryValue(r, "query"); q != want {
+			t.Errorf("query = %q, want %q", q, want)
+		}
+
+		// env-b has no result, env-c has too many
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"namespace":"env-a","owner":"team-a"},"value":[1700000000,"2"]},`+
+			`{"metric":{"namespace":"env-c","pod":"a"},"value":[1700000000,"1"]},`+
+			`{"metric":{"namespace":"env-c","pod":"b"},"value":[1700000000,"1"]}]}}`)
+	})
+	defer closeFn()
+
+	q := newTestBatchQuery(t, prom, `sum by (namespace) (up{namespace="{{.namespace}}"})`, "env-a", "env-b", "env-c")
+
+	sample, err := q.queryForEnvironment(t.Context(), "env-a", "env-a")
+	if err != nil {
+		t.Fatalf("queryForEnvironment(env-a) error = %v", err)
+	}
+	if sample.Value != 2 || sample.Metric["owner"] != "team-a" {
+		t.Fatalf("sample = %v, want value 2 with owner label", sample)
+	}
+
+	if _, err := q.queryForEnvironment(t.Context(), "env-b", "env-b"); !errors.Is(err, ErrResultNotFound) {
+		t.Fatalf("queryForEnvironment(env-b) error = %v, want ErrResultNotFound", err)
+	}
+	if _, err := q.queryForEnvironment(t.Context(), "env-c", "env-c"); !errors.Is(err, ErrTooManyResults) {
+		t.Fatalf("queryForEnvironment(env-c) error = %v, want ErrTooManyResults", err)
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+}
+
+func TestBatchQueryNotBatchable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "environment name", query: `up{namespace="{{.namespace}}", env="{{.name}}"}`},
+		{name: "no namespace", query: `up{job="api"}`},
+		{name: "namespace outside matcher", query: `up{namespace="{{.namespace}}", pod=~"{{.namespace}}-.*"}`},
+		{name: "negative matcher", query: `up{namespace!="{{.namespace}}"}`},
+		{name: "other label", query: `up{kube_namespace="{{.namespace}}"}`},
+		{name: "topk", query: `topk(1, up{namespace="{{.namespace}}"})`},
+		{name: "vector fallback", query: `sum by (namespace) (up{namespace="{{.namespace}}"}) or vector(0)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewBatchQuery(t.Context(), Prometheus{}, QueryConfig{
+				Name:     "healthy",
+				Kind:     QueryKindSingleValue,
+				Query:    tt.query,
+				Interval: time.Minute,
+				Timeout:  2 * time.Second,
+			})
+			if !errors.Is(err, ErrQueryNotBatchable) {
+				t.Fatalf("NewBatchQuery() error = %v, want ErrQueryNotBatchable", err)
+			}
+		})
+	}
+}
+
+func TestBatchQueryFallsBackToSingleQueries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// batch is the response to the batched query
+		batch         string
+		wantUnmatched bool
+	}{
+		{name: "batch fails", batch: `{"status":"error","errorType":"execution","error":"too many samples"}`},
+		{name: "namespace aggregated away", batch: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"3"]}]}}`, wantUnmatched: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var batches, singles atomic.Int32
+			prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(requestQueryValue(r, "query"), "=~") {
+					batches.Add(1)
+					if strings.Contains(tt.batch, `"error"`) {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusUnprocessableEntity)
+					}
+					writePromResponse(w, tt.batch)
+					return
+				}
+				singles.Add(1)
+				writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`)
+			})
+			defer closeFn()
+
+			q := newTestBatchQuery(t, prom, `sum(up{namespace="{{.namespace}}"})`, "env-a", "env-b")
+
+			for _, ns := range []string{"env-a", "env-b"} {
+				sample, err := q.queryForEnvironment(t.Context(), ns, ns)
+				if err != nil {
+					t.Fatalf("queryForEnvironment(%s) error = %v", ns, err)
+				}
+				if sample.Value != model.SampleValue(1) {
+					t.Fatalf("sample.Value = %v, want 1", sample.Value)
+				}
+			}
+
+			if batches.Load() != 1 || singles.Load() != 2 {
+				t.Fatalf("batches = %d, singles = %d, want 1 and 2", batches.Load(), singles.Load())
+			}
+			if q.unmatched.Load() != tt.wantUnmatched {
+				t.Fatalf("unmatched = %t, want %t", q.unmatched.Load(), tt.wantUnmatched)
+			}
+		})
+	}
+}
+
+func TestBatchQueryNewEnvironment(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if q := requestQueryValue(r, "query"); strings.Contains(q, "=~") {
+			t.Errorf("query = %q, want a single environment query", q)
+		}
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`)
+	})
+	defer closeFn()
+
+	q := newTestBatchQuery(t, prom, `sum by (namespace) (up{namespace="{{.namespace}}"})`)
+
+	// Environments created after the last batch are queried on their own until the next batch
+	q.lastQuery = time.Now()
+	for _, ns := range []string{"env-a", "env-b"} {
+		if _, err := q.AddEnvironment(ns, ns, ""); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", ns, err)
+		}
+		sample, err := q.queryForEnvironment(t.Context(), ns, ns)
+		if err != nil {
+			t.Fatalf("queryForEnvironment(%s) error = %v", ns, err)
+		}
+		if sample.Value != model.SampleValue(1) {
+			t.Fatalf("sample.Value = %v, want 1", sample.Value)
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+
+	// The namespace stays part of the batch while it holds an environment of another cluster
+	if _, err := q.AddEnvironment("env-a", "env-a", "west"); err != nil {
+		t.Fatalf("AddEnvironment(env-a, west) error = %v", err)
+	}
+	q.RemoveEnvironment("env-a", "env-a", "")
+	if _, ok := q.envs["env-a"]; !ok {
+		t.Fatal("namespace removed from the batch while cluster west still has an environment")
+	}
+	q.RemoveEnvironment("env-a", "env-a", "west")
+	if _, ok := q.envs["env-a"]; ok {
+		t.Fatal("removed namespace is still part of the batch")
+	}
+}
+
+func TestBatchQuerySurvivesCancelledCaller(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"namespace":"env-a"},"value":[1700000000,"1"]},`+
+			`{"metric":{"namespace":"env-b"},"value":[1700000000,"2"]}]}}`)
+	})
+	defer closeFn()
+
+	q := newTestBatchQuery(t, prom, `up{namespace="{{.namespace}}"}`, "env-a", "env-b")
+
+	// The caller starting the batch gives up, e.g. because its API client disconnected
+	ctx, cancel := context.WithCancel(t.Context())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := q.queryForEnvironment(ctx, "env-a", "env-a")
+		errs <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("queryForEnvironment(env-a) error = %v, want context.Canceled", err)
+	}
+
+	close(release)
+	sample, err := q.queryForEnvironment(t.Context(), "env-b", "env-b")
+	if err != nil {
+		t.Fatalf("queryForEnvironment(env-b) error = %v", err)
+	}
+	if sample.Value != 2 {
+		t.Fatalf("sample = %v, want value 2", sample)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("calls = %d, want the batch of the cancelled caller to be shared", got)
+	}
+}
+
+func TestPrometheusAcquireLimitsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	p := &Prometheus{slots: make(chan struct{}, 1)}
+
+	release, err := p.acquire(t.Context())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() with no free slot error = %v, want DeadlineExceeded", err)
+	}
+
+	release()
+	release, err = p.acquire(t.Context())
+	if err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func TestPrometheusCacheJitter(t *testing.T) {
+	t.Parallel()
+
+	p := &Prometheus{jitter: 0.1}
+	for range 100 {
+		if got := p.cacheJitter(time.Minute); got < 0 || got >= 6*time.Second {
+			t.Fatalf("cacheJitter() = %s, want within [0s, 6s)", got)
+		}
+	}
+
+	if got := (&Prometheus{}).cacheJitter(time.Minute); got != 0 {
+		t.Fatalf("cacheJitter() without jitter = %s, want 0s", got)
+	}
+}
+
+func TestNewPrometheusJitter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		jitter *float64
+		name   string
+		want   float64
+	}{
+		{name: "unset", jitter: nil, want: defaultJitter},
+		{name: "disabled", jitter: new(0.0), want: 0},
+		{name: "configured", jitter: new(0.5), want: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := newPrometheus("test", nil, Config{Jitter: tt.jitter}).jitter; got != tt.want {
+				t.Fatalf("jitter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -49,16 +49,16 @@ func (q *BulkValueQuery) matchKey(name, namespace string) string {
 	}
 }
 
-func (q *BulkValueQuery) AddEnvironment(name string, namespace string) (QueryExecutor, error) {
+func (q *BulkValueQuery) AddEnvironment(name string, namespace string, cluster string) (QueryExecutor, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	return &environmentQuery{
-		query:     q,
-		envName:   name,
-		namespace: namespace,
-	}, nil
+	return q.Prometheus.newEnvironmentQuery(q, name, namespace, cluster), nil
+
+}
 
+func (q *BulkValueQuery) RemoveEnvironment(name string, namespace string, cluster string) {
+	q.Prometheus.removeEnvironmentQuery(q, name, namespace, cluster)
 }
 
 func (q *BulkValueQuery) Config() QueryConfig {
@@ -88,7 +88,7 @@ func (q *BulkValueQuery) queryForEnvironment(ctx context.Context, envName string
 		}
 
 		log.DebugContext(ctx, "cached value not found for query, but interval has not elapsed", "match_key", match)
-		return model.Sample{Timestamp: model.TimeFromUnixNano(q.lastQuery.UnixNano())}, nil
+		return model.ZeroSample, ErrResultNotFound
 	}
 
 	// Need to perform a new bulk query
@@ -96,8 +96,14 @@ func (q *BulkValueQuery) queryForEnvironment(ctx context.Context, envName string
 	q.valCache = make(map[string]model.Sample)
 
 	// Perform the bulk query
+	release, err := q.Prometheus.acquire(ctx)
+	if err != nil {
+		return model.ZeroSample, err
+	}
+	defer release()
+
 	log.DebugContext(ctx, "executing Prometheus query")
-	res, warnings, err := q.Prometheus.apiClient.Query(
+	res, warnings, err := q.Prometheus.query(
 		ctx, q.cfg.Query, time.Now(),
 		v1.WithTimeout(q.cfg.Timeout),
 	)
@@ -133,10 +139,10 @@ func (q *BulkValueQuery) queryForEnvironment(ctx context.Context, envName string
 
 	val, ok := q.valCache[match]
 	if !ok {
-		// No result for this environment, don't treat as an error as the environment may legitimately have no data
-		// i.e: during creation or if the probe condition is not met
-		log.WarnContext(ctx, "no result for registered environment after bulk query", "match_key", match)
-		return model.Sample{Timestamp: model.Now()}, nil
+		// The environment may legitimately have no data, i.e: during creation or if the probe
+		// condition is not met. Its value is unknown rather than zero.
+		log.DebugContext(ctx, "no result for registered environment after bulk query", "match_key", match)
+		return model.ZeroSample, ErrResultNotFound
 	}
 
 	return val, nil
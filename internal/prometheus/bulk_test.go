@@ -63,12 +63,9 @@ func TestBulkValueQueryCacheAndMatchBehavior(t *testing.T) {
 		t.Fatalf("sampleB.Value = %v, want 0", sampleB.Value)
 	}
 
-	sampleMissing, err := q.queryForEnvironment(t.Context(), "env-c", "ns-c")
-	if err != nil {
-		t.Fatalf("queryForEnvironment(ns-c) error = %v", err)
-	}
-	if sampleMissing.Value != model.SampleValue(0) {
-		t.Fatalf("sampleMissing.Value = %v, want 0", sampleMissing.Value)
+	_, err = q.queryForEnvironment(t.Context(), "env-c", "ns-c")
+	if !errors.Is(err, ErrResultNotFound) {
+		t.Fatalf("queryForEnvironment(ns-c) error = %v, want ErrResultNotFound", err)
 	}
 
 	mu.Lock()
@@ -0,0 +1,128 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// connectTimeout bounds each connection check, so an unreachable datasource does not
+	// block the startup.
+	connectTimeout = 5 * time.Second
+	// minReconnectBackoff and maxReconnectBackoff bound the wait between reconnect attempts.
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// ErrUnavailable is returned by queries if neither the datasource nor any of its failover
+// datasources is connected.
+var ErrUnavailable = errors.New("datasource is not connected")
+
+var (
+	promDatasourceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeralenv_prometheus_datasource_up",
+		Help: "Whether the datasource is connected (1) or not (0)",
+	}, []string{"datasource"})
+)
+
+// endpoint is a named Prometheus API client and the state of its connection. While an
+// endpoint is disconnected, queries skip it and a background loop tries to reconnect.
+type endpoint struct {
+	api v1.API
+	// reconnect signals the background loop to reconnect the endpoint.
+	reconnect chan struct{}
+	name      string
+	connected atomic.Bool
+}
+
+func newEndpoint(name string, api v1.API) *endpoint {
+	return &endpoint{
+		api:       api,
+		name:      name,
+		reconnect: make(chan struct{}, 1),
+	}
+}
+
+// connect checks the connection to Prometheus and updates the connection state.
+func (e *endpoint) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	res, err := e.api.Buildinfo(ctx)
+	if err != nil {
+		e.setConnected(false)
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	slog.DebugContext(ctx, "Connected to Prometheus", "datasource", e.name, "build_info", res)
+	e.setConnected(true)
+	return nil
+}
+
+func (e *endpoint) setConnected(connected bool) {
+	e.connected.Store(connected)
+
+	up := 0.0
+	if connected {
+		up = 1
+	}
+	promDatasourceUp.WithLabelValues(e.name).Set(up)
+}
+
+// disconnect marks the endpoint as disconnected and triggers a reconnect.
+func (e *endpoint) disconnect() {
+	e.setConnected(false)
+
+	select {
+	case e.reconnect <- struct{}{}:
+	default:
+		// A reconnect is already pending
+	}
+}
+
+// run reconnects the endpoint whenever it is disconnected, until ctx is done. Reconnect
+// attempts are retried with an exponential backoff.
+func (e *endpoint) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.reconnect:
+		}
+
+		backoff := minReconnectBackoff
+		for !e.connected.Load() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if err := e.connect(ctx); err != nil {
+				slog.WarnContext(ctx, "failed to reconnect to datasource", "datasource", e.name, "error", err, "backoff", backoff.String())
+				backoff = min(2*backoff, maxReconnectBackoff)
+				continue
+			}
+			slog.InfoContext(ctx, "reconnected to datasource", "datasource", e.name)
+		}
+	}
+}
+
+// isConnectionError reports whether err indicates that the datasource is unreachable.
+// Errors returned by the Prometheus API mean the datasource is reachable.
+func isConnectionError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *v1.Error
+	return !errors.As(err, &apiErr)
+}
@@ -3,13 +3,36 @@ package prometheus
 import (
 	"context"
 	"fmt"
-	"log/slog"
+	"math/rand/v2"
+	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/config"
 )
 
+const (
+	// defaultMaxConcurrency is the maximum number of concurrent queries if not configured.
+	defaultMaxConcurrency = 10
+	// defaultJitter is the fraction of the interval cached values are kept longer if not configured.
+	defaultJitter = 0.1
+)
+
+var (
+	promQueriesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ephemeralenv_prometheus_queries_in_flight",
+		Help: "Number of Prometheus queries currently executing",
+	})
+	promQueryWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "ephemeralenv_prometheus_query_wait_seconds",
+		Help: "Time queries waited for a free Prometheus query slot",
+
+		NativeHistogramBucketFactor: 1.1,
+	})
+)
+
 type Config struct {
 	// ClientConfig provides all Prometheus HTTTP authentication options
 	ClientConfig config.HTTPClientConfig `yaml:"clientConfig,omitempty"`
@@ -18,13 +41,29 @@ type Config struct {
 	Headers map[string]string `yaml:"headers,omitempty"`
 	// The address of the Prometheus to connect to.
 	Address string `yaml:"address"`
+	// Failover lists the datasources that are queried in order if this datasource fails.
+	Failover []string `yaml:"failover"`
+	// Jitter is the fraction of the interval by which cached values are randomly kept
+	// longer, so queries of different environments are spread out. Defaults to 0.1,
+	// 0 disables it.
+	Jitter *float64 `yaml:"jitter"`
+	// MaxConcurrency is the maximum number of concurrent queries. Defaults to 10.
+	MaxConcurrency int `yaml:"maxConcurrency"`
 }
 
 type Prometheus struct {
-	apiClient v1.API
+	// slots bounds the number of concurrent queries. It is nil if queries are not limited.
+	slots chan struct{}
+	// snapshot seeds and stores the samples of environment queries. It is nil if disabled.
+	snapshot *Snapshot
+	// name is the name of the datasource, used in logs and metrics.
+	name string
+	// endpoints holds the datasource followed by its failover datasources, in the order they are queried.
+	endpoints []*endpoint
+	jitter    float64
 }
 
-func prometheusAPI(ctx context.Context, cfg Config) (v1.API, error) {
+func prometheusAPI(cfg Config) (v1.API, error) {
 	// Set headers from cfg.Headers into cfg.ClientConfig.HTTPHeaders
 	if cfg.ClientConfig.HTTPHeaders == nil {
 		cfg.ClientConfig.HTTPHeaders = &config.Headers{
@@ -49,24 +88,97 @@ func prometheusAPI(ctx context.Context, cfg Config) (v1.API, error) {
 		return nil, fmt.Errorf("invalid client: %w", err)
 	}
 
-	api := v1.NewAPI(client)
-	res, err := api.Buildinfo(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("connection failed: %w", err)
-	}
-
-	slog.DebugContext(ctx, "Connected to Prometheus", "build_info", res)
+	return v1.NewAPI(client), nil
+}
 
-	return api, nil
+func (c Config) Validate() error {
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("maxConcurrency must not be negative: %w", errInvalidVal)
+	}
+	if c.Jitter != nil && (*c.Jitter < 0 || *c.Jitter > 1) {
+		return fmt.Errorf("jitter must be between 0 and 1: %w", errInvalidVal)
+	}
+	return nil
 }
 
+// NewPrometheus creates a client for a single datasource. Like the datasources of a Registry,
+// it does not fail if Prometheus is unreachable, but connects in the background.
 func NewPrometheus(ctx context.Context, cfg Config) (*Prometheus, error) {
-	apiClient, err := prometheusAPI(ctx, cfg)
+	registry, err := NewRegistry(ctx, map[string]Config{DefaultDatasource: cfg})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Prometheus API client: %w", err)
+		return nil, err
+	}
+	return registry.Get(DefaultDatasource), nil
+}
+
+// newPrometheus creates a Prometheus with the limits of cfg.
+func newPrometheus(name string, endpoints []*endpoint, cfg Config) *Prometheus {
+	maxConcurrency := cfg.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+	jitter := defaultJitter
+	if cfg.Jitter != nil {
+		jitter = *cfg.Jitter
 	}
 
 	return &Prometheus{
-		apiClient: apiClient,
+		name:      name,
+		endpoints: endpoints,
+		slots:     make(chan struct{}, maxConcurrency),
+		jitter:    jitter,
+	}
+}
+
+// newEnvironmentQuery creates the cached query of an environment and adds it to the snapshot.
+func (p *Prometheus) newEnvironmentQuery(query EnvironmentQuerier, name string, namespace string, cluster string) *environmentQuery {
+	q := &environmentQuery{
+		query:     query,
+		envName:   name,
+		namespace: namespace,
+		cluster:   cluster,
+		jitter:    p.cacheJitter(query.Config().Interval),
+	}
+	if p.snapshot != nil {
+		p.snapshot.track(q)
+	}
+	return q
+}
+
+// removeEnvironmentQuery removes the query of a deleted environment from the snapshot.
+func (p *Prometheus) removeEnvironmentQuery(query EnvironmentQuerier, name string, namespace string, cluster string) {
+	if p.snapshot != nil {
+		p.snapshot.untrack(snapshotKey(query.Config(), name, namespace, cluster))
+	}
+}
+
+// acquire waits for a free query slot. The returned function releases the slot.
+func (p *Prometheus) acquire(ctx context.Context) (func(), error) {
+	if p.slots == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for query slot: %w", ctx.Err())
+	}
+	promQueryWait.Observe(time.Since(start).Seconds())
+	promQueriesInFlight.Inc()
+
+	return func() {
+		promQueriesInFlight.Dec()
+		<-p.slots
 	}, nil
 }
+
+// cacheJitter returns a random duration by which the cache of an environment is kept
+// longer than interval, so refreshes of environments registered at once drift apart.
+func (p *Prometheus) cacheJitter(interval time.Duration) time.Duration {
+	if p.jitter == 0 {
+		return 0
+	}
+	//nolint:gosec // The jitter does not need a secure random source
+	return time.Duration(rand.Float64() * p.jitter * float64(interval))
+}
@@ -20,7 +20,10 @@ func newTestPrometheus(t *testing.T, handler func(http.ResponseWriter, *http.Req
 		t.Fatalf("NewClient() error = %v", err)
 	}
 
-	return Prometheus{apiClient: v1.NewAPI(client)}, func() {
+	e := newEndpoint("test", v1.NewAPI(client))
+	e.connected.Store(true)
+
+	return Prometheus{name: "test", endpoints: []*endpoint{e}}, func() {
 		ts.Close()
 	}
 }
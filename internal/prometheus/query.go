@@ -3,10 +3,12 @@ package prometheus
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"log/slog"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,6 +21,9 @@ const (
 	// the current time and the sample timestamp before considering the sample stale.
 	// The caching assumes that samples are recent enough to be valid for the cache duration.
 	sampleDriftAllowance = 500 * time.Millisecond
+
+	// defaultMaxPoints is the maximum number of points of a range query if not configured.
+	defaultMaxPoints = 250
 )
 
 var (
@@ -48,6 +53,7 @@ type QueryKind string
 const (
 	QueryKindSingleValue QueryKind = "single"
 	QueryKindBulk        QueryKind = "bulk"
+	QueryKindRange       QueryKind = "range"
 )
 
 type QueryMatchOn string
@@ -60,10 +66,12 @@ const (
 type QueryConfig struct {
 	// Name is the unique name of the query (automatically set from config key)
 	Name string `yaml:"name"`
-	// Kind is the type of query to perform (`single` value or `bulk`)
+	// Kind is the type of query to perform (`single` value, `bulk` or `range`)
 	Kind QueryKind `yaml:"kind"`
+	// Datasource is the name of the datasource to query. Defaults to the default datasource.
+	Datasource string `yaml:"datasource"`
 	// Query is the Prometheus query template to execute
-	// For single value queries, the template can use the following fields:
+	// For single value and range queries, the template can use the following fields:
 	//   - name: the environment name
 	//   - namespace: the environment namespace
 	// For bulk queries, no template fields are available
@@ -82,11 +90,20 @@ type QueryConfig struct {
 	Interval time.Duration `yaml:"interval"`
 	// Timeout is the maximum duration to wait for a query to complete
 	Timeout time.Duration `yaml:"timeout"`
+
+	// Range (range only) is the time window of the returned series, e.g. 1h.
+	Range time.Duration `yaml:"range"`
+	// Step (range only) is the resolution of the returned series, e.g. 1m.
+	Step time.Duration `yaml:"step"`
+	// MaxPoints (range only) caps the number of points of the returned series. Defaults to 250.
+	MaxPoints int `yaml:"maxPoints"`
 }
 
 type EnvironmentQuerier interface {
-	// AddEnvironment registers a new environment to be queried.
-	AddEnvironment(name string, namespace string) (QueryExecutor, error)
+	// AddEnvironment registers a new environment of a cluster to be queried.
+	AddEnvironment(name string, namespace string, cluster string) (QueryExecutor, error)
+	// RemoveEnvironment releases a deleted environment, so its sample is no longer snapshotted.
+	RemoveEnvironment(name string, namespace string, cluster string)
 	// Config returns the base query configuration.
 	Config() QueryConfig
 	// queryForEnvironment executes the query for the given environment, returning the raw Prometheus sample.
@@ -110,10 +127,17 @@ type QueryExecutor interface {
 type environmentQuery struct {
 	lastStored model.Sample
 	lastUpdate time.Time
-	query      EnvironmentQuerier
-	envName    string
-	namespace  string
-	mu         sync.RWMutex
+	// lastAttempt is the time of the last query while the stored sample is stale.
+	lastAttempt time.Time
+	query       EnvironmentQuerier
+	envName     string
+	namespace   string
+	cluster     string
+	// jitter extends the cache duration to spread the queries of different environments.
+	jitter time.Duration
+	mu     sync.RWMutex
+	// stale is set while the stored sample was restored from a snapshot and not yet refreshed.
+	stale bool
 }
 
 var _ QueryExecutor = (*environmentQuery)(nil)
@@ -149,20 +173,63 @@ func (c QueryConfig) Validate() error {
 		if err := c.validateBulk(); err != nil {
 			return fmt.Errorf("bulk query config is invalid: %w", err)
 		}
+	case QueryKindRange:
+		if err := c.validateRange(); err != nil {
+			return fmt.Errorf("range query config is invalid: %w", err)
+		}
 	default:
 		return fmt.Errorf("invalid query kind: %w: %s", errInvalidVal, c.Kind)
 	}
 
+	if c.Kind != QueryKindRange && (c.Range != 0 || c.Step != 0 || c.MaxPoints != 0) {
+		return fmt.Errorf("range, step and maxPoints are only valid for range queries: %w", errInvalidVal)
+	}
+
 	return nil
 }
 
+func (c QueryConfig) validateRange() error {
+	if c.Range <= 0 || c.Step <= 0 {
+		return fmt.Errorf("range and step must be greater than 0: %w", errInvalidVal)
+	}
+	if c.Step > c.Range {
+		return fmt.Errorf("step must not be greater than range: %w", errInvalidVal)
+	}
+	if c.MaxPoints < 0 {
+		return fmt.Errorf("maxPoints must not be negative: %w", errInvalidVal)
+	}
+	if points := int(c.Range/c.Step) + 1; points > c.maxPoints() {
+		return fmt.Errorf("range and step result in %d points, more than maxPoints %d: %w", points, c.maxPoints(), errInvalidVal)
+	}
+
+	// Range queries use the same template fields as single value queries
+	return c.validateSingle()
+}
+
+// maxPoints returns the configured maximum number of points of a range query or the default.
+func (c QueryConfig) maxPoints() int {
+	if c.MaxPoints > 0 {
+		return c.MaxPoints
+	}
+	return defaultMaxPoints
+}
+
+// parseQueryTemplate parses a query template the way it is executed. Queries are not HTML,
+// so their values are not escaped.
+func parseQueryTemplate(query string) (*template.Template, error) {
+	t, err := template.New("query").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("query must be a valid template: %w", err)
+	}
+	return t, nil
+}
+
 func (c QueryConfig) validateSingle() error {
 	// The query must be a valid Template and only use the defined template fields
-	t, err := template.New("query").Parse(c.Query)
+	t, err := parseQueryTemplate(c.Query)
 	if err != nil {
-		return fmt.Errorf("query must be a valid template: %w", err)
+		return err
 	}
-	t.Option("missingkey=error")
 	err = t.Execute(io.Discard, map[string]string{
 		"name":      "test",
 		"namespace": "default",
@@ -187,11 +254,10 @@ func (c QueryConfig) validateBulk() error {
 	}
 
 	// The query must be a valid Template and not use any template fields
-	t, err := template.New("query").Parse(c.Query)
+	t, err := parseQueryTemplate(c.Query)
 	if err != nil {
-		return fmt.Errorf("query must be a valid template: %w", err)
+		return err
 	}
-	t.Option("missingkey=error")
 
 	err = t.Execute(io.Discard, nil)
 	if err != nil {
@@ -234,8 +300,16 @@ func (q *environmentQuery) sample(ctx context.Context) (model.Sample, error) {
 
 	cfg := q.query.Config()
 
+	// A stale sample is refreshed at most once per interval, so an unavailable Prometheus
+	// is not queried on every request
+	if q.stale && time.Since(q.lastAttempt) < cfg.Interval {
+		promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "stale").Inc()
+
+		return q.lastStored, nil
+	}
+
 	// If the last query was recent enough, return the cached value
-	if time.Since(q.lastUpdate) < cfg.Interval {
+	if !q.stale && time.Since(q.lastUpdate) < cfg.Interval+q.jitter {
 		promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "hit").Inc()
 
 		return q.lastStored, nil
@@ -243,13 +317,26 @@ func (q *environmentQuery) sample(ctx context.Context) (model.Sample, error) {
 
 	// Need to perform a new query
 	promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "miss").Inc()
+	q.lastAttempt = time.Now()
 
 	var sample model.Sample
 	sample, err := q.query.queryForEnvironment(ctx, q.envName, q.namespace)
+	if err != nil && q.stale && !answered(err) {
+		slog.DebugContext(ctx, "serving stale value, query failed", "name", cfg.Name, "env_name", q.envName, "env_namespace", q.namespace, "error", err)
+		promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "stale").Inc()
+
+		return q.lastStored, nil
+	}
+
 	if err != nil {
+		if q.stale {
+			// Prometheus answered, so the stale sample is outdated
+			q.lastStored, q.lastUpdate, q.stale = model.ZeroSample, time.Time{}, false
+		}
 		return model.ZeroSample, fmt.Errorf("failed to query Prometheus for value: %w", err)
 	}
 
+	q.stale = false
 	q.lastStored = sample
 	// Use the sample timestamp as the last update time, to avoid stacking cache durations
 	q.lastUpdate = cmp.Or(sample.Timestamp.Time(), time.Now())
@@ -257,6 +344,35 @@ func (q *environmentQuery) sample(ctx context.Context) (model.Sample, error) {
 	return sample, nil
 }
 
+// seed stores a sample restored from a snapshot. It is served as stale value until the
+// query succeeds.
+func (q *environmentQuery) seed(sample model.Sample, updated time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lastStored = sample
+	q.lastUpdate = updated
+	q.stale = true
+}
+
+// snapshot returns the stored sample and its update time. It reports false if there is no
+// sample or the sample is stale.
+func (q *environmentQuery) snapshot() (model.Sample, time.Time, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stale || q.lastUpdate.IsZero() {
+		return model.ZeroSample, time.Time{}, false
+	}
+	return q.lastStored, q.lastUpdate, true
+}
+
+// answered reports whether the query error was returned by Prometheus, as opposed to
+// Prometheus being unavailable.
+func answered(err error) bool {
+	return errors.Is(err, ErrResultNotFound) || errors.Is(err, ErrTooManyResults) || errors.Is(err, ErrResultNotParsable)
+}
+
 func (q *environmentQuery) LastUpdate() time.Time {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
@@ -71,6 +71,81 @@ func TestQueryConfigValidateCases(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid range",
+			cfg: QueryConfig{
+				Name:     "activity",
+				Kind:     QueryKindRange,
+				Query:    `sum(rate(http_requests_total{namespace="{{.namespace}}"}[1m]))`,
+				Interval: time.Minute,
+				Timeout:  5 * time.Second,
+				Range:    time.Hour,
+				Step:     time.Minute,
+			},
+		},
+		{
+			// Queries are validated as they are executed, not as HTML
+			name: "range with markup in matcher",
+			cfg: QueryConfig{
+				Name:     "activity",
+				Kind:     QueryKindRange,
+				Query:    `sum(rate(http_requests_total{handler=~"<a.*", namespace="{{.namespace}}"}[1m]))`,
+				Interval: time.Minute,
+				Timeout:  5 * time.Second,
+				Range:    time.Hour,
+				Step:     time.Minute,
+			},
+		},
+		{
+			name: "bulk with markup in matcher",
+			cfg: QueryConfig{
+				Name:       "bulk",
+				Kind:       QueryKindBulk,
+				Query:      `sum(up{job=~"<a.*"}) by (namespace)`,
+				MatchOn:    QueryMatchOnNamespace,
+				MatchLabel: "namespace",
+				Interval:   30 * time.Second,
+				Timeout:    2 * time.Second,
+			},
+		},
+		{
+			name: "range missing step",
+			cfg: QueryConfig{
+				Name:     "activity",
+				Kind:     QueryKindRange,
+				Query:    "vector(1)",
+				Interval: time.Minute,
+				Timeout:  5 * time.Second,
+				Range:    time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "range exceeds max points",
+			cfg: QueryConfig{
+				Name:      "activity",
+				Kind:      QueryKindRange,
+				Query:     "vector(1)",
+				Interval:  time.Minute,
+				Timeout:   5 * time.Second,
+				Range:     time.Hour,
+				Step:      time.Minute,
+				MaxPoints: 30,
+			},
+			wantErr: true,
+		},
+		{
+			name: "range settings for single",
+			cfg: QueryConfig{
+				Name:     "healthy",
+				Kind:     QueryKindSingleValue,
+				Query:    "vector(1)",
+				Interval: 30 * time.Second,
+				Timeout:  2 * time.Second,
+				Step:     time.Minute,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -261,10 +336,12 @@ type testQuerier struct {
 	calls  int
 }
 
-func (f *testQuerier) AddEnvironment(_, _ string) (QueryExecutor, error) {
+func (f *testQuerier) AddEnvironment(_, _, _ string) (QueryExecutor, error) {
 	panic("AddEnvironment should not be called in this unit test")
 }
 
+func (f *testQuerier) RemoveEnvironment(_, _, _ string) {}
+
 func (f *testQuerier) Config() QueryConfig {
 	return f.cfg
 }
@@ -0,0 +1,190 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// Point is a single point of a series.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// SeriesExecutor retrieves the series of an environment. Like the QueryExecutor, it
+// does not execute queries more frequently than the configured interval.
+type SeriesExecutor interface {
+	// Series returns the points of the series, ordered by time.
+	Series(ctx context.Context) ([]Point, error)
+	// LastUpdate returns the time of the last successful query
+	LastUpdate() time.Time
+}
+
+type RangeQuery struct {
+	Prometheus *Prometheus
+	QueryTpl   *template.Template
+	cfg        QueryConfig
+}
+
+// NewRangeQuery creates a Prometheus query that returns a single series over the configured range.
+func NewRangeQuery(ctx context.Context, prom Prometheus, cfg QueryConfig) (*RangeQuery, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if cfg.Kind != QueryKindRange {
+		return nil, fmt.Errorf("%w: %s for range query", ErrInvalidQueryKind, cfg.Kind)
+	}
+
+	t, err := parseQueryTemplate(cfg.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "creating range Prometheus query", "name", cfg.Name, "query_kind", cfg.Kind, "query", cfg.Query, "interval", cfg.Interval.String(), "timeout", cfg.Timeout.String(), "range", cfg.Range.String(), "step", cfg.Step.String())
+
+	return &RangeQuery{
+		Prometheus: &prom,
+		QueryTpl:   t,
+		cfg:        cfg,
+	}, nil
+}
+
+func (q *RangeQuery) AddEnvironment(name string, namespace string) SeriesExecutor {
+	return &environmentRangeQuery{
+		query:     q,
+		envName:   name,
+		namespace: namespace,
+		jitter:    q.Prometheus.cacheJitter(q.cfg.Interval),
+	}
+}
+
+func (q *RangeQuery) Config() QueryConfig {
+	return q.cfg
+}
+
+func (q *RangeQuery) queryForEnvironment(ctx context.Context, name string, namespace string) ([]Point, error) {
+	start := time.Now()
+	queryStatus := "failed"
+	defer func() {
+		promQueryDuration.WithLabelValues(q.cfg.Name, string(q.cfg.Kind), queryStatus).Observe(time.Since(start).Seconds())
+	}()
+
+	log := slog.With("name", q.cfg.Name, "query_kind", q.cfg.Kind, "env_name", name, "env_namespace", namespace)
+	tplData := map[string]string{
+		"name":      name,
+		"namespace": namespace,
+	}
+
+	var sb strings.Builder
+	err := q.QueryTpl.Execute(&sb, tplData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query template: %w", err)
+	}
+	query := sb.String()
+
+	log = log.With("query", query)
+	log.DebugContext(ctx, "executing Prometheus range query")
+
+	release, err := q.Prometheus.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	end := time.Now()
+	res, warnings, err := q.Prometheus.queryRange(
+		ctx, query,
+		v1.Range{Start: end.Add(-q.cfg.Range), End: end, Step: q.cfg.Step},
+		v1.WithTimeout(q.cfg.Timeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if len(warnings) > 0 {
+		log.WarnContext(ctx, "prometheus query succeeded with warnings", "warnings", warnings)
+	}
+
+	series, ok := res.(model.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %T: %w", res, ErrResultNotParsable)
+	}
+	if len(series) == 0 {
+		log.DebugContext(ctx, "prometheus query returned no results")
+		return nil, ErrResultNotFound
+	}
+	if len(series) > 1 {
+		log.ErrorContext(ctx, "prometheus query returned too many series", "num_results", len(series))
+		return nil, ErrTooManyResults
+	}
+
+	values := series[0].Values
+	if maxPoints := q.cfg.maxPoints(); len(values) > maxPoints {
+		// Keep the most recent points
+		values = values[len(values)-maxPoints:]
+	}
+
+	points := make([]Point, 0, len(values))
+	for _, v := range values {
+		points = append(points, Point{Time: v.Timestamp.Time(), Value: float64(v.Value)})
+	}
+
+	log.DebugContext(ctx, "prometheus query returned a series", "num_points", len(points))
+
+	queryStatus = "success"
+	return points, nil
+}
+
+type environmentRangeQuery struct {
+	lastUpdate time.Time
+	query      *RangeQuery
+	envName    string
+	namespace  string
+	lastStored []Point
+	jitter     time.Duration
+	mu         sync.RWMutex
+}
+
+var _ SeriesExecutor = (*environmentRangeQuery)(nil)
+
+func (q *environmentRangeQuery) Series(ctx context.Context) ([]Point, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cfg := q.query.Config()
+
+	// If the last query was recent enough, return the cached series
+	if time.Since(q.lastUpdate) < cfg.Interval+q.jitter {
+		promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "hit").Inc()
+
+		return q.lastStored, nil
+	}
+
+	promQueryCache.WithLabelValues(cfg.Name, string(cfg.Kind), "miss").Inc()
+
+	points, err := q.query.queryForEnvironment(ctx, q.envName, q.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus for series: %w", err)
+	}
+
+	q.lastStored = points
+	q.lastUpdate = time.Now()
+
+	return points, nil
+}
+
+func (q *environmentRangeQuery) LastUpdate() time.Time {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.lastUpdate
+}
@@ -0,0 +1,121 @@
+package prometheus
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRangeQuerySeries(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/api/v1/query_range" {
+			t.Fatalf("path = %q, want %q", r.URL.Path, "/api/v1/query_range")
+		}
+		if q := requestQueryValue(r, "query"); q != `sum(rate(requests{namespace="env-ns"}[1m]))` {
+			t.Fatalf("query = %q", q)
+		}
+		if step := requestQueryValue(r, "step"); step != "60" {
+			t.Fatalf("step = %q, want 60", step)
+		}
+
+		writePromResponse(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1700000000,"1"],[1700000060,"2"],[1700000120,"3"],[1700000180,"4"]]}]}}`)
+	})
+	defer closeFn()
+
+	q, err := NewRangeQuery(t.Context(), prom, QueryConfig{
+		Name:      "activity",
+		Kind:      QueryKindRange,
+		Query:     `sum(rate(requests{namespace="{{.namespace}}"}[1m]))`,
+		Interval:  time.Minute,
+		Timeout:   5 * time.Second,
+		Range:     2 * time.Minute,
+		Step:      time.Minute,
+		MaxPoints: 3,
+	})
+	if err != nil {
+		t.Fatalf("NewRangeQuery() error = %v", err)
+	}
+
+	series := q.AddEnvironment("env-a", "env-ns")
+	for range 2 {
+		points, err := series.Series(t.Context())
+		if err != nil {
+			t.Fatalf("Series() error = %v", err)
+		}
+
+		// Only the most recent points are kept
+		if len(points) != 3 {
+			t.Fatalf("len(points) = %d, want 3", len(points))
+		}
+		if points[0].Value != 2 || !points[0].Time.Equal(time.Unix(1700000060, 0)) || points[2].Value != 4 {
+			t.Fatalf("points = %#v, want the last three points", points)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1 (second call cached)", calls)
+	}
+	if series.LastUpdate().IsZero() {
+		t.Fatal("LastUpdate() is zero after successful query")
+	}
+}
+
+func TestRangeQuerySeriesErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		wantErr  error
+		name     string
+		response string
+	}{
+		{
+			name:     "no series",
+			response: `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr:  ErrResultNotFound,
+		},
+		{
+			name:     "too many series",
+			response: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"a":"1"},"values":[[1700000000,"1"]]},{"metric":{"a":"2"},"values":[[1700000000,"1"]]}]}}`,
+			wantErr:  ErrTooManyResults,
+		},
+		{
+			name:     "unexpected result type",
+			response: `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr:  ErrResultNotParsable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, _ *http.Request) {
+				writePromResponse(w, tt.response)
+			})
+			defer closeFn()
+
+			q, err := NewRangeQuery(t.Context(), prom, QueryConfig{
+				Name:     "activity",
+				Kind:     QueryKindRange,
+				Query:    "vector(1)",
+				Interval: time.Minute,
+				Timeout:  5 * time.Second,
+				Range:    time.Hour,
+				Step:     time.Minute,
+			})
+			if err != nil {
+				t.Fatalf("NewRangeQuery() error = %v", err)
+			}
+
+			_, err = q.AddEnvironment("env-a", "env-ns").Series(t.Context())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Series() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultDatasource is the name of the datasource used by queries without a datasource.
+const DefaultDatasource = "default"
+
+var ErrUnknownDatasource = errors.New("unknown datasource")
+
+var (
+	promFailover = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ephemeralenv_prometheus_failover_total",
+		Help: "Total number of queries that failed over to another datasource",
+	}, []string{"datasource", "failover"})
+)
+
+// Registry holds the clients of all configured datasources.
+type Registry struct {
+	datasources map[string]*Prometheus
+	endpoints   map[string]*endpoint
+}
+
+// NewRegistry creates the clients of the datasources. The connection to each datasource is
+// checked on startup, but unreachable datasources do not cause an error: queries skip them
+// until they are reconnected in the background. Reconnecting stops when ctx is done.
+func NewRegistry(ctx context.Context, cfgs map[string]Config) (*Registry, error) {
+	r := &Registry{
+		datasources: make(map[string]*Prometheus, len(cfgs)),
+		endpoints:   make(map[string]*endpoint, len(cfgs)),
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[name]
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("datasource %q: %w", name, err)
+		}
+
+		api, err := prometheusAPI(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("datasource %q: failed to create Prometheus API client: %w", name, err)
+		}
+		r.endpoints[name] = newEndpoint(name, api)
+	}
+
+	for name, cfg := range cfgs {
+		endpoints := []*endpoint{r.endpoints[name]}
+		for _, failover := range cfg.Failover {
+			e, exists := r.endpoints[failover]
+			if !exists || failover == name {
+				return nil, fmt.Errorf("datasource %q: %w: failover %q", name, ErrUnknownDatasource, failover)
+			}
+			endpoints = append(endpoints, e)
+		}
+		r.datasources[name] = newPrometheus(name, endpoints, cfg)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.endpoints)) {
+		e := r.endpoints[name]
+		if err := e.connect(ctx); err != nil {
+			slog.WarnContext(ctx, "datasource is not reachable, queries are unavailable until it is reconnected", "datasource", name, "error", err)
+			e.disconnect()
+		}
+		go e.run(ctx)
+	}
+
+	return r, nil
+}
+
+// Get returns the datasource with the given name, or the default datasource if name is empty.
+// It returns nil if the datasource is not configured.
+func (r *Registry) Get(name string) *Prometheus {
+	if name == "" {
+		name = DefaultDatasource
+	}
+	return r.datasources[name]
+}
+
+// UseSnapshot seeds the environment queries of all datasources from snapshot and adds them
+// to it. It must be called before queries are created.
+func (r *Registry) UseSnapshot(snapshot *Snapshot) {
+	for _, p := range r.datasources {
+		p.snapshot = snapshot
+	}
+}
+
+// Health returns whether each datasource is connected.
+func (r *Registry) Health() map[string]bool {
+	health := make(map[string]bool, len(r.endpoints))
+	for name, e := range r.endpoints {
+		health[name] = e.connected.Load()
+	}
+	return health
+}
+
+// query executes an instant query, failing over to the next datasource if a datasource fails.
+func (p *Prometheus) query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	return withFailover(ctx, p, func(api v1.API) (model.Value, v1.Warnings, error) {
+		return api.Query(ctx, query, ts, opts...) //nolint:wrapcheck // Wrapped by the caller
+	})
+}
+
+// queryRange executes a range query, failing over to the next datasource if a datasource fails.
+func (p *Prometheus) queryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	return withFailover(ctx, p, func(api v1.API) (model.Value, v1.Warnings, error) {
+		return api.QueryRange(ctx, query, r, opts...) //nolint:wrapcheck // Wrapped by the caller
+	})
+}
+
+// withFailover runs the query on the first connected endpoint, failing over to the next one
+// if it fails. Endpoints that fail with a connection error are disconnected.
+func withFailover(ctx context.Context, p *Prometheus, run func(api v1.API) (model.Value, v1.Warnings, error)) (model.Value, v1.Warnings, error) {
+	var err error
+	var last *endpoint
+	for _, e := range p.endpoints {
+		if !e.connected.Load() {
+			continue
+		}
+		if last != nil {
+			slog.WarnContext(ctx, "query failed, trying failover datasource", "datasource", last.name, "failover", e.name, "error", err)
+			promFailover.WithLabelValues(p.name, e.name).Inc()
+		}
+
+		var res model.Value
+		var warnings v1.Warnings
+		res, warnings, err = run(e.api)
+		if err == nil {
+			return res, warnings, nil
+		}
+		if isConnectionError(ctx, err) {
+			slog.WarnContext(ctx, "lost connection to datasource", "datasource", e.name, "error", err)
+			e.disconnect()
+		}
+		if !shouldFailover(ctx, err) {
+			return nil, warnings, err
+		}
+		last = e
+	}
+
+	if last == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnavailable, p.name)
+	}
+	return nil, nil, err
+}
+
+// shouldFailover reports whether a failed query may succeed on another datasource.
+// Invalid queries fail on all datasources.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Type != v1.ErrBadData
+	}
+	return true
+}
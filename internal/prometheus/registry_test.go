@@ -0,0 +1,224 @@
+package prometheus
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const buildinfoResponse = `{"status":"success","data":{"version":"3.0.0"}}`
+
+func newTestDatasource(t *testing.T, handler func(http.ResponseWriter, *http.Request)) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/status/buildinfo" {
+			writePromResponse(w, buildinfoResponse)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRegistryFailover(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		primary       string
+		primaryStatus int
+		wantValue     float64
+		wantFailovers int32
+		wantErr       bool
+	}{
+		{
+			name:          "primary succeeds",
+			primaryStatus: http.StatusOK,
+			primary:       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`,
+			wantValue:     1,
+		},
+		{
+			name:          "server error fails over",
+			primaryStatus: http.StatusServiceUnavailable,
+			primary:       `{"status":"error","errorType":"unavailable","error":"overloaded"}`,
+			wantValue:     2,
+			wantFailovers: 1,
+		},
+		{
+			name:          "bad data does not fail over",
+			primaryStatus: http.StatusBadRequest,
+			primary:       `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			primary := newTestDatasource(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.primaryStatus)
+				_, _ = w.Write([]byte(tt.primary))
+			})
+
+			var failovers atomic.Int32
+			secondary := newTestDatasource(t, func(w http.ResponseWriter, _ *http.Request) {
+				failovers.Add(1)
+				writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"2"]}]}}`)
+			})
+
+			registry, err := NewRegistry(t.Context(), map[string]Config{
+				"primary":   {Address: primary.URL, Failover: []string{"secondary"}},
+				"secondary": {Address: secondary.URL},
+			})
+			if err != nil {
+				t.Fatalf("NewRegistry() error = %v", err)
+			}
+
+			prom := registry.Get("primary")
+			if prom == nil {
+				t.Fatal("Get(primary) = nil")
+			}
+
+			q, err := NewSingleValueQuery(t.Context(), *prom, QueryConfig{
+				Name:     "healthy",
+				Kind:     QueryKindSingleValue,
+				Query:    `up{namespace="{{.namespace}}"}`,
+				Interval: time.Minute,
+				Timeout:  2 * time.Second,
+			})
+			if err != nil {
+				t.Fatalf("NewSingleValueQuery() error = %v", err)
+			}
+
+			sample, err := q.queryForEnvironment(t.Context(), "a", "env-a")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("queryForEnvironment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && float64(sample.Value) != tt.wantValue {
+				t.Errorf("value = %v, want %v", sample.Value, tt.wantValue)
+			}
+			if got := failovers.Load(); got != tt.wantFailovers {
+				t.Errorf("failover queries = %d, want %d", got, tt.wantFailovers)
+			}
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	t.Parallel()
+
+	reachable := newTestDatasource(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	tests := []struct {
+		cfgs       map[string]Config
+		wantHealth map[string]bool
+		wantErr    error
+		name       string
+	}{
+		{
+			name: "unknown failover",
+			cfgs: map[string]Config{
+				"default": {Address: reachable.URL, Failover: []string{"missing"}},
+			},
+			wantErr: ErrUnknownDatasource,
+		},
+		{
+			name: "self failover",
+			cfgs: map[string]Config{
+				"default": {Address: reachable.URL, Failover: []string{"default"}},
+			},
+			wantErr: ErrUnknownDatasource,
+		},
+		{
+			name: "unreachable",
+			cfgs: map[string]Config{
+				"default": {Address: unreachable.URL},
+			},
+			wantHealth: map[string]bool{"default": false},
+		},
+		{
+			name: "unreachable with reachable failover",
+			cfgs: map[string]Config{
+				"default":   {Address: unreachable.URL, Failover: []string{"secondary"}},
+				"secondary": {Address: reachable.URL},
+			},
+			wantHealth: map[string]bool{"default": false, "secondary": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			registry, err := NewRegistry(t.Context(), tt.cfgs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewRegistry() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if registry.Get("") == nil {
+				t.Error("Get(\"\") = nil, want default datasource")
+			}
+			if got := registry.Health(); !maps.Equal(got, tt.wantHealth) {
+				t.Errorf("Health() = %v, want %v", got, tt.wantHealth)
+			}
+		})
+	}
+}
+
+func TestRegistryDisconnectedDatasource(t *testing.T) {
+	t.Parallel()
+
+	var down atomic.Bool
+	ds := newTestDatasource(t, func(w http.ResponseWriter, _ *http.Request) {
+		if down.Load() {
+			// Simulate a connection failure
+			panic(http.ErrAbortHandler)
+		}
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`)
+	})
+
+	registry, err := NewRegistry(t.Context(), map[string]Config{"default": {Address: ds.URL}})
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	prom := registry.Get("")
+
+	if _, _, err := prom.query(t.Context(), "up", time.Now()); err != nil {
+		t.Fatalf("query() error = %v", err)
+	}
+
+	down.Store(true)
+	if _, _, err := prom.query(t.Context(), "up", time.Now()); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("query() error = %v, want connection error", err)
+	}
+	if registry.Health()["default"] {
+		t.Fatal("datasource is connected after connection error")
+	}
+
+	// Queries fail fast while the datasource is disconnected
+	down.Store(false)
+	if _, _, err := prom.query(t.Context(), "up", time.Now()); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("query() error = %v, want %v", err, ErrUnavailable)
+	}
+
+	if err := registry.endpoints["default"].connect(t.Context()); err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	if _, _, err := prom.query(t.Context(), "up", time.Now()); err != nil {
+		t.Fatalf("query() after reconnect error = %v", err)
+	}
+}
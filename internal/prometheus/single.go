@@ -31,9 +31,9 @@ func NewSingleValueQuery(ctx context.Context, prom Prometheus, cfg QueryConfig)
 		return nil, fmt.Errorf("%w: %s for single value query", ErrInvalidQueryKind, cfg.Kind)
 	}
 
-	t, err := template.New("query").Option("missingkey=error").Parse(cfg.Query)
+	t, err := parseQueryTemplate(cfg.Query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse query template: %w", err)
+		return nil, err
 	}
 
 	slog.DebugContext(ctx, "creating single value Prometheus query", "name", cfg.Name, "query_kind", cfg.Kind, "query", cfg.Query, "interval", cfg.Interval.String(), "timeout", cfg.Timeout.String())
@@ -45,12 +45,12 @@ func NewSingleValueQuery(ctx context.Context, prom Prometheus, cfg QueryConfig)
 	}, nil
 }
 
-func (q *SingleValueQuery) AddEnvironment(name string, namespace string) (QueryExecutor, error) {
-	return &environmentQuery{
-		query:     q,
-		envName:   name,
-		namespace: namespace,
-	}, nil
+func (q *SingleValueQuery) AddEnvironment(name string, namespace string, cluster string) (QueryExecutor, error) {
+	return q.Prometheus.newEnvironmentQuery(q, name, namespace, cluster), nil
+}
+
+func (q *SingleValueQuery) RemoveEnvironment(name string, namespace string, cluster string) {
+	q.Prometheus.removeEnvironmentQuery(q, name, namespace, cluster)
 }
 
 func (q *SingleValueQuery) Config() QueryConfig {
@@ -80,7 +80,13 @@ func (q *SingleValueQuery) queryForEnvironment(ctx context.Context, name string,
 	log = log.With("query", query)
 	log.DebugContext(ctx, "executing Prometheus query")
 
-	res, warnings, err := q.Prometheus.apiClient.Query(
+	release, err := q.Prometheus.acquire(ctx)
+	if err != nil {
+		return model.ZeroSample, err
+	}
+	defer release()
+
+	res, warnings, err := q.Prometheus.query(
 		ctx, query, time.Now(),
 		v1.WithTimeout(q.cfg.Timeout),
 		// Limit the results to 2 to detect if there are too many results (we expect 0 or 1)
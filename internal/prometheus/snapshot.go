@@ -0,0 +1,161 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sberz/ephemeral-envs/internal/state"
+)
+
+// snapshotPrefix is the prefix of the state keys of snapshot samples.
+const snapshotPrefix = "snapshot"
+
+const defaultSnapshotInterval = time.Minute
+
+// SnapshotConfig configures the snapshot of the last known query results.
+type SnapshotConfig struct {
+	// Interval is the time between snapshots. Defaults to 1m.
+	Interval time.Duration `yaml:"interval"`
+	// Enabled enables the snapshot. It requires a persistent state backend.
+	Enabled bool `yaml:"enabled"`
+}
+
+// ApplyDefaults sets the default values of unset fields.
+func (c *SnapshotConfig) ApplyDefaults() {
+	if c.Interval == 0 {
+		c.Interval = defaultSnapshotInterval
+	}
+}
+
+func (c *SnapshotConfig) Validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be greater than 0: %w", errInvalidVal)
+	}
+	return nil
+}
+
+// Snapshot periodically stores the last known samples of environment queries in a state
+// backend. On startup, the queries are seeded with the stored samples, so values are
+// available before Prometheus answers. Seeded samples are stale until the query succeeds.
+type Snapshot struct {
+	backend state.Backend
+	// seeds holds the stored samples not yet used to seed a query.
+	seeds   map[string]snapshotSample
+	queries map[string]*environmentQuery
+	// saved holds the update time of the last stored sample of each query.
+	saved map[string]time.Time
+	mu    sync.Mutex
+}
+
+type snapshotSample struct {
+	updated time.Time
+	sample  model.Sample
+}
+
+// NewSnapshot loads the stored samples from backend.
+func NewSnapshot(ctx context.Context, backend state.Backend) (*Snapshot, error) {
+	records, err := backend.List(ctx, snapshotPrefix+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	seeds := make(map[string]snapshotSample, len(records))
+	for key, rec := range records {
+		var sample model.Sample
+		if err := json.Unmarshal([]byte(rec.Value), &sample); err != nil {
+			slog.WarnContext(ctx, "ignoring invalid snapshot sample", "key", key, "error", err)
+			continue
+		}
+		seeds[key] = snapshotSample{sample: sample, updated: rec.UpdatedAt}
+	}
+
+	slog.DebugContext(ctx, "loaded query snapshot", "samples", len(seeds))
+	return &Snapshot{
+		backend: backend,
+		seeds:   seeds,
+		queries: make(map[string]*environmentQuery),
+		saved:   make(map[string]time.Time),
+	}, nil
+}
+
+// Run stores the samples of all queries every interval until ctx is done. With multiple
+// replicas, only the leader runs it, so replicas do not overwrite each other's samples.
+func (s *Snapshot) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(ctx); err != nil {
+				slog.WarnContext(ctx, "failed to save query snapshot", "error", err)
+			}
+		}
+	}
+}
+
+// Save stores the samples of all queries that changed since the last save. Stale samples
+// are not stored again.
+func (s *Snapshot) Save(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, q := range s.queries {
+		sample, updated, ok := q.snapshot()
+		if !ok || s.saved[key].Equal(updated) {
+			continue
+		}
+
+		data, err := json.Marshal(sample)
+		if err != nil {
+			return fmt.Errorf("failed to encode sample %q: %w", key, err)
+		}
+		if err := s.backend.Set(ctx, key, state.Record{UpdatedAt: updated, Value: string(data)}); err != nil {
+			return fmt.Errorf("failed to store sample %q: %w", key, err)
+		}
+		s.saved[key] = updated
+	}
+	return nil
+}
+
+// track adds q to the snapshot and seeds it with its stored sample.
+func (s *Snapshot) track(q *environmentQuery) {
+	key := snapshotKey(q.query.Config(), q.envName, q.namespace, q.cluster)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries[key] = q
+
+	if seed, ok := s.seeds[key]; ok {
+		delete(s.seeds, key)
+		q.seed(seed.sample, seed.updated)
+	}
+}
+
+// untrack removes the query of key from the snapshot. Its stored sample is no longer
+// updated and expires with the retention of the backend.
+func (s *Snapshot) untrack(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.queries, key)
+	delete(s.saved, key)
+}
+
+// snapshotKey identifies the sample of an environment of a cluster. It contains a hash of the
+// query, so samples of changed queries are not restored.
+func snapshotKey(cfg QueryConfig, name string, namespace string, cluster string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(cfg.Query + "\x00" + cfg.ExtractLabel))
+	return state.Key(snapshotPrefix, cfg.Name, strconv.FormatUint(uint64(h.Sum32()), 16), cluster, namespace, name)
+}
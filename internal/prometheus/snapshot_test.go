@@ -0,0 +1,204 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sberz/ephemeral-envs/internal/state"
+)
+
+func TestSnapshotSeedsQueries(t *testing.T) {
+	t.Parallel()
+
+	snapshotAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tests := []struct {
+		wantUpdated time.Time
+		wantErr     error
+		handler     func(w http.ResponseWriter, r *http.Request)
+		name        string
+		wantText    string
+	}{
+		{
+			name: "serves stale value while Prometheus fails",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			wantText:    "team-a",
+			wantUpdated: snapshotAt,
+		},
+		{
+			name: "refreshes stale value",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"owner":"team-b"},"value":[1700000000,"1"]}]}}`)
+			},
+			wantText:    "team-b",
+			wantUpdated: time.Unix(1700000000, 0),
+		},
+		{
+			name: "drops stale value without result",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+			},
+			wantErr: ErrResultNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			prom, closeFn := newTestPrometheus(t, tt.handler)
+			defer closeFn()
+
+			cfg := QueryConfig{Name: "owner", Kind: QueryKindSingleValue, Query: `owner{namespace="{{.namespace}}"}`, ExtractLabel: "owner", Interval: 30 * time.Second, Timeout: 2 * time.Second}
+			backend := state.NewMemory()
+			stored, err := json.Marshal(model.Sample{Metric: model.Metric{"owner": "team-a"}, Value: 1})
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if err := backend.Set(t.Context(), snapshotKey(cfg, "env", "ns", ""), state.Record{UpdatedAt: snapshotAt, Value: string(stored)}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			snapshot, err := NewSnapshot(t.Context(), backend)
+			if err != nil {
+				t.Fatalf("NewSnapshot() error = %v", err)
+			}
+			prom.snapshot = snapshot
+
+			q, err := NewSingleValueQuery(t.Context(), prom, cfg)
+			if err != nil {
+				t.Fatalf("NewSingleValueQuery() error = %v", err)
+			}
+			env, err := q.AddEnvironment("env", "ns", "")
+			if err != nil {
+				t.Fatalf("AddEnvironment() error = %v", err)
+			}
+
+			text, err := env.Text(t.Context())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Text() error = %v, want %v", err, tt.wantErr)
+			}
+			if text != tt.wantText {
+				t.Fatalf("Text() = %q, want %q", text, tt.wantText)
+			}
+			if updated := env.LastUpdate(); !updated.Equal(tt.wantUpdated) {
+				t.Fatalf("LastUpdate() = %v, want %v", updated, tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestSnapshotSave(t *testing.T) {
+	t.Parallel()
+
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, _ *http.Request) {
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"3"]}]}}`)
+	})
+	defer closeFn()
+
+	backend := state.NewMemory()
+	snapshot, err := NewSnapshot(t.Context(), backend)
+	if err != nil {
+		t.Fatalf("NewSnapshot() error = %v", err)
+	}
+	prom.snapshot = snapshot
+
+	cfg := QueryConfig{Name: "replicas", Kind: QueryKindSingleValue, Query: `vector(3)`, Interval: 30 * time.Second, Timeout: 2 * time.Second}
+	q, err := NewSingleValueQuery(t.Context(), prom, cfg)
+	if err != nil {
+		t.Fatalf("NewSingleValueQuery() error = %v", err)
+	}
+	env, err := q.AddEnvironment("env", "ns", "")
+	if err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	// Queries without a result are not stored
+	if err := snapshot.Save(t.Context()); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if records, _ := backend.List(t.Context(), snapshotPrefix); len(records) != 0 {
+		t.Fatalf("stored records = %v, want none", records)
+	}
+
+	if _, err := env.Value(t.Context()); err != nil {
+		t.Fatalf("Value() error = %v", err)
+	}
+	if err := snapshot.Save(t.Context()); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	rec, ok, err := backend.Get(t.Context(), snapshotKey(cfg, "env", "ns", ""))
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %t, %v, want record", rec, ok, err)
+	}
+	if !rec.UpdatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("stored update time = %v, want %v", rec.UpdatedAt, time.Unix(1700000000, 0))
+	}
+}
+
+// countingBackend counts the records stored in the wrapped backend.
+type countingBackend struct {
+	*state.Memory
+	sets int
+}
+
+func (b *countingBackend) Set(ctx context.Context, key string, rec state.Record) error {
+	b.sets++
+	return b.Memory.Set(ctx, key, rec) //nolint:wrapcheck // the error of the wrapped backend is returned unchanged
+}
+
+func TestSnapshotSaveChangedAndUntrack(t *testing.T) {
+	t.Parallel()
+
+	prom, closeFn := newTestPrometheus(t, func(w http.ResponseWriter, _ *http.Request) {
+		writePromResponse(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"3"]}]}}`)
+	})
+	defer closeFn()
+
+	backend := &countingBackend{Memory: state.NewMemory()}
+	snapshot, err := NewSnapshot(t.Context(), backend)
+	if err != nil {
+		t.Fatalf("NewSnapshot() error = %v", err)
+	}
+	prom.snapshot = snapshot
+
+	cfg := QueryConfig{Name: "replicas", Kind: QueryKindSingleValue, Query: `vector(3)`, Interval: 30 * time.Second, Timeout: 2 * time.Second}
+	q, err := NewSingleValueQuery(t.Context(), prom, cfg)
+	if err != nil {
+		t.Fatalf("NewSingleValueQuery() error = %v", err)
+	}
+	// The same environment is discovered in two clusters
+	for _, cluster := range []string{"east", "west"} {
+		env, err := q.AddEnvironment("env", "ns", cluster)
+		if err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", cluster, err)
+		}
+		if _, err := env.Value(t.Context()); err != nil {
+			t.Fatalf("Value(%s) error = %v", cluster, err)
+		}
+	}
+
+	// Unchanged samples are not stored again
+	for range 2 {
+		if err := snapshot.Save(t.Context()); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	if backend.sets != 2 {
+		t.Fatalf("stored records = %d, want 2", backend.sets)
+	}
+
+	q.RemoveEnvironment("env", "ns", "east")
+	if _, ok := snapshot.queries[snapshotKey(cfg, "env", "ns", "west")]; !ok || len(snapshot.queries) != 1 {
+		t.Fatalf("tracked queries = %v, want only the query of cluster west", slices.Collect(maps.Keys(snapshot.queries)))
+	}
+}
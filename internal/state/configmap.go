@@ -0,0 +1,246 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// configMapKey is the key of the ConfigMap data holding the records.
+	configMapKey = "state.json"
+	// maxConfigMapSize limits the encoded records. ConfigMaps are limited to 1 MiB,
+	// including their metadata.
+	maxConfigMapSize = 1000 << 10
+)
+
+var stateSyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ephemeralenv_state_syncs_total",
+	Help: "Total number of state synchronizations with the ConfigMap",
+}, []string{"status"})
+
+// ConfigMap is a backend that shares the state between replicas in a ConfigMap. Records
+// are kept in memory and synchronized with the ConfigMap periodically, so reads and
+// writes do not cause API requests. Concurrent updates of replicas are merged, the newest
+// record of each key wins.
+type ConfigMap struct {
+	client kubernetes.Interface
+	// records holds the local state, including records not yet written to the ConfigMap.
+	records map[string]Record
+	// dirty holds the keys set since the last synchronization.
+	dirty map[string]bool
+	// deleted holds the keys deleted since the last synchronization.
+	deleted   map[string]bool
+	name      string
+	namespace string
+	// retention is the time after which records are removed. Zero keeps records forever.
+	retention time.Duration
+	// maxSize is the maximum size of the encoded records. The oldest records are not
+	// written if it is exceeded.
+	maxSize int
+	mu      sync.Mutex
+}
+
+var _ Backend = (*ConfigMap)(nil)
+
+// NewConfigMap creates a backend that stores the state in the ConfigMap name in namespace.
+// The ConfigMap is created on the first synchronization if it does not exist.
+func NewConfigMap(client kubernetes.Interface, namespace string, name string, retention time.Duration) *ConfigMap {
+	return &ConfigMap{
+		client:    client,
+		records:   make(map[string]Record),
+		dirty:     make(map[string]bool),
+		deleted:   make(map[string]bool),
+		name:      name,
+		namespace: namespace,
+		retention: retention,
+		maxSize:   maxConfigMapSize,
+	}
+}
+
+func (c *ConfigMap) Get(_ context.Context, key string) (Record, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rec, ok := c.records[key]
+	return rec, ok, nil
+}
+
+func (c *ConfigMap) Set(_ context.Context, key string, rec Record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if merge(c.records, key, rec) {
+		c.dirty[key] = true
+	}
+	return nil
+}
+
+func (c *ConfigMap) List(_ context.Context, prefix string) (map[string]Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return filterPrefix(c.records, prefix), nil
+}
+
+func (c *ConfigMap) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.records, key)
+	delete(c.dirty, key)
+	c.deleted[key] = true
+	return nil
+}
+
+// Run synchronizes the state with the ConfigMap every interval until ctx is done.
+func (c *ConfigMap) Run(ctx context.Context, interval time.Duration) {
+	runSync(ctx, c.name, c.Sync, interval)
+}
+
+// Sync merges the local state with the ConfigMap. Records of other replicas are loaded
+// and local records set or deleted since the last synchronization are written. If the ConfigMap was
+// updated concurrently, the write fails and is retried on the next synchronization.
+func (c *ConfigMap) Sync(ctx context.Context) error {
+	status := "failed"
+	defer func() {
+		stateSyncs.WithLabelValues(status).Inc()
+	}()
+
+	exists := true
+	cm, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace}}
+		exists = false
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	remote := make(map[string]Record)
+	if data := cm.Data[configMapKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &remote); err != nil {
+			slog.WarnContext(ctx, "ignoring invalid state in ConfigMap", "configmap", c.name, "error", err)
+			remote = make(map[string]Record)
+		}
+	}
+
+	c.mu.Lock()
+	changed := false
+	for key := range c.deleted {
+		if _, ok := remote[key]; ok {
+			delete(remote, key)
+			changed = true
+		}
+	}
+	for key, rec := range remote {
+		merge(c.records, key, rec)
+	}
+	expire(c.records, c.retention)
+
+	for key := range c.dirty {
+		if rec, ok := c.records[key]; ok && merge(remote, key, rec) {
+			changed = true
+		}
+	}
+	dirty, deleted := c.dirty, c.deleted
+	c.dirty, c.deleted = make(map[string]bool), make(map[string]bool)
+	c.mu.Unlock()
+
+	if !changed {
+		status = "success"
+		return nil
+	}
+
+	if err := c.write(ctx, cm, exists, remote); err != nil {
+		// Keep the keys dirty, so they are written on the next synchronization
+		c.mu.Lock()
+		maps.Copy(c.dirty, dirty)
+		for key := range deleted {
+			if _, ok := c.records[key]; !ok {
+				c.deleted[key] = true
+			}
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	status = "success"
+	return nil
+}
+
+// write stores the records in the ConfigMap. The update fails if the ConfigMap was changed
+// since it was read.
+func (c *ConfigMap) write(ctx context.Context, cm *corev1.ConfigMap, exists bool, records map[string]Record) error {
+	expire(records, c.retention)
+
+	evicted, err := evict(records, c.maxSize)
+	if err != nil {
+		return err
+	}
+	if evicted > 0 {
+		slog.WarnContext(ctx, "state exceeds the size of the ConfigMap, oldest records are not shared", "configmap", c.name, "evicted", evicted, "records", len(records))
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[configMapKey] = string(data)
+
+	if exists {
+		_, err = c.client.CoreV1().ConfigMaps(c.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	} else {
+		_, err = c.client.CoreV1().ConfigMaps(c.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// evict removes the oldest records until their encoded size is at most maxSize. It
+// returns the number of removed records.
+func evict(records map[string]Record, maxSize int) (int, error) {
+	sizes := make(map[string]int, len(records))
+	total := len("{}")
+	for key, rec := range records {
+		data, err := json.Marshal(map[string]Record{key: rec})
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode state: %w", err)
+		}
+		// The braces of the single entry are at least the size of the separating comma
+		sizes[key] = len(data) - 1
+		total += sizes[key]
+	}
+
+	evicted := 0
+	oldest := slices.SortedFunc(maps.Keys(records), func(a, b string) int {
+		return records[a].UpdatedAt.Compare(records[b].UpdatedAt)
+	})
+	for _, key := range oldest {
+		if total <= maxSize {
+			break
+		}
+		total -= sizes[key]
+		delete(records, key)
+		evicted++
+	}
+	return evicted, nil
+}
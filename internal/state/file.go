@@ -0,0 +1,79 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a backend that keeps the state in memory and writes it to a local file, so it is
+// preserved across restarts. It is not shared between replicas.
+type File struct {
+	*Memory
+	path string
+	// retention is the time after which records are removed. Zero keeps records forever.
+	retention time.Duration
+}
+
+var _ Backend = (*File)(nil)
+
+// NewFile creates a backend that stores the state in the file at path. Records are loaded
+// from the file if it exists.
+func NewFile(path string, retention time.Duration) (*File, error) {
+	f := &File{Memory: NewMemory(), path: path, retention: retention}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &f.records); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	expire(f.records, retention)
+	return f, nil
+}
+
+// Run writes the state to the file every interval until ctx is done.
+func (f *File) Run(ctx context.Context, interval time.Duration) {
+	runSync(ctx, f.path, f.Sync, interval)
+}
+
+// Sync writes the state to the file. The file is replaced atomically, so a crash while
+// writing does not corrupt the state.
+func (f *File) Sync(_ context.Context) error {
+	f.mu.Lock()
+	expire(f.records, f.retention)
+	data, err := json.Marshal(f.records)
+	f.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	// The temporary file is gone after a successful rename
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
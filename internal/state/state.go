@@ -0,0 +1,218 @@
+// Package state provides backends to share state between replicas and preserve it
+// across restarts.
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidBackend = errors.New("invalid state backend config")
+
+// BackendType is the type of the state backend.
+type BackendType string
+
+const (
+	// BackendMemory keeps the state in memory. It is neither shared nor preserved.
+	BackendMemory BackendType = "memory"
+	// BackendConfigMap keeps the state in a ConfigMap shared by all replicas.
+	BackendConfigMap BackendType = "configmap"
+	// BackendFile keeps the state in a local file. It is preserved, but not shared.
+	BackendFile BackendType = "file"
+)
+
+// Record is a value stored in a backend.
+type Record struct {
+	// UpdatedAt is the time the value was determined. Newer records replace older ones.
+	UpdatedAt time.Time `json:"updatedAt"`
+	Value     string    `json:"value"`
+}
+
+// Backend stores records by key. Keys are grouped by `/` separated prefixes.
+type Backend interface {
+	// Get returns the record of the key and whether it exists.
+	Get(ctx context.Context, key string) (Record, bool, error)
+	// Set stores the record unless a newer record exists for the key.
+	Set(ctx context.Context, key string, rec Record) error
+	// List returns all records with keys starting with prefix.
+	List(ctx context.Context, prefix string) (map[string]Record, error)
+	// Delete removes the record of the key, e.g. when its environment is removed.
+	Delete(ctx context.Context, key string) error
+}
+
+// Memory is a backend that keeps the state in memory.
+type Memory struct {
+	records map[string]Record
+	mu      sync.RWMutex
+}
+
+var _ Backend = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]Record)}
+}
+
+func (m *Memory) Get(_ context.Context, key string) (Record, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.records[key]
+	return rec, ok, nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merge(m.records, key, rec)
+	return nil
+}
+
+func (m *Memory) List(_ context.Context, prefix string) (map[string]Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return filterPrefix(m.records, prefix), nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// merge stores rec unless records holds a newer record for the key. It reports whether
+// the record was stored.
+func merge(records map[string]Record, key string, rec Record) bool {
+	if old, ok := records[key]; ok && !rec.UpdatedAt.After(old.UpdatedAt) {
+		return false
+	}
+	records[key] = rec
+	return true
+}
+
+func filterPrefix(records map[string]Record, prefix string) map[string]Record {
+	res := maps.Clone(records)
+	maps.DeleteFunc(res, func(key string, _ Record) bool {
+		return !strings.HasPrefix(key, prefix)
+	})
+	return res
+}
+
+// expire removes the records older than retention. Zero keeps records forever.
+func expire(records map[string]Record, retention time.Duration) {
+	if retention == 0 {
+		return
+	}
+	maps.DeleteFunc(records, func(_ string, rec Record) bool {
+		return time.Since(rec.UpdatedAt) > retention
+	})
+}
+
+// runSync calls sync every interval until ctx is done, and once more before returning
+// so pending records are not lost on shutdown.
+func runSync(ctx context.Context, name string, sync func(ctx context.Context) error, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := sync(context.WithoutCancel(ctx)); err != nil {
+				slog.WarnContext(ctx, "failed to write state on shutdown", "backend", name, "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := sync(ctx); err != nil {
+				slog.WarnContext(ctx, "failed to synchronize state", "backend", name, "error", err)
+			}
+		}
+	}
+}
+
+// Key joins the parts of a key with `/`.
+func Key(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+const (
+	defaultConfigMapName = "ephemeral-envs-state"
+	defaultSyncInterval  = 10 * time.Second
+	defaultMaxAge        = 10 * time.Minute
+	defaultRetention     = 7 * 24 * time.Hour
+)
+
+// Config configures the state backend.
+type Config struct {
+	// ConfigMap configures the ConfigMap of the configmap backend.
+	ConfigMap ConfigMapConfig `yaml:"configMap"`
+	// File configures the file of the file backend.
+	File FileConfig `yaml:"file"`
+	// Backend is the type of the backend. Defaults to `memory`.
+	Backend BackendType `yaml:"backend"`
+	// SyncInterval is the time between synchronizations with the ConfigMap or file.
+	SyncInterval time.Duration `yaml:"syncInterval"`
+	// MaxAge is the age after which probe values of other replicas are no longer served.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// Retention is the time after which records that were not updated are removed.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// ConfigMapConfig configures the ConfigMap holding the state.
+type ConfigMapConfig struct {
+	// Name is the name of the ConfigMap. Defaults to `ephemeral-envs-state`.
+	Name string `yaml:"name"`
+	// Namespace is the namespace of the ConfigMap. Defaults to the namespace of the pod.
+	Namespace string `yaml:"namespace"`
+	// Cluster is the name of the cluster holding the ConfigMap. Defaults to the first cluster.
+	Cluster string `yaml:"cluster"`
+}
+
+// FileConfig configures the file holding the state.
+type FileConfig struct {
+	// Path is the path of the file. The directory must exist and be writable.
+	Path string `yaml:"path"`
+}
+
+// ApplyDefaults sets the default values of unset fields.
+func (c *Config) ApplyDefaults() {
+	if c.Backend == "" {
+		c.Backend = BackendMemory
+	}
+	if c.ConfigMap.Name == "" {
+		c.ConfigMap.Name = defaultConfigMapName
+	}
+	if c.SyncInterval == 0 {
+		c.SyncInterval = defaultSyncInterval
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = defaultMaxAge
+	}
+	if c.Retention == 0 {
+		c.Retention = defaultRetention
+	}
+}
+
+func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendMemory, BackendConfigMap:
+	case BackendFile:
+		if c.File.Path == "" {
+			return fmt.Errorf("%w: file.path must be set for the file backend", ErrInvalidBackend)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported backend %q", ErrInvalidBackend, c.Backend)
+	}
+	if c.SyncInterval <= 0 || c.MaxAge <= 0 || c.Retention <= 0 {
+		return fmt.Errorf("%w: syncInterval, maxAge and retention must be positive", ErrInvalidBackend)
+	}
+	return nil
+}
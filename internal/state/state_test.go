@@ -0,0 +1,277 @@
+package state
+
+import (
+	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		records  map[string]Record
+		key      string
+		want     string
+		wantKeys []string
+	}{
+		{
+			name:     "newer record wins",
+			records:  map[string]Record{"probe/a": {UpdatedAt: now.Add(-time.Minute), Value: "old"}, "probe/b": {UpdatedAt: now, Value: "new"}},
+			key:      "probe/a",
+			want:     "old",
+			wantKeys: []string{"probe/a", "probe/b"},
+		},
+		{
+			name:     "older record is ignored",
+			records:  map[string]Record{"ignition/ns/env": {UpdatedAt: now, Value: "new"}},
+			key:      "ignition/ns/env",
+			want:     "new",
+			wantKeys: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := NewMemory()
+			for key, rec := range tt.records {
+				if err := m.Set(t.Context(), key, rec); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			// An older record must not replace the existing one
+			if err := m.Set(t.Context(), tt.key, Record{UpdatedAt: now.Add(-time.Hour), Value: "stale"}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			rec, ok, err := m.Get(t.Context(), tt.key)
+			if err != nil || !ok {
+				t.Fatalf("Get() = %v, %t, %v, want record", rec, ok, err)
+			}
+			if rec.Value != tt.want {
+				t.Fatalf("Get() value = %q, want %q", rec.Value, tt.want)
+			}
+
+			list, err := m.List(t.Context(), "probe/")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if keys := slices.Sorted(maps.Keys(list)); !slices.Equal(keys, tt.wantKeys) {
+				t.Fatalf("List() keys = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestConfigMapSync(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	replicaA := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	replicaB := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+
+	now := time.Now().Truncate(time.Second)
+	records := []struct {
+		backend *ConfigMap
+		key     string
+		rec     Record
+	}{
+		{backend: replicaA, key: "probe/a", rec: Record{UpdatedAt: now.Add(-time.Minute), Value: "a-old"}},
+		{backend: replicaB, key: "probe/a", rec: Record{UpdatedAt: now, Value: "a-new"}},
+		{backend: replicaA, key: "probe/b", rec: Record{UpdatedAt: now, Value: "b"}},
+		{backend: replicaB, key: "probe/expired", rec: Record{UpdatedAt: now.Add(-2 * time.Hour), Value: "expired"}},
+	}
+	for _, r := range records {
+		if err := r.backend.Set(t.Context(), r.key, r.rec); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	// Synchronize both replicas twice, so each sees the records of the other
+	for range 2 {
+		for _, backend := range []*ConfigMap{replicaA, replicaB} {
+			if err := backend.Sync(t.Context()); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+		}
+	}
+
+	want := map[string]string{"probe/a": "a-new", "probe/b": "b"}
+	for name, backend := range map[string]*ConfigMap{"replica a": replicaA, "replica b": replicaB} {
+		list, err := backend.List(t.Context(), "probe/")
+		if err != nil {
+			t.Fatalf("%s: List() error = %v", name, err)
+		}
+		got := make(map[string]string, len(list))
+		for key, rec := range list {
+			got[key] = rec.Value
+		}
+		if !maps.Equal(got, want) {
+			t.Fatalf("%s: List() = %v, want %v", name, got, want)
+		}
+	}
+
+	// A restarted replica loads the state from the ConfigMap
+	restarted := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	if err := restarted.Sync(t.Context()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if rec, ok, _ := restarted.Get(t.Context(), "probe/a"); !ok || rec.Value != "a-new" {
+		t.Fatalf("Get() = %v, %t, want a-new", rec, ok)
+	}
+}
+
+func TestConfigMapDelete(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	replicaA := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	replicaB := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+
+	now := time.Now().Truncate(time.Second)
+	for _, key := range []string{"probe/a", "probe/b"} {
+		if err := replicaA.Set(t.Context(), key, Record{UpdatedAt: now, Value: key}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	for _, backend := range []*ConfigMap{replicaA, replicaB} {
+		if err := backend.Sync(t.Context()); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+
+	// Each replica deletes the records of a removed environment
+	for _, backend := range []*ConfigMap{replicaA, replicaB} {
+		if err := backend.Delete(t.Context(), "probe/a"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if err := backend.Sync(t.Context()); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+
+	restarted := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	if err := restarted.Sync(t.Context()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	list, err := restarted.List(t.Context(), "probe/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if keys := slices.Sorted(maps.Keys(list)); !slices.Equal(keys, []string{"probe/b"}) {
+		t.Fatalf("List() keys = %v, want [probe/b]", keys)
+	}
+}
+
+func TestConfigMapEvictsOldestRecords(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	backend := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	backend.maxSize = 200
+
+	now := time.Now().Truncate(time.Second)
+	for i, key := range []string{"probe/oldest", "probe/older", "probe/new"} {
+		rec := Record{UpdatedAt: now.Add(time.Duration(i) * time.Minute), Value: key}
+		if err := backend.Set(t.Context(), key, rec); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	// Exceeding the size is not an error, the oldest records are not shared
+	if err := backend.Sync(t.Context()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	restarted := NewConfigMap(client, "ephemeral-envs", "state", time.Hour)
+	if err := restarted.Sync(t.Context()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	list, err := restarted.List(t.Context(), "probe/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if keys := slices.Sorted(maps.Keys(list)); !slices.Equal(keys, []string{"probe/new", "probe/older"}) {
+		t.Fatalf("List() keys = %v, want [probe/new probe/older]", keys)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "defaults", cfg: Config{}},
+		{name: "configmap", cfg: Config{Backend: BackendConfigMap}},
+		{name: "unsupported backend", cfg: Config{Backend: "redis"}, wantErr: true},
+		{name: "negative max age", cfg: Config{MaxAge: -time.Minute}, wantErr: true},
+		{name: "file", cfg: Config{Backend: BackendFile, File: FileConfig{Path: "/var/lib/ephemeral-envs/state.json"}}},
+		{name: "file without path", cfg: Config{Backend: BackendFile}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.cfg.ApplyDefaults()
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBackend) {
+				t.Fatalf("Validate() error = %v, want %v", err, ErrInvalidBackend)
+			}
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now().Truncate(time.Second)
+
+	f, err := NewFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	for key, rec := range map[string]Record{
+		"snapshot/a": {UpdatedAt: now, Value: "a"},
+		"snapshot/b": {UpdatedAt: now.Add(-2 * time.Hour), Value: "expired"},
+	} {
+		if err := f.Set(t.Context(), key, rec); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := f.Sync(t.Context()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// A restarted replica loads the state from the file
+	restarted, err := NewFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	list, err := restarted.List(t.Context(), "snapshot/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if keys := slices.Sorted(maps.Keys(list)); !slices.Equal(keys, []string{"snapshot/a"}) {
+		t.Fatalf("List() keys = %v, want [snapshot/a]", keys)
+	}
+	if rec := list["snapshot/a"]; rec.Value != "a" || !rec.UpdatedAt.Equal(now) {
+		t.Fatalf("record = %v, want value a at %v", rec, now)
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// ResolveEnvironment retrieves an environment by its name, one of its aliases or its short ID.
+// Names take precedence over aliases and IDs.
+func (s *Store) ResolveEnvironment(_ context.Context, ref string) (Environment, error) {
+	snap := s.snap.Load()
+
+	if env, exists := snap.env[ref]; exists {
+		return env, nil
+	}
+	if name, exists := snap.aliases[ref]; exists {
+		return snap.env[name], nil
+	}
+
+	return Environment{}, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, ref)
+}
+
+// reindex returns the index of the aliases and short IDs of the served environments. Aliases
+// and IDs matching the name of an environment are ignored. If multiple environments claim
+// the same alias or ID, the environment that takes precedence keeps it. Ignored aliases and
+// IDs of the changed environment are logged.
+// It must be called with the store's mutex already held.
+func (s *Store) reindex(ctx context.Context, changed string) map[string]string {
+	envs := slices.SortedFunc(maps.Values(s.env), func(a, b Environment) int {
+		if a.precedes(b) {
+			return -1
+		}
+		return 1
+	})
+
+	aliases := make(map[string]string)
+	for _, env := range envs {
+		for _, alias := range env.references() {
+			owner, taken := aliases[alias]
+			_, isName := s.env[alias]
+			if !isName && !taken {
+				aliases[alias] = env.Name
+				continue
+			}
+
+			if env.Name == changed && owner != env.Name {
+				slog.WarnContext(ctx, "ignoring environment alias claimed by another environment",
+					"name", env.Name,
+					"alias", alias,
+					"claimed_by", cmp.Or(owner, alias),
+				)
+			}
+		}
+	}
+
+	return aliases
+}
+
+// references returns the aliases and the short ID of the environment.
+func (e *Environment) references() []string {
+	refs := slices.Clone(e.Aliases)
+	if e.ID != "" {
+		refs = append(refs, e.ID)
+	}
+	return refs
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var envConflicts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ephemeralenv_environment_conflicts",
+	Help: "Number of rejected claims of environment names claimed by multiple namespaces",
+}, []string{"name"})
+
+// ConflictPolicy decides which namespace keeps an environment name claimed by multiple namespaces.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyOldest serves the environment of the oldest namespace.
+	ConflictPolicyOldest ConflictPolicy = "oldest"
+	// ConflictPolicyNewest serves the environment of the newest namespace.
+	ConflictPolicyNewest ConflictPolicy = "newest"
+	// ConflictPolicyReject serves none of the environments until the conflict is resolved.
+	ConflictPolicyReject ConflictPolicy = "reject"
+)
+
+func (p ConflictPolicy) Validate() error {
+	switch p {
+	case ConflictPolicyOldest, ConflictPolicyNewest, ConflictPolicyReject:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q, must be oldest, newest or reject", ErrInvalidConflictPolicy, p)
+	}
+}
+
+// winner returns the claim that is served. The claims must be sorted by precedence.
+// It returns false if no claim is served.
+func (p ConflictPolicy) winner(claims []Environment) (Environment, bool) {
+	switch {
+	case len(claims) == 0:
+		return Environment{}, false
+	case len(claims) == 1:
+		return claims[0], true
+	}
+
+	switch p {
+	case ConflictPolicyReject:
+		return Environment{}, false
+	case ConflictPolicyNewest:
+		return claims[len(claims)-1], true
+	default:
+		return claims[0], true
+	}
+}
+
+// Conflict is an environment name claimed by multiple namespaces.
+type Conflict struct {
+	// Winner is the claim that is served. It is nil if the policy rejects all claims.
+	Winner   *Claim  `json:"winner"`
+	Name     string  `json:"name"`
+	Rejected []Claim `json:"rejected"`
+}
+
+// Claim is a namespace claiming an environment name.
+type Claim struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Namespace string    `json:"namespace"`
+	Cluster   string    `json:"cluster,omitempty"`
+}
+
+func claimOf(env Environment) Claim {
+	return Claim{CreatedAt: env.CreatedAt, Namespace: env.Namespace, Cluster: env.Cluster}
+}
+
+// ConflictFunc is called when the claim of an environment name is rejected because another
+// namespace claims the same name. winner is the served environment, it is nil if the
+// conflict policy rejects all claims.
+type ConflictFunc func(ctx context.Context, rejected Environment, winner *Environment)
+
+// OnConflict sets the function called on rejected claims. It is called with the store
+// locked, so it must not call the store. OnConflict must be called before the store is used.
+func (s *Store) OnConflict(fn ConflictFunc) {
+	s.onConflict = fn
+}
+
+// SetConflictPolicy sets the policy deciding which namespace keeps a conflicting name.
+// Defaults to ConflictPolicyOldest. It must be called before the store is used.
+func (s *Store) SetConflictPolicy(policy ConflictPolicy) {
+	s.policy = policy
+}
+
+// GetConflicts returns the environment names that are currently claimed by multiple namespaces, sorted by name.
+func (s *Store) GetConflicts(_ context.Context) []Conflict {
+	return slices.Clone(s.snap.Load().conflicts)
+}
+
+// buildConflicts returns the current conflicts, sorted by name.
+// It must be called with the store's mutex already held.
+func (s *Store) buildConflicts() []Conflict {
+	conflicts := make([]Conflict, 0, len(s.conflicts))
+	for _, name := range slices.Sorted(maps.Keys(s.conflicts)) {
+		c := Conflict{Name: name}
+		if env, ok := s.env[name]; ok {
+			winner := claimOf(env)
+			c.Winner = &winner
+		}
+		for _, env := range s.conflicts[name] {
+			c.Rejected = append(c.Rejected, claimOf(env))
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts
+}
+
+// claims returns all claims of the name, the served environment and the rejected ones.
+// It must be called with the store's mutex already held.
+func (s *Store) claims(name string) []Environment {
+	claims := slices.Clone(s.conflicts[name])
+	if env, ok := s.env[name]; ok {
+		claims = append(claims, env)
+	}
+	return claims
+}
+
+// resolve replaces the claims of the name and decides which one is served according to the
+// conflict policy. Rejected claims are kept, so they are restored once the conflict is resolved.
+// It returns the served environment, if any. It must be called with the store's mutex already held.
+func (s *Store) resolve(ctx context.Context, name string, claims []Environment) (Environment, bool) {
+	prev, wasServed := s.env[name]
+	prevRejected := s.conflicts[name]
+
+	if wasServed {
+		delete(s.env, name)
+		envInfo.DeleteLabelValues(prev.Name, prev.Namespace, prev.Cluster)
+	}
+	delete(s.conflicts, name)
+
+	slices.SortFunc(claims, func(a, b Environment) int {
+		if a.precedes(b) {
+			return -1
+		}
+		return 1
+	})
+
+	winner, served := s.policy.winner(claims)
+	if served {
+		s.env[name] = winner
+		envInfo.WithLabelValues(winner.Name, winner.Namespace, winner.Cluster).Set(1)
+
+		if slices.ContainsFunc(prevRejected, winner.sameOrigin) {
+			slog.InfoContext(ctx, "restored environment after name conflict", "name", name, "namespace", winner.Namespace, "cluster", winner.Cluster)
+		}
+	}
+
+	rejected := slices.DeleteFunc(claims, func(env Environment) bool { return served && winner.sameOrigin(env) })
+	if len(rejected) == 0 {
+		envConflicts.DeleteLabelValues(name)
+		return winner, served
+	}
+
+	s.conflicts[name] = rejected
+	envConflicts.WithLabelValues(name).Set(float64(len(rejected)))
+
+	var winnerRef *Environment
+	if served {
+		winnerRef = &winner
+	}
+	for _, env := range rejected {
+		if slices.ContainsFunc(prevRejected, env.sameOrigin) {
+			// Already reported
+			continue
+		}
+
+		slog.WarnContext(ctx, "environment name is claimed by multiple namespaces, rejecting a claim",
+			"name", name,
+			"policy", s.policy,
+			"served_namespace", winner.Namespace,
+			"served_cluster", winner.Cluster,
+			"rejected_namespace", env.Namespace,
+			"rejected_cluster", env.Cluster,
+		)
+		if s.onConflict != nil {
+			s.onConflict(ctx, env, winnerRef)
+		}
+	}
+
+	return winner, served
+}
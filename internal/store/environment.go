@@ -2,7 +2,6 @@ package store
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"time"
 
@@ -17,18 +16,35 @@ const (
 
 // Environment is a empheral environment representation.
 type Environment struct {
-	CreatedAt    time.Time                      `json:"createdAt"`
-	URL          map[string]string              `json:"url"`
-	StatusChecks map[string]probe.Probe[bool]   `json:"-"`
-	MetaProbes   map[string]probe.MetadataProbe `json:"-"`
-	Name         string                         `json:"name"`
-	Namespace    string                         `json:"namespace"`
+	CreatedAt    time.Time                    `json:"createdAt"`
+	URL          map[string]string            `json:"url"`
+	StatusChecks map[string]probe.Probe[bool] `json:"-"`
+	// EnumChecks are status checks with a fixed set of string values. They are optional.
+	EnumChecks map[string]probe.Probe[string] `json:"-"`
+	MetaProbes map[string]probe.MetadataProbe `json:"-"`
+	// Labels are the labels of the object the environment was discovered from.
+	Labels    map[string]string `json:"-"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Cluster   string            `json:"cluster,omitempty"`
+	// Source identifies the object the environment was discovered from within its namespace,
+	// e.g. `deployments.apps/web`. Objects in the same namespace may claim the same name.
+	Source string `json:"-"`
+	// Owner is the owner metadata of the environment if it is defined via annotation.
+	// Dynamic metadata is not indexed.
+	Owner string `json:"-"`
+	// ID is a stable, URL-safe short ID the environment can be retrieved by.
+	ID string `json:"id,omitempty"`
+	// Aliases are alternative names the environment can be retrieved by.
+	Aliases []string `json:"aliases,omitempty"`
 }
 
 type EnvironmentResponse struct {
-	Status        map[string]bool      `json:"status"`
-	StatusUpdated map[string]time.Time `json:"statusUpdatedAt"`
-	Meta          map[string]any       `json:"meta,omitempty"`
+	Status        map[string]CheckStatus `json:"status"`
+	StatusUpdated map[string]time.Time   `json:"statusUpdatedAt"`
+	// StatusReason explains why the value of a status check is unknown.
+	StatusReason map[string]string `json:"statusReason,omitempty"`
+	Meta         map[string]any    `json:"meta,omitempty"`
 	Environment
 }
 
@@ -85,6 +101,15 @@ func (e *Environment) IsValid() (problems map[string]string) {
 		}
 	}
 
+	for k, v := range e.EnumChecks {
+		if k == "" {
+			problems["enumCheckKey"] = invalidEmpty
+		}
+		if v == nil {
+			problems["enumCheckValue"] = invalidNil
+		}
+	}
+
 	if e.MetaProbes == nil {
 		problems["metadata"] = invalidNil
 	} else {
@@ -111,6 +136,10 @@ func (e *Environment) UpdateEnvironment(_ context.Context, env Environment) erro
 		return ErrImmutableFieldChanged
 	}
 
+	if env.Cluster != e.Cluster {
+		return ErrImmutableFieldChanged
+	}
+
 	// As the Namespace is immutable, its property CreatedAt is also immutable.
 	if !env.CreatedAt.IsZero() && !env.CreatedAt.Equal(e.CreatedAt) {
 		return ErrImmutableFieldChanged
@@ -124,27 +153,60 @@ func (e *Environment) UpdateEnvironment(_ context.Context, env Environment) erro
 		e.StatusChecks = env.StatusChecks
 	}
 
+	if env.EnumChecks != nil {
+		e.EnumChecks = env.EnumChecks
+	}
+
 	if env.MetaProbes != nil {
-		e.MetaProbes = env.MetaProbes
+		// The owner is derived from the metadata
+		e.MetaProbes, e.Owner = env.MetaProbes, env.Owner
+	}
+
+	if env.Labels != nil {
+		e.Labels = env.Labels
+	}
+
+	if env.Aliases != nil {
+		e.Aliases = env.Aliases
+	}
+
+	if env.ID != "" {
+		e.ID = env.ID
 	}
 
 	return nil
 }
 
-func (e *Environment) MatchesStatus(ctx context.Context, state map[string]bool) bool {
-	for check, filterValue := range state {
-		probe, exists := e.StatusChecks[check]
-		if !exists {
-			// Count missing checks as value false
-			if filterValue {
-				return false
-			}
-			continue
-		}
+// sameOrigin reports whether both environments were discovered from the same object in the
+// same namespace and cluster.
+func (e *Environment) sameOrigin(other Environment) bool {
+	return e.Cluster == other.Cluster && e.Namespace == other.Namespace && e.Source == other.Source
+}
+
+// precedes reports whether e takes precedence over other when both claim the same name.
+// The oldest environment wins; ties are broken by cluster, namespace and source so the
+// result does not depend on the order of events.
+func (e *Environment) precedes(other Environment) bool {
+	if !e.CreatedAt.Equal(other.CreatedAt) {
+		return e.CreatedAt.Before(other.CreatedAt)
+	}
 
-		// Ignore the error, if the check fails, the value will be false
-		val, _ := probe.Value(ctx)
-		if val != filterValue {
+	if e.Cluster != other.Cluster {
+		return e.Cluster < other.Cluster
+	}
+
+	if e.Namespace != other.Namespace {
+		return e.Namespace < other.Namespace
+	}
+
+	return e.Source < other.Source
+}
+
+// MatchesStatus reports whether all status checks of the environment have the state
+// required by the filter. Missing checks and failing probes are unknown.
+func (e *Environment) MatchesStatus(ctx context.Context, filter map[string]CheckStatus) bool {
+	for check, want := range filter {
+		if got, _ := e.checkStatus(ctx, check); got != want {
 			return false
 		}
 	}
@@ -152,53 +214,57 @@ func (e *Environment) MatchesStatus(ctx context.Context, state map[string]bool)
 	return true
 }
 
-// ResolveProbes resolves the probes for the environment using the provided prober.
-// The statusChecks slice contains the names of the probes to resolve. If nil, all
-// probes in the environment will be resolved. Empty slice means no probes will be resolved.
-func (e *Environment) ResolveProbes(ctx context.Context, includeMeta bool, status map[string]bool) (EnvironmentResponse, error) {
+// ResolveProbes resolves the probes for the environment. The status filter contains the
+// names of the status and enum checks to resolve, only entries set to StatusTrue are included.
+// If nil, all status checks of the environment are resolved. Checks whose value cannot
+// be determined are reported as unknown with a reason, failing metadata probes are omitted.
+func (e *Environment) ResolveProbes(ctx context.Context, includeMeta bool, status map[string]CheckStatus) EnvironmentResponse {
 	res := EnvironmentResponse{
 		Environment:   *e,
-		Status:        make(map[string]bool),
+		Status:        make(map[string]CheckStatus),
 		StatusUpdated: make(map[string]time.Time),
 	}
 
 	if includeMeta {
 		res.Meta = make(map[string]any)
 
-		if e.MetaProbes != nil {
-			for name, probe := range e.MetaProbes {
-				val, err := probe.Value(ctx)
-				if err != nil {
-					slog.ErrorContext(ctx, "failed to get metadata value", "error", err, "name", e.Name, "metadata", name)
-					return res, fmt.Errorf("failed to get metadata value for probe %q: %w", name, err)
-				}
-
-				res.Meta[name] = val
+		for name, probe := range e.MetaProbes {
+			val, err := probe.Value(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to get metadata value", "error", err, "name", e.Name, "metadata", name)
+				continue
 			}
+
+			res.Meta[name] = val
 		}
 	}
 
-	if e.StatusChecks != nil && len(e.StatusChecks) == 0 {
-		return res, nil
+	for name, probe := range e.StatusChecks {
+		e.resolveStatus(ctx, &res, status, name, probe.LastUpdate)
+	}
+	for name, probe := range e.EnumChecks {
+		e.resolveStatus(ctx, &res, status, name, probe.LastUpdate)
 	}
 
-	for name, probe := range e.StatusChecks {
-		if status != nil {
-			if val, ok := status[name]; !ok || !val {
-				// Skip this probe, it's not in the list of probes to resolve or it's set to false
-				continue
-			}
-		}
+	return res
+}
 
-		val, err := probe.Value(ctx)
-		if err != nil {
-			slog.ErrorContext(ctx, "failed to get status check value", "error", err, "name", e.Name, "check", name)
-			return res, fmt.Errorf("failed to get status check value for probe %q: %w", name, err)
-		}
+// resolveStatus adds the value of a status check to res if it is included by the status filter.
+func (e *Environment) resolveStatus(ctx context.Context, res *EnvironmentResponse, status map[string]CheckStatus, name string, lastUpdate func() time.Time) {
+	if status != nil && status[name] != StatusTrue {
+		// Skip this probe, it's not in the list of probes to resolve
+		return
+	}
 
-		res.Status[name] = val
-		res.StatusUpdated[name] = probe.LastUpdate()
+	val, reason := e.checkStatus(ctx, name)
+	if val == StatusUnknown {
+		slog.WarnContext(ctx, "status check value is unknown", "name", e.Name, "check", name, "reason", reason)
+		if res.StatusReason == nil {
+			res.StatusReason = make(map[string]string)
+		}
+		res.StatusReason[name] = reason
 	}
 
-	return res, nil
+	res.Status[name] = val
+	res.StatusUpdated[name] = lastUpdate()
 }
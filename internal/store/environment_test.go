@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"maps"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,21 +31,22 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 		},
 	}
 
-	successTests := []struct {
-		filter         map[string]bool
-		wantStatus     map[string]bool
+	tests := []struct {
+		filter         map[string]CheckStatus
+		wantStatus     map[string]CheckStatus
 		wantStatusKeys map[string]bool
 		wantMeta       map[string]any
 		env            Environment
 		name           string
+		wantReasons    []string
 		includeMeta    bool
 	}{
 		{
 			name:        "filter and metadata",
 			env:         baseEnv,
 			includeMeta: true,
-			filter:      map[string]bool{"healthy": true},
-			wantStatus:  map[string]bool{"healthy": true},
+			filter:      map[string]CheckStatus{"healthy": StatusTrue},
+			wantStatus:  map[string]CheckStatus{"healthy": StatusTrue},
 			wantStatusKeys: map[string]bool{
 				"healthy": true,
 			},
@@ -54,7 +57,7 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 			env:            baseEnv,
 			includeMeta:    false,
 			filter:         nil,
-			wantStatus:     map[string]bool{"healthy": true, "ready": false},
+			wantStatus:     map[string]CheckStatus{"healthy": StatusTrue, "ready": StatusFalse},
 			wantStatusKeys: map[string]bool{"healthy": true, "ready": true},
 			wantMeta:       nil,
 		},
@@ -62,8 +65,8 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 			name:        "false filter values are skipped",
 			env:         baseEnv,
 			includeMeta: true,
-			filter:      map[string]bool{"healthy": false, "ready": true},
-			wantStatus:  map[string]bool{"ready": false},
+			filter:      map[string]CheckStatus{"healthy": StatusFalse, "ready": StatusTrue},
+			wantStatus:  map[string]CheckStatus{"ready": StatusFalse},
 			wantStatusKeys: map[string]bool{
 				"ready": true,
 			},
@@ -81,7 +84,7 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 			},
 			includeMeta:    true,
 			filter:         nil,
-			wantStatus:     map[string]bool{},
+			wantStatus:     map[string]CheckStatus{},
 			wantStatusKeys: map[string]bool{},
 			wantMeta:       map[string]any{"owner": "team-core"},
 		},
@@ -96,19 +99,12 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 			},
 			includeMeta:    true,
 			filter:         nil,
-			wantStatus:     map[string]bool{},
+			wantStatus:     map[string]CheckStatus{},
 			wantStatusKeys: map[string]bool{},
 			wantMeta:       map[string]any{"owner": "team-core"},
 		},
-	}
-
-	errorTests := []struct {
-		env         Environment
-		name        string
-		includeMeta bool
-	}{
 		{
-			name: "status probe error",
+			name: "status probe error is unknown",
 			env: Environment{
 				Name:      baseEnv.Name,
 				Namespace: baseEnv.Namespace,
@@ -116,13 +112,17 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 				URL:       baseEnv.URL,
 				StatusChecks: map[string]probe.Probe[bool]{
 					"healthy": failingBoolProbe{},
+					"ready":   probe.NewStaticProbe(true),
 				},
 				MetaProbes: map[string]probe.MetadataProbe{},
 			},
-			includeMeta: false,
+			includeMeta:    false,
+			wantStatus:     map[string]CheckStatus{"healthy": StatusUnknown, "ready": StatusTrue},
+			wantStatusKeys: map[string]bool{"healthy": true, "ready": true},
+			wantReasons:    []string{"healthy"},
 		},
 		{
-			name: "metadata probe error",
+			name: "metadata probe error is omitted",
 			env: Environment{
 				Name:      baseEnv.Name,
 				Namespace: baseEnv.Namespace,
@@ -132,73 +132,67 @@ func TestEnvironmentResolveProbesFilterAndMetadata(t *testing.T) {
 					"healthy": probe.NewStaticProbe(true),
 				},
 				MetaProbes: map[string]probe.MetadataProbe{
-					"owner": failingMetadataProbe{},
+					"owner":  failingMetadataProbe{},
+					"active": probe.WrapProbe(probe.NewStaticProbe(true)),
 				},
 			},
-			includeMeta: true,
+			includeMeta:    true,
+			wantStatus:     map[string]CheckStatus{"healthy": StatusTrue},
+			wantStatusKeys: map[string]bool{"healthy": true},
+			wantMeta:       map[string]any{"active": true},
+		},
+		{
+			name: "enum checks",
+			env: Environment{
+				Name:         baseEnv.Name,
+				Namespace:    baseEnv.Namespace,
+				CreatedAt:    baseEnv.CreatedAt,
+				URL:          baseEnv.URL,
+				StatusChecks: baseEnv.StatusChecks,
+				EnumChecks: map[string]probe.Probe[string]{
+					"phase": probe.NewStaticProbe("running"),
+				},
+				MetaProbes: baseEnv.MetaProbes,
+			},
+			includeMeta:    false,
+			filter:         map[string]CheckStatus{"phase": StatusTrue},
+			wantStatus:     map[string]CheckStatus{"phase": "running"},
+			wantStatusKeys: map[string]bool{"phase": true},
 		},
 	}
 
-	for _, tt := range successTests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			assertResolveProbesSuccess(t, tt.env, tt.includeMeta, tt.filter, tt.wantStatus, tt.wantMeta, tt.wantStatusKeys)
-		})
-	}
-
-	for _, tt := range errorTests {
+	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			assertResolveProbesError(t, tt.env, tt.includeMeta)
-		})
-	}
-}
 
-func assertResolveProbesSuccess(
-	t *testing.T,
-	env Environment,
-	includeMeta bool,
-	filter map[string]bool,
-	wantStatus map[string]bool,
-	wantMeta map[string]any,
-	wantStatusKeys map[string]bool,
-) {
-	t.Helper()
+			res := tt.env.ResolveProbes(t.Context(), tt.includeMeta, tt.filter)
 
-	res, err := env.ResolveProbes(t.Context(), includeMeta, filter)
-	if err != nil {
-		t.Fatalf("ResolveProbes() error = %v", err)
-	}
-
-	if !maps.Equal(res.Status, wantStatus) {
-		t.Fatalf("status = %#v, want %#v", res.Status, wantStatus)
-	}
-
-	if !maps.EqualFunc(res.Meta, wantMeta, func(a any, b any) bool { return a == b }) {
-		t.Fatalf("meta = %#v, want %#v", res.Meta, wantMeta)
-	}
-
-	if len(res.StatusUpdated) != len(wantStatusKeys) {
-		t.Fatalf("statusUpdated len = %d, want %d", len(res.StatusUpdated), len(wantStatusKeys))
-	}
-
-	for key := range wantStatusKeys {
-		if _, ok := res.StatusUpdated[key]; !ok {
-			t.Fatalf("statusUpdated missing key %q in %#v", key, res.StatusUpdated)
-		}
-	}
-}
+			if !maps.Equal(res.Status, tt.wantStatus) {
+				t.Fatalf("status = %#v, want %#v", res.Status, tt.wantStatus)
+			}
 
-func assertResolveProbesError(t *testing.T, env Environment, includeMeta bool) {
-	t.Helper()
+			if !maps.EqualFunc(res.Meta, tt.wantMeta, func(a any, b any) bool { return a == b }) {
+				t.Fatalf("meta = %#v, want %#v", res.Meta, tt.wantMeta)
+			}
 
-	_, err := env.ResolveProbes(t.Context(), includeMeta, nil)
-	if err == nil {
-		t.Fatal("ResolveProbes() error = nil, want non-nil")
-	}
+			if len(res.StatusUpdated) != len(tt.wantStatusKeys) {
+				t.Fatalf("statusUpdated len = %d, want %d", len(res.StatusUpdated), len(tt.wantStatusKeys))
+			}
+			for key := range tt.wantStatusKeys {
+				if _, ok := res.StatusUpdated[key]; !ok {
+					t.Fatalf("statusUpdated missing key %q in %#v", key, res.StatusUpdated)
+				}
+			}
 
-	if !errors.Is(err, errProbeFailed) {
-		t.Fatalf("ResolveProbes() error = %v, want wrapped errProbeFailed", err)
+			if len(res.StatusReason) != len(tt.wantReasons) {
+				t.Fatalf("statusReason = %#v, want reasons for %v", res.StatusReason, tt.wantReasons)
+			}
+			for _, key := range tt.wantReasons {
+				if !strings.Contains(res.StatusReason[key], errProbeFailed.Error()) {
+					t.Fatalf("statusReason[%q] = %q, want probe error", key, res.StatusReason[key])
+				}
+			}
+		})
 	}
 }
 
@@ -209,39 +203,73 @@ func TestEnvironmentMatchesStatus(t *testing.T) {
 		StatusChecks: map[string]probe.Probe[bool]{
 			"healthy": probe.NewStaticProbe(true),
 			"ready":   probe.NewStaticProbe(false),
+			"failing": failingBoolProbe{},
+		},
+		EnumChecks: map[string]probe.Probe[string]{
+			"phase": probe.NewStaticProbe("running"),
 		},
 	}
 
 	tests := []struct {
-		state map[string]bool
+		state map[string]CheckStatus
 		name  string
 		want  bool
 	}{
 		{
 			name:  "empty filter matches",
-			state: map[string]bool{},
+			state: map[string]CheckStatus{},
 			want:  true,
 		},
 		{
 			name:  "exact matching checks",
-			state: map[string]bool{"healthy": true, "ready": false},
+			state: map[string]CheckStatus{"healthy": StatusTrue, "ready": StatusFalse},
 			want:  true,
 		},
 		{
 			name:  "mismatch check value",
-			state: map[string]bool{"ready": true},
+			state: map[string]CheckStatus{"ready": StatusTrue},
 			want:  false,
 		},
 		{
 			name:  "missing check required true",
-			state: map[string]bool{"missing": true},
+			state: map[string]CheckStatus{"missing": StatusTrue},
 			want:  false,
 		},
 		{
 			name:  "missing check required false",
-			state: map[string]bool{"missing": false},
+			state: map[string]CheckStatus{"missing": StatusFalse},
+			want:  false,
+		},
+		{
+			name:  "missing check required unknown",
+			state: map[string]CheckStatus{"missing": StatusUnknown},
+			want:  true,
+		},
+		{
+			name:  "failing check required false",
+			state: map[string]CheckStatus{"failing": StatusFalse},
+			want:  false,
+		},
+		{
+			name:  "failing check required unknown",
+			state: map[string]CheckStatus{"failing": StatusUnknown, "healthy": StatusTrue},
+			want:  true,
+		},
+		{
+			name:  "known check required unknown",
+			state: map[string]CheckStatus{"healthy": StatusUnknown},
+			want:  false,
+		},
+		{
+			name:  "matching enum value",
+			state: map[string]CheckStatus{"phase": "running", "healthy": StatusTrue},
 			want:  true,
 		},
+		{
+			name:  "mismatch enum value",
+			state: map[string]CheckStatus{"phase": "pending"},
+			want:  false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -256,6 +284,28 @@ func TestEnvironmentMatchesStatus(t *testing.T) {
 	}
 }
 
+func TestCheckStatusMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	got, err := json.Marshal(map[string]CheckStatus{"a": StatusTrue, "b": StatusFalse, "c": StatusUnknown, "d": "running"})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	want := `{"a":true,"b":false,"c":null,"d":"running"}`
+	if string(got) != want {
+		t.Fatalf("json.Marshal() = %s, want %s", got, want)
+	}
+
+	var decoded map[string]CheckStatus
+	if err := json.Unmarshal(got, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if decoded["a"] != StatusTrue || decoded["b"] != StatusFalse || decoded["c"] != StatusUnknown || decoded["d"] != "running" {
+		t.Fatalf("json.Unmarshal() = %#v, want round trip", decoded)
+	}
+}
+
 var errProbeFailed = errors.New("probe failed")
 
 type failingBoolProbe struct{}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+// snapshot is an immutable view of the served environments and their secondary indexes.
+// It is replaced on every change, so readers never block writers and probes are never
+// evaluated while the store is locked.
+type snapshot struct {
+	env map[string]Environment
+	// aliases maps the aliases and short IDs of the environments to their names.
+	aliases map[string]string
+	// The indexes map values to the names of the environments, sorted by name.
+	byNamespace map[string][]string
+	byLabel     map[label][]string
+	byOwner     map[string][]string
+	names       []string
+	conflicts   []Conflict
+}
+
+type label struct {
+	key   string
+	value string
+}
+
+// Query selects environments. Fields that are not set match all environments.
+type Query struct {
+	// Labels are the label values the environment must have.
+	Labels map[string]string
+	// Status are the states the status and enum checks must have, see Environment.MatchesStatus.
+	Status    map[string]CheckStatus
+	Namespace string
+	Cluster   string
+	Owner     string
+}
+
+// publish replaces the snapshot with the current state of the store. Ignored aliases of the
+// changed environment are logged.
+// It must be called with the store's mutex already held.
+func (s *Store) publish(ctx context.Context, changed string) {
+	snap := &snapshot{
+		env:         maps.Clone(s.env),
+		aliases:     s.reindex(ctx, changed),
+		byNamespace: make(map[string][]string),
+		byLabel:     make(map[label][]string),
+		byOwner:     make(map[string][]string),
+		names:       slices.Sorted(maps.Keys(s.env)),
+		conflicts:   s.buildConflicts(),
+	}
+
+	for _, name := range snap.names {
+		env := snap.env[name]
+
+		snap.byNamespace[env.Namespace] = append(snap.byNamespace[env.Namespace], name)
+		for k, v := range env.Labels {
+			l := label{key: k, value: v}
+			snap.byLabel[l] = append(snap.byLabel[l], name)
+		}
+		if env.Owner != "" {
+			snap.byOwner[env.Owner] = append(snap.byOwner[env.Owner], name)
+		}
+	}
+
+	s.snap.Store(snap)
+}
+
+// FindEnvironments returns the environments matching the query, sorted by name. Candidates
+// are taken from the most selective index, status checks are evaluated last.
+func (s *Store) FindEnvironments(ctx context.Context, q Query) []Environment {
+	snap := s.snap.Load()
+
+	candidates := snap.names
+	narrow := func(names []string) {
+		if len(names) < len(candidates) {
+			candidates = names
+		}
+	}
+	if q.Namespace != "" {
+		narrow(snap.byNamespace[q.Namespace])
+	}
+	if q.Owner != "" {
+		narrow(snap.byOwner[q.Owner])
+	}
+	for k, v := range q.Labels {
+		narrow(snap.byLabel[label{key: k, value: v}])
+	}
+
+	envs := []Environment{}
+	for _, name := range candidates {
+		env := snap.env[name]
+		if !q.matches(&env) {
+			continue
+		}
+		if len(q.Status) > 0 && !env.MatchesStatus(ctx, q.Status) {
+			continue
+		}
+		envs = append(envs, env)
+	}
+	return envs
+}
+
+// matches reports whether the environment matches the query, without evaluating status checks.
+func (q *Query) matches(env *Environment) bool {
+	if q.Namespace != "" && env.Namespace != q.Namespace {
+		return false
+	}
+	if q.Cluster != "" && env.Cluster != q.Cluster {
+		return false
+	}
+	if q.Owner != "" && env.Owner != q.Owner {
+		return false
+	}
+	for k, v := range q.Labels {
+		if got, ok := env.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
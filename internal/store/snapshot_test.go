@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sberz/ephemeral-envs/internal/probe"
+)
+
+func TestStoreFindEnvironments(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+
+	a := newTestEnvironment("a", "env-shared", map[string]bool{"healthy": true})
+	a.Labels = map[string]string{"team": "mobile", "tier": "dev"}
+	a.Owner = "team-mobile"
+	b := newTestEnvironment("b", "env-shared", map[string]bool{"healthy": false})
+	b.Labels = map[string]string{"team": "mobile"}
+	c := newTestEnvironment("c", "env-c", map[string]bool{"healthy": true})
+	c.Cluster = "east"
+	c.Labels = map[string]string{"team": "web"}
+	c.Owner = "team-mobile"
+
+	for _, env := range []Environment{c, b, a} {
+		if err := s.AddEnvironment(ctx, env); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", env.Name, err)
+		}
+	}
+
+	tests := map[string]struct {
+		query Query
+		want  []string
+	}{
+		"all":                 {want: []string{"a", "b", "c"}},
+		"namespace":           {query: Query{Namespace: "env-shared"}, want: []string{"a", "b"}},
+		"unknown namespace":   {query: Query{Namespace: "env-missing"}, want: []string{}},
+		"cluster":             {query: Query{Cluster: "east"}, want: []string{"c"}},
+		"label":               {query: Query{Labels: map[string]string{"team": "mobile"}}, want: []string{"a", "b"}},
+		"labels":              {query: Query{Labels: map[string]string{"team": "mobile", "tier": "dev"}}, want: []string{"a"}},
+		"owner":               {query: Query{Owner: "team-mobile"}, want: []string{"a", "c"}},
+		"owner and namespace": {query: Query{Owner: "team-mobile", Namespace: "env-c"}, want: []string{"c"}},
+		"status":              {query: Query{Status: map[string]CheckStatus{"healthy": StatusTrue}}, want: []string{"a", "c"}},
+		"label and status":    {query: Query{Labels: map[string]string{"team": "mobile"}, Status: map[string]CheckStatus{"healthy": StatusFalse}}, want: []string{"b"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := []string{}
+			for _, env := range s.FindEnvironments(ctx, tt.query) {
+				got = append(got, env.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("FindEnvironments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreIndexesFollowUpdates(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+
+	env := newTestEnvironment("a", "env-a", nil)
+	env.Labels = map[string]string{"team": "mobile"}
+	env.Owner = "team-mobile"
+	if err := s.AddEnvironment(ctx, env); err != nil {
+		t.Fatalf("AddEnvironment() error = %v", err)
+	}
+
+	updated := env
+	updated.Labels = map[string]string{"team": "web"}
+	updated.Owner = ""
+	if err := s.UpdateEnvironment(ctx, "a", updated); err != nil {
+		t.Fatalf("UpdateEnvironment() error = %v", err)
+	}
+
+	if got := s.FindEnvironments(ctx, Query{Labels: map[string]string{"team": "mobile"}}); len(got) != 0 {
+		t.Fatalf("FindEnvironments(team=mobile) = %v, want none", got)
+	}
+	if got := s.FindEnvironments(ctx, Query{Owner: "team-mobile"}); len(got) != 0 {
+		t.Fatalf("FindEnvironments(owner) = %v, want none", got)
+	}
+	if got := s.FindEnvironments(ctx, Query{Labels: map[string]string{"team": "web"}}); len(got) != 1 {
+		t.Fatalf("FindEnvironments(team=web) = %v, want a", got)
+	}
+
+	if err := s.DeleteEnvironment(ctx, "a"); err != nil {
+		t.Fatalf("DeleteEnvironment() error = %v", err)
+	}
+	if _, err := s.GetEnvironmentByNamespace(ctx, "env-a"); err == nil {
+		t.Fatal("GetEnvironmentByNamespace() after delete error = nil, want ErrEnvironmentNotFound")
+	}
+}
+
+func TestStoreStatusFilterDoesNotBlockWriters(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+
+	blocking := &blockingBoolProbe{called: make(chan struct{}), release: make(chan struct{})}
+	env := newTestEnvironment("slow", "env-slow", nil)
+	env.StatusChecks["healthy"] = blocking
+	if err := s.AddEnvironment(ctx, env); err != nil {
+		t.Fatalf("AddEnvironment(slow) error = %v", err)
+	}
+
+	done := make(chan []string)
+	go func() {
+		done <- s.GetEnvironmentNamesWithState(ctx, map[string]CheckStatus{"healthy": StatusTrue})
+	}()
+	<-blocking.called
+
+	// The probe is still being evaluated, writers must not wait for it
+	added := make(chan error)
+	go func() {
+		added <- s.AddEnvironment(ctx, newTestEnvironment("other", "env-other", nil))
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatalf("AddEnvironment(other) error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddEnvironment() blocked by a probe evaluated by a reader")
+	}
+
+	close(blocking.release)
+	if got := <-done; !slices.Equal(got, []string{"slow"}) {
+		t.Fatalf("GetEnvironmentNamesWithState() = %v, want [slow]", got)
+	}
+}
+
+// blockingBoolProbe blocks until released.
+type blockingBoolProbe struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+var _ probe.Probe[bool] = (*blockingBoolProbe)(nil)
+
+func (p *blockingBoolProbe) Value(_ context.Context) (bool, error) {
+	close(p.called)
+	<-p.release
+	return true, nil
+}
+
+func (p *blockingBoolProbe) LastUpdate() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// CheckStatus is the value of a status check. Boolean checks are true, false or unknown,
+// enum checks have one of their allowed values or are unknown.
+type CheckStatus string
+
+const (
+	// StatusUnknown is reported if a check is not defined for an environment or its value
+	// cannot be determined, e.g. because the probe failed or the query returned no data.
+	StatusUnknown CheckStatus = ""
+	StatusFalse   CheckStatus = "false"
+	StatusTrue    CheckStatus = "true"
+)
+
+// Reasons reported for unknown status checks.
+const (
+	reasonNotDefined = "check is not defined for this environment"
+)
+
+// StatusOf converts a boolean check value to a status.
+func StatusOf(v bool) CheckStatus {
+	if v {
+		return StatusTrue
+	}
+	return StatusFalse
+}
+
+func (s CheckStatus) String() string {
+	if s == StatusUnknown {
+		return "unknown"
+	}
+	return string(s)
+}
+
+// MarshalJSON encodes boolean values as booleans, enum values as strings and unknown
+// values as null, so clients that only expect booleans keep working.
+func (s CheckStatus) MarshalJSON() ([]byte, error) {
+	var v any
+	switch s {
+	case StatusUnknown:
+		v = nil
+	case StatusTrue, StatusFalse:
+		v = s == StatusTrue
+	default:
+		v = string(s)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode check status: %w", err)
+	}
+	return data, nil
+}
+
+func (s *CheckStatus) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("failed to decode check status: %w", err)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		*s = StatusUnknown
+	case bool:
+		*s = StatusOf(v)
+	case string:
+		*s = CheckStatus(v)
+	default:
+		return fmt.Errorf("%w: unexpected check status %s", ErrInvalidStatus, data)
+	}
+	return nil
+}
+
+// checkStatus evaluates a status check of the environment. For unknown values, the
+// reason explains why the value could not be determined.
+func (e *Environment) checkStatus(ctx context.Context, name string) (status CheckStatus, reason string) {
+	if p, exists := e.EnumChecks[name]; exists && p != nil {
+		val, err := p.Value(ctx)
+		if err != nil {
+			return StatusUnknown, err.Error()
+		}
+		return CheckStatus(val), ""
+	}
+
+	p, exists := e.StatusChecks[name]
+	if !exists || p == nil {
+		return StatusUnknown, reasonNotDefined
+	}
+
+	val, err := p.Value(ctx)
+	if err != nil {
+		return StatusUnknown, err.Error()
+	}
+
+	return StatusOf(val), ""
+}
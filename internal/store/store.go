@@ -5,10 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"maps"
 	"slices"
-	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -18,29 +17,45 @@ var (
 	ErrInvalidEnvironment    = errors.New("invalid environment")
 	ErrEnvironmentNotFound   = errors.New("environment not found")
 	ErrImmutableFieldChanged = errors.New("immutable field changed")
+	ErrEnvironmentConflict   = errors.New("environment name already claimed")
+	ErrInvalidConflictPolicy = errors.New("invalid conflict policy")
+	ErrInvalidStatus         = errors.New("invalid check status")
 )
 
 var envInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
 	Name: "ephemeralenv_environment_info",
 	Help: "Information about the discovered environments",
-}, []string{"name", "namespace"})
+}, []string{"name", "namespace", "cluster"})
 
 // Store manages ephemeral environments.
-// It provides methods to add, update, delete, and retrieve environments.
+// It provides methods to add, update, delete, and retrieve environments. Writers are
+// serialized by a mutex, readers use an immutable snapshot and never block.
 type Store struct {
+	// snap is the snapshot read by the getters.
+	snap atomic.Pointer[snapshot]
+	// env holds the served environment of each name.
 	env map[string]Environment
-	mu  sync.RWMutex
+	// conflicts holds the rejected claims of names claimed by multiple namespaces, sorted by precedence.
+	conflicts  map[string][]Environment
+	onConflict ConflictFunc
+	policy     ConflictPolicy
+	mu         sync.Mutex
 }
 
 // NewStore creates a new Store instance.
 func NewStore() *Store {
-	return &Store{
-		env: make(map[string]Environment),
+	s := &Store{
+		env:       make(map[string]Environment),
+		conflicts: make(map[string][]Environment),
+		policy:    ConflictPolicyOldest,
 	}
+	s.snap.Store(&snapshot{})
+	return s
 }
 
 // addEnvironment is a internal method that adds an environment to the store.
 // This method is used internally to avoid code duplication in AddEnvironment and UpdateEnvironment.
+// If another namespace claims the same name, the conflict policy decides which environment is served.
 // It does not lock the store, so it must be called with the store's mutex already held.
 func (s *Store) addEnvironment(ctx context.Context, env Environment) error {
 	problems := env.IsValid()
@@ -48,30 +63,25 @@ func (s *Store) addEnvironment(ctx context.Context, env Environment) error {
 		return fmt.Errorf("%w: %v", ErrInvalidEnvironment, problems)
 	}
 
-	if oldEnv, exists := s.env[env.Name]; exists {
-		// If the environment already exists, print a warning and overwrite it.
-		// Blocking the creation would desynchronize the store with the Kubernetes events.
-		slog.WarnContext(ctx, "environment with this name already exists, overwriting it",
-			"name", env.Name,
-			"old_namespace", oldEnv.Namespace,
-			"new_namespace", env.Namespace,
-		)
+	slog.DebugContext(ctx, "adding environment to store", "name", env.Name, "namespace", env.Namespace, "cluster", env.Cluster)
 
-		err := s.deleteEnvironment(ctx, env.Name)
-		if err != nil {
-			return fmt.Errorf("could not remove previous env: %w", err)
-		}
-	}
+	// A previous claim of the same object is replaced
+	claims := slices.DeleteFunc(s.claims(env.Name), env.sameOrigin)
+	claims = append(claims, env)
 
-	slog.DebugContext(ctx, "adding environment to store", "name", env.Name, "namespace", env.Namespace)
-
-	s.env[env.Name] = env
-	envInfo.WithLabelValues(env.Name, env.Namespace).Set(1)
+	winner, served := s.resolve(ctx, env.Name, claims)
+	switch {
+	case !served:
+		return fmt.Errorf("%w: %s by %d namespaces", ErrEnvironmentConflict, env.Name, len(claims))
+	case !winner.sameOrigin(env):
+		return fmt.Errorf("%w: %s by namespace %s in cluster %q", ErrEnvironmentConflict, env.Name, winner.Namespace, winner.Cluster)
+	}
 
 	return nil
 }
 
-// deleteEnvironment is a internal method to remove a environment.
+// deleteEnvironment is a internal method to remove a environment. A rejected claim of the
+// same name is served instead, if the conflict policy allows it.
 // It does not lock the store, so it must be called with the store's mutex already held.
 func (s *Store) deleteEnvironment(ctx context.Context, name string) error {
 	env, exists := s.env[name]
@@ -79,19 +89,33 @@ func (s *Store) deleteEnvironment(ctx context.Context, name string) error {
 		return fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
 	}
 
-	slog.DebugContext(ctx, "deleting environment from store", "name", name, "namespace", env.Namespace)
-
-	delete(s.env, name)
-	// Clean up the metric
-	envInfo.DeleteLabelValues(env.Name, env.Namespace)
+	slog.DebugContext(ctx, "deleting environment from store", "name", name, "namespace", env.Namespace, "cluster", env.Cluster)
 
+	s.resolve(ctx, name, slices.Clone(s.conflicts[name]))
 	return nil
 }
 
+// deleteClaim removes the claim of the name by the origin object, whether it is served or
+// rejected. It reports whether a claim was removed.
+// It does not lock the store, so it must be called with the store's mutex already held.
+func (s *Store) deleteClaim(ctx context.Context, name string, origin Environment) bool {
+	claims := s.claims(name)
+	remaining := slices.DeleteFunc(slices.Clone(claims), origin.sameOrigin)
+	if len(remaining) == len(claims) {
+		return false
+	}
+
+	slog.DebugContext(ctx, "deleting environment from store", "name", name, "namespace", origin.Namespace, "cluster", origin.Cluster)
+
+	s.resolve(ctx, name, remaining)
+	return true
+}
+
 // AddEnvironment adds a new environment to the store.
 func (s *Store) AddEnvironment(ctx context.Context, env Environment) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.publish(ctx, env.Name)
 
 	return s.addEnvironment(ctx, env)
 }
@@ -100,16 +124,30 @@ func (s *Store) AddEnvironment(ctx context.Context, env Environment) error {
 func (s *Store) DeleteEnvironment(ctx context.Context, name string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.publish(ctx, name)
 
 	return s.deleteEnvironment(ctx, name)
 }
 
+// DeleteEnvironmentFrom removes an environment from the store by its name, but only if it
+// was discovered from the given source object in the namespace and cluster. This prevents the
+// deletion of an object from removing an environment with the same name that is backed by
+// another object. Rejected claims of conflicting names are removed as well.
+func (s *Store) DeleteEnvironmentFrom(ctx context.Context, name string, cluster string, namespace string, source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.publish(ctx, name)
+
+	if !s.deleteClaim(ctx, name, Environment{Cluster: cluster, Namespace: namespace, Source: source}) {
+		return fmt.Errorf("%w: %s from %s in namespace %s of cluster %q", ErrEnvironmentNotFound, name, source, namespace, cluster)
+	}
+
+	return nil
+}
+
 // GetEnvironment retrieves an environment by its name.
 func (s *Store) GetEnvironment(_ context.Context, name string) (Environment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	env, exists := s.env[name]
+	env, exists := s.snap.Load().env[name]
 	if !exists {
 		return Environment{}, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
 	}
@@ -117,62 +155,42 @@ func (s *Store) GetEnvironment(_ context.Context, name string) (Environment, err
 	return env, nil
 }
 
-// GetAllEnvironments retrieves all environments in the store.
-func (s *Store) GetAllEnvironments(_ context.Context) []Environment {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	return slices.SortedFunc(maps.Values(s.env), func(a, b Environment) int {
-		return strings.Compare(a.Name, b.Name)
-	})
+// GetAllEnvironments retrieves all environments in the store, sorted by name.
+func (s *Store) GetAllEnvironments(ctx context.Context) []Environment {
+	return s.FindEnvironments(ctx, Query{})
 }
 
-// GetEnvironmentByNamespace retrieves an environment by its namespace.
+// GetEnvironmentByNamespace retrieves an environment by its namespace. If the namespace
+// holds multiple environments, the first one by name is returned.
 func (s *Store) GetEnvironmentByNamespace(_ context.Context, namespace string) (Environment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	snap := s.snap.Load()
 
-	for _, env := range s.env {
-		if env.Namespace == namespace {
-			return env, nil
-		}
+	names := snap.byNamespace[namespace]
+	if len(names) == 0 {
+		return Environment{}, fmt.Errorf("%w: namespace %s", ErrEnvironmentNotFound, namespace)
 	}
 
-	return Environment{}, fmt.Errorf("%w: namespace %s", ErrEnvironmentNotFound, namespace)
+	return snap.env[names[0]], nil
 }
 
 // GetEnvironmentNamesWithState returns a list of environment names that match the provided status check states.
-func (s *Store) GetEnvironmentNamesWithState(ctx context.Context, state map[string]bool) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+func (s *Store) GetEnvironmentNamesWithState(ctx context.Context, state map[string]CheckStatus) []string {
 	envs := []string{}
-	for name, env := range s.env {
-		if env.MatchesStatus(ctx, state) {
-			envs = append(envs, name)
-		}
+	for _, env := range s.FindEnvironments(ctx, Query{Status: state}) {
+		envs = append(envs, env.Name)
 	}
 
-	// Sort the names for consistent ordering
-	slices.Sort(envs)
-
 	return envs
 }
 
 // GetEnvironmentCount returns the number of environments currently stored.
 func (s *Store) GetEnvironmentCount(_ context.Context) int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	return len(s.env)
+	return len(s.snap.Load().names)
 }
 
 // ListEnvironmentNames returns a list of all environment names currently stored.
 func (s *Store) ListEnvironmentNames(_ context.Context) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	return slices.Sorted(maps.Keys(s.env))
+	return slices.Clone(s.snap.Load().names)
 }
 
 // UpdateEnvironment updates an existing environment.
@@ -181,10 +199,15 @@ func (s *Store) ListEnvironmentNames(_ context.Context) []string {
 func (s *Store) UpdateEnvironment(ctx context.Context, name string, env Environment) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.publish(ctx, env.Name)
 
 	current, exists := s.env[name]
-	if !exists {
-		// If the environment does not exist, we try to add it
+	if !exists || !current.sameOrigin(env) {
+		if name != env.Name {
+			// The object may hold a rejected claim of the previous name
+			s.deleteClaim(ctx, name, env)
+		}
+		// If the environment does not exist or is backed by another namespace, we try to add it
 		return s.addEnvironment(ctx, env)
 	}
 
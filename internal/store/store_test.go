@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"maps"
 	"slices"
@@ -28,7 +29,7 @@ func TestStoreGetEnvironmentNamesWithStateSorted(t *testing.T) {
 		}
 	}
 
-	got := s.GetEnvironmentNamesWithState(ctx, map[string]bool{"healthy": true})
+	got := s.GetEnvironmentNamesWithState(ctx, map[string]CheckStatus{"healthy": StatusTrue})
 	want := []string{"alpha", "gamma"}
 
 	if !slices.Equal(got, want) {
@@ -50,7 +51,8 @@ func TestStoreUpdateEnvironmentImmutableChangeReadds(t *testing.T) {
 		t.Fatalf("AddEnvironment() error = %v", err)
 	}
 
-	newEnv := newTestEnvironment("new", "env-new", map[string]bool{"healthy": false})
+	// The namespace stays the same, a different namespace would be a competing claim instead of an update.
+	newEnv := newTestEnvironment("new", "env-old", map[string]bool{"healthy": false})
 	newEnv.CreatedAt = createdAt.Add(time.Minute)
 
 	if err := s.UpdateEnvironment(ctx, "old", newEnv); err != nil {
@@ -67,8 +69,156 @@ func TestStoreUpdateEnvironmentImmutableChangeReadds(t *testing.T) {
 		t.Fatalf("GetEnvironment(new) error = %v", err)
 	}
 
-	if got.Name != "new" || got.Namespace != "env-new" {
-		t.Fatalf("updated env = %#v, want name=new namespace=env-new", got)
+	if got.Name != "new" || got.Namespace != "env-old" {
+		t.Fatalf("updated env = %#v, want name=new namespace=env-old", got)
+	}
+}
+
+func TestStoreNameConflictIsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Unix(1700000000, 0).UTC()
+
+	older := newTestEnvironment("shared", "env-a", nil)
+	older.Cluster = "east"
+	older.CreatedAt = createdAt
+
+	newer := newTestEnvironment("shared", "env-b", nil)
+	newer.Cluster = "west"
+	newer.CreatedAt = createdAt.Add(time.Hour)
+
+	orders := map[string][]Environment{
+		"older first": {older, newer},
+		"newer first": {newer, older},
+	}
+
+	for name, order := range orders {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			s := NewStore()
+
+			for _, env := range order {
+				err := s.AddEnvironment(ctx, env)
+				if err != nil && !errors.Is(err, ErrEnvironmentConflict) {
+					t.Fatalf("AddEnvironment(%s/%s) error = %v", env.Cluster, env.Namespace, err)
+				}
+			}
+
+			got, err := s.GetEnvironment(ctx, "shared")
+			if err != nil {
+				t.Fatalf("GetEnvironment(shared) error = %v", err)
+			}
+			if got.Cluster != "east" || got.Namespace != "env-a" {
+				t.Fatalf("GetEnvironment(shared) = %s/%s, want east/env-a", got.Cluster, got.Namespace)
+			}
+
+			// Updates and deletes of the losing namespace must not affect the winner.
+			if err := s.UpdateEnvironment(ctx, "shared", newer); !errors.Is(err, ErrEnvironmentConflict) {
+				t.Fatalf("UpdateEnvironment(newer) error = %v, want ErrEnvironmentConflict", err)
+			}
+			// The rejected claim is removed from the conflicts
+			if err := s.DeleteEnvironmentFrom(ctx, "shared", "west", "env-b", ""); err != nil {
+				t.Fatalf("DeleteEnvironmentFrom(west/env-b) error = %v", err)
+			}
+			if conflicts := s.GetConflicts(ctx); len(conflicts) != 0 {
+				t.Fatalf("GetConflicts() after loser delete = %+v, want none", conflicts)
+			}
+			if _, err := s.GetEnvironment(ctx, "shared"); err != nil {
+				t.Fatalf("GetEnvironment(shared) after loser delete error = %v", err)
+			}
+
+			if err := s.DeleteEnvironmentFrom(ctx, "shared", "east", "env-a", ""); err != nil {
+				t.Fatalf("DeleteEnvironmentFrom(east/env-a) error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreNameConflictWithinNamespace(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+	createdAt := time.Unix(1700000000, 0).UTC()
+
+	web := newTestEnvironment("shared", "env-a", nil)
+	web.Source = "deployments.apps/web"
+	web.CreatedAt = createdAt
+
+	api := newTestEnvironment("shared", "env-a", nil)
+	api.Source = "deployments.apps/api"
+	api.CreatedAt = createdAt.Add(time.Hour)
+
+	if err := s.AddEnvironment(ctx, web); err != nil {
+		t.Fatalf("AddEnvironment(web) error = %v", err)
+	}
+	// Objects of the same namespace do not overwrite each other
+	if err := s.AddEnvironment(ctx, api); !errors.Is(err, ErrEnvironmentConflict) {
+		t.Fatalf("AddEnvironment(api) error = %v, want ErrEnvironmentConflict", err)
+	}
+	if conflicts := s.GetConflicts(ctx); len(conflicts) != 1 {
+		t.Fatalf("GetConflicts() = %+v, want one conflict", conflicts)
+	}
+
+	// Deleting the served object serves the rejected claim of the other one
+	if err := s.DeleteEnvironmentFrom(ctx, "shared", "", "env-a", web.Source); err != nil {
+		t.Fatalf("DeleteEnvironmentFrom(web) error = %v", err)
+	}
+	got, err := s.GetEnvironment(ctx, "shared")
+	if err != nil {
+		t.Fatalf("GetEnvironment(shared) error = %v", err)
+	}
+	if got.Source != api.Source {
+		t.Fatalf("GetEnvironment(shared) source = %q, want %q", got.Source, api.Source)
+	}
+}
+
+func TestStoreOnConflict(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Unix(1700000000, 0).UTC()
+
+	older := newTestEnvironment("shared", "env-a", nil)
+	older.CreatedAt = createdAt
+
+	newer := newTestEnvironment("shared", "env-b", nil)
+	newer.CreatedAt = createdAt.Add(time.Hour)
+
+	orders := map[string][]Environment{
+		"older first": {older, newer},
+		"newer first": {newer, older},
+	}
+
+	for name, order := range orders {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			s := NewStore()
+
+			var conflicts [][2]string
+			s.OnConflict(func(_ context.Context, rejected Environment, winner *Environment) {
+				conflicts = append(conflicts, [2]string{winner.Namespace, rejected.Namespace})
+			})
+
+			for _, env := range order {
+				err := s.AddEnvironment(ctx, env)
+				if err != nil && !errors.Is(err, ErrEnvironmentConflict) {
+					t.Fatalf("AddEnvironment(%s) error = %v", env.Namespace, err)
+				}
+			}
+			// Updates of the same namespace are no conflict
+			if err := s.UpdateEnvironment(ctx, "shared", older); err != nil {
+				t.Fatalf("UpdateEnvironment(older) error = %v", err)
+			}
+
+			want := [][2]string{{"env-a", "env-b"}}
+			if !slices.Equal(conflicts, want) {
+				t.Fatalf("conflicts = %v, want %v", conflicts, want)
+			}
+		})
 	}
 }
 
@@ -219,3 +369,221 @@ func TestStoreUpdateEnvironmentAddsWhenMissing(t *testing.T) {
 		t.Fatalf("GetEnvironment(new).Namespace = %q, want %q", got.Namespace, "env-new")
 	}
 }
+
+func TestStoreConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Unix(1700000000, 0).UTC()
+
+	older := newTestEnvironment("shared", "env-a", nil)
+	older.CreatedAt = createdAt
+
+	newer := newTestEnvironment("shared", "env-b", nil)
+	newer.CreatedAt = createdAt.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		policy     ConflictPolicy
+		wantServed string
+		// wantRestored is served once the first served environment is deleted.
+		wantRestored string
+		wantRejected []string
+	}{
+		{
+			name:         "oldest",
+			policy:       ConflictPolicyOldest,
+			wantServed:   "env-a",
+			wantRejected: []string{"env-b"},
+			wantRestored: "env-b",
+		},
+		{
+			name:         "newest",
+			policy:       ConflictPolicyNewest,
+			wantServed:   "env-b",
+			wantRejected: []string{"env-a"},
+			wantRestored: "env-a",
+		},
+		{
+			name:         "reject",
+			policy:       ConflictPolicyReject,
+			wantRejected: []string{"env-a", "env-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			s := NewStore()
+			s.SetConflictPolicy(tt.policy)
+
+			for _, env := range []Environment{older, newer} {
+				err := s.AddEnvironment(ctx, env)
+				if err != nil && !errors.Is(err, ErrEnvironmentConflict) {
+					t.Fatalf("AddEnvironment(%s) error = %v", env.Namespace, err)
+				}
+			}
+
+			conflicts := s.GetConflicts(ctx)
+			if len(conflicts) != 1 || conflicts[0].Name != "shared" {
+				t.Fatalf("GetConflicts() = %+v, want conflict of shared", conflicts)
+			}
+			var rejected []string
+			for _, c := range conflicts[0].Rejected {
+				rejected = append(rejected, c.Namespace)
+			}
+			if !slices.Equal(rejected, tt.wantRejected) {
+				t.Fatalf("rejected = %v, want %v", rejected, tt.wantRejected)
+			}
+
+			got, err := s.GetEnvironment(ctx, "shared")
+			if tt.wantServed == "" {
+				if !errors.Is(err, ErrEnvironmentNotFound) || conflicts[0].Winner != nil {
+					t.Fatalf("GetEnvironment(shared) = %s, %v, want ErrEnvironmentNotFound", got.Namespace, err)
+				}
+
+				// Once only one claim is left, it is served
+				if err := s.DeleteEnvironmentFrom(ctx, "shared", "", "env-a", ""); err != nil {
+					t.Fatalf("DeleteEnvironmentFrom(env-a) error = %v", err)
+				}
+				if got, err := s.GetEnvironment(ctx, "shared"); err != nil || got.Namespace != "env-b" {
+					t.Fatalf("GetEnvironment(shared) after delete = %s, %v, want env-b", got.Namespace, err)
+				}
+				return
+			}
+
+			if err != nil || got.Namespace != tt.wantServed || conflicts[0].Winner.Namespace != tt.wantServed {
+				t.Fatalf("GetEnvironment(shared) = %s, %v, want %s", got.Namespace, err, tt.wantServed)
+			}
+
+			// Deleting the served namespace restores the rejected one
+			if err := s.DeleteEnvironmentFrom(ctx, "shared", "", tt.wantServed, ""); err != nil {
+				t.Fatalf("DeleteEnvironmentFrom(%s) error = %v", tt.wantServed, err)
+			}
+			got, err = s.GetEnvironment(ctx, "shared")
+			if err != nil || got.Namespace != tt.wantRestored {
+				t.Fatalf("GetEnvironment(shared) after delete = %s, %v, want %s", got.Namespace, err, tt.wantRestored)
+			}
+			if conflicts := s.GetConflicts(ctx); len(conflicts) != 0 {
+				t.Fatalf("GetConflicts() after delete = %+v, want none", conflicts)
+			}
+		})
+	}
+}
+
+func TestStoreConflictRename(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+
+	older := newTestEnvironment("shared", "env-a", nil)
+	newer := newTestEnvironment("shared", "env-b", nil)
+	newer.CreatedAt = older.CreatedAt.Add(time.Hour)
+
+	if err := s.AddEnvironment(ctx, older); err != nil {
+		t.Fatalf("AddEnvironment(older) error = %v", err)
+	}
+	if err := s.AddEnvironment(ctx, newer); !errors.Is(err, ErrEnvironmentConflict) {
+		t.Fatalf("AddEnvironment(newer) error = %v, want ErrEnvironmentConflict", err)
+	}
+
+	// Renaming the rejected namespace resolves the conflict
+	renamed := newer
+	renamed.Name = "other"
+	if err := s.UpdateEnvironment(ctx, "shared", renamed); err != nil {
+		t.Fatalf("UpdateEnvironment(renamed) error = %v", err)
+	}
+	if conflicts := s.GetConflicts(ctx); len(conflicts) != 0 {
+		t.Fatalf("GetConflicts() after rename = %+v, want none", conflicts)
+	}
+	if names := s.ListEnvironmentNames(ctx); !slices.Equal(names, []string{"other", "shared"}) {
+		t.Fatalf("ListEnvironmentNames() = %v, want [other shared]", names)
+	}
+
+	// Renaming the served namespace to the claimed name creates a new conflict
+	if err := s.UpdateEnvironment(ctx, "other", newer); !errors.Is(err, ErrEnvironmentConflict) {
+		t.Fatalf("UpdateEnvironment(newer) error = %v, want ErrEnvironmentConflict", err)
+	}
+	if names := s.ListEnvironmentNames(ctx); !slices.Equal(names, []string{"shared"}) {
+		t.Fatalf("ListEnvironmentNames() = %v, want [shared]", names)
+	}
+	if conflicts := s.GetConflicts(ctx); len(conflicts) != 1 || conflicts[0].Winner.Namespace != "env-a" {
+		t.Fatalf("GetConflicts() = %+v, want env-a serving shared", conflicts)
+	}
+}
+
+func TestConflictPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []ConflictPolicy{ConflictPolicyOldest, ConflictPolicyNewest, ConflictPolicyReject} {
+		if err := policy.Validate(); err != nil {
+			t.Fatalf("Validate(%s) error = %v", policy, err)
+		}
+	}
+	if err := ConflictPolicy("random").Validate(); !errors.Is(err, ErrInvalidConflictPolicy) {
+		t.Fatalf("Validate(random) error = %v, want ErrInvalidConflictPolicy", err)
+	}
+}
+
+func TestStoreResolveEnvironment(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	s := NewStore()
+
+	older := newTestEnvironment("a", "env-a", nil)
+	older.Aliases = []string{"pr-1", "b", "shared"}
+	older.ID = "abcdefgh"
+	newer := newTestEnvironment("b", "env-b", nil)
+	newer.CreatedAt = older.CreatedAt.Add(time.Hour)
+	newer.Aliases = []string{"shared", "pr-2"}
+
+	for _, env := range []Environment{older, newer} {
+		if err := s.AddEnvironment(ctx, env); err != nil {
+			t.Fatalf("AddEnvironment(%s) error = %v", env.Name, err)
+		}
+	}
+
+	tests := map[string]string{
+		"a":        "a",
+		"pr-1":     "a",
+		"abcdefgh": "a",
+		// Names take precedence over aliases
+		"b": "b",
+		// The older environment keeps a shared alias
+		"shared": "a",
+		"pr-2":   "b",
+	}
+	for ref, want := range tests {
+		env, err := s.ResolveEnvironment(ctx, ref)
+		if err != nil {
+			t.Fatalf("ResolveEnvironment(%s) error = %v", ref, err)
+		}
+		if env.Name != want {
+			t.Fatalf("ResolveEnvironment(%s) = %s, want %s", ref, env.Name, want)
+		}
+	}
+
+	// Removed aliases no longer resolve, and a released alias moves to the next claimant
+	updated := older
+	updated.Aliases = []string{}
+	if err := s.UpdateEnvironment(ctx, "a", updated); err != nil {
+		t.Fatalf("UpdateEnvironment(a) error = %v", err)
+	}
+	if _, err := s.ResolveEnvironment(ctx, "pr-1"); !errors.Is(err, ErrEnvironmentNotFound) {
+		t.Fatalf("ResolveEnvironment(pr-1) error = %v, want ErrEnvironmentNotFound", err)
+	}
+	if env, err := s.ResolveEnvironment(ctx, "shared"); err != nil || env.Name != "b" {
+		t.Fatalf("ResolveEnvironment(shared) = %s, %v, want b", env.Name, err)
+	}
+
+	// Aliases of deleted environments no longer resolve
+	if err := s.DeleteEnvironment(ctx, "b"); err != nil {
+		t.Fatalf("DeleteEnvironment(b) error = %v", err)
+	}
+	if _, err := s.ResolveEnvironment(ctx, "pr-2"); !errors.Is(err, ErrEnvironmentNotFound) {
+		t.Fatalf("ResolveEnvironment(pr-2) error = %v, want ErrEnvironmentNotFound", err)
+	}
+}